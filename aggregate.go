@@ -16,8 +16,6 @@ package eventhorizon
 
 import (
 	"errors"
-	"fmt"
-	"sync"
 )
 
 // Aggregate is an interface representing a versioned data entity created from
@@ -64,6 +62,29 @@ type Aggregate interface {
 // AggregateType is the type of an aggregate.
 type AggregateType string
 
+// ApplyOnStoreAggregate is an aggregate that can apply events when they are
+// stored, which makes its version include the uncommitted events. The
+// EventSourcingRepository uses it to find the version of the saved events.
+type ApplyOnStoreAggregate interface {
+	Aggregate
+
+	// AppliesOnStore returns true if the events are applied when stored.
+	AppliesOnStore() bool
+}
+
+// SnapshotAggregate is an aggregate that can encode its state as a snapshot,
+// which the EventSourcingRepository uses to load the aggregate without
+// applying the events before the snapshot.
+type SnapshotAggregate interface {
+	Aggregate
+
+	// SnapshotState returns the encoded state of the aggregate.
+	SnapshotState() ([]byte, error)
+
+	// ApplySnapshotState sets the state of the aggregate from a snapshot.
+	ApplySnapshotState([]byte) error
+}
+
 // AggregateBase is a CQRS aggregate base to embed in domain specific aggregates.
 //
 // A typical aggregate example:
@@ -74,10 +95,28 @@ type AggregateType string
 //   }
 // The embedded aggregate is then initialized by the factory function in the
 // callback repository.
+//
+// Events can either be applied by an ApplyEvent method of the aggregate, or by
+// apply funcs set per event type with SetApplyFunc or OnEvent, which are used
+// by the ApplyEvent method of the base. With EnableApplyOnStore the events are
+// also applied when stored, keeping the aggregate up to date while handling a
+// command:
+//   func NewUserAggregate(id eventhorizon.UUID) *UserAggregate {
+//       a := &UserAggregate{AggregateBase: eventhorizon.NewAggregateBase(id)}
+//       eventhorizon.OnEvent(a.AggregateBase, UserCreatedEvent, func(e *UserCreated) {
+//           a.name = e.Name
+//       })
+//       a.EnableApplyOnStore(a)
+//       return a
+//   }
 type AggregateBase struct {
 	id                UUID
 	version           int
 	uncommittedEvents []Event
+
+	applyFuncs map[EventType]func(Event)
+	// applyOnStore applies stored events, if enabled.
+	applyOnStore func(Event)
 }
 
 // NewAggregateBase creates an aggregate.
@@ -103,9 +142,62 @@ func (a *AggregateBase) IncrementVersion() {
 	a.version++
 }
 
-// StoreEvent stores an event until as uncommitted.
+// StoreEvent stores an event until as uncommitted. The event is also applied
+// and the version incremented if enabled with EnableApplyOnStore.
 func (a *AggregateBase) StoreEvent(event Event) {
 	a.uncommittedEvents = append(a.uncommittedEvents, event)
+
+	if a.applyOnStore != nil {
+		a.applyOnStore(event)
+		a.IncrementVersion()
+	}
+}
+
+// ApplyEvent applies an event with the apply func set for its type. Events
+// without an apply func are ignored. Aggregates that apply events themselves
+// implement their own ApplyEvent method instead.
+func (a *AggregateBase) ApplyEvent(event Event) {
+	if apply, ok := a.applyFuncs[event.EventType()]; ok {
+		apply(event)
+	}
+}
+
+// SetApplyFunc sets the func used by ApplyEvent to apply events of a type,
+// replacing any previous func for the type.
+func (a *AggregateBase) SetApplyFunc(eventType EventType, apply func(Event)) {
+	if a.applyFuncs == nil {
+		a.applyFuncs = make(map[EventType]func(Event))
+	}
+	a.applyFuncs[eventType] = apply
+}
+
+// OnEvent sets a typed apply func for events of a type, for events of the Go
+// type E. Events of the type that are not an E are ignored.
+func OnEvent[E Event](a *AggregateBase, eventType EventType, apply func(E)) {
+	a.SetApplyFunc(eventType, func(event Event) {
+		if e, ok := event.(E); ok {
+			apply(e)
+		}
+	})
+}
+
+// EnableApplyOnStore makes StoreEvent apply events to the aggregate and
+// increment its version immediately, instead of only when the aggregate is
+// loaded the next time. The aggregate is the domain aggregate that embeds the
+// base, which is needed to use its own ApplyEvent method. The ApplyEvent
+// method of the base is used if it is nil.
+func (a *AggregateBase) EnableApplyOnStore(aggregate Aggregate) {
+	if aggregate == nil {
+		a.applyOnStore = a.ApplyEvent
+		return
+	}
+	a.applyOnStore = aggregate.ApplyEvent
+}
+
+// AppliesOnStore implements the AppliesOnStore method of the
+// ApplyOnStoreAggregate interface.
+func (a *AggregateBase) AppliesOnStore() bool {
+	return a.applyOnStore != nil
 }
 
 // GetUncommittedEvents gets all uncommitted events for storing.
@@ -118,46 +210,22 @@ func (a *AggregateBase) ClearUncommittedEvents() {
 	a.uncommittedEvents = []Event{}
 }
 
-var aggregates = make(map[AggregateType]func(UUID) Aggregate)
-var registerAggregateLock sync.RWMutex
-
 // ErrAggregateNotRegistered is when no aggregate factory was registered.
 var ErrAggregateNotRegistered = errors.New("aggregate not registered")
 
-// RegisterAggregate registers an aggregate factory for a type. The factory is
-// used to create concrete aggregate types when loading from the database.
+// RegisterAggregate registers an aggregate factory for a type in the default
+// registry. The factory is used to create concrete aggregate types when
+// loading from the database. It panics if the type is empty or already
+// registered.
 //
 // An example would be:
 //     RegisterAggregate(func(id UUID) Aggregate { return &MyAggregate{id} })
 func RegisterAggregate(factory func(UUID) Aggregate) {
-	// TODO: Explore the use of reflect/gob for creating concrete types without
-	// a factory func.
-
-	// Check that the created aggregate matches the type registered.
-	aggregate := factory(NewUUID())
-	if aggregate == nil {
-		panic("eventhorizon: created aggregate is nil")
-	}
-	aggregateType := aggregate.AggregateType()
-	if aggregateType == AggregateType("") {
-		panic("eventhorizon: attempt to register empty aggregate type")
-	}
-
-	registerAggregateLock.Lock()
-	defer registerAggregateLock.Unlock()
-	if _, ok := aggregates[aggregateType]; ok {
-		panic(fmt.Sprintf("eventhorizon: registering duplicate types for %q", aggregateType))
-	}
-	aggregates[aggregateType] = factory
+	mustRegister(defaultRegistry.RegisterAggregate(factory), "aggregate")
 }
 
 // CreateAggregate creates an aggregate of a type with an ID using the factory
 // registered with RegisterAggregate.
 func CreateAggregate(aggregateType AggregateType, id UUID) (Aggregate, error) {
-	registerAggregateLock.RLock()
-	defer registerAggregateLock.RUnlock()
-	if factory, ok := aggregates[aggregateType]; ok {
-		return factory(id), nil
-	}
-	return nil, ErrAggregateNotRegistered
+	return defaultRegistry.CreateAggregate(aggregateType, id)
 }
@@ -15,6 +15,7 @@
 package eventhorizon
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -92,6 +93,59 @@ func TestAggregateClearUncommittedEvents(t *testing.T) {
 	}
 }
 
+func TestAggregateApplyFuncs(t *testing.T) {
+	agg := NewAggregateBase(NewUUID())
+	var applied []string
+	OnEvent(agg, TestEventType, func(e *TestEvent) {
+		applied = append(applied, e.Content)
+	})
+
+	event1 := &TestEvent{NewUUID(), "event1"}
+	agg.ApplyEvent(event1)
+	if !reflect.DeepEqual(applied, []string{"event1"}) {
+		t.Error("the event should be applied:", applied)
+	}
+	agg.ApplyEvent(&TestEvent2{NewUUID(), "other"})
+	if !reflect.DeepEqual(applied, []string{"event1"}) {
+		t.Error("the other event should not be applied:", applied)
+	}
+
+	agg.StoreEvent(event1)
+	if len(applied) != 1 {
+		t.Error("the stored event should not be applied:", applied)
+	}
+	if agg.Version() != 0 {
+		t.Error("the version should be 0:", agg.Version())
+	}
+}
+
+func TestAggregateApplyOnStore(t *testing.T) {
+	agg := NewAggregateBase(NewUUID())
+	if agg.AppliesOnStore() {
+		t.Error("the aggregate should not apply on store")
+	}
+	var applied []string
+	OnEvent(agg, TestEventType, func(e *TestEvent) {
+		applied = append(applied, e.Content)
+	})
+	agg.EnableApplyOnStore(nil)
+	if !agg.AppliesOnStore() {
+		t.Error("the aggregate should apply on store")
+	}
+
+	agg.StoreEvent(&TestEvent{NewUUID(), "event1"})
+	agg.StoreEvent(&TestEvent{NewUUID(), "event2"})
+	if !reflect.DeepEqual(applied, []string{"event1", "event2"}) {
+		t.Error("the events should be applied:", applied)
+	}
+	if agg.Version() != 2 {
+		t.Error("the version should be 2:", agg.Version())
+	}
+	if len(agg.GetUncommittedEvents()) != 2 {
+		t.Error("there should be 2 uncommitted events:", agg.GetUncommittedEvents())
+	}
+}
+
 func TestCreateAggregate(t *testing.T) {
 	id := NewUUID()
 	aggregate, err := CreateAggregate(TestAggregateRegisterType, id)
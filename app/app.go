@@ -0,0 +1,178 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package app starts and closes the buses, stores and read repositories of an
+// application in order.
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrAlreadyStarted is when adding components to or starting an app that is
+// already started.
+var ErrAlreadyStarted = errors.New("app is already started")
+
+// ErrNoComponentDefined is when adding a nil component.
+var ErrNoComponentDefined = errors.New("no component defined")
+
+// Error is returned when a component could not be started or closed.
+type Error struct {
+	Err  error
+	Name string
+}
+
+// Error implements the Error method of the error interface.
+func (e Error) Error() string {
+	return fmt.Sprintf("%s: %s", e.Name, e.Err)
+}
+
+// Unwrap returns the error of the component.
+func (e Error) Unwrap() error {
+	return e.Err
+}
+
+// App starts and closes components, like buses, stores and read repositories,
+// in order. Components are started in the order they are added and closed in
+// the reverse order, which means that stores and read repositories typically
+// are added first and the buses that use them after.
+type App struct {
+	components []component
+	// started is the number of components that are started.
+	started int
+	running bool
+	logger  eh.Logger
+	mu      sync.Mutex
+}
+
+type component struct {
+	name      string
+	lifecycle eh.Lifecycle
+}
+
+// New creates an App without components.
+func New() *App {
+	return &App{
+		logger: eh.DefaultLogger(),
+	}
+}
+
+// SetLogger sets the logger, the default is eventhorizon.DefaultLogger.
+func (a *App) SetLogger(logger eh.Logger) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.logger = logger
+}
+
+// Add adds a component with a name used in logs and errors. It is started
+// after and closed before the components that are already added.
+func (a *App) Add(name string, lifecycle eh.Lifecycle) error {
+	if lifecycle == nil {
+		return ErrNoComponentDefined
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.running {
+		return ErrAlreadyStarted
+	}
+
+	a.components = append(a.components, component{
+		name:      name,
+		lifecycle: lifecycle,
+	})
+	return nil
+}
+
+// Start starts the components in order. If a component could not be started
+// the components that are already started are closed in reverse order, with
+// the same context, and the error is returned.
+func (a *App) Start(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.running {
+		return ErrAlreadyStarted
+	}
+
+	for _, c := range a.components {
+		a.logger.Info("app: starting component", eh.F("component", c.name))
+		if err := c.lifecycle.Start(ctx); err != nil {
+			startErr := Error{Err: err, Name: c.name}
+			a.logger.Error("app: could not start component",
+				eh.F("component", c.name),
+				eh.F("error", err),
+			)
+			if err := a.close(ctx); err != nil {
+				return errors.Join(startErr, err)
+			}
+			return startErr
+		}
+		a.started++
+	}
+
+	a.running = true
+	return nil
+}
+
+// Close closes the started components in reverse order. All components are
+// closed even if some of them fail, and their errors are returned joined. The
+// context limits how long the components wait for the work in progress.
+func (a *App) Close(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.running = false
+	return a.close(ctx)
+}
+
+// Run starts the app and closes it when the context is done, for example by a
+// signal with signal.NotifyContext. The components get the timeout to finish
+// the work in progress when closed.
+func (a *App) Run(ctx context.Context, timeout time.Duration) error {
+	if err := a.Start(ctx); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	closeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	return a.Close(closeCtx)
+}
+
+func (a *App) close(ctx context.Context) error {
+	var errs []error
+	for i := a.started - 1; i >= 0; i-- {
+		c := a.components[i]
+		a.logger.Info("app: closing component", eh.F("component", c.name))
+		if err := c.lifecycle.Close(ctx); err != nil {
+			a.logger.Error("app: could not close component",
+				eh.F("component", c.name),
+				eh.F("error", err),
+			)
+			errs = append(errs, Error{Err: err, Name: c.name})
+		}
+	}
+	a.started = 0
+	return errors.Join(errs...)
+}
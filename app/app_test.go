@@ -0,0 +1,174 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package app
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// recorder records the order in which components are started and closed.
+type recorder struct {
+	calls []string
+}
+
+type mockComponent struct {
+	name     string
+	rec      *recorder
+	startErr error
+	closeErr error
+}
+
+func (c *mockComponent) Start(ctx context.Context) error {
+	c.rec.calls = append(c.rec.calls, "start "+c.name)
+	return c.startErr
+}
+
+func (c *mockComponent) Close(ctx context.Context) error {
+	c.rec.calls = append(c.rec.calls, "close "+c.name)
+	return c.closeErr
+}
+
+func newApp(t *testing.T, components ...*mockComponent) *App {
+	a := New()
+	a.SetLogger(eh.NopLogger{})
+	for _, c := range components {
+		if err := a.Add(c.name, c); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+	return a
+}
+
+func TestApp(t *testing.T) {
+	ctx := context.Background()
+	rec := &recorder{}
+	a := newApp(t,
+		&mockComponent{name: "store", rec: rec},
+		&mockComponent{name: "bus", rec: rec},
+	)
+
+	t.Log("start")
+	if err := a.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := a.Start(ctx); err != ErrAlreadyStarted {
+		t.Error("the error should be correct:", err)
+	}
+	if err := a.Add("late", &mockComponent{name: "late", rec: rec}); err != ErrAlreadyStarted {
+		t.Error("the error should be correct:", err)
+	}
+
+	t.Log("close")
+	if err := a.Close(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	expected := []string{"start store", "start bus", "close bus", "close store"}
+	if !reflect.DeepEqual(rec.calls, expected) {
+		t.Error("the calls should be in order:", rec.calls)
+	}
+
+	t.Log("close again")
+	if err := a.Close(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(rec.calls) != len(expected) {
+		t.Error("there should be no more calls:", rec.calls)
+	}
+
+	if err := a.Add("nil", nil); err != ErrNoComponentDefined {
+		t.Error("the error should be correct:", err)
+	}
+}
+
+func TestAppStartError(t *testing.T) {
+	ctx := context.Background()
+	rec := &recorder{}
+	startErr := errors.New("start error")
+	closeErr := errors.New("close error")
+	a := newApp(t,
+		&mockComponent{name: "store", rec: rec, closeErr: closeErr},
+		&mockComponent{name: "repo", rec: rec},
+		&mockComponent{name: "bus", rec: rec, startErr: startErr},
+		&mockComponent{name: "never", rec: rec},
+	)
+
+	err := a.Start(ctx)
+	if !errors.Is(err, startErr) || !errors.Is(err, closeErr) {
+		t.Error("the error should contain both errors:", err)
+	}
+	var appErr Error
+	if !errors.As(err, &appErr) || appErr.Name != "bus" {
+		t.Error("the error should have the component name:", err)
+	}
+	expected := []string{"start store", "start repo", "start bus", "close repo", "close store"}
+	if !reflect.DeepEqual(rec.calls, expected) {
+		t.Error("the started components should be closed in order:", rec.calls)
+	}
+}
+
+func TestAppCloseError(t *testing.T) {
+	ctx := context.Background()
+	rec := &recorder{}
+	err1 := errors.New("error 1")
+	err2 := errors.New("error 2")
+	a := newApp(t,
+		&mockComponent{name: "store", rec: rec, closeErr: err1},
+		&mockComponent{name: "repo", rec: rec},
+		&mockComponent{name: "bus", rec: rec, closeErr: err2},
+	)
+
+	if err := a.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	err := a.Close(ctx)
+	if !errors.Is(err, err1) || !errors.Is(err, err2) {
+		t.Error("the error should contain both errors:", err)
+	}
+	expected := []string{"start store", "start repo", "start bus", "close bus", "close repo", "close store"}
+	if !reflect.DeepEqual(rec.calls, expected) {
+		t.Error("all components should be closed:", rec.calls)
+	}
+}
+
+func TestAppRun(t *testing.T) {
+	rec := &recorder{}
+	a := newApp(t, &mockComponent{name: "bus", rec: rec})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error)
+	go func() {
+		errCh <- a.Run(ctx, time.Second)
+	}()
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the app should be closed")
+	}
+	expected := []string{"start bus", "close bus"}
+	if !reflect.DeepEqual(rec.calls, expected) {
+		t.Error("the component should be started and closed:", rec.calls)
+	}
+}
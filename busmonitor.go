@@ -0,0 +1,32 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"time"
+)
+
+// BusMonitor is notified about the delivery of events and commands by the
+// remote buses that support it, for example to collect metrics.
+type BusMonitor interface {
+	// Received is called for each received event or command with its type
+	// and the time since it was sent. The lag includes any clock difference
+	// between the sending and receiving hosts.
+	Received(messageType string, lag time.Duration)
+
+	// Reconnected is called when the bus has reconnected to its server after
+	// losing the connection.
+	Reconnected()
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+// Codec is an interface for encoding and decoding events and commands to and
+// from bytes. Stores and buses that serialize data accept a codec with
+// SetCodec, which makes it possible to use the same wire format end to end.
+//
+// There are JSON, BSON, Protobuf and MessagePack implementations in the codec
+// package.
+type Codec interface {
+	// Marshal encodes a value into bytes.
+	Marshal(interface{}) ([]byte, error)
+
+	// Unmarshal decodes bytes into a value, which must be a pointer.
+	Unmarshal([]byte, interface{}) error
+}
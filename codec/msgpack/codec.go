@@ -0,0 +1,38 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package msgpack implements an eventhorizon.Codec using MessagePack.
+package msgpack
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec is a codec that encodes values as MessagePack.
+type Codec struct{}
+
+// NewCodec creates a new Codec.
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// Marshal implements the Marshal method of the eventhorizon.Codec interface.
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal implements the Unmarshal method of the eventhorizon.Codec interface.
+func (c *Codec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package protobuf implements an eventhorizon.Codec using Protocol Buffers.
+package protobuf
+
+import (
+	"errors"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNotProtoMessage is when a value is not a protobuf message.
+var ErrNotProtoMessage = errors.New("not a protobuf message")
+
+// Codec is a codec that encodes values as Protocol Buffers. Only values that
+// are protobuf messages can be encoded, for example events and commands that
+// are generated from .proto files.
+type Codec struct{}
+
+// NewCodec creates a new Codec.
+func NewCodec() *Codec {
+	return &Codec{}
+}
+
+// Marshal implements the Marshal method of the eventhorizon.Codec interface.
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal implements the Unmarshal method of the eventhorizon.Codec interface.
+func (c *Codec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, m)
+}
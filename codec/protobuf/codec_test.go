@@ -0,0 +1,57 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestCodec(t *testing.T) {
+	codec := NewCodec()
+	if codec == nil {
+		t.Fatal("there should be a codec")
+	}
+
+	t.Log("marshal and unmarshal message")
+	msg := wrapperspb.String("message1")
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	decoded := &wrapperspb.StringValue{}
+	if err := codec.Unmarshal(data, decoded); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !proto.Equal(decoded, msg) {
+		t.Error("the decoded message should be correct:", decoded)
+	}
+
+	t.Log("marshal non-proto value")
+	event := &mocks.Event{ID: eh.NewUUID(), Content: "event1"}
+	if _, err := codec.Marshal(event); err != ErrNotProtoMessage {
+		t.Error("there should be a ErrNotProtoMessage error:", err)
+	}
+
+	t.Log("unmarshal into non-proto value")
+	if err := codec.Unmarshal(data, event); err != ErrNotProtoMessage {
+		t.Error("there should be a ErrNotProtoMessage error:", err)
+	}
+}
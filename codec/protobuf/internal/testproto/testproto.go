@@ -0,0 +1,53 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package testproto contains protobuf events and commands used for testing.
+package testproto
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative testproto.proto
+
+import (
+	eh "github.com/looplab/eventhorizon"
+)
+
+const (
+	// AggregateType is the aggregate type for the test messages.
+	AggregateType eh.AggregateType = "ProtoAggregate"
+
+	// EventType is the event type for all versions of Event.
+	EventType eh.EventType = "ProtoEvent"
+
+	// CommandType is the command type for Command.
+	CommandType eh.CommandType = "ProtoCommand"
+)
+
+func (e *Event) AggregateID() eh.UUID            { return eh.UUID(e.GetId()) }
+func (e *Event) AggregateType() eh.AggregateType { return AggregateType }
+func (e *Event) EventType() eh.EventType         { return EventType }
+
+func (e *EventCompatible) AggregateID() eh.UUID            { return eh.UUID(e.GetId()) }
+func (e *EventCompatible) AggregateType() eh.AggregateType { return AggregateType }
+func (e *EventCompatible) EventType() eh.EventType         { return EventType }
+
+func (e *EventRemovedField) AggregateID() eh.UUID            { return eh.UUID(e.GetId()) }
+func (e *EventRemovedField) AggregateType() eh.AggregateType { return AggregateType }
+func (e *EventRemovedField) EventType() eh.EventType         { return EventType }
+
+func (e *EventIncompatible) AggregateID() eh.UUID            { return eh.UUID(e.GetId()) }
+func (e *EventIncompatible) AggregateType() eh.AggregateType { return AggregateType }
+func (e *EventIncompatible) EventType() eh.EventType         { return EventType }
+
+func (c *Command) AggregateID() eh.UUID            { return eh.UUID(c.GetId()) }
+func (c *Command) AggregateType() eh.AggregateType { return AggregateType }
+func (c *Command) CommandType() eh.CommandType     { return CommandType }
@@ -0,0 +1,257 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrAlreadyRegistered is when an event or command type is already registered
+// by something else than the schema registry.
+var ErrAlreadyRegistered = errors.New("already registered")
+
+// SchemaError is returned when a schema is not backward compatible with the
+// schema that was previously registered for the same type.
+type SchemaError struct {
+	Message string
+	Field   string
+	Reason  string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("incompatible schema: %s.%s %s", e.Message, e.Field, e.Reason)
+}
+
+// Registry is a local schema registry for events and commands that are
+// protobuf messages. It registers factories derived from the message
+// descriptors with eventhorizon.RegisterEvent and eventhorizon.RegisterCommand,
+// which removes the need for handwritten factories.
+//
+// Registering a type again with a new schema replaces the message used by the
+// factory, but only if the new schema is backward compatible with the old one,
+// so that already stored events can still be decoded.
+type Registry struct {
+	events   map[eh.EventType]protoreflect.MessageType
+	commands map[eh.CommandType]protoreflect.MessageType
+	mu       sync.RWMutex
+
+	// registry is where the factories are registered.
+	registry *eh.Registry
+}
+
+// NewRegistry creates a new Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		events:   make(map[eh.EventType]protoreflect.MessageType),
+		commands: make(map[eh.CommandType]protoreflect.MessageType),
+		registry: eh.DefaultRegistry(),
+	}
+}
+
+// SetRegistry sets the eventhorizon.Registry to register the factories in,
+// the default is eventhorizon.DefaultRegistry. It must be set before
+// registering any events or commands.
+func (r *Registry) SetRegistry(registry *eh.Registry) {
+	r.registry = registry
+}
+
+// DefaultRegistry is the registry used by RegisterEvent and RegisterCommand.
+var DefaultRegistry = NewRegistry()
+
+// RegisterEvent registers a protobuf event with the DefaultRegistry. It panics
+// if the event could not be registered.
+//
+// An example would be:
+//
+//	RegisterEvent(&pb.InviteCreated{})
+func RegisterEvent(event eh.Event) {
+	if err := DefaultRegistry.RegisterEvent(event); err != nil {
+		panic(fmt.Sprintf("eventhorizon: could not register %q: %s", event.EventType(), err))
+	}
+}
+
+// RegisterCommand registers a protobuf command with the DefaultRegistry. It
+// panics if the command could not be registered.
+//
+// An example would be:
+//
+//	RegisterCommand(&pb.CreateInvite{})
+func RegisterCommand(command eh.Command) {
+	if err := DefaultRegistry.RegisterCommand(command); err != nil {
+		panic(fmt.Sprintf("eventhorizon: could not register %q: %s", command.CommandType(), err))
+	}
+}
+
+// RegisterEvent registers an event that is a protobuf message. Returns
+// ErrNotProtoMessage if the event is not a protobuf message and a SchemaError
+// if it is not compatible with a previously registered schema.
+func (r *Registry) RegisterEvent(event eh.Event) error {
+	m, ok := event.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	eventType := event.EventType()
+	messageType := m.ProtoReflect().Type()
+
+	r.mu.Lock()
+	if old, ok := r.events[eventType]; ok {
+		defer r.mu.Unlock()
+		if err := CheckCompatibility(old.Descriptor(), messageType.Descriptor()); err != nil {
+			return err
+		}
+		r.events[eventType] = messageType
+		return nil
+	}
+	if _, err := r.registry.CreateEvent(eventType); err == nil {
+		r.mu.Unlock()
+		return ErrAlreadyRegistered
+	}
+	r.events[eventType] = messageType
+	r.mu.Unlock()
+
+	// The factory is registered without holding the lock, as it is called
+	// once when registering.
+	return r.registry.RegisterEvent(func() eh.Event {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.events[eventType].New().Interface().(eh.Event)
+	})
+}
+
+// RegisterCommand registers a command that is a protobuf message. Returns
+// ErrNotProtoMessage if the command is not a protobuf message and a
+// SchemaError if it is not compatible with a previously registered schema.
+func (r *Registry) RegisterCommand(command eh.Command) error {
+	m, ok := command.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	commandType := command.CommandType()
+	messageType := m.ProtoReflect().Type()
+
+	r.mu.Lock()
+	if old, ok := r.commands[commandType]; ok {
+		defer r.mu.Unlock()
+		if err := CheckCompatibility(old.Descriptor(), messageType.Descriptor()); err != nil {
+			return err
+		}
+		r.commands[commandType] = messageType
+		return nil
+	}
+	if _, err := r.registry.CreateCommand(commandType); err == nil {
+		r.mu.Unlock()
+		return ErrAlreadyRegistered
+	}
+	r.commands[commandType] = messageType
+	r.mu.Unlock()
+
+	// The factory is registered without holding the lock, as it is called
+	// once when registering.
+	return r.registry.RegisterCommand(func() eh.Command {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return r.commands[commandType].New().Interface().(eh.Command)
+	})
+}
+
+// EventDescriptor returns the message descriptor registered for an event type.
+func (r *Registry) EventDescriptor(eventType eh.EventType) (protoreflect.MessageDescriptor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if messageType, ok := r.events[eventType]; ok {
+		return messageType.Descriptor(), true
+	}
+	return nil, false
+}
+
+// CommandDescriptor returns the message descriptor registered for a command type.
+func (r *Registry) CommandDescriptor(commandType eh.CommandType) (protoreflect.MessageDescriptor, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if messageType, ok := r.commands[commandType]; ok {
+		return messageType.Descriptor(), true
+	}
+	return nil, false
+}
+
+// CheckCompatibility checks that data written with the previous message
+// schema can be read with the next one. Fields may be added, and removed if
+// their numbers are reserved, but existing fields may not change type.
+func CheckCompatibility(prev, next protoreflect.MessageDescriptor) error {
+	return checkMessage(prev, next, map[[2]protoreflect.FullName]bool{})
+}
+
+func checkMessage(prev, next protoreflect.MessageDescriptor, checked map[[2]protoreflect.FullName]bool) error {
+	// Guard against recursive messages.
+	key := [2]protoreflect.FullName{prev.FullName(), next.FullName()}
+	if checked[key] {
+		return nil
+	}
+	checked[key] = true
+
+	prevFields := prev.Fields()
+	for i := 0; i < prevFields.Len(); i++ {
+		prevField := prevFields.Get(i)
+		nextField := next.Fields().ByNumber(prevField.Number())
+		if nextField == nil {
+			if !next.ReservedRanges().Has(prevField.Number()) {
+				return SchemaError{string(next.FullName()), string(prevField.Name()), "is removed without being reserved"}
+			}
+			continue
+		}
+
+		if nextField.Kind() != prevField.Kind() {
+			return SchemaError{string(next.FullName()), string(nextField.Name()),
+				fmt.Sprintf("has changed type from %s to %s", prevField.Kind(), nextField.Kind())}
+		}
+		if nextField.IsList() != prevField.IsList() || nextField.IsMap() != prevField.IsMap() {
+			return SchemaError{string(next.FullName()), string(nextField.Name()), "has changed cardinality"}
+		}
+		if nextField.Cardinality() == protoreflect.Required && prevField.Cardinality() != protoreflect.Required {
+			return SchemaError{string(next.FullName()), string(nextField.Name()), "has become required"}
+		}
+
+		if prevField.Message() != nil {
+			if err := checkMessage(prevField.Message(), nextField.Message(), checked); err != nil {
+				return err
+			}
+		}
+	}
+
+	nextFields := next.Fields()
+	for i := 0; i < nextFields.Len(); i++ {
+		nextField := nextFields.Get(i)
+		if prevFields.ByNumber(nextField.Number()) != nil {
+			continue
+		}
+		if prev.ReservedRanges().Has(nextField.Number()) {
+			return SchemaError{string(next.FullName()), string(nextField.Name()), "reuses a reserved field number"}
+		}
+		if nextField.Cardinality() == protoreflect.Required {
+			return SchemaError{string(next.FullName()), string(nextField.Name()), "is a new required field"}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protobuf
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/codec/protobuf/internal/testproto"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	if r == nil {
+		t.Fatal("there should be a registry")
+	}
+	codec := NewCodec()
+
+	t.Log("register non-proto event")
+	if err := r.RegisterEvent(&mocks.Event{}); err != ErrNotProtoMessage {
+		t.Error("there should be a ErrNotProtoMessage error:", err)
+	}
+
+	t.Log("register event registered elsewhere")
+	if err := r.RegisterEvent(&testproto.Event{}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := NewRegistry().RegisterEvent(&testproto.Event{}); err != ErrAlreadyRegistered {
+		t.Error("there should be a ErrAlreadyRegistered error:", err)
+	}
+
+	t.Log("create and decode event")
+	data, err := codec.Marshal(&testproto.Event{Id: "id1", Content: "event1"})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	event, err := eh.CreateEvent(testproto.EventType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, ok := event.(*testproto.Event); !ok {
+		t.Errorf("the event should be of the correct type: %T", event)
+	}
+	if err := codec.Unmarshal(data, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !proto.Equal(event.(proto.Message), &testproto.Event{Id: "id1", Content: "event1"}) {
+		t.Error("the event should be correct:", event)
+	}
+
+	t.Log("register compatible event schema")
+	if err := r.RegisterEvent(&testproto.EventCompatible{}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	event, err = eh.CreateEvent(testproto.EventType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := codec.Unmarshal(data, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !proto.Equal(event.(proto.Message), &testproto.EventCompatible{Id: "id1", Content: "event1"}) {
+		t.Error("the event should be correct:", event)
+	}
+	if desc, ok := r.EventDescriptor(testproto.EventType); !ok ||
+		desc.FullName() != "eventhorizon.testproto.EventCompatible" {
+		t.Error("the descriptor should be correct:", desc)
+	}
+
+	t.Log("register incompatible event schema")
+	err = r.RegisterEvent(&testproto.EventIncompatible{})
+	if err, ok := err.(SchemaError); !ok || err.Field != "content" {
+		t.Error("there should be a schema error:", err)
+	}
+	if _, ok := mustCreateEvent(t).(*testproto.EventCompatible); !ok {
+		t.Error("the registered schema should not change")
+	}
+
+	t.Log("register command")
+	if err := r.RegisterCommand(&testproto.Command{}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	command, err := eh.CreateCommand(testproto.CommandType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, ok := command.(*testproto.Command); !ok {
+		t.Errorf("the command should be of the correct type: %T", command)
+	}
+}
+
+func TestRegistrySetRegistry(t *testing.T) {
+	registry := eh.NewRegistry()
+	r := NewRegistry()
+	r.SetRegistry(registry)
+
+	// The event type is not registered in the separate registry, even if it
+	// is in the default registry.
+	if err := r.RegisterEvent(&testproto.Event{}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	event, err := registry.CreateEvent(testproto.EventType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, ok := event.(*testproto.Event); !ok {
+		t.Errorf("the event should be of the correct type: %T", event)
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	event := (&testproto.Event{}).ProtoReflect().Descriptor()
+	compatible := (&testproto.EventCompatible{}).ProtoReflect().Descriptor()
+	removed := (&testproto.EventRemovedField{}).ProtoReflect().Descriptor()
+	incompatible := (&testproto.EventIncompatible{}).ProtoReflect().Descriptor()
+
+	if err := CheckCompatibility(event, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := CheckCompatibility(event, compatible); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := CheckCompatibility(event, removed); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := CheckCompatibility(removed, event); err == nil {
+		t.Error("there should be an error for reusing a reserved field")
+	}
+	if err := CheckCompatibility(compatible, event); err == nil {
+		t.Error("there should be an error for removing a field")
+	}
+	if err := CheckCompatibility(event, incompatible); err == nil {
+		t.Error("there should be an error for changing a field type")
+	}
+}
+
+func mustCreateEvent(t *testing.T) eh.Event {
+	event, err := eh.CreateEvent(testproto.EventType)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	return event
+}
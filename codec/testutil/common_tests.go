@@ -0,0 +1,68 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"reflect"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// CodecCommonTests are test cases that are common to all codecs that can
+// encode plain Go structs.
+func CodecCommonTests(t *testing.T, codec eh.Codec) {
+	t.Log("marshal and unmarshal event")
+	event := &mocks.Event{ID: eh.NewUUID(), Content: "event1"}
+	data, err := codec.Marshal(event)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	decodedEvent := &mocks.Event{}
+	if err := codec.Unmarshal(data, decodedEvent); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(decodedEvent, event) {
+		t.Error("the decoded event should be correct:", decodedEvent)
+	}
+
+	t.Log("marshal and unmarshal command")
+	command := &mocks.Command{ID: eh.NewUUID(), Content: "command1"}
+	data, err = codec.Marshal(command)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	decodedCommand := &mocks.Command{}
+	if err := codec.Unmarshal(data, decodedCommand); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(decodedCommand, command) {
+		t.Error("the decoded command should be correct:", decodedCommand)
+	}
+
+	t.Log("unmarshal into registered event")
+	newEvent, err := eh.CreateEvent(mocks.EventType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	data, _ = codec.Marshal(event)
+	if err := codec.Unmarshal(data, newEvent); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(newEvent, event) {
+		t.Error("the decoded event should be correct:", newEvent)
+	}
+}
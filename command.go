@@ -16,8 +16,6 @@ package eventhorizon
 
 import (
 	"errors"
-	"fmt"
-	"sync"
 )
 
 // Command is a domain command that is sent to a Dispatcher.
@@ -44,46 +42,21 @@ type Command interface {
 // CommandType is the type of a command, used as its unique identifier.
 type CommandType string
 
-var commands = make(map[CommandType]func() Command)
-var registerCommandLock sync.RWMutex
-
 // ErrCommandNotRegistered is when no command factory was registered.
 var ErrCommandNotRegistered = errors.New("command not registered")
 
-// RegisterCommand registers an command factory for a type. The factory is
-// used to create concrete command types.
+// RegisterCommand registers an command factory for a type in the default
+// registry. The factory is used to create concrete command types. It panics if
+// the type is empty or already registered.
 //
 // An example would be:
 //     RegisterCommand(func() Command { return &MyCommand{} })
 func RegisterCommand(factory func() Command) {
-	// TODO: Explore the use of reflect/gob for creating concrete types without
-	// a factory func.
-
-	// Check that the created command matches the type registered.
-	command := factory()
-	if command == nil {
-		panic("eventhorizon: created command is nil")
-	}
-	commandType := command.CommandType()
-	if commandType == CommandType("") {
-		panic("eventhorizon: attempt to register empty command type")
-	}
-
-	registerCommandLock.Lock()
-	defer registerCommandLock.Unlock()
-	if _, ok := commands[commandType]; ok {
-		panic(fmt.Sprintf("eventhorizon: registering duplicate types for %q", commandType))
-	}
-	commands[commandType] = factory
+	mustRegister(defaultRegistry.RegisterCommand(factory), "command")
 }
 
-// CreateCommand creates an command of a type with an ID using the factory
-// registered with RegisterCommand.
+// CreateCommand creates an command of a type using the factory registered
+// with RegisterCommand.
 func CreateCommand(commandType CommandType) (Command, error) {
-	registerCommandLock.RLock()
-	defer registerCommandLock.RUnlock()
-	if factory, ok := commands[commandType]; ok {
-		return factory(), nil
-	}
-	return nil, ErrCommandNotRegistered
+	return defaultRegistry.CreateCommand(commandType)
 }
@@ -1,6 +1,8 @@
 package distributed
 
 import (
+	"context"
+
 	eh "github.com/looplab/eventhorizon"
 )
 
@@ -8,3 +10,11 @@ type CommandBusConnector interface {
 	Send(eh.Command) error
 	Subscribe(eh.CommandHandler, eh.CommandType) error
 }
+
+// ContextCommandBusConnector is a CommandBusConnector that can send commands
+// with the values of a context, which are passed on to the remote handlers.
+type ContextCommandBusConnector interface {
+	CommandBusConnector
+
+	SendContext(context.Context, eh.Command) error
+}
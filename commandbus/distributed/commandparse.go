@@ -31,3 +31,24 @@ func (jcp *JsonCommandParse) Decode(msg string, command eh.Command) (eh.Command,
 		return command, nil
 	}
 }
+
+// CodecCommandParse is a CommandParse that uses an eventhorizon.Codec, for
+// example to send commands as Protobuf or MessagePack instead of JSON.
+type CodecCommandParse struct {
+	Codec eh.Codec
+}
+
+func (ccp *CodecCommandParse) Encode(command eh.Command) (string, error) {
+	msg, err := ccp.Codec.Marshal(command)
+	if err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func (ccp *CodecCommandParse) Decode(msg string, command eh.Command) (eh.Command, error) {
+	if err := ccp.Codec.Unmarshal([]byte(msg), command); err != nil {
+		return nil, err
+	}
+	return command, nil
+}
@@ -1,6 +1,8 @@
 package distributed
 
 import (
+	"context"
+
 	eh "github.com/looplab/eventhorizon"
 )
 
@@ -19,6 +21,9 @@ func NewDistributedCommandBus(domain string) *DistributedCommandBus {
 			commandParse:  &JsonCommandParse{},
 			routeStrategy: &StaticRoutingStrategy{domain: domain},
 			configs:       Config{broker: "tcp://localhost:1883", username: "guest", password: "guest", cleansession: false},
+			logger:        eh.DefaultLogger(),
+			registry:      eh.DefaultRegistry(),
+			exit:          make(chan struct{}),
 		},
 	}
 	return b
@@ -32,14 +37,94 @@ func NewCustomDistributedCommandBus(connector CommandBusConnector) *DistributedC
 	return b
 }
 
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts the connector, if supported by the connector.
+func (b *DistributedCommandBus) Start(ctx context.Context) error {
+	if c, ok := b.connector.(eh.Lifecycle); ok {
+		return c.Start(ctx)
+	}
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the connector, if supported by the connector.
+func (b *DistributedCommandBus) Close(ctx context.Context) error {
+	if c, ok := b.connector.(eh.Lifecycle); ok {
+		return c.Close(ctx)
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It checks the connector, if supported
+// by the connector.
+func (b *DistributedCommandBus) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if c, ok := b.connector.(eh.HealthChecker); ok {
+		return c.CheckHealth(ctx)
+	}
+	return eh.Health{}, nil
+}
+
 // HandleCommand handles a command with a handler capable of handling it.
 func (b *DistributedCommandBus) HandleCommand(command eh.Command) error {
 
 	return b.connector.Send(command)
 }
 
+// HandleCommandContext implements the HandleCommandContext method of the
+// eventhorizon.ContextCommandBus interface. The command is not sent if the
+// context is done. The context values are passed on to the remote handler if
+// the connector is a ContextCommandBusConnector.
+func (b *DistributedCommandBus) HandleCommandContext(ctx context.Context, command eh.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if c, ok := b.connector.(ContextCommandBusConnector); ok {
+		return c.SendContext(ctx, command)
+	}
+	return b.connector.Send(command)
+}
+
 // SetHandler adds a handler for a specific command.
 func (b *DistributedCommandBus) SetHandler(handler eh.CommandHandler, commandType eh.CommandType) error {
 
 	return b.connector.Subscribe(handler, commandType)
 }
+
+// SetCodec sets the codec to use for commands, if supported by the connector.
+func (b *DistributedCommandBus) SetCodec(codec eh.Codec) {
+	if c, ok := b.connector.(interface {
+		SetCodec(eh.Codec)
+	}); ok {
+		c.SetCodec(codec)
+	}
+}
+
+// SetMonitor sets a monitor that is notified about the lag of received
+// commands and about reconnects, if supported by the connector.
+func (b *DistributedCommandBus) SetMonitor(monitor eh.BusMonitor) {
+	if c, ok := b.connector.(interface {
+		SetMonitor(eh.BusMonitor)
+	}); ok {
+		c.SetMonitor(monitor)
+	}
+}
+
+// SetLogger sets the logger, if supported by the connector.
+func (b *DistributedCommandBus) SetLogger(logger eh.Logger) {
+	if c, ok := b.connector.(interface {
+		SetLogger(eh.Logger)
+	}); ok {
+		c.SetLogger(logger)
+	}
+}
+
+// SetRegistry sets the registry used to create the received commands, if
+// supported by the connector.
+func (b *DistributedCommandBus) SetRegistry(registry *eh.Registry) {
+	if c, ok := b.connector.(interface {
+		SetRegistry(*eh.Registry)
+	}); ok {
+		c.SetRegistry(registry)
+	}
+}
@@ -37,6 +37,7 @@ func TestExample(t *testing.T) {
 		commandParse:  &JsonCommandParse{},
 		routeStrategy: &StaticRoutingStrategy{domain: "domain"},
 		configs:       Config{broker: "tcp://localhost:1883", username: "guest", password: "guest", cleansession: false},
+		exit:          make(chan struct{}),
 	})
 	rbc.SetHandler(handler, domain.CreateInviteCommand)
 	log.Println("step4")
@@ -1,20 +1,42 @@
 package distributed
 
 import (
-	"log"
+	"context"
+	"errors"
+	"sync"
+	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jpillora/backoff"
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/internal/message"
 )
 
 var subscribflag bool = false
 
+// ErrNotSubscribed is when a connector with handlers is not subscribed to the
+// broker.
+var ErrNotSubscribed = errors.New("not subscribed")
+
 type RabbitMQTTCBC struct {
 	handlers      map[eh.CommandType]eh.CommandHandler
 	topicStrategy TopicStrategy
 	commandParse  CommandParse
 	routeStrategy CommandRoute
 	configs       Config
+	monitor       eh.BusMonitor
+	logger        eh.Logger
+	registry      *eh.Registry
+
+	// exit is closed to stop receiving, wg tracks the receive goroutines and
+	// subclients are their connections, guarded by mu.
+	exit       chan struct{}
+	exitOnce   sync.Once
+	wg         sync.WaitGroup
+	subclients []MQTT.Client
+	listeners  int
+	closed     bool
+	mu         sync.Mutex
 }
 
 type Config struct {
@@ -51,7 +73,146 @@ func (rbmcbc *RabbitMQTTCBC) initOpts() *MQTT.ClientOptions {
 
 	return opts
 }
+
+// SetCodec sets the codec to use for the commands sent over MQTT.
+func (rbmcbc *RabbitMQTTCBC) SetCodec(codec eh.Codec) {
+	rbmcbc.commandParse = &CodecCommandParse{Codec: codec}
+}
+
+// SetMonitor sets a monitor that is notified about the lag of received
+// commands and about reconnects to the broker.
+func (rbmcbc *RabbitMQTTCBC) SetMonitor(monitor eh.BusMonitor) {
+	rbmcbc.monitor = monitor
+}
+
+// SetLogger sets the logger, the default is eventhorizon.DefaultLogger. The
+// payloads of commands are logged if enabled with eventhorizon.WithPayloads.
+func (rbmcbc *RabbitMQTTCBC) SetLogger(logger eh.Logger) {
+	rbmcbc.logger = logger
+}
+
+// SetRegistry sets the registry used to create the received commands, the
+// default is eventhorizon.DefaultRegistry.
+func (rbmcbc *RabbitMQTTCBC) SetRegistry(registry *eh.Registry) {
+	rbmcbc.registry = registry
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It connects to the broker and returns the error if it can not be reached.
+// Subscribing when handlers are added is retried until the connector is closed.
+func (rbmcbc *RabbitMQTTCBC) Start(ctx context.Context) error {
+	rbmcbc.mu.Lock()
+	closed := rbmcbc.closed
+	rbmcbc.mu.Unlock()
+	if closed {
+		return eh.ErrClosed
+	}
+
+	return rbmcbc.ping(ctx)
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// Commands sent after closing return eventhorizon.ErrClosed. It stops
+// receiving, waits for the command being handled until the context is done
+// and disconnects from the broker.
+func (rbmcbc *RabbitMQTTCBC) Close(ctx context.Context) error {
+	rbmcbc.mu.Lock()
+	rbmcbc.closed = true
+	rbmcbc.mu.Unlock()
+	rbmcbc.exitOnce.Do(func() { close(rbmcbc.exit) })
+
+	stopped := make(chan struct{})
+	go func() {
+		rbmcbc.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	rbmcbc.mu.Lock()
+	defer rbmcbc.mu.Unlock()
+	for _, c := range rbmcbc.subclients {
+		c.Disconnect(250)
+	}
+	rbmcbc.subclients = nil
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It connects to the broker and reports
+// if the connector is subscribed, which it must be once handlers are added.
+func (rbmcbc *RabbitMQTTCBC) CheckHealth(ctx context.Context) (eh.Health, error) {
+	rbmcbc.mu.Lock()
+	closed := rbmcbc.closed
+	listeners := rbmcbc.listeners
+	subscribed := listeners > 0 && len(rbmcbc.subclients) == listeners
+	for _, c := range rbmcbc.subclients {
+		if !c.IsConnectionOpen() {
+			subscribed = false
+		}
+	}
+	rbmcbc.mu.Unlock()
+	if closed {
+		return eh.Health{}, eh.ErrClosed
+	}
+
+	start := time.Now()
+	err := rbmcbc.ping(ctx)
+	if ctx.Err() != nil {
+		return eh.Health{}, ctx.Err()
+	}
+	health := eh.Health{
+		Latency: time.Since(start),
+		Details: map[string]interface{}{"subscribed": subscribed},
+	}
+	if err != nil {
+		return health, err
+	}
+
+	if listeners > 0 && !subscribed {
+		return health, ErrNotSubscribed
+	}
+	return health, nil
+}
+
+// ping connects to the broker and disconnects again, or returns the error of
+// connecting or of the context.
+func (rbmcbc *RabbitMQTTCBC) ping(ctx context.Context) error {
+	client := MQTT.NewClient(rbmcbc.initOpts())
+	token := client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		go func() {
+			<-token.Done()
+			client.Disconnect(0)
+		}()
+		return ctx.Err()
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+	client.Disconnect(250)
+	return nil
+}
+
 func (rbmcbc *RabbitMQTTCBC) Send(command eh.Command) error {
+	return rbmcbc.SendContext(context.Background(), command)
+}
+
+// SendContext sends the command with the values of the context as message
+// headers.
+func (rbmcbc *RabbitMQTTCBC) SendContext(ctx context.Context, command eh.Command) error {
+	rbmcbc.mu.Lock()
+	closed := rbmcbc.closed
+	rbmcbc.mu.Unlock()
+	if closed {
+		return eh.ErrClosed
+	}
+
 	opts := rbmcbc.initOpts()
 	client := MQTT.NewClient(opts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
@@ -62,8 +223,17 @@ func (rbmcbc *RabbitMQTTCBC) Send(command eh.Command) error {
 	if err != nil {
 		return err
 	}
-	log.Println("routekey : " + string(rbmcbc.routeStrategy.GetRoutingKey(command)))
-	token := client.Publish(string(rbmcbc.routeStrategy.GetRoutingKey(command)), byte(0), false, msg)
+	rbmcbc.logger.Debug("commandbus: send command",
+		eh.F("command_type", command.CommandType()),
+		eh.F("routing_key", rbmcbc.routeStrategy.GetRoutingKey(command)),
+	)
+	eh.LogPayload(rbmcbc.logger, "commandbus: send command", []byte(msg),
+		eh.F("command_type", command.CommandType()),
+	)
+	headers := message.ContextHeaders(ctx, nil)
+	message.SetSentAt(headers, time.Now())
+	payload := message.Encode(headers, []byte(msg))
+	token := client.Publish(string(rbmcbc.routeStrategy.GetRoutingKey(command)), byte(0), false, payload)
 	token.Wait()
 
 	client.Disconnect(250)
@@ -77,6 +247,13 @@ func (rbmcbc *RabbitMQTTCBC) Subscribe(commandHandler eh.CommandHandler, command
 	}
 	rbmcbc.handlers[commandType] = commandHandler
 	if !subscribflag {
+		rbmcbc.mu.Lock()
+		defer rbmcbc.mu.Unlock()
+		if rbmcbc.closed {
+			return eh.ErrClosed
+		}
+		rbmcbc.wg.Add(1)
+		rbmcbc.listeners++
 		go rbmcbc.connectServer()
 		subscribflag = true
 	}
@@ -85,48 +262,119 @@ func (rbmcbc *RabbitMQTTCBC) Subscribe(commandHandler eh.CommandHandler, command
 }
 
 func (rbmcbc *RabbitMQTTCBC) connectServer() {
+	defer rbmcbc.wg.Done()
 
-	opts := rbmcbc.initOpts()
 	choke := make(chan [2]string)
 
+	// Used for exponential fallback on failed subscriptions.
+	delay := &backoff.Backoff{Max: 5 * time.Minute}
+	for {
+		err := rbmcbc.subscribe(choke)
+		if err == nil {
+			break
+		}
+		d := delay.Duration()
+		rbmcbc.logger.Warn("commandbus: subscribe failed, retrying",
+			eh.F("delay", d),
+			eh.F("error", err),
+		)
+		select {
+		case <-time.After(d):
+		case <-rbmcbc.exit:
+			return
+		}
+	}
+	rbmcbc.listening(choke)
+}
+
+// subscribe connects to the broker and subscribes to the topic pattern, with
+// the received messages sent on choke.
+func (rbmcbc *RabbitMQTTCBC) subscribe(choke chan [2]string) error {
+	opts := rbmcbc.initOpts()
+
 	opts.SetDefaultPublishHandler(func(client MQTT.Client, msg MQTT.Message) {
-		log.Printf("RECEIVED TOPIC: %s MESSAGE: %s\n", msg.Topic(), string(msg.Payload()))
-		choke <- [2]string{msg.Topic(), string(msg.Payload())}
+		rbmcbc.logger.Debug("commandbus: received message", eh.F("topic", msg.Topic()))
+		select {
+		case choke <- [2]string{msg.Topic(), string(msg.Payload())}:
+		case <-rbmcbc.exit:
+		}
+	})
+	connected := false
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		if connected && rbmcbc.monitor != nil {
+			rbmcbc.monitor.Reconnected()
+		}
+		connected = true
 	})
 	subclient := MQTT.NewClient(opts)
 	if token := subclient.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+		return token.Error()
 	}
-	log.Println("route : " + string(rbmcbc.routeStrategy.GetTopicPattern()))
+	rbmcbc.logger.Info("commandbus: subscribing", eh.F("topic", rbmcbc.routeStrategy.GetTopicPattern()))
 
 	if token := subclient.Subscribe(string(rbmcbc.routeStrategy.GetTopicPattern()), 0, nil); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+		subclient.Disconnect(0)
+		return token.Error()
 	}
-	rbmcbc.listening(choke)
+	rbmcbc.mu.Lock()
+	rbmcbc.subclients = append(rbmcbc.subclients, subclient)
+	rbmcbc.mu.Unlock()
+	return nil
 }
 
 func (rbmcbc *RabbitMQTTCBC) listening(choke chan [2]string) {
-	log.Println("start to listen ")
+	rbmcbc.logger.Info("commandbus: start receiving")
 	for {
-		incoming := <-choke
+		var incoming [2]string
+		select {
+		case incoming = <-choke:
+		case <-rbmcbc.exit:
+			rbmcbc.logger.Info("commandbus: stop receiving")
+			return
+		}
 		ct := rbmcbc.topicStrategy.ParseTopic(incoming[0])
 		if ct == "" {
 			continue
 		} else {
 			if handler, ok := rbmcbc.handlers[ct]; ok {
-				command, err := eh.CreateCommand(ct)
+				command, err := rbmcbc.registry.CreateCommand(ct)
 				if err != nil {
-					log.Fatal("get command origin type error" + string(err.Error()))
+					rbmcbc.logger.Error("commandbus: could not receive command",
+						eh.F("command_type", ct),
+						eh.F("error", err),
+					)
 					continue
 				} else {
-					command, err = rbmcbc.commandParse.Decode(incoming[1], command)
+					headers, data, err := message.Decode([]byte(incoming[1]))
+					if err != nil {
+						rbmcbc.logger.Error("commandbus: could not receive command",
+							eh.F("command_type", ct),
+							eh.F("error", err),
+						)
+						continue
+					}
+					if sentAt, ok := message.SentAt(headers); ok && rbmcbc.monitor != nil {
+						rbmcbc.monitor.Received(string(ct), time.Since(sentAt))
+					}
+					eh.LogPayload(rbmcbc.logger, "commandbus: receive command", data,
+						eh.F("command_type", ct),
+					)
+					command, err = rbmcbc.commandParse.Decode(string(data), command)
 					if err != nil {
-						log.Fatal("generate commande instance error" + string(err.Error()))
+						rbmcbc.logger.Error("commandbus: could not receive command",
+							eh.F("command_type", ct),
+							eh.F("error", err),
+						)
 						continue
 					} else {
-						err = handler.HandleCommand(command)
+						ctx := message.Context(headers)
+						err = eh.AdaptCommandHandler(handler).HandleCommandContext(ctx, command)
 						if err != nil {
-							log.Fatal("commandhandle error : " + string(err.Error()))
+							rbmcbc.logger.Error("commandbus: could not handle command",
+								eh.F("command_type", ct),
+								eh.F("aggregate_id", command.AggregateID()),
+								eh.F("error", err),
+							)
 						}
 					}
 				}
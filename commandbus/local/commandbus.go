@@ -15,6 +15,7 @@
 package local
 
 import (
+	"context"
 	"sync"
 
 	eh "github.com/looplab/eventhorizon"
@@ -25,6 +26,11 @@ import (
 type CommandBus struct {
 	handlers   map[eh.CommandType]eh.CommandHandler
 	handlersMu sync.RWMutex
+
+	// inFlight tracks the commands that are handled, closed is guarded by
+	// handlersMu.
+	inFlight sync.WaitGroup
+	closed   bool
 }
 
 // NewCommandBus creates a CommandBus.
@@ -37,14 +43,58 @@ func NewCommandBus() *CommandBus {
 
 // HandleCommand handles a command with a handler capable of handling it.
 func (b *CommandBus) HandleCommand(command eh.Command) error {
+	return b.HandleCommandContext(context.Background(), command)
+}
+
+// HandleCommandContext implements the HandleCommandContext method of the
+// eventhorizon.ContextCommandBus interface. Commands handled after closing
+// return eventhorizon.ErrClosed.
+func (b *CommandBus) HandleCommandContext(ctx context.Context, command eh.Command) error {
 	b.handlersMu.RLock()
-	defer b.handlersMu.RUnlock()
+	if b.closed {
+		b.handlersMu.RUnlock()
+		return eh.ErrClosed
+	}
+	handler, ok := b.handlers[command.CommandType()]
+	if ok {
+		b.inFlight.Add(1)
+	}
+	b.handlersMu.RUnlock()
 
-	if handler, ok := b.handlers[command.CommandType()]; ok {
-		return handler.HandleCommand(command)
+	if !ok {
+		return eh.ErrHandlerNotFound
 	}
 
-	return eh.ErrHandlerNotFound
+	defer b.inFlight.Done()
+	return eh.AdaptCommandHandler(handler).HandleCommandContext(ctx, command)
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// The bus needs no starting and can be used directly.
+func (b *CommandBus) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It stops accepting commands and waits for the commands that are handled
+// until the context is done.
+func (b *CommandBus) Close(ctx context.Context) error {
+	b.handlersMu.Lock()
+	b.closed = true
+	b.handlersMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // SetHandler adds a handler for a specific command.
@@ -15,7 +15,9 @@
 package local
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/mocks"
@@ -55,3 +57,54 @@ func TestCommandBus(t *testing.T) {
 		t.Error("there should be a ErrHandlerAlreadySet error:", err)
 	}
 }
+
+func TestCommandBusClose(t *testing.T) {
+	ctx := context.Background()
+	bus := NewCommandBus()
+	if err := bus.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := blockingHandler{started, release}
+	if err := bus.SetHandler(handler, mocks.CommandType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	command1 := &mocks.Command{eh.NewUUID(), "command1"}
+	handled := make(chan error)
+	go func() {
+		handled <- bus.HandleCommand(command1)
+	}()
+	<-started
+
+	t.Log("close with a command that is not done in time")
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := bus.Close(timeoutCtx); err != context.DeadlineExceeded {
+		t.Error("there should be a DeadlineExceeded error:", err)
+	}
+	if err := bus.HandleCommand(command1); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+
+	t.Log("close when the command is done")
+	close(release)
+	if err := <-handled; err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := bus.Close(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+type blockingHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h blockingHandler) HandleCommand(command eh.Command) error {
+	close(h.started)
+	<-h.release
+	return nil
+}
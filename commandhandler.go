@@ -15,9 +15,8 @@
 package eventhorizon
 
 import (
+	"context"
 	"errors"
-	"reflect"
-	"time"
 )
 
 // ErrNilRepository is when a dispatcher is created with a nil repository.
@@ -29,13 +28,23 @@ var ErrAggregateAlreadySet = errors.New("aggregate is already set")
 // ErrAggregateNotFound is when no aggregate can be found.
 var ErrAggregateNotFound = errors.New("no aggregate for command")
 
-// CommandFieldError is returned by Dispatch when a field is incorrect.
+// CommandFieldError is a field of a command that is missing or invalid, as
+// part of the CommandFieldErrors returned when validating commands.
 type CommandFieldError struct {
+	// Field is the name of the field, with the path of the struct or slice
+	// element for nested fields, for example "Address.Street" or
+	// "Items[1].Name".
 	Field string
+	// Reason is why the field is invalid, "missing" for missing fields.
+	Reason string
 }
 
+// Error implements the Error method of the error interface.
 func (c CommandFieldError) Error() string {
-	return "missing field: " + c.Field
+	if c.Reason == "" || c.Reason == "missing" {
+		return "missing field: " + c.Field
+	}
+	return "invalid field: " + c.Field + ": " + c.Reason
 }
 
 // AggregateCommandHandler dispatches commands to registered aggregates.
@@ -78,9 +87,23 @@ func (h *AggregateCommandHandler) SetAggregate(aggregateType AggregateType, comm
 	return nil
 }
 
-// HandleCommand handles a command with the registered aggregate.
-// Returns ErrAggregateNotFound if no aggregate could be found.
+// HandleCommand handles a command with the registered aggregate. The command
+// is first validated with ValidateCommand, which returns CommandFieldErrors
+// for invalid fields. Returns ErrAggregateNotFound if no aggregate could be
+// found.
 func (h *AggregateCommandHandler) HandleCommand(command Command) error {
+	return h.HandleCommandContext(context.Background(), command)
+}
+
+// HandleCommandContext implements the HandleCommandContext method of the
+// eventhorizon.ContextCommandHandler interface. The aggregate is loaded and
+// saved with the context, using the repository of the tenant of the context
+// or command if any.
+func (h *AggregateCommandHandler) HandleCommandContext(ctx context.Context, command Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	err := h.checkCommand(command)
 	if err != nil {
 		return err
@@ -91,7 +114,27 @@ func (h *AggregateCommandHandler) HandleCommand(command Command) error {
 		return ErrAggregateNotFound
 	}
 
-	aggregate, err := h.repository.Load(aggregateType, command.AggregateID())
+	// Use the repository of the tenant for commands of a tenant.
+	tenantID, _ := TenantIDFromContext(ctx)
+	if c, ok := command.(TenantCommand); ok && c.TenantID() != "" {
+		if tenantID != "" && tenantID != c.TenantID() {
+			return ErrCrossTenantAccess
+		}
+		tenantID = c.TenantID()
+	}
+	repository := h.repository
+	if tenantID != "" {
+		r, ok := repository.(MultiTenantRepository)
+		if !ok {
+			return ErrTenantsNotSupported
+		}
+		if repository, err = r.ForTenant(tenantID); err != nil {
+			return err
+		}
+	}
+	contextRepository := AdaptRepository(repository)
+
+	aggregate, err := contextRepository.LoadContext(ctx, aggregateType, command.AggregateID())
 	if err != nil {
 		return err
 	} else if aggregate == nil {
@@ -102,7 +145,7 @@ func (h *AggregateCommandHandler) HandleCommand(command Command) error {
 		return err
 	}
 
-	if err = h.repository.Save(aggregate); err != nil {
+	if err = contextRepository.SaveContext(ctx, aggregate); err != nil {
 		return err
 	}
 
@@ -110,58 +153,5 @@ func (h *AggregateCommandHandler) HandleCommand(command Command) error {
 }
 
 func (h *AggregateCommandHandler) checkCommand(command Command) error {
-	rv := reflect.Indirect(reflect.ValueOf(command))
-	rt := rv.Type()
-
-	for i := 0; i < rt.NumField(); i++ {
-		field := rt.Field(i)
-		if field.PkgPath != "" {
-			continue // Skip private field.
-		}
-
-		tag := field.Tag.Get("eh")
-		if tag == "optional" {
-			continue // Optional field.
-		}
-
-		if isZero(rv.Field(i)) {
-			return CommandFieldError{field.Name}
-		}
-	}
-	return nil
-}
-
-func isZero(v reflect.Value) bool {
-	switch v.Kind() {
-	case reflect.Func, reflect.Chan, reflect.Uintptr, reflect.Ptr, reflect.UnsafePointer:
-		// Types that are not allowed at all.
-		// NOTE: Would be better with its own error for this.
-		return true
-	case reflect.Map, reflect.Array, reflect.Slice:
-		return v.IsNil()
-	case reflect.Interface, reflect.String:
-		z := reflect.Zero(v.Type())
-		return v.Interface() == z.Interface()
-	case reflect.Struct:
-		// Special case to get zero values by method.
-		switch obj := v.Interface().(type) {
-		case time.Time:
-			return obj.IsZero()
-		}
-
-		// Check public fields for zero values.
-		z := true
-		for i := 0; i < v.NumField(); i++ {
-			if v.Type().Field(i).PkgPath != "" {
-				continue // Skip private fields.
-			}
-			z = z && isZero(v.Field(i))
-		}
-		return z
-	default:
-		// Don't check for zero for value types:
-		// Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32,
-		// Uint64, Float32, Float64, Complex64, Complex128
-		return false
-	}
+	return ValidateCommand(command)
 }
@@ -0,0 +1,269 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatcher
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoHandlerDefined is when no command handler is defined.
+var ErrNoHandlerDefined = errors.New("no command handler defined")
+
+// ErrInvalidWorkers is when the number of workers is not positive.
+var ErrInvalidWorkers = errors.New("invalid number of workers")
+
+// ErrInvalidQueueSize is when the queue size is not positive.
+var ErrInvalidQueueSize = errors.New("invalid queue size")
+
+// ErrQueueFull is when the queue of an aggregate is full, which callers
+// should handle by retrying later.
+var ErrQueueFull = errors.New("queue is full")
+
+// ErrClosed is when handling commands after the dispatcher is closed, which is
+// eventhorizon.ErrClosed as for other closed components.
+var ErrClosed = eh.ErrClosed
+
+// Dispatcher is a command handler that serializes the commands of each
+// aggregate, typically in front of an eventhorizon.AggregateCommandHandler.
+// Commands are queued per aggregate ID (and tenant) and the queues are
+// processed by a pool of workers, one command at a time for each aggregate,
+// which avoids version conflicts between concurrent commands on the same
+// aggregate while different aggregates are handled in parallel.
+//
+// HandleCommand waits until the command is handled and returns the error of
+// the handler. The queue of each aggregate is bounded, and ErrQueueFull is
+// returned when it is full. A command that is canceled by its context before
+// it is handled is skipped.
+type Dispatcher struct {
+	handler   eh.ContextCommandHandler
+	queueSize int
+
+	queues map[key]*queue
+	// ready are the queues with commands waiting for a worker.
+	ready  *list.List
+	cond   *sync.Cond
+	closed bool
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+}
+
+type key struct {
+	tenantID eh.TenantID
+	id       eh.UUID
+}
+
+// queue is the queue of commands of an aggregate.
+type queue struct {
+	key  key
+	jobs []*job
+}
+
+const (
+	jobQueued int32 = iota
+	jobRunning
+	jobCanceled
+)
+
+type job struct {
+	ctx     context.Context
+	command eh.Command
+	state   int32
+	err     chan error
+}
+
+// NewDispatcher creates a new Dispatcher with a number of workers, and a max
+// number of queued commands per aggregate.
+func NewDispatcher(handler eh.CommandHandler, workers, queueSize int) (*Dispatcher, error) {
+	if handler == nil {
+		return nil, ErrNoHandlerDefined
+	}
+	if workers <= 0 {
+		return nil, ErrInvalidWorkers
+	}
+	if queueSize <= 0 {
+		return nil, ErrInvalidQueueSize
+	}
+
+	d := &Dispatcher{
+		handler:   eh.AdaptCommandHandler(handler),
+		queueSize: queueSize,
+		queues:    make(map[key]*queue),
+		ready:     list.New(),
+	}
+	d.cond = sync.NewCond(&d.mu)
+
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+
+	return d, nil
+}
+
+// HandleCommand implements the HandleCommand method of the
+// eventhorizon.CommandHandler interface.
+func (d *Dispatcher) HandleCommand(command eh.Command) error {
+	return d.HandleCommandContext(context.Background(), command)
+}
+
+// HandleCommandContext implements the HandleCommandContext method of the
+// eventhorizon.ContextCommandHandler interface. Commands are queued by the
+// tenant of the context or command, if any.
+func (d *Dispatcher) HandleCommandContext(ctx context.Context, command eh.Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	k := key{id: command.AggregateID()}
+	k.tenantID, _ = eh.TenantIDFromContext(ctx)
+	if c, ok := command.(eh.TenantCommand); ok && c.TenantID() != "" {
+		k.tenantID = c.TenantID()
+	}
+
+	j := &job{
+		ctx:     ctx,
+		command: command,
+		err:     make(chan error, 1),
+	}
+	if err := d.enqueue(k, j); err != nil {
+		return err
+	}
+
+	select {
+	case err := <-j.err:
+		return err
+	case <-ctx.Done():
+		// Wait for the result if the command is already being handled.
+		if atomic.CompareAndSwapInt32(&j.state, jobQueued, jobCanceled) {
+			return ctx.Err()
+		}
+		return <-j.err
+	}
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// The workers are already started when the dispatcher is created.
+func (d *Dispatcher) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It stops accepting commands and waits for the queued commands to be handled
+// until the context is done, then the error of the context is returned. The
+// workers handle the queued commands also after the context is done.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	d.mu.Lock()
+	d.closed = true
+	d.cond.Broadcast()
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// QueueLength returns the number of queued commands for an aggregate,
+// including a command that is being handled.
+func (d *Dispatcher) QueueLength(tenantID eh.TenantID, id eh.UUID) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if q, ok := d.queues[key{tenantID, id}]; ok {
+		return len(q.jobs)
+	}
+	return 0
+}
+
+func (d *Dispatcher) enqueue(k key, j *job) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrClosed
+	}
+
+	q, ok := d.queues[k]
+	if !ok {
+		q = &queue{key: k}
+		d.queues[k] = q
+	}
+	if len(q.jobs) >= d.queueSize {
+		return ErrQueueFull
+	}
+	q.jobs = append(q.jobs, j)
+
+	// Queues with more commands are already waiting for, or handled by, a
+	// worker.
+	if len(q.jobs) == 1 {
+		d.ready.PushBack(q)
+		d.cond.Signal()
+	}
+
+	return nil
+}
+
+// work handles the commands of the ready queues until the dispatcher is closed
+// and all queues are handled.
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+
+	for {
+		d.mu.Lock()
+		for d.ready.Len() == 0 && !(d.closed && len(d.queues) == 0) {
+			d.cond.Wait()
+		}
+		if d.ready.Len() == 0 {
+			d.mu.Unlock()
+			return
+		}
+		q := d.ready.Remove(d.ready.Front()).(*queue)
+		j := q.jobs[0]
+		d.mu.Unlock()
+
+		if atomic.CompareAndSwapInt32(&j.state, jobQueued, jobRunning) {
+			j.err <- d.handler.HandleCommandContext(j.ctx, j.command)
+		}
+
+		// Let other aggregates be handled before the next command of this
+		// aggregate.
+		d.mu.Lock()
+		q.jobs = q.jobs[1:]
+		if len(q.jobs) > 0 {
+			d.ready.PushBack(q)
+			d.cond.Signal()
+		} else {
+			delete(d.queues, q.key)
+			if d.closed && len(d.queues) == 0 {
+				d.cond.Broadcast()
+			}
+		}
+		d.mu.Unlock()
+	}
+}
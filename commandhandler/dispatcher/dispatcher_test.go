@@ -0,0 +1,220 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// blockingHandler blocks on commands with the content "block" until released,
+// and records the max number of concurrent commands per aggregate.
+type blockingHandler struct {
+	started chan eh.Command
+	release chan struct{}
+
+	running    map[eh.UUID]int
+	maxRunning int
+	handled    []string
+	mu         sync.Mutex
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{
+		started: make(chan eh.Command, 10),
+		release: make(chan struct{}),
+		running: make(map[eh.UUID]int),
+	}
+}
+
+func (h *blockingHandler) HandleCommand(command eh.Command) error {
+	c := command.(*mocks.Command)
+	h.mu.Lock()
+	h.running[c.ID]++
+	if h.running[c.ID] > h.maxRunning {
+		h.maxRunning = h.running[c.ID]
+	}
+	h.mu.Unlock()
+
+	h.started <- command
+	if c.Content == "block" {
+		<-h.release
+	}
+
+	h.mu.Lock()
+	h.running[c.ID]--
+	h.handled = append(h.handled, c.Content)
+	h.mu.Unlock()
+
+	if c.Content == "error" {
+		return errors.New("command error")
+	}
+	return nil
+}
+
+func TestNewDispatcher(t *testing.T) {
+	if _, err := NewDispatcher(nil, 1, 1); err != ErrNoHandlerDefined {
+		t.Error("there should be a ErrNoHandlerDefined error:", err)
+	}
+	if _, err := NewDispatcher(&mocks.CommandHandler{}, 0, 1); err != ErrInvalidWorkers {
+		t.Error("there should be a ErrInvalidWorkers error:", err)
+	}
+	if _, err := NewDispatcher(&mocks.CommandHandler{}, 1, 0); err != ErrInvalidQueueSize {
+		t.Error("there should be a ErrInvalidQueueSize error:", err)
+	}
+
+	handler := &mocks.CommandHandler{}
+	d, err := NewDispatcher(handler, 1, 1)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	command := &mocks.Command{ID: eh.NewUUID(), Content: "command"}
+	if err := d.HandleCommand(command); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if handler.Command != command {
+		t.Error("the command should be handled:", handler.Command)
+	}
+
+	if err := d.Close(context.Background()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := d.HandleCommand(command); err != ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+}
+
+func TestDispatcherSerialization(t *testing.T) {
+	handler := newBlockingHandler()
+	d, err := NewDispatcher(handler, 4, 10)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer d.Close(context.Background())
+
+	id1, id2 := eh.NewUUID(), eh.NewUUID()
+	var wg sync.WaitGroup
+	handle := func(id eh.UUID, content string, expected error) {
+		defer wg.Done()
+		err := d.HandleCommand(&mocks.Command{ID: id, Content: content})
+		if (err == nil) != (expected == nil) || (err != nil && err.Error() != expected.Error()) {
+			t.Error("the error should be correct:", err)
+		}
+	}
+
+	// Block the first aggregate, the second should still be handled.
+	wg.Add(1)
+	go handle(id1, "block", nil)
+	<-handler.started
+	wg.Add(2)
+	go handle(id1, "queued", nil)
+	go handle(id2, "error", errors.New("command error"))
+	if c := <-handler.started; c.AggregateID() != id2 {
+		t.Error("the other aggregate should be handled:", c)
+	}
+
+	select {
+	case c := <-handler.started:
+		t.Error("the queued command should not be handled:", c)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if l := d.QueueLength("", id1); l != 2 {
+		t.Error("there should be 2 queued commands:", l)
+	}
+
+	close(handler.release)
+	wg.Wait()
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if handler.maxRunning != 1 {
+		t.Error("the commands of an aggregate should be handled one at a time:", handler.maxRunning)
+	}
+	if len(handler.handled) != 3 || handler.handled[2] != "queued" {
+		t.Error("all commands should be handled in order:", handler.handled)
+	}
+	if l := d.QueueLength("", id1); l != 0 {
+		t.Error("there should be no queued commands:", l)
+	}
+}
+
+func TestDispatcherBackpressure(t *testing.T) {
+	handler := newBlockingHandler()
+	d, err := NewDispatcher(handler, 2, 2)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer d.Close(context.Background())
+	defer close(handler.release)
+
+	id := eh.NewUUID()
+	go d.HandleCommand(&mocks.Command{ID: id, Content: "block"})
+	<-handler.started
+
+	t.Log("cancel queued command")
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- d.HandleCommandContext(ctx, &mocks.Command{ID: id, Content: "canceled"})
+	}()
+	for d.QueueLength("", id) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Log("queue full")
+	if err := d.HandleCommand(&mocks.Command{ID: id, Content: "full"}); err != ErrQueueFull {
+		t.Error("there should be a ErrQueueFull error:", err)
+	}
+
+	t.Log("other aggregate with tenant")
+	ctxA := eh.WithTenantID(context.Background(), "a")
+	if err := d.HandleCommandContext(ctxA, &mocks.Command{ID: id, Content: "tenant"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	<-handler.started
+
+	cancel()
+	if err := <-errCh; err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+}
+
+func TestDispatcherCloseContext(t *testing.T) {
+	handler := newBlockingHandler()
+	d, err := NewDispatcher(handler, 1, 1)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	go d.HandleCommand(&mocks.Command{ID: eh.NewUUID(), Content: "block"})
+	<-handler.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); err != context.DeadlineExceeded {
+		t.Error("there should be a context.DeadlineExceeded error:", err)
+	}
+
+	close(handler.release)
+	if err := d.Close(context.Background()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
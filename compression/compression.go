@@ -0,0 +1,99 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package compression contains the interface and helpers for compressing
+// serialized event payloads in the stores and buses.
+package compression
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrUnknownCompression is when a payload is compressed with a compressor
+// that is not registered.
+var ErrUnknownCompression = errors.New("unknown compression")
+
+// DefaultThreshold is a suitable payload size in bytes from which to compress
+// payloads, smaller payloads does not gain much from compression.
+const DefaultThreshold = 1024
+
+// Compressor compresses and decompresses serialized payloads. The name is
+// stored together with each compressed payload as a flag, used to find the
+// compressor to decompress it with.
+type Compressor interface {
+	// Name returns the name of the compression, for example "gzip".
+	Name() string
+
+	// Compress compresses the data.
+	Compress(data []byte) ([]byte, error)
+
+	// Decompress decompresses data that was compressed by Compress.
+	Decompress(data []byte) ([]byte, error)
+}
+
+var compressors = make(map[string]Compressor)
+var compressorsMu sync.RWMutex
+
+// Register registers a compressor to be used when decompressing payloads with
+// its name. Compressors that have been used earlier should be registered to
+// still be able to load old data after switching compression.
+//
+// An example would be:
+//     compression.Register(gzip.NewCompressor())
+func Register(c Compressor) {
+	if c.Name() == "" {
+		panic("eventhorizon: attempt to register empty compression name")
+	}
+
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// Compress compresses the data with c if it is at least threshold bytes long.
+// It returns the resulting data and the name of the compression, which is
+// empty if the data was not compressed.
+func Compress(c Compressor, threshold int, data []byte) ([]byte, string, error) {
+	if c == nil || len(data) < threshold {
+		return data, "", nil
+	}
+
+	compressed, err := c.Compress(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return compressed, c.Name(), nil
+}
+
+// Decompress decompresses data that was compressed with the named
+// compression. The compressor c is used if it has the name, otherwise a
+// registered compressor. Data without a compression name is returned as is.
+func Decompress(c Compressor, name string, data []byte) ([]byte, error) {
+	if name == "" {
+		return data, nil
+	}
+
+	if c == nil || c.Name() != name {
+		compressorsMu.RLock()
+		registered, ok := compressors[name]
+		compressorsMu.RUnlock()
+		if !ok {
+			return nil, ErrUnknownCompression
+		}
+		c = registered
+	}
+
+	return c.Decompress(data)
+}
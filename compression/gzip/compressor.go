@@ -0,0 +1,74 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package gzip implements a compression.Compressor using gzip.
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+)
+
+// Name is the name of the gzip compression.
+const Name = "gzip"
+
+// Compressor is a compressor that uses gzip.
+type Compressor struct {
+	level int
+}
+
+// NewCompressor creates a new Compressor with the default compression level.
+func NewCompressor() *Compressor {
+	return &Compressor{
+		level: gzip.DefaultCompression,
+	}
+}
+
+// SetLevel sets the compression level, see the compress/gzip package.
+func (c *Compressor) SetLevel(level int) {
+	c.level = level
+}
+
+// Name implements the Name method of the compression.Compressor interface.
+func (c *Compressor) Name() string {
+	return Name
+}
+
+// Compress implements the Compress method of the compression.Compressor interface.
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress implements the Decompress method of the compression.Compressor interface.
+func (c *Compressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/looplab/eventhorizon/compression"
+)
+
+// CompressorCommonTests are test cases that are common to all compressors.
+func CompressorCommonTests(t *testing.T, c compression.Compressor) {
+	data := bytes.Repeat([]byte("eventhorizon "), 1000)
+
+	t.Log("compress and decompress")
+	compressed, err := c.Compress(data)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(compressed) >= len(data) {
+		t.Error("the data should be compressed:", len(compressed))
+	}
+	decompressed, err := c.Decompress(compressed)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Error("the decompressed data should be correct")
+	}
+
+	t.Log("compress and decompress empty data")
+	compressed, err = c.Compress([]byte{})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	decompressed, err = c.Decompress(compressed)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(decompressed) != 0 {
+		t.Error("the decompressed data should be empty:", decompressed)
+	}
+
+	t.Log("compress below threshold")
+	small := []byte("small")
+	result, name, err := compression.Compress(c, 100, small)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if name != "" || !bytes.Equal(result, small) {
+		t.Error("the data should not be compressed:", name)
+	}
+
+	t.Log("compress above threshold")
+	result, name, err = compression.Compress(c, 100, data)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if name != c.Name() {
+		t.Error("the compression name should be correct:", name)
+	}
+	decompressed, err = compression.Decompress(c, name, result)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Error("the decompressed data should be correct")
+	}
+
+	t.Log("decompress uncompressed data")
+	decompressed, err = compression.Decompress(c, "", small)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !bytes.Equal(decompressed, small) {
+		t.Error("the data should be returned as is")
+	}
+
+	t.Log("decompress with unknown compression")
+	if _, err := compression.Decompress(c, "unknown", result); err != compression.ErrUnknownCompression {
+		t.Error("there should be a ErrUnknownCompression error:", err)
+	}
+
+	t.Log("decompress with registered compression")
+	compression.Register(c)
+	decompressed, err = compression.Decompress(nil, c.Name(), result)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Error("the decompressed data should be correct")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zstd implements a compression.Compressor using Zstandard.
+package zstd
+
+import (
+	"github.com/klauspost/compress/zstd"
+)
+
+// Name is the name of the Zstandard compression.
+const Name = "zstd"
+
+// Compressor is a compressor that uses Zstandard. It is safe for concurrent
+// use.
+type Compressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewCompressor creates a new Compressor.
+func NewCompressor() (*Compressor, error) {
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Compressor{
+		encoder: encoder,
+		decoder: decoder,
+	}
+	return c, nil
+}
+
+// Name implements the Name method of the compression.Compressor interface.
+func (c *Compressor) Name() string {
+	return Name
+}
+
+// Compress implements the Compress method of the compression.Compressor interface.
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+// Decompress implements the Decompress method of the compression.Compressor interface.
+func (c *Compressor) Decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,30 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zstd
+
+import (
+	"testing"
+
+	"github.com/looplab/eventhorizon/compression/testutil"
+)
+
+func TestCompressor(t *testing.T) {
+	c, err := NewCompressor()
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.CompressorCommonTests(t, c)
+}
@@ -0,0 +1,406 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// ContextCommandHandler is a CommandHandler that can handle commands with a
+// context, for cancellation, deadlines and request scoped values.
+type ContextCommandHandler interface {
+	CommandHandler
+
+	// HandleCommandContext handles a command with a context.
+	HandleCommandContext(context.Context, Command) error
+}
+
+// ContextCommandBus is a CommandBus that can handle commands with a context.
+type ContextCommandBus interface {
+	CommandBus
+
+	// HandleCommandContext handles a command on the bus with a context.
+	HandleCommandContext(context.Context, Command) error
+}
+
+// ContextEventStore is an EventStore that can save and load events with a
+// context.
+type ContextEventStore interface {
+	EventStore
+
+	// SaveContext appends all events in the event stream to the store.
+	SaveContext(ctx context.Context, events []Event, originalVersion int) error
+
+	// LoadContext loads all events for the aggregate id from the store.
+	LoadContext(context.Context, AggregateType, UUID) ([]EventRecord, error)
+}
+
+// ContextEventBus is an EventBus that can publish events with a context. The
+// values of the context are passed on to the handlers and observers, also to
+// remote ones for the values that have a registered ContextMarshalFunc.
+type ContextEventBus interface {
+	EventBus
+
+	// PublishEventContext publishes an event on the event bus. It returns the
+	// error of the context if it is done before the event is published.
+	PublishEventContext(context.Context, Event) error
+}
+
+// ContextEventHandler is an EventHandler that can handle events with a
+// context.
+type ContextEventHandler interface {
+	EventHandler
+
+	// HandleEventContext handles an event with a context.
+	HandleEventContext(context.Context, Event)
+}
+
+// ContextEventObserver is an EventObserver that can be notified with a
+// context.
+type ContextEventObserver interface {
+	EventObserver
+
+	// NotifyContext is notified about an event with a context.
+	NotifyContext(context.Context, Event)
+}
+
+// ContextRepository is a Repository that can load and save aggregates with a
+// context.
+type ContextRepository interface {
+	Repository
+
+	// LoadContext loads the most recent version of an aggregate with a type
+	// and id.
+	LoadContext(context.Context, AggregateType, UUID) (Aggregate, error)
+
+	// SaveContext saves the uncommittend events for an aggregate.
+	SaveContext(context.Context, Aggregate) error
+}
+
+// ContextReadRepository is a ReadRepository that can save, find and remove
+// read models with a context.
+type ContextReadRepository interface {
+	ReadRepository
+
+	// SaveContext saves a read model with id to the repository.
+	SaveContext(context.Context, UUID, interface{}) error
+
+	// FindContext returns one read model with using an id.
+	FindContext(context.Context, UUID) (interface{}, error)
+
+	// FindAllContext returns all read models in the repository.
+	FindAllContext(context.Context) ([]interface{}, error)
+
+	// RemoveContext removes a read model with id from the repository.
+	RemoveContext(context.Context, UUID) error
+}
+
+// AdaptCommandHandler returns the handler as a ContextCommandHandler. Handlers
+// that do not handle contexts are wrapped to not be called with a done context.
+func AdaptCommandHandler(handler CommandHandler) ContextCommandHandler {
+	if h, ok := handler.(ContextCommandHandler); ok {
+		return h
+	}
+	return &contextCommandHandler{handler}
+}
+
+type contextCommandHandler struct {
+	CommandHandler
+}
+
+func (h *contextCommandHandler) HandleCommandContext(ctx context.Context, command Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return h.HandleCommand(command)
+}
+
+// AdaptEventStore returns the event store as a ContextEventStore. Event stores
+// that do not handle contexts are wrapped to not be called with a done context.
+func AdaptEventStore(eventStore EventStore) ContextEventStore {
+	if s, ok := eventStore.(ContextEventStore); ok {
+		return s
+	}
+	return &contextEventStore{eventStore}
+}
+
+type contextEventStore struct {
+	EventStore
+}
+
+func (s *contextEventStore) SaveContext(ctx context.Context, events []Event, originalVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Save(events, originalVersion)
+}
+
+func (s *contextEventStore) LoadContext(ctx context.Context, aggregateType AggregateType, id UUID) ([]EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Load(aggregateType, id)
+}
+
+// AdaptEventBus returns the event bus as a ContextEventBus. Event buses that
+// do not handle contexts are wrapped to not publish events with a done
+// context; the values of the context are not passed on.
+func AdaptEventBus(eventBus EventBus) ContextEventBus {
+	if b, ok := eventBus.(ContextEventBus); ok {
+		return b
+	}
+	return &contextEventBus{eventBus}
+}
+
+type contextEventBus struct {
+	EventBus
+}
+
+func (b *contextEventBus) PublishEventContext(ctx context.Context, event Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.PublishEvent(event)
+	return nil
+}
+
+// AdaptEventHandler returns the handler as a ContextEventHandler. Handlers
+// that do not handle contexts are wrapped to skip events with a done context.
+func AdaptEventHandler(handler EventHandler) ContextEventHandler {
+	if h, ok := handler.(ContextEventHandler); ok {
+		return h
+	}
+	return &contextEventHandler{handler}
+}
+
+type contextEventHandler struct {
+	EventHandler
+}
+
+func (h *contextEventHandler) HandleEventContext(ctx context.Context, event Event) {
+	if ctx.Err() != nil {
+		return
+	}
+	h.HandleEvent(event)
+}
+
+// AdaptEventObserver returns the observer as a ContextEventObserver. Observers
+// that do not handle contexts are wrapped to skip events with a done context.
+func AdaptEventObserver(observer EventObserver) ContextEventObserver {
+	if o, ok := observer.(ContextEventObserver); ok {
+		return o
+	}
+	return &contextEventObserver{observer}
+}
+
+type contextEventObserver struct {
+	EventObserver
+}
+
+func (o *contextEventObserver) NotifyContext(ctx context.Context, event Event) {
+	if ctx.Err() != nil {
+		return
+	}
+	o.Notify(event)
+}
+
+// AdaptRepository returns the repository as a ContextRepository. Repositories
+// that do not handle contexts are wrapped to not be called with a done context.
+func AdaptRepository(repository Repository) ContextRepository {
+	if r, ok := repository.(ContextRepository); ok {
+		return r
+	}
+	return &contextRepository{repository}
+}
+
+type contextRepository struct {
+	Repository
+}
+
+func (r *contextRepository) LoadContext(ctx context.Context, aggregateType AggregateType, id UUID) (Aggregate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Load(aggregateType, id)
+}
+
+func (r *contextRepository) SaveContext(ctx context.Context, aggregate Aggregate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Save(aggregate)
+}
+
+// AdaptReadRepository returns the read repository as a ContextReadRepository.
+// Read repositories that do not handle contexts are wrapped to not be called
+// with a done context.
+func AdaptReadRepository(repository ReadRepository) ContextReadRepository {
+	if r, ok := repository.(ContextReadRepository); ok {
+		return r
+	}
+	return &contextReadRepository{repository}
+}
+
+type contextReadRepository struct {
+	ReadRepository
+}
+
+func (r *contextReadRepository) SaveContext(ctx context.Context, id UUID, model interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Save(id, model)
+}
+
+func (r *contextReadRepository) FindContext(ctx context.Context, id UUID) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.Find(id)
+}
+
+func (r *contextReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return r.FindAll()
+}
+
+func (r *contextReadRepository) RemoveContext(ctx context.Context, id UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.Remove(id)
+}
+
+// ContextMarshalFunc adds the values of a context that should be sent along
+// with events to remote buses to the map.
+type ContextMarshalFunc func(context.Context, map[string]string)
+
+// ContextUnmarshalFunc returns a context with the values from the map, as
+// marshaled by the ContextMarshalFunc of the same values.
+type ContextUnmarshalFunc func(context.Context, map[string]string) context.Context
+
+var contextMarshalFuncs []ContextMarshalFunc
+var contextUnmarshalFuncs []ContextUnmarshalFunc
+var contextMarshalFuncsMu sync.RWMutex
+
+// RegisterContextMarshaler registers a marshaler and unmarshaler of context
+// values, used by the remote event buses to pass on request scoped values to
+// the observers. The tenant ID is registered by default.
+func RegisterContextMarshaler(marshal ContextMarshalFunc, unmarshal ContextUnmarshalFunc) {
+	contextMarshalFuncsMu.Lock()
+	defer contextMarshalFuncsMu.Unlock()
+
+	contextMarshalFuncs = append(contextMarshalFuncs, marshal)
+	contextUnmarshalFuncs = append(contextUnmarshalFuncs, unmarshal)
+}
+
+// MarshalContext marshals the values of the context with all registered
+// marshalers.
+func MarshalContext(ctx context.Context) map[string]string {
+	contextMarshalFuncsMu.RLock()
+	defer contextMarshalFuncsMu.RUnlock()
+
+	values := map[string]string{}
+	for _, f := range contextMarshalFuncs {
+		f(ctx, values)
+	}
+	return values
+}
+
+// UnmarshalContext returns a new context with the values unmarshaled with all
+// registered unmarshalers.
+func UnmarshalContext(values map[string]string) context.Context {
+	contextMarshalFuncsMu.RLock()
+	defer contextMarshalFuncsMu.RUnlock()
+
+	ctx := context.Background()
+	for _, f := range contextUnmarshalFuncs {
+		ctx = f(ctx, values)
+	}
+	return ctx
+}
+
+type contextKey int
+
+const (
+	tenantIDKey contextKey = iota
+	eventVersionKey
+)
+
+// tenantIDValue is the name of the marshaled tenant ID.
+const tenantIDValue = "eh_tenant_id"
+
+// eventVersionValue is the name of the marshaled event version.
+const eventVersionValue = "eh_event_version"
+
+func init() {
+	RegisterContextMarshaler(
+		func(ctx context.Context, values map[string]string) {
+			if tenantID, ok := TenantIDFromContext(ctx); ok {
+				values[tenantIDValue] = string(tenantID)
+			}
+		},
+		func(ctx context.Context, values map[string]string) context.Context {
+			if tenantID, ok := values[tenantIDValue]; ok {
+				return WithTenantID(ctx, TenantID(tenantID))
+			}
+			return ctx
+		},
+	)
+	RegisterContextMarshaler(
+		func(ctx context.Context, values map[string]string) {
+			if version, ok := EventVersionFromContext(ctx); ok {
+				values[eventVersionValue] = strconv.Itoa(version)
+			}
+		},
+		func(ctx context.Context, values map[string]string) context.Context {
+			if version, err := strconv.Atoi(values[eventVersionValue]); err == nil {
+				return WithEventVersion(ctx, version)
+			}
+			return ctx
+		},
+	)
+}
+
+// WithTenantID returns a context with the tenant ID. Commands handled with the
+// context by the AggregateCommandHandler use the repository of the tenant;
+// a TenantCommand of another tenant is rejected with ErrCrossTenantAccess.
+func WithTenantID(ctx context.Context, tenantID TenantID) context.Context {
+	return context.WithValue(ctx, tenantIDKey, tenantID)
+}
+
+// TenantIDFromContext returns the tenant ID of the context, if any.
+func TenantIDFromContext(ctx context.Context) (TenantID, bool) {
+	tenantID, ok := ctx.Value(tenantIDKey).(TenantID)
+	return tenantID, ok
+}
+
+// WithEventVersion returns a context with the version of the aggregate after
+// an event. The EventSourcingRepository publishes events with the version from
+// their event record, which is used by projectors to detect duplicate and
+// missing events.
+func WithEventVersion(ctx context.Context, version int) context.Context {
+	return context.WithValue(ctx, eventVersionKey, version)
+}
+
+// EventVersionFromContext returns the event version of the context, if any.
+func EventVersionFromContext(ctx context.Context) (int, bool) {
+	version, ok := ctx.Value(eventVersionKey).(int)
+	return version, ok
+}
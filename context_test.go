@@ -0,0 +1,241 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type MockContextEventBus struct {
+	MockEventBus
+	Contexts []context.Context
+}
+
+func (m *MockContextEventBus) PublishEventContext(ctx context.Context, event Event) error {
+	m.Contexts = append(m.Contexts, ctx)
+	m.PublishEvent(event)
+	return nil
+}
+
+type MockEventHandler struct {
+	Events []Event
+}
+
+func (m *MockEventHandler) HandleEvent(event Event) {
+	m.Events = append(m.Events, event)
+}
+
+func (m *MockEventHandler) HandlerType() EventHandlerType {
+	return "MockEventHandler"
+}
+
+func TestAdaptEventStore(t *testing.T) {
+	store := &MockEventStore{Events: make([]EventRecord, 0)}
+	s := AdaptEventStore(store)
+	if AdaptEventStore(s) != s {
+		t.Error("a context event store should not be adapted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	id := NewUUID()
+	if err := s.SaveContext(ctx, []Event{&TestEvent{id, "event1"}}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := s.LoadContext(ctx, TestAggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	cancel()
+	if err := s.SaveContext(ctx, []Event{&TestEvent{id, "event2"}}, 1); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if len(store.Events) != 1 {
+		t.Error("there should be one event stored:", len(store.Events))
+	}
+	if _, err := s.LoadContext(ctx, TestAggregateType, id); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+}
+
+func TestAdaptEventBus(t *testing.T) {
+	bus := &MockEventBus{Events: make([]Event, 0)}
+	b := AdaptEventBus(bus)
+	if AdaptEventBus(b) != b {
+		t.Error("a context event bus should not be adapted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	event := &TestEvent{NewUUID(), "event"}
+	if err := b.PublishEventContext(ctx, event); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	cancel()
+	if err := b.PublishEventContext(ctx, event); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if len(bus.Events) != 1 {
+		t.Error("there should be one event published:", len(bus.Events))
+	}
+}
+
+func TestAdaptEventHandler(t *testing.T) {
+	handler := &MockEventHandler{}
+	h := AdaptEventHandler(handler)
+	if AdaptEventHandler(h) != h {
+		t.Error("a context event handler should not be adapted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	event := &TestEvent{NewUUID(), "event"}
+	h.HandleEventContext(ctx, event)
+	cancel()
+	h.HandleEventContext(ctx, event)
+	if len(handler.Events) != 1 {
+		t.Error("there should be one event handled:", len(handler.Events))
+	}
+}
+
+func TestAdaptReadRepository(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	r := AdaptReadRepository(&MockVersionedReadRepository{})
+	if err := r.SaveContext(ctx, NewUUID(), nil); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if _, err := r.FindContext(ctx, NewUUID()); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if _, err := r.FindAllContext(ctx); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if err := r.RemoveContext(ctx, NewUUID()); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+}
+
+func TestTenantIDContext(t *testing.T) {
+	if _, ok := TenantIDFromContext(context.Background()); ok {
+		t.Error("there should be no tenant")
+	}
+
+	ctx := WithTenantID(context.Background(), "a")
+	if tenantID, ok := TenantIDFromContext(ctx); !ok || tenantID != "a" {
+		t.Error("the tenant should be correct:", tenantID)
+	}
+}
+
+func TestMarshalContext(t *testing.T) {
+	ctx := WithTenantID(context.Background(), "a")
+	values := MarshalContext(ctx)
+	if !reflect.DeepEqual(values, map[string]string{tenantIDValue: "a"}) {
+		t.Error("the values should be correct:", values)
+	}
+
+	ctx = UnmarshalContext(values)
+	if tenantID, ok := TenantIDFromContext(ctx); !ok || tenantID != "a" {
+		t.Error("the tenant should be correct:", tenantID)
+	}
+
+	if values := MarshalContext(context.Background()); len(values) != 0 {
+		t.Error("there should be no values:", values)
+	}
+}
+
+func TestCommandHandlerContext(t *testing.T) {
+	aggregate, handler := createAggregateAndHandler(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	command := &TestCommand{aggregate.AggregateID(), "command1"}
+	if err := handler.HandleCommandContext(ctx, command); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if aggregate.dispatchedCommand != nil {
+		t.Error("the command should not be dispatched:", aggregate.dispatchedCommand)
+	}
+}
+
+func TestCommandHandlerContextTenant(t *testing.T) {
+	store := &MockMultiTenantEventStore{Tenants: make(map[TenantID]*MockEventStore)}
+	bus := &MockMultiTenantEventBus{Tenants: make(map[TenantID]*MockEventBus)}
+	repo, err := NewEventSourcingRepository(store, bus)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	h, err := NewAggregateCommandHandler(repo)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.SetAggregate(TestAggregateType, TestCommandType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.SetAggregate(TestAggregateType, TestTenantCommandType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("handle command with tenant in context")
+	ctx := WithTenantID(context.Background(), "a")
+	if err := h.HandleCommandContext(ctx, &TestCommand{NewUUID(), "command"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(store.Events) != 0 || len(store.Tenants["a"].Events) != 1 {
+		t.Error("the event should be stored for the tenant:", store.Tenants["a"].Events)
+	}
+
+	t.Log("handle command of other tenant")
+	err = h.HandleCommandContext(ctx, &TestTenantCommand{TestID: NewUUID(), Tenant: "b", Content: "command"})
+	if err != ErrCrossTenantAccess {
+		t.Error("there should be a ErrCrossTenantAccess error:", err)
+	}
+}
+
+func TestEventSourcingRepositorySaveContext(t *testing.T) {
+	store := &MockEventStore{Events: make([]EventRecord, 0)}
+	bus := &MockContextEventBus{}
+	repo, err := NewEventSourcingRepository(store, bus)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	ctx := WithTenantID(context.Background(), "a")
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	agg := &TestAggregate{AggregateBase: NewAggregateBase(NewUUID())}
+	agg.StoreEvent(&TestEvent{agg.AggregateID(), "event"})
+	if err := repo.SaveContext(ctx, agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(bus.Contexts) != 1 {
+		t.Fatal("the event should be published with a context:", len(bus.Contexts))
+	}
+	if tenantID, _ := TenantIDFromContext(bus.Contexts[0]); tenantID != "a" {
+		t.Error("the context values should be kept:", tenantID)
+	}
+	cancel()
+	if bus.Contexts[0].Err() != nil {
+		t.Error("the publish context should not be canceled")
+	}
+
+	agg.StoreEvent(&TestEvent{agg.AggregateID(), "event"})
+	if err := repo.SaveContext(ctx, agg); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if len(store.Events) != 1 {
+		t.Error("there should be one event stored:", len(store.Events))
+	}
+}
@@ -17,8 +17,6 @@ package eventhorizon
 
 import (
 	"errors"
-	"fmt"
-	"sync"
 )
 
 // Event is a domain event describing a change that has happened to an aggregate.
@@ -44,46 +42,21 @@ type Event interface {
 // EventType is the type of an event, used as its unique identifier.
 type EventType string
 
-var events = make(map[EventType]func() Event)
-var registerEventLock sync.RWMutex
-
 // ErrEventNotRegistered is when no event factory was registered.
 var ErrEventNotRegistered = errors.New("event not registered")
 
-// RegisterEvent registers an event factory for a type. The factory is
-// used to create concrete event types when loading from the database.
+// RegisterEvent registers an event factory for a type in the default
+// registry. The factory is used to create concrete event types when loading
+// from the database. It panics if the type is empty or already registered.
 //
 // An example would be:
 //     RegisterEvent(func() Event { return &MyEvent{} })
 func RegisterEvent(factory func() Event) {
-	// TODO: Explore the use of reflect/gob for creating concrete types without
-	// a factory func.
-
-	// Check that the created event matches the type registered.
-	event := factory()
-	if event == nil {
-		panic("eventhorizon: created event is nil")
-	}
-	eventType := event.EventType()
-	if eventType == EventType("") {
-		panic("eventhorizon: attempt to register empty event type")
-	}
-
-	registerEventLock.Lock()
-	defer registerEventLock.Unlock()
-	if _, ok := events[eventType]; ok {
-		panic(fmt.Sprintf("eventhorizon: registering duplicate types for %q", eventType))
-	}
-	events[eventType] = factory
+	mustRegister(defaultRegistry.RegisterEvent(factory), "event")
 }
 
-// CreateEvent creates an event of a type with an ID using the factory
-// registered with RegisterEvent.
+// CreateEvent creates an event of a type using the factory registered with
+// RegisterEvent.
 func CreateEvent(eventType EventType) (Event, error) {
-	registerEventLock.RLock()
-	defer registerEventLock.RUnlock()
-	if factory, ok := events[eventType]; ok {
-		return factory(), nil
-	}
-	return nil, ErrEventNotRegistered
+	return defaultRegistry.CreateEvent(eventType)
 }
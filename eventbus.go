@@ -64,4 +64,13 @@ const (
 	// AsyncEventHandlingStrategy will handle events concurrently in their own
 	// goroutines and not wait for them to finish.
 	AsyncEventHandlingStrategy
+	// PooledEventHandlingStrategy will handle events concurrently with a pool
+	// of workers and a bounded queue, publishing blocks when the queue is
+	// full. Events are not handled in order.
+	PooledEventHandlingStrategy
+	// OrderedEventHandlingStrategy will handle events concurrently with a
+	// pool of workers, where the events of each aggregate are handled in
+	// order by the same worker. Each worker has a bounded queue, publishing
+	// blocks when it is full.
+	OrderedEventHandlingStrategy
 )
@@ -1,6 +1,8 @@
 package distributed
 
 import (
+	"context"
+
 	eh "github.com/looplab/eventhorizon"
 )
 
@@ -18,19 +20,90 @@ func NewEventBus() *ClusteringEventBus {
 			eventParse:    &JsonEventParse{},
 			eventRoute:    &EventRoutingStrategy{"domain"},
 			configs:       Config{broker: "tcp://localhost:1883", username: "guest", password: "guest", cleansession: false},
+			logger:        eh.DefaultLogger(),
+			registry:      eh.DefaultRegistry(),
+			exit:          make(chan struct{}),
 		},
 		observers: make(map[eh.EventObserver]bool),
 	}
 	return b
 }
 
+// ForTenant returns the bus of a tenant, which routes events with the tenant
+// ID as prefix if the terminal supports tenants. The observers of this bus are
+// not added to the bus of the tenant.
+func (b *ClusteringEventBus) ForTenant(tenantID eh.TenantID) (*ClusteringEventBus, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	t, ok := b.terminal.(interface {
+		ForTenant(eh.TenantID) EventBusTerminal
+	})
+	if !ok {
+		return nil, eh.ErrTenantsNotSupported
+	}
+
+	tenant := &ClusteringEventBus{
+		terminal:  t.ForTenant(tenantID),
+		observers: make(map[eh.EventObserver]bool),
+	}
+	return tenant, nil
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts the terminal, if supported by the terminal.
+func (b *ClusteringEventBus) Start(ctx context.Context) error {
+	if t, ok := b.terminal.(eh.Lifecycle); ok {
+		return t.Start(ctx)
+	}
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the terminal, if supported by the terminal.
+func (b *ClusteringEventBus) Close(ctx context.Context) error {
+	if t, ok := b.terminal.(eh.Lifecycle); ok {
+		return t.Close(ctx)
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It checks the terminal, if supported
+// by the terminal.
+func (b *ClusteringEventBus) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if t, ok := b.terminal.(eh.HealthChecker); ok {
+		return t.CheckHealth(ctx)
+	}
+	return eh.Health{}, nil
+}
+
 func (b *ClusteringEventBus) PublishEvent(event eh.Event) {
+	b.PublishEventContext(context.Background(), event)
+}
 
-	b.terminal.Publish(event)
+// PublishEventContext implements the PublishEventContext method of the
+// eventhorizon.ContextEventBus interface. The context values are passed on to
+// the remote handlers if the terminal is a ContextEventBusTerminal. It returns
+// eventhorizon.ErrClosed if the terminal is closed.
+func (b *ClusteringEventBus) PublishEventContext(ctx context.Context, event eh.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if t, ok := b.terminal.(ContextEventBusTerminal); ok {
+		if err := t.PublishContext(ctx, event); err == eh.ErrClosed {
+			return err
+		}
+	} else if err := b.terminal.Publish(event); err == eh.ErrClosed {
+		return err
+	}
 	// Notify all observers about the event.
 	for o := range b.observers {
-		o.Notify(event)
+		eh.AdaptEventObserver(o).NotifyContext(ctx, event)
 	}
+	return nil
 }
 
 // AddHandler implements the AddHandler method of the EventHandler interface.
@@ -42,3 +115,41 @@ func (b *ClusteringEventBus) AddHandler(handler eh.EventHandler, eventType eh.Ev
 func (b *ClusteringEventBus) AddObserver(observer eh.EventObserver) {
 	b.observers[observer] = true
 }
+
+// SetCodec sets the codec to use for events, if supported by the terminal.
+func (b *ClusteringEventBus) SetCodec(codec eh.Codec) {
+	if t, ok := b.terminal.(interface {
+		SetCodec(eh.Codec)
+	}); ok {
+		t.SetCodec(codec)
+	}
+}
+
+// SetMonitor sets a monitor that is notified about the lag of received events
+// and about reconnects, if supported by the terminal.
+func (b *ClusteringEventBus) SetMonitor(monitor eh.BusMonitor) {
+	if t, ok := b.terminal.(interface {
+		SetMonitor(eh.BusMonitor)
+	}); ok {
+		t.SetMonitor(monitor)
+	}
+}
+
+// SetLogger sets the logger, if supported by the terminal.
+func (b *ClusteringEventBus) SetLogger(logger eh.Logger) {
+	if t, ok := b.terminal.(interface {
+		SetLogger(eh.Logger)
+	}); ok {
+		t.SetLogger(logger)
+	}
+}
+
+// SetRegistry sets the registry used to create the received events, if
+// supported by the terminal.
+func (b *ClusteringEventBus) SetRegistry(registry *eh.Registry) {
+	if t, ok := b.terminal.(interface {
+		SetRegistry(*eh.Registry)
+	}); ok {
+		t.SetRegistry(registry)
+	}
+}
@@ -1,24 +1,54 @@
 package distributed
 
 import (
-	"log"
+	"context"
+	"errors"
+	"sync"
+	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/jpillora/backoff"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/internal/message"
 )
 
+// ErrNotSubscribed is when a terminal with handlers is not subscribed to the
+// broker.
+var ErrNotSubscribed = errors.New("not subscribed")
+
 type EventBusTerminal interface {
 	Publish(eh.Event) error
 	AddHandler(eh.EventHandler, eh.EventType) error
 }
 
+// ContextEventBusTerminal is an EventBusTerminal that can publish events with
+// the values of a context, which are passed on to the remote handlers.
+type ContextEventBusTerminal interface {
+	EventBusTerminal
+
+	PublishContext(context.Context, eh.Event) error
+}
+
 type RabbitMqttEBT struct {
 	handlers      map[eh.EventType]map[eh.EventHandler]bool
 	topicStrategy EventTopicStrategy
 	eventParse    EventParse
 	eventRoute    EventRoute
 	configs       Config
+	monitor       eh.BusMonitor
+	logger        eh.Logger
+	registry      *eh.Registry
+
+	// exit is closed to stop receiving, wg tracks the receive goroutines and
+	// subclients are their connections, guarded by mu.
+	exit       chan struct{}
+	exitOnce   sync.Once
+	wg         sync.WaitGroup
+	subclients []MQTT.Client
+	listeners  int
+	closed     bool
+	mu         sync.Mutex
 }
 
 type Config struct {
@@ -58,7 +88,161 @@ func (b *RabbitMqttEBT) initOpts() *MQTT.ClientOptions {
 	return opts
 }
 
+// SetCodec sets the codec to use for the events sent over MQTT.
+func (b *RabbitMqttEBT) SetCodec(codec eh.Codec) {
+	b.eventParse = &CodecEventParse{Codec: codec}
+}
+
+// SetMonitor sets a monitor that is notified about the lag of received events
+// and about reconnects to the broker.
+func (b *RabbitMqttEBT) SetMonitor(monitor eh.BusMonitor) {
+	b.monitor = monitor
+}
+
+// SetLogger sets the logger, the default is eventhorizon.DefaultLogger. The
+// payloads of events are logged if enabled with eventhorizon.WithPayloads.
+func (b *RabbitMqttEBT) SetLogger(logger eh.Logger) {
+	b.logger = logger
+}
+
+// SetRegistry sets the registry used to create the received events, the
+// default is eventhorizon.DefaultRegistry.
+func (b *RabbitMqttEBT) SetRegistry(registry *eh.Registry) {
+	b.registry = registry
+}
+
+// ForTenant returns a terminal with the same config that routes events with
+// the tenant ID as prefix.
+func (b *RabbitMqttEBT) ForTenant(tenantID eh.TenantID) EventBusTerminal {
+	return &RabbitMqttEBT{
+		handlers:      make(map[eh.EventType]map[eh.EventHandler]bool),
+		topicStrategy: b.topicStrategy,
+		eventParse:    b.eventParse,
+		eventRoute:    &TenantEventRoute{EventRoute: b.eventRoute, tenantID: tenantID},
+		configs:       b.configs,
+		monitor:       b.monitor,
+		logger:        b.logger,
+		registry:      b.registry,
+		exit:          make(chan struct{}),
+	}
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It connects to the broker and returns the error if it can not be reached.
+// Subscribing when handlers are added is retried until the terminal is closed.
+func (b *RabbitMqttEBT) Start(ctx context.Context) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return eh.ErrClosed
+	}
+
+	return b.ping(ctx)
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// Events published after closing return eventhorizon.ErrClosed. It stops
+// receiving, waits for the handlers of received events until the context is
+// done and disconnects from the broker.
+func (b *RabbitMqttEBT) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.exitOnce.Do(func() { close(b.exit) })
+
+	stopped := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.subclients {
+		c.Disconnect(250)
+	}
+	b.subclients = nil
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It connects to the broker and reports
+// if the terminal is subscribed, which it must be once handlers are added.
+func (b *RabbitMqttEBT) CheckHealth(ctx context.Context) (eh.Health, error) {
+	b.mu.Lock()
+	closed := b.closed
+	listeners := b.listeners
+	subscribed := listeners > 0 && len(b.subclients) == listeners
+	for _, c := range b.subclients {
+		if !c.IsConnectionOpen() {
+			subscribed = false
+		}
+	}
+	b.mu.Unlock()
+	if closed {
+		return eh.Health{}, eh.ErrClosed
+	}
+
+	start := time.Now()
+	err := b.ping(ctx)
+	if ctx.Err() != nil {
+		return eh.Health{}, ctx.Err()
+	}
+	health := eh.Health{
+		Latency: time.Since(start),
+		Details: map[string]interface{}{"subscribed": subscribed},
+	}
+	if err != nil {
+		return health, err
+	}
+
+	if listeners > 0 && !subscribed {
+		return health, ErrNotSubscribed
+	}
+	return health, nil
+}
+
+// ping connects to the broker and disconnects again, or returns the error of
+// connecting or of the context.
+func (b *RabbitMqttEBT) ping(ctx context.Context) error {
+	client := MQTT.NewClient(b.initOpts())
+	token := client.Connect()
+	select {
+	case <-token.Done():
+	case <-ctx.Done():
+		go func() {
+			<-token.Done()
+			client.Disconnect(0)
+		}()
+		return ctx.Err()
+	}
+	if err := token.Error(); err != nil {
+		return err
+	}
+	client.Disconnect(250)
+	return nil
+}
+
 func (b *RabbitMqttEBT) Publish(event eh.Event) error {
+	return b.PublishContext(context.Background(), event)
+}
+
+// PublishContext publishes the event with the values of the context as message
+// headers.
+func (b *RabbitMqttEBT) PublishContext(ctx context.Context, event eh.Event) error {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return eh.ErrClosed
+	}
+
 	opts := b.initOpts()
 	client := MQTT.NewClient(opts)
 	if token := client.Connect(); token.Wait() && token.Error() != nil {
@@ -69,8 +253,17 @@ func (b *RabbitMqttEBT) Publish(event eh.Event) error {
 	if err != nil {
 		return err
 	}
-	log.Println("routekey : " + string(b.eventRoute.GetRoutingKey(event)))
-	token := client.Publish(string(b.eventRoute.GetRoutingKey(event)), byte(0), false, msg)
+	b.logger.Debug("eventbus: publish event",
+		eh.F("event_type", event.EventType()),
+		eh.F("routing_key", b.eventRoute.GetRoutingKey(event)),
+	)
+	eh.LogPayload(b.logger, "eventbus: publish event", []byte(msg),
+		eh.F("event_type", event.EventType()),
+	)
+	headers := message.ContextHeaders(ctx, nil)
+	message.SetSentAt(headers, time.Now())
+	payload := message.Encode(headers, []byte(msg))
+	token := client.Publish(string(b.eventRoute.GetRoutingKey(event)), byte(0), false, payload)
 	token.Wait()
 
 	client.Disconnect(250)
@@ -85,6 +278,13 @@ func (b *RabbitMqttEBT) AddHandler(handler eh.EventHandler, eventType eh.EventTy
 	}
 	b.handlers[eventType][handler] = true
 	if !eventListenFlag {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.closed {
+			return eh.ErrClosed
+		}
+		b.wg.Add(1)
+		b.listeners++
 		go b.connectServer()
 	}
 	return nil
@@ -92,54 +292,118 @@ func (b *RabbitMqttEBT) AddHandler(handler eh.EventHandler, eventType eh.EventTy
 }
 
 func (b *RabbitMqttEBT) connectServer() {
+	defer b.wg.Done()
 
-	opts := b.initOpts()
 	choke := make(chan [2]string)
 
+	// Used for exponential fallback on failed subscriptions.
+	delay := &backoff.Backoff{Max: 5 * time.Minute}
+	for {
+		err := b.subscribe(choke)
+		if err == nil {
+			break
+		}
+		d := delay.Duration()
+		b.logger.Warn("eventbus: subscribe failed, retrying",
+			eh.F("delay", d),
+			eh.F("error", err),
+		)
+		select {
+		case <-time.After(d):
+		case <-b.exit:
+			return
+		}
+	}
+	b.listening(choke)
+}
+
+// subscribe connects to the broker and subscribes to the topic pattern, with
+// the received messages sent on choke.
+func (b *RabbitMqttEBT) subscribe(choke chan [2]string) error {
+	opts := b.initOpts()
+
 	opts.SetDefaultPublishHandler(func(client MQTT.Client, msg MQTT.Message) {
-		log.Printf("RECEIVED TOPIC: %s MESSAGE: %s\n", msg.Topic(), string(msg.Payload()))
-		choke <- [2]string{msg.Topic(), string(msg.Payload())}
+		b.logger.Debug("eventbus: received message", eh.F("topic", msg.Topic()))
+		select {
+		case choke <- [2]string{msg.Topic(), string(msg.Payload())}:
+		case <-b.exit:
+		}
+	})
+	connected := false
+	opts.SetOnConnectHandler(func(client MQTT.Client) {
+		if connected && b.monitor != nil {
+			b.monitor.Reconnected()
+		}
+		connected = true
 	})
 	subclient := MQTT.NewClient(opts)
 	if token := subclient.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+		return token.Error()
 	}
-	log.Println("route : " + string(b.eventRoute.GetTopicPattern()))
+	b.logger.Info("eventbus: subscribing", eh.F("topic", b.eventRoute.GetTopicPattern()))
 
 	if token := subclient.Subscribe(string(b.eventRoute.GetTopicPattern()), 0, nil); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+		subclient.Disconnect(0)
+		return token.Error()
 	}
-	b.listening(choke)
+	b.mu.Lock()
+	b.subclients = append(b.subclients, subclient)
+	b.mu.Unlock()
+	return nil
 }
 
 func (b *RabbitMqttEBT) listening(choke chan [2]string) {
-	log.Println("start to listen ")
+	b.logger.Info("eventbus: start receiving")
 	for {
-		incoming := <-choke
+		var incoming [2]string
+		select {
+		case incoming = <-choke:
+		case <-b.exit:
+			b.logger.Info("eventbus: stop receiving")
+			return
+		}
 		et := b.topicStrategy.ParseTopic(incoming[0])
 		if et == "" {
 			continue
 		} else {
 
-			event, err := eh.CreateEvent(et)
+			event, err := b.registry.CreateEvent(et)
 			if err != nil {
-				log.Fatal("get event origin type error" + string(err.Error()))
+				b.logger.Error("eventbus: could not receive event",
+					eh.F("event_type", et),
+					eh.F("error", err),
+				)
 				continue
 			} else {
-				event, err = b.eventParse.Decode(incoming[1], event)
+				headers, data, err := message.Decode([]byte(incoming[1]))
+				if err != nil {
+					b.logger.Error("eventbus: could not receive event",
+						eh.F("event_type", et),
+						eh.F("error", err),
+					)
+					continue
+				}
+				if sentAt, ok := message.SentAt(headers); ok && b.monitor != nil {
+					b.monitor.Received(string(et), time.Since(sentAt))
+				}
+				eh.LogPayload(b.logger, "eventbus: receive event", data,
+					eh.F("event_type", et),
+				)
+				event, err = b.eventParse.Decode(string(data), event)
 				if err != nil {
-					log.Fatal("generate event instance error" + string(err.Error()))
+					b.logger.Error("eventbus: could not receive event",
+						eh.F("event_type", et),
+						eh.F("error", err),
+					)
 					continue
 				} else {
 					//err = handler.HandleCommand(command)
+					ctx := message.Context(headers)
 					if handlers, ok := b.handlers[event.EventType()]; ok {
 						for h := range handlers {
-							h.HandleEvent(event)
+							eh.AdaptEventHandler(h).HandleEventContext(ctx, event)
 						}
 					}
-					//						if err != nil {
-					//							log.Fatal("commandhandle error : " + string(err.Error()))
-					//						}
 				}
 			}
 
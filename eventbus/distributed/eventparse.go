@@ -31,3 +31,24 @@ func (jep *JsonEventParse) Decode(msg string, event eh.Event) (eh.Event, error)
 		return event, nil
 	}
 }
+
+// CodecEventParse is an EventParse that uses an eventhorizon.Codec, for
+// example to send events as Protobuf or MessagePack instead of JSON.
+type CodecEventParse struct {
+	Codec eh.Codec
+}
+
+func (cep *CodecEventParse) Encode(event eh.Event) (string, error) {
+	msg, err := cep.Codec.Marshal(event)
+	if err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func (cep *CodecEventParse) Decode(msg string, event eh.Event) (eh.Event, error) {
+	if err := cep.Codec.Unmarshal([]byte(msg), event); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -29,3 +29,18 @@ func (rs *EventRoutingStrategy) GetTopicPattern() EventTopicPattern {
 	}
 	return EventTopicPattern("event." + rs.domain + ".#")
 }
+
+// TenantEventRoute prefixes the routing keys and topic pattern of a route with
+// the ID of a tenant.
+type TenantEventRoute struct {
+	EventRoute
+	tenantID eh.TenantID
+}
+
+func (rs *TenantEventRoute) GetRoutingKey(event eh.Event) EventRoutingKey {
+	return EventRoutingKey("tenant." + string(rs.tenantID) + "." + string(rs.EventRoute.GetRoutingKey(event)))
+}
+
+func (rs *TenantEventRoute) GetTopicPattern() EventTopicPattern {
+	return EventTopicPattern("tenant." + string(rs.tenantID) + "." + string(rs.EventRoute.GetTopicPattern()))
+}
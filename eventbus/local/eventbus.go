@@ -15,9 +15,12 @@
 package local
 
 import (
+	"context"
+	"errors"
 	"sync"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/internal/handling"
 )
 
 // EventBus is an event bus that notifies registered EventHandlers of
@@ -34,6 +37,17 @@ type EventBus struct {
 	// handlingStrategy is the strategy to use when handling event, for example
 	// to handle the asynchronously.
 	handlingStrategy eh.EventHandlingStrategy
+
+	// workers and queueSize configure the pooled and ordered strategies.
+	workers   int
+	queueSize int
+
+	// executor runs the handlers and observers with the strategy.
+	executor *handling.Executor
+	closed   bool
+
+	tenants   map[eh.TenantID]*EventBus
+	tenantsMu sync.Mutex
 }
 
 // NewEventBus creates a EventBus.
@@ -41,42 +55,175 @@ func NewEventBus() *EventBus {
 	b := &EventBus{
 		handlers:  make(map[eh.EventType]map[eh.EventHandler]bool),
 		observers: make(map[eh.EventObserver]bool),
+		tenants:   make(map[eh.TenantID]*EventBus),
+		executor:  handling.NewExecutor(eh.SimpleEventHandlingStrategy, 0, 0),
 	}
 	return b
 }
 
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventBus interface. Each tenant has its own separate
+// bus, which uses the handling strategy of this bus when created.
+func (b *EventBus) ForTenant(tenantID eh.TenantID) (eh.EventBus, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.handlerMu.RLock()
+	closed := b.closed
+	b.handlerMu.RUnlock()
+	if closed {
+		return nil, eh.ErrClosed
+	}
+
+	b.tenantsMu.Lock()
+	defer b.tenantsMu.Unlock()
+
+	if _, ok := b.tenants[tenantID]; !ok {
+		tenant := NewEventBus()
+		tenant.SetWorkerPool(b.workers, b.queueSize)
+		tenant.SetHandlingStrategy(b.handlingStrategy)
+		b.tenants[tenantID] = tenant
+	}
+
+	return b.tenants[tenantID], nil
+}
+
 // SetHandlingStrategy implements the SetHandlingStrategy method of the
-// eventhorizon.EventBus interface.
+// eventhorizon.EventBus interface. It waits for the events that are handled
+// with the previous strategy.
 func (b *EventBus) SetHandlingStrategy(strategy eh.EventHandlingStrategy) {
 	b.handlingStrategy = strategy
+	b.setExecutor()
+}
+
+// SetWorkerPool sets the number of workers and the size of the queues used by
+// the PooledEventHandlingStrategy and OrderedEventHandlingStrategy. The
+// default is one worker per CPU and queues of 1000 events.
+func (b *EventBus) SetWorkerPool(workers, queueSize int) {
+	b.workers = workers
+	b.queueSize = queueSize
+	b.setExecutor()
+}
+
+func (b *EventBus) setExecutor() {
+	b.handlerMu.Lock()
+	old := b.executor
+	b.executor = handling.NewExecutor(b.handlingStrategy, b.workers, b.queueSize)
+	b.handlerMu.Unlock()
+
+	old.Close()
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// The bus needs no starting and can be used directly.
+func (b *EventBus) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// Events published after closing return eventhorizon.ErrClosed, and the events
+// that are handled asynchronously are waited for until the context is done.
+// The buses of tenants are also closed.
+func (b *EventBus) Close(ctx context.Context) error {
+	b.handlerMu.Lock()
+	b.closed = true
+	executor := b.executor
+	b.handlerMu.Unlock()
+
+	b.tenantsMu.Lock()
+	tenants := make([]*EventBus, 0, len(b.tenants))
+	for _, tenant := range b.tenants {
+		tenants = append(tenants, tenant)
+	}
+	b.tenantsMu.Unlock()
+
+	var errs []error
+	for _, tenant := range tenants {
+		if err := tenant.Close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := executor.CloseContext(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// Wait waits until the events that are handled asynchronously are handled,
+// also for the buses of tenants. Events published while waiting are also
+// waited for.
+func (b *EventBus) Wait() {
+	b.tenantsMu.Lock()
+	tenants := make([]*EventBus, 0, len(b.tenants))
+	for _, tenant := range b.tenants {
+		tenants = append(tenants, tenant)
+	}
+	b.tenantsMu.Unlock()
+
+	b.executor.Wait()
+	for _, tenant := range tenants {
+		tenant.Wait()
+	}
 }
 
 // PublishEvent publishes an event to all handlers capable of handling it.
 // TODO: Put the event in a buffered channel consumed by another goroutine
 // to simulate a distributed bus.
 func (b *EventBus) PublishEvent(event eh.Event) {
-	b.handlerMu.RLock()
-	defer b.handlerMu.RUnlock()
-
-	// Handle the event if there is a handler registered.
-	if handlers, ok := b.handlers[event.EventType()]; ok {
-		for h := range handlers {
-			if b.handlingStrategy == eh.AsyncEventHandlingStrategy {
-				go h.HandleEvent(event)
-			} else {
-				h.HandleEvent(event)
-			}
-		}
+	b.PublishEventContext(context.Background(), event)
+}
+
+// PublishEventContext implements the PublishEventContext method of the
+// eventhorizon.ContextEventBus interface. Handlers and observers get the
+// context, which is not canceled for them with the asynchronous strategies as
+// they may outlive the publisher.
+func (b *EventBus) PublishEventContext(ctx context.Context, event eh.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
-	// Notify all observers about the event.
+	// Collect the handlers and observers, which are run without holding the
+	// lock as running them can wait for room in the queues of the executor.
+	b.handlerMu.RLock()
+	if b.closed {
+		b.handlerMu.RUnlock()
+		return eh.ErrClosed
+	}
+	executor := b.executor
+	var handlers []eh.ContextEventHandler
+	for h := range b.handlers[event.EventType()] {
+		handlers = append(handlers, eh.AdaptEventHandler(h))
+	}
+	observers := make([]eh.ContextEventObserver, 0, len(b.observers))
 	for o := range b.observers {
-		if b.handlingStrategy == eh.AsyncEventHandlingStrategy {
-			go o.Notify(event)
-		} else {
-			o.Notify(event)
-		}
+		observers = append(observers, eh.AdaptEventObserver(o))
 	}
+	b.handlerMu.RUnlock()
+
+	if executor.Async() {
+		ctx = context.WithoutCancel(ctx)
+	}
+
+	// Handle the event if there is a handler registered, and notify all
+	// observers about the event. The funcs are run together to be queued
+	// before any events that they publish.
+	fs := make([]func(context.Context), 0, len(handlers)+len(observers))
+	for _, handler := range handlers {
+		handler := handler
+		fs = append(fs, func(ctx context.Context) {
+			handler.HandleEventContext(ctx, event)
+		})
+	}
+	for _, observer := range observers {
+		observer := observer
+		fs = append(fs, func(ctx context.Context) {
+			observer.NotifyContext(ctx, event)
+		})
+	}
+	executor.Run(ctx, event.AggregateID(), fs...)
+
+	return nil
 }
 
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
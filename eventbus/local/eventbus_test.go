@@ -15,8 +15,13 @@
 package local
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/mocks"
@@ -105,3 +110,210 @@ func TestEventBusAsync(t *testing.T) {
 		t.Error("the observed events should be correct:", observer.Events)
 	}
 }
+
+func TestEventBusOrdered(t *testing.T) {
+	bus := NewEventBus()
+	bus.SetWorkerPool(2, 10)
+	bus.SetHandlingStrategy(eh.OrderedEventHandlingStrategy)
+
+	handler := &orderHandler{}
+	bus.AddHandler(handler, mocks.EventType)
+
+	id := eh.NewUUID()
+	var events []eh.Event
+	for i := 0; i < 20; i++ {
+		event := &mocks.Event{id, fmt.Sprintf("event%d", i)}
+		events = append(events, event)
+		bus.PublishEvent(event)
+	}
+	bus.Wait()
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	if !reflect.DeepEqual(handler.events, events) {
+		t.Error("the events should be handled in order:", handler.events)
+	}
+}
+
+func TestEventBusPublishFromHandler(t *testing.T) {
+	bus := NewEventBus()
+	bus.SetWorkerPool(1, 1)
+	bus.SetHandlingStrategy(eh.OrderedEventHandlingStrategy)
+	defer bus.Close(context.Background())
+
+	// The handler publishes more events than fit in the queue of its own
+	// worker, which should not block it or change the order of the events.
+	handler := &publishingHandler{bus: bus, published: make(chan struct{})}
+	bus.AddHandler(handler, mocks.EventType)
+	orderHandler := &orderHandler{}
+	bus.AddHandler(orderHandler, mocks.EventType)
+	bus.PublishEvent(&mocks.Event{eh.NewUUID(), "event1"})
+	select {
+	case <-handler.published:
+	case <-time.After(time.Second):
+		t.Fatal("the handler should not block when publishing")
+	}
+	bus.Wait()
+
+	orderHandler.mu.Lock()
+	defer orderHandler.mu.Unlock()
+	contents := []string{}
+	for _, event := range orderHandler.events {
+		contents = append(contents, event.(*mocks.Event).Content)
+	}
+	if !reflect.DeepEqual(contents, []string{"event1", "event2", "event3", "event4"}) {
+		t.Error("the events should be handled in order:", contents)
+	}
+}
+
+type publishingHandler struct {
+	bus       *EventBus
+	published chan struct{}
+}
+
+func (h *publishingHandler) HandlerType() eh.EventHandlerType { return "publishingHandler" }
+func (h *publishingHandler) HandleEvent(event eh.Event) {
+	h.HandleEventContext(context.Background(), event)
+}
+func (h *publishingHandler) HandleEventContext(ctx context.Context, event eh.Event) {
+	if event.(*mocks.Event).Content != "event1" {
+		return
+	}
+	for i := 2; i <= 4; i++ {
+		h.bus.PublishEventContext(ctx, &mocks.Event{event.AggregateID(), fmt.Sprintf("event%d", i)})
+	}
+	close(h.published)
+}
+
+type orderHandler struct {
+	events []eh.Event
+	mu     sync.Mutex
+}
+
+func (h *orderHandler) HandlerType() eh.EventHandlerType { return "orderHandler" }
+func (h *orderHandler) HandleEvent(event eh.Event) {
+	time.Sleep(time.Millisecond)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, event)
+}
+
+func TestEventBusTenants(t *testing.T) {
+	bus := NewEventBus()
+	observer := mocks.NewEventObserver()
+	bus.AddObserver(observer)
+
+	if _, err := bus.ForTenant(""); err != eh.ErrInvalidTenant {
+		t.Error("there should be a ErrInvalidTenant error:", err)
+	}
+	tenantBus, err := bus.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	tenantObserver := mocks.NewEventObserver()
+	tenantBus.AddObserver(tenantObserver)
+
+	t.Log("publish event for tenant")
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	tenantBus.PublishEvent(event1)
+	if !reflect.DeepEqual(tenantObserver.Events, []eh.Event{event1}) {
+		t.Error("the tenant observed events should be correct:", tenantObserver.Events)
+	}
+	if len(observer.Events) != 0 {
+		t.Error("there should be no observed events:", observer.Events)
+	}
+
+	t.Log("get bus for tenant again")
+	if b, _ := bus.ForTenant("a"); b != tenantBus {
+		t.Error("the bus should be the same:", b)
+	}
+}
+
+func TestEventBusContext(t *testing.T) {
+	bus := NewEventBus()
+	observer := mocks.NewContextEventObserver()
+	bus.AddObserver(observer)
+
+	t.Log("publish event with context")
+	ctx := eh.WithTenantID(context.Background(), "a")
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	if err := bus.PublishEventContext(ctx, event1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(observer.Contexts) != 1 {
+		t.Fatal("the event should be observed with a context:", len(observer.Contexts))
+	}
+	if tenantID, _ := eh.TenantIDFromContext(observer.Contexts[0]); tenantID != "a" {
+		t.Error("the context values should be passed on:", tenantID)
+	}
+
+	t.Log("publish event with canceled context")
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := bus.PublishEventContext(ctx, event1); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if !reflect.DeepEqual(observer.Events, []eh.Event{event1}) {
+		t.Error("the observed events should be correct:", observer.Events)
+	}
+}
+
+func TestEventBusClose(t *testing.T) {
+	ctx := context.Background()
+	bus := NewEventBus()
+	bus.SetHandlingStrategy(eh.AsyncEventHandlingStrategy)
+	if err := bus.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	tenantBus, err := bus.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	handler := &orderHandler{}
+	bus.AddHandler(handler, mocks.EventType)
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	bus.PublishEvent(event1)
+
+	t.Log("close and drain the handled events")
+	if err := bus.Close(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	handler.mu.Lock()
+	if !reflect.DeepEqual(handler.events, []eh.Event{event1}) {
+		t.Error("the events should be handled before closing:", handler.events)
+	}
+	handler.mu.Unlock()
+
+	t.Log("publish after closing")
+	event2 := &mocks.Event{eh.NewUUID(), "event2"}
+	if err := bus.PublishEventContext(ctx, event2); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if err := tenantBus.(*EventBus).PublishEventContext(ctx, event2); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error for the tenant:", err)
+	}
+	if _, err := bus.ForTenant("b"); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+
+	t.Log("close with a handler that is not done in time")
+	bus = NewEventBus()
+	bus.SetHandlingStrategy(eh.AsyncEventHandlingStrategy)
+	release := make(chan struct{})
+	bus.AddObserver(blockingObserver(release))
+	bus.PublishEvent(event1)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := bus.Close(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("there should be a DeadlineExceeded error:", err)
+	}
+	close(release)
+	bus.Wait()
+}
+
+type blockingObserver chan struct{}
+
+func (o blockingObserver) Notify(event eh.Event) {
+	<-o
+}
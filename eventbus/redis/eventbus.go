@@ -15,25 +15,36 @@
 package redis
 
 import (
+	"context"
 	"errors"
-	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/jpillora/backoff"
-	"gopkg.in/mgo.v2/bson"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/codec/bson"
+	"github.com/looplab/eventhorizon/compression"
+	"github.com/looplab/eventhorizon/internal/handling"
+	"github.com/looplab/eventhorizon/internal/message"
 )
 
-// ErrCouldNotMarshalEvent is when an event could not be marshaled into BSON.
+// ErrCouldNotMarshalEvent is when an event could not be marshaled.
 var ErrCouldNotMarshalEvent = errors.New("could not marshal event")
 
 // ErrCouldNotUnmarshalEvent is when an event could not be unmarshaled into a concrete type.
 var ErrCouldNotUnmarshalEvent = errors.New("could not unmarshal event")
 
+// ErrNotSubscribed is when the bus is not subscribed to Redis, because it is
+// not started or is reconnecting.
+var ErrNotSubscribed = errors.New("not subscribed")
+
+// compressionHeader is the message header with the compression of the event.
+const compressionHeader = "compression"
+
 // EventBus is an event bus that notifies registered EventHandlers of
 // published events. It will use the SimpleEventHandlingStrategy by default.
 type EventBus struct {
@@ -49,11 +60,59 @@ type EventBus struct {
 	// to handle the asynchronously.
 	handlingStrategy eh.EventHandlingStrategy
 
+	// workers and queueSize configure the pooled and ordered strategies.
+	workers   int
+	queueSize int
+
+	// executor runs the handlers and observers with the strategy, guarded by
+	// handlerMu.
+	executor *handling.Executor
+
+	// codec is used to encode and decode the events sent over Redis.
+	codec eh.Codec
+
+	// compressor is used to compress events of at least threshold bytes.
+	compressor compression.Compressor
+	threshold  int
+
+	// monitor is notified about received events and reconnects, if set.
+	monitor eh.BusMonitor
+
+	// logger is used for errors and for the payloads of events, if enabled.
+	logger eh.Logger
+
+	// registry is used to create the received events.
+	registry *eh.Registry
+
+	// subscribed is set when first subscribed, to detect reconnects. Only used
+	// by the receive goroutine.
+	subscribed bool
+
+	// connected is set while subscribed, for health checks.
+	connected atomic.Bool
+
+	appID  string
 	prefix string
 	pool   *redis.Pool
 	conn   *redis.PubSubConn
-	ready  chan bool // NOTE: Used for testing only
-	exit   chan bool
+
+	// ready is closed when first subscribed, exit is closed to stop receiving
+	// and done is closed when the receive goroutine has returned.
+	ready     chan struct{}
+	readyOnce sync.Once
+	exit      chan struct{}
+	exitOnce  sync.Once
+	done      chan struct{}
+	startOnce sync.Once
+
+	// closed is set when the bus is closed, guarded by handlerMu.
+	closed bool
+
+	// started is set when the bus is started, to start the buses of tenants
+	// when created, guarded by tenantsMu.
+	started   bool
+	tenants   map[eh.TenantID]*EventBus
+	tenantsMu sync.Mutex
 }
 
 // NewEventBus creates a EventBus for remote events.
@@ -83,67 +142,324 @@ func NewEventBus(appID, server, password string) (*EventBus, error) {
 	return NewEventBusWithPool(appID, pool)
 }
 
-// NewEventBusWithPool creates a EventBus for remote events.
+// NewEventBusWithPool creates a EventBus for remote events. Events are received
+// from other buses when the bus is started with Start.
 func NewEventBusWithPool(appID string, pool *redis.Pool) (*EventBus, error) {
 	b := &EventBus{
 		handlers:  make(map[eh.EventType]map[eh.EventHandler]bool),
 		observers: make(map[eh.EventObserver]bool),
+		codec:     bson.NewCodec(),
+		appID:     appID,
 		prefix:    appID + ":events:",
 		pool:      pool,
-		ready:     make(chan bool, 1), // Buffered to not block receive loop.
-		exit:      make(chan bool),
+		ready:     make(chan struct{}),
+		exit:      make(chan struct{}),
+		done:      make(chan struct{}),
+		tenants:   make(map[eh.TenantID]*EventBus),
+		logger:    eh.DefaultLogger(),
+		registry:  eh.DefaultRegistry(),
+		executor:  handling.NewExecutor(eh.SimpleEventHandlingStrategy, 0, 0),
 	}
 
-	go func() {
-		log.Println("eventbus: start receiving")
-		defer log.Println("eventbus: stop receiving")
+	return b, nil
+}
 
-		// Used for exponential fallback on reconnects.
-		delay := &backoff.Backoff{
-			Max: 5 * time.Minute,
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts receiving events, also for the buses of tenants, and waits until
+// subscribed to Redis or until the context is done. Receiving is retried in
+// the background if the context is done first, until the bus is closed.
+func (b *EventBus) Start(ctx context.Context) error {
+	b.handlerMu.RLock()
+	closed := b.closed
+	b.handlerMu.RUnlock()
+	if closed {
+		return eh.ErrClosed
+	}
+
+	b.tenantsMu.Lock()
+	b.started = true
+	buses := []*EventBus{b}
+	for _, tenant := range b.tenants {
+		buses = append(buses, tenant)
+	}
+	b.tenantsMu.Unlock()
+
+	for _, bus := range buses {
+		bus.startOnce.Do(func() { go bus.receive() })
+	}
+	for _, bus := range buses {
+		select {
+		case <-bus.ready:
+		case <-bus.exit:
+			return eh.ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+	}
+	return nil
+}
+
+func (b *EventBus) receive() {
+	defer close(b.done)
 
-		for {
-			if err := b.recv(delay); err != nil {
-				d := delay.Duration()
-				log.Printf("eventbus: receive failed, retrying in %s: %s", d, err)
-				time.Sleep(d)
+	b.logger.Info("eventbus: start receiving", eh.F("app_id", b.appID))
+	defer b.logger.Info("eventbus: stop receiving", eh.F("app_id", b.appID))
+
+	// Used for exponential fallback on reconnects.
+	delay := &backoff.Backoff{
+		Max: 5 * time.Minute,
+	}
+
+	for {
+		if err := b.recv(delay); err != nil {
+			d := delay.Duration()
+			b.logger.Warn("eventbus: receive failed, retrying",
+				eh.F("app_id", b.appID),
+				eh.F("delay", d),
+				eh.F("error", err),
+			)
+			select {
+			case <-time.After(d):
 				continue
+			case <-b.exit:
+				return
 			}
+		}
 
-			return
+		return
+	}
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It pings Redis and reports if the bus
+// and the buses of tenants are subscribed, which they must be to be healthy.
+func (b *EventBus) CheckHealth(ctx context.Context) (eh.Health, error) {
+	b.handlerMu.RLock()
+	closed := b.closed
+	b.handlerMu.RUnlock()
+	if closed {
+		return eh.Health{}, eh.ErrClosed
+	}
+
+	b.tenantsMu.Lock()
+	subscribed := b.connected.Load()
+	for _, tenant := range b.tenants {
+		if !tenant.connected.Load() {
+			subscribed = false
 		}
-	}()
+	}
+	tenants := len(b.tenants)
+	b.tenantsMu.Unlock()
+
+	start := time.Now()
+	err := b.ping(ctx)
+	health := eh.Health{
+		Latency: time.Since(start),
+		Details: map[string]interface{}{
+			"subscribed": subscribed,
+			"tenants":    tenants,
+		},
+	}
+	if err != nil {
+		return health, err
+	}
+	if !subscribed {
+		return health, ErrNotSubscribed
+	}
+	return health, nil
+}
 
-	return b, nil
+func (b *EventBus) ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	conn := b.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	return err
 }
 
 // SetHandlingStrategy implements the SetHandlingStrategy method of the
-// eventhorizon.EventBus interface.
+// eventhorizon.EventBus interface. It waits for the events that are handled
+// with the previous strategy.
 func (b *EventBus) SetHandlingStrategy(strategy eh.EventHandlingStrategy) {
 	b.handlingStrategy = strategy
+	b.setExecutor()
+}
+
+// SetWorkerPool sets the number of workers and the size of the queues used by
+// the PooledEventHandlingStrategy and OrderedEventHandlingStrategy. The
+// default is one worker per CPU and queues of 1000 events.
+func (b *EventBus) SetWorkerPool(workers, queueSize int) {
+	b.workers = workers
+	b.queueSize = queueSize
+	b.setExecutor()
+}
+
+func (b *EventBus) setExecutor() {
+	b.handlerMu.Lock()
+	old := b.executor
+	b.executor = handling.NewExecutor(b.handlingStrategy, b.workers, b.queueSize)
+	b.handlerMu.Unlock()
+
+	old.Close()
+}
+
+// Wait waits until the events that are handled asynchronously are handled,
+// also for the buses of tenants. Events published or received while waiting
+// are also waited for.
+func (b *EventBus) Wait() {
+	b.tenantsMu.Lock()
+	tenants := make([]*EventBus, 0, len(b.tenants))
+	for _, tenant := range b.tenants {
+		tenants = append(tenants, tenant)
+	}
+	b.tenantsMu.Unlock()
+
+	b.handlerMu.RLock()
+	executor := b.executor
+	b.handlerMu.RUnlock()
+
+	executor.Wait()
+	for _, tenant := range tenants {
+		tenant.Wait()
+	}
+}
+
+// SetCodec sets the codec to use for the events sent over Redis, the default
+// is BSON. All buses that share an app ID must use the same codec.
+func (b *EventBus) SetCodec(codec eh.Codec) {
+	b.codec = codec
+}
+
+// SetCompressor sets a compressor to use for events of at least threshold
+// bytes. Compressed events are sent with a header, which keeps buses without
+// compression able to receive them if the compressor is registered.
+func (b *EventBus) SetCompressor(compressor compression.Compressor, threshold int) {
+	b.compressor = compressor
+	b.threshold = threshold
+}
+
+// SetMonitor sets a monitor that is notified about the lag of received events
+// and about reconnects to Redis.
+func (b *EventBus) SetMonitor(monitor eh.BusMonitor) {
+	b.monitor = monitor
+}
+
+// SetLogger sets the logger, the default is eventhorizon.DefaultLogger. The
+// payloads of events are logged if enabled with eventhorizon.WithPayloads.
+func (b *EventBus) SetLogger(logger eh.Logger) {
+	b.logger = logger
+}
+
+// SetRegistry sets the registry used to create the received events, the
+// default is eventhorizon.DefaultRegistry.
+func (b *EventBus) SetRegistry(registry *eh.Registry) {
+	b.registry = registry
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventBus interface. The events of a tenant are sent
+// on separate channels, prefixed with both the app ID and the tenant ID. The
+// bus of a tenant shares the pool, codec, compressor, handling strategy,
+// monitor, logger and registry of this bus, is started when this bus is started
+// and is closed when this bus is closed.
+func (b *EventBus) ForTenant(tenantID eh.TenantID) (eh.EventBus, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	b.handlerMu.RLock()
+	closed := b.closed
+	b.handlerMu.RUnlock()
+	if closed {
+		return nil, eh.ErrClosed
+	}
+
+	b.tenantsMu.Lock()
+	defer b.tenantsMu.Unlock()
+
+	if tenant, ok := b.tenants[tenantID]; ok {
+		return tenant, nil
+	}
+
+	tenant, err := NewEventBusWithPool(b.appID+":tenants:"+string(tenantID), b.pool)
+	if err != nil {
+		return nil, err
+	}
+	tenant.SetWorkerPool(b.workers, b.queueSize)
+	tenant.SetHandlingStrategy(b.handlingStrategy)
+	tenant.SetCodec(b.codec)
+	tenant.SetCompressor(b.compressor, b.threshold)
+	tenant.SetMonitor(b.monitor)
+	tenant.SetLogger(b.logger)
+	tenant.SetRegistry(b.registry)
+	b.tenants[tenantID] = tenant
+
+	// Start receiving directly if this bus is already started.
+	if b.started {
+		tenant.startOnce.Do(func() { go tenant.receive() })
+	}
+
+	return tenant, nil
 }
 
 // PublishEvent publishes an event to all handlers capable of handling it.
 func (b *EventBus) PublishEvent(event eh.Event) {
+	b.PublishEventContext(context.Background(), event)
+}
+
+// PublishEventContext implements the PublishEventContext method of the
+// eventhorizon.ContextEventBus interface. The context values that have a
+// registered eventhorizon.ContextMarshalFunc are sent along with the event to
+// the observers.
+func (b *EventBus) PublishEventContext(ctx context.Context, event eh.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Collect the handlers, which are run without holding the lock as running
+	// them can wait for room in the queues of the executor.
 	b.handlerMu.RLock()
-	defer b.handlerMu.RUnlock()
+	if b.closed {
+		b.handlerMu.RUnlock()
+		return eh.ErrClosed
+	}
+	executor := b.executor
+	var handlers []eh.ContextEventHandler
+	for h := range b.handlers[event.EventType()] {
+		handlers = append(handlers, eh.AdaptEventHandler(h))
+	}
+	b.handlerMu.RUnlock()
 
 	// Handle the event if there is a handler registered.
-	if handlers, ok := b.handlers[event.EventType()]; ok {
-		for h := range handlers {
-			if b.handlingStrategy == eh.AsyncEventHandlingStrategy {
-				go h.HandleEvent(event)
-			} else {
-				h.HandleEvent(event)
-			}
-		}
+	handlerCtx := ctx
+	if executor.Async() {
+		handlerCtx = context.WithoutCancel(ctx)
+	}
+	// The funcs are run together to be queued before any events that they
+	// publish.
+	fs := make([]func(context.Context), 0, len(handlers))
+	for _, handler := range handlers {
+		handler := handler
+		fs = append(fs, func(ctx context.Context) {
+			handler.HandleEventContext(ctx, event)
+		})
 	}
+	executor.Run(handlerCtx, event.AggregateID(), fs...)
 
 	// Notify all observers about the event.
-	if err := b.notify(event); err != nil {
-		log.Println("error: event bus publish:", err)
+	if err := b.notify(ctx, event); err != nil {
+		b.logger.Error("eventbus: could not publish event",
+			eh.F("event_type", event.EventType()),
+			eh.F("aggregate_id", event.AggregateID()),
+			eh.F("error", err),
+		)
 	}
+
+	return nil
 }
 
 // AddHandler implements the AddHandler method of the eventhorizon.EventBus interface.
@@ -168,18 +484,59 @@ func (b *EventBus) AddObserver(observer eh.EventObserver) {
 	b.observers[observer] = true
 }
 
-// Close exits the recive goroutine by unsubscribing to all channels.
-func (b *EventBus) Close() error {
-	select {
-	case b.exit <- true:
-	default:
-		log.Println("eventbus: already closed")
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// Events published after closing return eventhorizon.ErrClosed. It stops
+// receiving by unsubscribing to all channels and waits for the events that are
+// handled asynchronously until the context is done, also for the buses of all
+// tenants, and then closes the pool.
+func (b *EventBus) Close(ctx context.Context) error {
+	b.tenantsMu.Lock()
+	tenants := make([]*EventBus, 0, len(b.tenants))
+	for _, tenant := range b.tenants {
+		tenants = append(tenants, tenant)
+	}
+	b.tenantsMu.Unlock()
+
+	var errs []error
+	for _, tenant := range tenants {
+		if err := tenant.stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := b.stop(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := b.pool.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func (b *EventBus) stop(ctx context.Context) error {
+	b.handlerMu.Lock()
+	b.closed = true
+	executor := b.executor
+	b.handlerMu.Unlock()
+
+	b.exitOnce.Do(func() { close(b.exit) })
+
+	// Wait for the receive goroutine if started, it is never started after
+	// the exit channel is closed.
+	started := true
+	b.startOnce.Do(func() { started = false })
+	if started {
+		select {
+		case <-b.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
 
-	return b.pool.Close()
+	// Wait for the events that are handled asynchronously.
+	return executor.CloseContext(ctx)
 }
 
-func (b *EventBus) notify(event eh.Event) error {
+func (b *EventBus) notify(ctx context.Context, event eh.Event) error {
 	conn := b.pool.Get()
 	defer conn.Close()
 
@@ -187,12 +544,28 @@ func (b *EventBus) notify(event eh.Event) error {
 		return err
 	}
 
-	// Marshal event data (using BSON for now).
+	// Marshal event data with the codec.
 	var data []byte
 	var err error
-	if data, err = bson.Marshal(event); err != nil {
+	if data, err = b.codec.Marshal(event); err != nil {
 		return ErrCouldNotMarshalEvent
 	}
+	eh.LogPayload(b.logger, "eventbus: publish event", data,
+		eh.F("event_type", event.EventType()),
+	)
+
+	// Compress large events and flag them with a header, and send the values
+	// of the context and the time sent as headers.
+	headers := message.ContextHeaders(ctx, nil)
+	message.SetSentAt(headers, time.Now())
+	data, name, err := compression.Compress(b.compressor, b.threshold, data)
+	if err != nil {
+		return err
+	}
+	if name != "" {
+		headers[compressionHeader] = name
+	}
+	data = message.Encode(headers, data)
 
 	// Publish all events on their own channel.
 	if _, err = conn.Do("PUBLISH", b.prefix+string(event.EventType()), data); err != nil {
@@ -205,15 +578,22 @@ func (b *EventBus) notify(event eh.Event) error {
 func (b *EventBus) recv(delay *backoff.Backoff) error {
 	conn := b.pool.Get()
 	defer conn.Close()
+	defer b.connected.Store(false)
 
 	pubSubConn := &redis.PubSubConn{Conn: conn}
+	returned := make(chan struct{})
+	defer close(returned)
 	go func() {
-		<-b.exit
+		select {
+		case <-b.exit:
+		case <-returned:
+			return
+		}
 		if err := pubSubConn.PUnsubscribe(); err != nil {
-			log.Println("eventbus: could not unsubscribe:", err)
+			b.logger.Warn("eventbus: could not unsubscribe", eh.F("error", err))
 		}
 		if err := pubSubConn.Close(); err != nil {
-			log.Println("eventbus: could not close connection:", err)
+			b.logger.Warn("eventbus: could not close connection", eh.F("error", err))
 		}
 	}()
 
@@ -229,42 +609,83 @@ func (b *EventBus) recv(delay *backoff.Backoff) error {
 			eventType := eh.EventType(strings.TrimPrefix(v.Channel, b.prefix))
 
 			// Create an event of the correct type.
-			event, err := eh.CreateEvent(eventType)
+			event, err := b.registry.CreateEvent(eventType)
 			if err != nil {
-				log.Println("error: event bus receive:", err)
+				b.logger.Error("eventbus: could not receive event",
+					eh.F("event_type", eventType),
+					eh.F("error", err),
+				)
 				continue
 			}
 
-			// Manually decode the raw BSON event.
-			data := bson.Raw{
-				Kind: 3,
-				Data: v.Data,
+			// Decode the message and decompress the event data if needed.
+			headers, data, err := message.Decode(v.Data)
+			if err != nil {
+				b.logger.Error("eventbus: could not receive event",
+					eh.F("event_type", eventType),
+					eh.F("error", err),
+				)
+				continue
+			}
+			data, err = compression.Decompress(b.compressor, headers[compressionHeader], data)
+			if err != nil {
+				b.logger.Error("eventbus: could not receive event",
+					eh.F("event_type", eventType),
+					eh.F("error", err),
+				)
+				continue
 			}
-			if err := data.Unmarshal(event); err != nil {
-				log.Println("error: event bus receive:", ErrCouldNotUnmarshalEvent)
+			eh.LogPayload(b.logger, "eventbus: receive event", data,
+				eh.F("event_type", eventType),
+			)
+
+			// Decode the event data with the codec.
+			if err := b.codec.Unmarshal(data, event); err != nil {
+				b.logger.Error("eventbus: could not receive event",
+					eh.F("event_type", eventType),
+					eh.F("error", ErrCouldNotUnmarshalEvent),
+				)
 				continue
 			}
 
+			// Restore the context values from the headers.
+			ctx := message.Context(headers)
+
+			if sentAt, ok := message.SentAt(headers); ok && b.monitor != nil {
+				b.monitor.Received(string(eventType), time.Since(sentAt))
+			}
+
+			// Notify the observers without holding the lock, as running them
+			// can wait for room in the queues of the executor.
 			b.handlerMu.RLock()
+			executor := b.executor
+			observers := make([]eh.ContextEventObserver, 0, len(b.observers))
 			for o := range b.observers {
-				if b.handlingStrategy == eh.AsyncEventHandlingStrategy {
-					go o.Notify(event)
-				} else {
-					o.Notify(event)
-				}
+				observers = append(observers, eh.AdaptEventObserver(o))
 			}
 			b.handlerMu.RUnlock()
 
+			fs := make([]func(context.Context), 0, len(observers))
+			for _, observer := range observers {
+				observer := observer
+				fs = append(fs, func(ctx context.Context) {
+					observer.NotifyContext(ctx, event)
+				})
+			}
+			executor.Run(ctx, event.AggregateID(), fs...)
+
 		case redis.Subscription:
 			if v.Kind == "psubscribe" {
-				log.Println("eventbus: subscribed to:", v.Channel)
+				b.logger.Info("eventbus: subscribed", eh.F("channel", v.Channel))
 				delay.Reset()
 
-				// Don't block if no one is receiving and buffer is full.
-				select {
-				case b.ready <- true:
-				default:
+				if b.subscribed && b.monitor != nil {
+					b.monitor.Reconnected()
 				}
+				b.subscribed = true
+				b.connected.Store(true)
+
+				b.readyOnce.Do(func() { close(b.ready) })
 			}
 		case error:
 			// Don' treat connections closed by the user as errors.
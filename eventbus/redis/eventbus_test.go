@@ -15,11 +15,15 @@
 package redis
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/compression"
+	"github.com/looplab/eventhorizon/compression/gzip"
 	"github.com/looplab/eventhorizon/mocks"
 )
 
@@ -40,7 +44,7 @@ func TestEventBus(t *testing.T) {
 	if bus == nil {
 		t.Fatal("there should be a bus")
 	}
-	defer bus.Close()
+	defer bus.Close(context.Background())
 	observer := mocks.NewEventObserver()
 	bus.AddObserver(observer)
 
@@ -49,13 +53,17 @@ func TestEventBus(t *testing.T) {
 	if err != nil {
 		t.Fatal("there should be no error:", err)
 	}
-	defer bus2.Close()
+	defer bus2.Close(context.Background())
 	observer2 := mocks.NewEventObserver()
 	bus2.AddObserver(observer2)
 
-	// Wait for subscriptions to be ready.
-	<-bus.ready
-	<-bus2.ready
+	// Start receiving and wait for the subscriptions.
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := bus2.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
 
 	t.Log("publish event without handler")
 	event1 := &mocks.Event{eh.NewUUID(), "event1"}
@@ -102,6 +110,59 @@ func TestEventBus(t *testing.T) {
 	}
 }
 
+func TestEventBusTenants(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	port := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+	url := ":6379"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	bus, err := NewEventBus("test", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer bus.Close(context.Background())
+	observer := mocks.NewEventObserver()
+	bus.AddObserver(observer)
+
+	if _, err := bus.ForTenant("a:b"); err != eh.ErrInvalidTenant {
+		t.Error("there should be a ErrInvalidTenant error:", err)
+	}
+	tenantBus, err := bus.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	tenantObserver := mocks.NewEventObserver()
+	tenantBus.AddObserver(tenantObserver)
+
+	// Start receiving and wait for the subscriptions.
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("publish event for tenant")
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	tenantBus.PublishEvent(event1)
+	tenantObserver.WaitForEvent(t)
+	if !reflect.DeepEqual(tenantObserver.Events, []eh.Event{event1}) {
+		t.Error("the tenant observed events should be correct:", tenantObserver.Events)
+	}
+
+	t.Log("publish event without tenant")
+	event2 := &mocks.Event{eh.NewUUID(), "event2"}
+	bus.PublishEvent(event2)
+	observer.WaitForEvent(t)
+	if !reflect.DeepEqual(observer.Events, []eh.Event{event2}) {
+		t.Error("the observed events should be correct:", observer.Events)
+	}
+	if !reflect.DeepEqual(tenantObserver.Events, []eh.Event{event1}) {
+		t.Error("the tenant observed events should be correct:", tenantObserver.Events)
+	}
+}
+
 func TestEventBusAsync(t *testing.T) {
 	// Support Wercker testing with MongoDB.
 	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
@@ -119,7 +180,7 @@ func TestEventBusAsync(t *testing.T) {
 	if bus == nil {
 		t.Fatal("there should be a bus")
 	}
-	defer bus.Close()
+	defer bus.Close(context.Background())
 	bus.SetHandlingStrategy(eh.AsyncEventHandlingStrategy)
 	observer := mocks.NewEventObserver()
 	bus.AddObserver(observer)
@@ -129,14 +190,18 @@ func TestEventBusAsync(t *testing.T) {
 	if err != nil {
 		t.Fatal("there should be no error:", err)
 	}
-	defer bus2.Close()
+	defer bus2.Close(context.Background())
 	bus2.SetHandlingStrategy(eh.AsyncEventHandlingStrategy)
 	observer2 := mocks.NewEventObserver()
 	bus2.AddObserver(observer2)
 
-	// Wait for subscriptions to be ready.
-	<-bus.ready
-	<-bus2.ready
+	// Start receiving and wait for the subscriptions.
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := bus2.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
 
 	t.Log("publish event without handler")
 	event1 := &mocks.Event{eh.NewUUID(), "event1"}
@@ -184,3 +249,198 @@ func TestEventBusAsync(t *testing.T) {
 		t.Error("the second observed events should be correct:", observer2.Events)
 	}
 }
+
+func TestEventBusWithCompression(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	port := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+	url := ":6379"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	bus, err := NewEventBus("test", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer bus.Close(context.Background())
+	bus.SetCompressor(gzip.NewCompressor(), 0)
+
+	// Another bus without compression, but with the compressor registered.
+	compression.Register(gzip.NewCompressor())
+	bus2, err := NewEventBus("test", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer bus2.Close(context.Background())
+	observer2 := mocks.NewEventObserver()
+	bus2.AddObserver(observer2)
+
+	// Start receiving and wait for the subscriptions.
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := bus2.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("publish compressed event")
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	bus.PublishEvent(event1)
+	observer2.WaitForEvent(t)
+	if !reflect.DeepEqual(observer2.Events, []eh.Event{event1}) {
+		t.Error("the second observed events should be correct:", observer2.Events)
+	}
+}
+
+func TestEventBusContext(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	port := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+	url := ":6379"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	bus, err := NewEventBus("test", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	defer bus.Close(context.Background())
+	observer := mocks.NewContextEventObserver()
+	bus.AddObserver(observer)
+
+	// Start receiving and wait for the subscriptions.
+	if err := bus.Start(context.Background()); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("publish event with context")
+	ctx := eh.WithTenantID(context.Background(), "a")
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	if err := bus.PublishEventContext(ctx, event1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	observer.WaitForEvent(t)
+	if len(observer.Contexts) != 1 {
+		t.Fatal("the event should be observed with a context:", len(observer.Contexts))
+	}
+	if tenantID, _ := eh.TenantIDFromContext(observer.Contexts[0]); tenantID != "a" {
+		t.Error("the context values should be sent with the event:", tenantID)
+	}
+
+	t.Log("publish event with canceled context")
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := bus.PublishEventContext(ctx, event1); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+}
+
+func TestEventBusClose(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	port := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+	url := ":6379"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	ctx := context.Background()
+	bus, err := NewEventBus("test", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	bus.SetHandlingStrategy(eh.AsyncEventHandlingStrategy)
+	tenantBus, err := bus.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := bus.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("close and stop receiving")
+	closed := make(chan error)
+	go func() {
+		closed <- bus.Close(ctx)
+	}()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the bus should be closed")
+	}
+
+	t.Log("use after closing")
+	event1 := &mocks.Event{eh.NewUUID(), "event1"}
+	if err := bus.PublishEventContext(ctx, event1); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if err := tenantBus.(*EventBus).PublishEventContext(ctx, event1); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error for the tenant:", err)
+	}
+	if err := bus.Start(ctx); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if _, err := bus.ForTenant("b"); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+}
+
+func TestEventBusHealth(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	port := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+	url := ":6379"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	ctx := context.Background()
+	bus, err := NewEventBus("test", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if _, err := bus.ForTenant("a"); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("check before starting")
+	health, err := bus.CheckHealth(ctx)
+	if err != ErrNotSubscribed {
+		t.Error("there should be a ErrNotSubscribed error:", err)
+	}
+	if health.Details["subscribed"] != false {
+		t.Error("the bus should not be subscribed:", health.Details)
+	}
+
+	t.Log("check after starting")
+	if err := bus.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	health, err = bus.CheckHealth(ctx)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if health.Details["subscribed"] != true || health.Details["tenants"] != 1 {
+		t.Error("the details should be correct:", health.Details)
+	}
+	if health.Latency <= 0 {
+		t.Error("the latency should be measured:", health.Latency)
+	}
+
+	t.Log("check after closing")
+	if err := bus.Close(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if _, err := bus.CheckHealth(ctx); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+}
@@ -64,6 +64,9 @@ func (a *TestAggregate) HandleCommand(command Command) error {
 		}
 		a.StoreEvent(&TestEvent{command.TestID, command.Content})
 		return nil
+	case *TestTenantCommand:
+		a.StoreEvent(&TestTenantEvent{TestID: command.TestID, Content: command.Content})
+		return nil
 	}
 	return errors.New("couldn't handle command")
 }
@@ -152,11 +155,12 @@ func (m *MockRepository) Save(aggregate Aggregate) error {
 }
 
 type MockEventRecord struct {
-	event Event
+	event   Event
+	version int
 }
 
 func (e MockEventRecord) Version() int {
-	return 0
+	return e.version
 }
 
 func (e MockEventRecord) Timestamp() time.Time {
@@ -182,8 +186,8 @@ func (m *MockEventStore) Save(events []Event, originalVersion int) error {
 	if m.err != nil {
 		return m.err
 	}
-	for _, event := range events {
-		m.Events = append(m.Events, MockEventRecord{event: event})
+	for i, event := range events {
+		m.Events = append(m.Events, MockEventRecord{event: event, version: originalVersion + i + 1})
 	}
 	return nil
 }
@@ -15,6 +15,7 @@
 package eventhorizon
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -22,6 +23,27 @@ import (
 // ErrNoEventsToAppend is when no events are available to append.
 var ErrNoEventsToAppend = errors.New("no events to append")
 
+// ErrIncorrectAggregateVersion is when saving events with an original version
+// that is not the version of the stored aggregate, which was changed since it
+// was loaded.
+var ErrIncorrectAggregateVersion = errors.New("incorrect aggregate version")
+
+// ErrStreamClosed is when saving events to a closed aggregate stream.
+var ErrStreamClosed = errors.New("stream is closed")
+
+// ErrStreamNotFound is when there is no stream for an aggregate.
+var ErrStreamNotFound = errors.New("stream not found")
+
+// ErrStreamNotClosed is when deleting or archiving a stream that is not closed.
+var ErrStreamNotClosed = errors.New("stream is not closed")
+
+// ErrNoSnapshot is when truncating a stream that has no snapshot.
+var ErrNoSnapshot = errors.New("no snapshot")
+
+// ErrInvalidSnapshot is when saving a snapshot with a version after the last
+// event of its stream.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
 // EventStore is an interface for an event sourcing event store.
 type EventStore interface {
 	// Save appends all events in the event stream to the store.
@@ -31,6 +53,27 @@ type EventStore interface {
 	Load(AggregateType, UUID) ([]EventRecord, error)
 }
 
+// IncrementalEventStore is an EventStore that can load only the newest events
+// of an aggregate, used to bring an already loaded aggregate up to date.
+type IncrementalEventStore interface {
+	EventStore
+
+	// LoadAfter loads the events for the aggregate id with a version after
+	// the version from the store.
+	LoadAfter(ctx context.Context, aggregateType AggregateType, id UUID, version int) ([]EventRecord, error)
+}
+
+// ImportEventStore is an EventStore that can save the event records of another
+// store as they are, used to copy streams between stores.
+type ImportEventStore interface {
+	EventStore
+
+	// Import appends the event records of an aggregate to the store, keeping
+	// their versions and timestamps. The first record must follow the last
+	// event of the stream.
+	Import(ctx context.Context, eventRecords []EventRecord) error
+}
+
 // AggregateRecord is a stored record of an aggregate in form of its events.
 // NOTE: Not currently used.
 type AggregateRecord interface {
@@ -50,3 +93,57 @@ type EventRecord interface {
 	// A string representation of the event.
 	String() string
 }
+
+// StreamInfo is the state of the event stream of an aggregate.
+type StreamInfo struct {
+	// Version is the version of the last event of the stream.
+	Version int
+	// Closed is true if the stream is closed.
+	Closed bool
+	// SnapshotVersion is the version of the latest snapshot, 0 if none.
+	SnapshotVersion int
+}
+
+// StreamEventStore is an EventStore that can close, truncate and delete the
+// event streams of aggregates.
+type StreamEventStore interface {
+	EventStore
+
+	// StreamInfo returns the state of the stream of an aggregate, or
+	// ErrStreamNotFound.
+	StreamInfo(ctx context.Context, aggregateType AggregateType, id UUID) (StreamInfo, error)
+
+	// CloseStream closes the stream of an aggregate, further saves return
+	// ErrStreamClosed. Streams that do not exist are closed as tombstones.
+	CloseStream(ctx context.Context, aggregateType AggregateType, id UUID) error
+
+	// TruncateStream removes the events of an aggregate up to the version of
+	// its latest snapshot. Returns ErrNoSnapshot if there is no snapshot.
+	TruncateStream(ctx context.Context, aggregateType AggregateType, id UUID) error
+
+	// DeleteStream removes a closed stream with its events and snapshot.
+	// Returns ErrStreamNotClosed if the stream is not closed.
+	DeleteStream(ctx context.Context, aggregateType AggregateType, id UUID) error
+}
+
+// Snapshot is the encoded state of an aggregate at a version.
+type Snapshot struct {
+	Version   int
+	Timestamp time.Time
+	State     []byte
+}
+
+// SnapshotEventStore is an EventStore that can save snapshots of aggregates,
+// which are used to load aggregates with truncated streams.
+type SnapshotEventStore interface {
+	EventStore
+
+	// SaveSnapshot saves a snapshot of an aggregate, replacing the previous
+	// snapshot. The snapshot of an aggregate without stream creates a stream
+	// at the version of the snapshot, which is used when archiving.
+	SaveSnapshot(ctx context.Context, aggregateType AggregateType, id UUID, snapshot Snapshot) error
+
+	// LoadSnapshot loads the latest snapshot of an aggregate, or nil if there
+	// is none.
+	LoadSnapshot(ctx context.Context, aggregateType AggregateType, id UUID) (*Snapshot, error)
+}
@@ -0,0 +1,381 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoStoreDefined is when a primary or archive store is not defined.
+var ErrNoStoreDefined = errors.New("no event store defined")
+
+// ErrArchiveConflict is when the archive already has a different stream for
+// an aggregate.
+var ErrArchiveConflict = errors.New("conflicting stream in archive")
+
+// ErrInvalidStore is when the store of a tenant can not be used by the
+// archive.
+var ErrInvalidStore = errors.New("invalid event store")
+
+// Store is an event store that can be used by the archive, with closable
+// streams, snapshots and imports of event records.
+type Store interface {
+	eh.ContextEventStore
+	eh.IncrementalEventStore
+	eh.StreamEventStore
+	eh.SnapshotEventStore
+	eh.ImportEventStore
+}
+
+// EventStore is an event store that moves the streams of closed aggregates
+// from a primary store to a secondary store used as cold storage. Loading is
+// transparent: streams that are not in the primary store are loaded from the
+// archive. Archived streams are closed, saving events to them returns
+// eventhorizon.ErrStreamClosed.
+type EventStore struct {
+	primary Store
+	archive Store
+}
+
+// NewEventStore creates a new EventStore with a primary store and an archive
+// store.
+func NewEventStore(primary, archive Store) (*EventStore, error) {
+	if primary == nil || archive == nil {
+		return nil, ErrNoStoreDefined
+	}
+
+	s := &EventStore{
+		primary: primary,
+		archive: archive,
+	}
+	return s, nil
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface, with the stores of the tenant
+// of both the primary and archive stores. Returns
+// eventhorizon.ErrTenantsNotSupported if the stores are not multi tenant.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	primary, err := forTenant(s.primary, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := forTenant(s.archive, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEventStore(primary, archive)
+}
+
+func forTenant(store Store, tenantID eh.TenantID) (Store, error) {
+	m, ok := store.(eh.MultiTenantEventStore)
+	if !ok {
+		return nil, eh.ErrTenantsNotSupported
+	}
+	tenant, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := tenant.(Store)
+	if !ok {
+		return nil, ErrInvalidStore
+	}
+	return s, nil
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts the primary and the archive store, if supported.
+func (s *EventStore) Start(ctx context.Context) error {
+	for _, store := range []Store{s.primary, s.archive} {
+		if l, ok := store.(eh.Lifecycle); ok {
+			if err := l.Start(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It checks both the primary and the
+// archive store, if supported. The latency is the highest of the two and the
+// details of each store are reported under "primary" and "archive".
+func (s *EventStore) CheckHealth(ctx context.Context) (eh.Health, error) {
+	health := eh.Health{Details: map[string]interface{}{}}
+	var errs []error
+	for name, store := range map[string]Store{"primary": s.primary, "archive": s.archive} {
+		c, ok := store.(eh.HealthChecker)
+		if !ok {
+			continue
+		}
+		h, err := c.CheckHealth(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+		if h.Latency > health.Latency {
+			health.Latency = h.Latency
+		}
+		if h.Details != nil {
+			health.Details[name] = h.Details
+		}
+	}
+	return health, errors.Join(errs...)
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes both the primary and the archive store, if supported, and returns
+// their errors joined.
+func (s *EventStore) Close(ctx context.Context) error {
+	var errs []error
+	for _, store := range []Store{s.primary, s.archive} {
+		if l, ok := store.(eh.Lifecycle); ok {
+			if err := l.Close(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.SaveContext(context.Background(), events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface. Events are saved in the primary
+// store, unless the stream is archived.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if len(events) == 0 {
+		return eh.ErrNoEventsToAppend
+	}
+	aggregateType, id := events[0].AggregateType(), events[0].AggregateID()
+
+	// New streams must not exist in the archive.
+	if originalVersion == 0 {
+		if err := s.checkNotArchived(ctx, aggregateType, id); err != nil {
+			return err
+		}
+	}
+
+	if err := s.primary.SaveContext(ctx, events, originalVersion); err != nil {
+		// Saving fails for archived streams, which are not in the primary
+		// store.
+		if archiveErr := s.checkNotArchived(ctx, aggregateType, id); archiveErr != nil {
+			return archiveErr
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface. The events are loaded from the
+// archive if the stream is not in the primary store.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	eventRecords, err := s.primary.LoadContext(ctx, aggregateType, id)
+	if err != nil || len(eventRecords) > 0 {
+		return eventRecords, err
+	}
+
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil || store == s.primary {
+		return eventRecords, err
+	}
+	return store.LoadContext(ctx, aggregateType, id)
+}
+
+// LoadAfter implements the LoadAfter method of the
+// eventhorizon.IncrementalEventStore interface.
+func (s *EventStore) LoadAfter(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID, version int) ([]eh.EventRecord, error) {
+	eventRecords, err := s.primary.LoadAfter(ctx, aggregateType, id, version)
+	if err != nil || len(eventRecords) > 0 {
+		return eventRecords, err
+	}
+
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil || store == s.primary {
+		return eventRecords, err
+	}
+	return store.LoadAfter(ctx, aggregateType, id, version)
+}
+
+// StreamInfo implements the StreamInfo method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) StreamInfo(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.StreamInfo, error) {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil {
+		return eh.StreamInfo{}, err
+	}
+	return store.StreamInfo(ctx, aggregateType, id)
+}
+
+// CloseStream implements the CloseStream method of the
+// eventhorizon.StreamEventStore interface. Archived streams are already
+// closed.
+func (s *EventStore) CloseStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil || store == s.archive {
+		return err
+	}
+	return store.CloseStream(ctx, aggregateType, id)
+}
+
+// TruncateStream implements the TruncateStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) TruncateStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	return store.TruncateStream(ctx, aggregateType, id)
+}
+
+// DeleteStream implements the DeleteStream method of the
+// eventhorizon.StreamEventStore interface, also for archived streams.
+func (s *EventStore) DeleteStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	return store.DeleteStream(ctx, aggregateType, id)
+}
+
+// SaveSnapshot implements the SaveSnapshot method of the
+// eventhorizon.SnapshotEventStore interface.
+func (s *EventStore) SaveSnapshot(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID, snapshot eh.Snapshot) error {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	return store.SaveSnapshot(ctx, aggregateType, id, snapshot)
+}
+
+// LoadSnapshot implements the LoadSnapshot method of the
+// eventhorizon.SnapshotEventStore interface.
+func (s *EventStore) LoadSnapshot(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (*eh.Snapshot, error) {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil {
+		return nil, err
+	}
+	return store.LoadSnapshot(ctx, aggregateType, id)
+}
+
+// Archive moves the closed stream of an aggregate, with its snapshot, from the
+// primary store to the archive. The archived events keep their versions and
+// timestamps. Returns eventhorizon.ErrStreamNotClosed if the stream is not
+// closed.
+//
+// Archiving a stream that was copied to the archive before, by an archiving
+// that failed to remove it from the primary store, only removes it.
+func (s *EventStore) Archive(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	info, err := s.primary.StreamInfo(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	if !info.Closed {
+		return eh.ErrStreamNotClosed
+	}
+
+	archived, err := s.archive.StreamInfo(ctx, aggregateType, id)
+	if err == eh.ErrStreamNotFound {
+		if err := s.copy(ctx, aggregateType, id); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if archived != info {
+		return ErrArchiveConflict
+	}
+
+	return s.primary.DeleteStream(ctx, aggregateType, id)
+}
+
+// copy copies a stream from the primary store to the archive.
+func (s *EventStore) copy(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	snapshot, err := s.primary.LoadSnapshot(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	eventRecords, err := s.primary.LoadContext(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+
+	// The snapshot of a truncated stream is saved first, which creates the
+	// stream at the version of the snapshot.
+	truncated := snapshot != nil && (len(eventRecords) == 0 || eventRecords[0].Version() > 1)
+	if truncated {
+		if err := s.archive.SaveSnapshot(ctx, aggregateType, id, *snapshot); err != nil {
+			return err
+		}
+	}
+
+	if len(eventRecords) > 0 {
+		if err := s.archive.Import(ctx, eventRecords); err != nil {
+			return err
+		}
+	}
+
+	if snapshot != nil && !truncated {
+		if err := s.archive.SaveSnapshot(ctx, aggregateType, id, *snapshot); err != nil {
+			return err
+		}
+	}
+
+	return s.archive.CloseStream(ctx, aggregateType, id)
+}
+
+// storeFor returns the store with the stream of an aggregate, the primary
+// store if the stream is in none of them.
+func (s *EventStore) storeFor(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (Store, error) {
+	if _, err := s.primary.StreamInfo(ctx, aggregateType, id); err == nil {
+		return s.primary, nil
+	} else if err != eh.ErrStreamNotFound {
+		return nil, err
+	}
+
+	if _, err := s.archive.StreamInfo(ctx, aggregateType, id); err == nil {
+		return s.archive, nil
+	} else if err != eh.ErrStreamNotFound {
+		return nil, err
+	}
+
+	return s.primary, nil
+}
+
+// checkNotArchived returns eventhorizon.ErrStreamClosed if the stream of an
+// aggregate is archived.
+func (s *EventStore) checkNotArchived(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	store, err := s.storeFor(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	if store == s.archive {
+		return eh.ErrStreamClosed
+	}
+	return nil
+}
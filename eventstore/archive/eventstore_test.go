@@ -0,0 +1,158 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"context"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/eventstore/testutil"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestEventStore(t *testing.T) {
+	if _, err := NewEventStore(nil, memory.NewEventStore()); err != ErrNoStoreDefined {
+		t.Error("there should be a ErrNoStoreDefined error:", err)
+	}
+
+	store, err := NewEventStore(memory.NewEventStore(), memory.NewEventStore())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.EventStoreCommonTests(t, store)
+}
+
+func TestEventStoreStreams(t *testing.T) {
+	store, err := NewEventStore(memory.NewEventStore(), memory.NewEventStore())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.EventStoreStreamTests(t, store)
+	testutil.EventStoreIncrementalTests(t, store)
+}
+
+func TestEventStoreTenants(t *testing.T) {
+	store, err := NewEventStore(memory.NewEventStore(), memory.NewEventStore())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.EventStoreTenantTests(t, store)
+}
+
+func TestEventStoreArchive(t *testing.T) {
+	ctx := context.Background()
+	primary := memory.NewEventStore()
+	archive := memory.NewEventStore()
+	store, err := NewEventStore(primary, archive)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := eh.NewUUID()
+	if err := store.Archive(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("there should be a ErrStreamNotFound error:", err)
+	}
+
+	event1 := &mocks.Event{id, "event1"}
+	event2 := &mocks.Event{id, "event2"}
+	event3 := &mocks.Event{id, "event3"}
+	if err := store.Save([]eh.Event{event1, event2, event3}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Archive(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotClosed {
+		t.Error("there should be a ErrStreamNotClosed error:", err)
+	}
+
+	t.Log("archive truncated stream")
+	if err := store.SaveSnapshot(ctx, mocks.AggregateType, id, eh.Snapshot{Version: 2, State: []byte("state")}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.TruncateStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.CloseStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	primaryRecords, err := primary.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Archive(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := primary.StreamInfo(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("the stream should be removed from the primary store:", err)
+	}
+	info, err := archive.StreamInfo(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if info != (eh.StreamInfo{Version: 3, Closed: true, SnapshotVersion: 2}) {
+		t.Error("the archived stream info should be correct:", info)
+	}
+
+	t.Log("load archived stream")
+	eventRecords, err := store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 1 || eventRecords[0].Version() != 3 || eventRecords[0].Event() != event3 {
+		t.Error("the archived events should be loaded:", eventRecords)
+	}
+	if len(primaryRecords) != 1 || !eventRecords[0].Timestamp().Equal(primaryRecords[0].Timestamp()) {
+		t.Error("the archived events should keep their timestamps:", eventRecords)
+	}
+	snapshot, err := store.LoadSnapshot(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot == nil || snapshot.Version != 2 || string(snapshot.State) != "state" {
+		t.Error("the archived snapshot should be loaded:", snapshot)
+	}
+
+	t.Log("save to archived stream")
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event4"}}, 3); err != eh.ErrStreamClosed {
+		t.Error("there should be a ErrStreamClosed error:", err)
+	}
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event1"}}, 0); err != eh.ErrStreamClosed {
+		t.Error("there should be a ErrStreamClosed error:", err)
+	}
+
+	t.Log("archive stream that is already copied")
+	id = eh.NewUUID()
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event1"}}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.CloseStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := archive.Save([]eh.Event{&mocks.Event{id, "event1"}}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := archive.CloseStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Archive(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := primary.StreamInfo(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("the stream should be removed from the primary store:", err)
+	}
+}
@@ -15,6 +15,8 @@
 package dynamodb
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -27,6 +29,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/compression"
 )
 
 // ErrCouldNotClearDB is when the database could not be cleared.
@@ -49,8 +52,12 @@ var ErrInvalidEvent = errors.New("invalid event")
 
 // EventStore implements an EventStore for MongoDB.
 type EventStore struct {
-	service *dynamodb.DynamoDB
-	config  *EventStoreConfig
+	service    *dynamodb.DynamoDB
+	config     *EventStoreConfig
+	codec      eh.Codec
+	compressor compression.Compressor
+	threshold  int
+	registry   *eh.Registry
 }
 
 // EventStoreConfig is a config for the DynamoDB event store.
@@ -80,8 +87,9 @@ func NewEventStore(config *EventStoreConfig) (*EventStore, error) {
 	service := dynamodb.New(session.New(), awsConfig)
 
 	s := &EventStore{
-		service: service,
-		config:  config,
+		service:  service,
+		config:   config,
+		registry: eh.DefaultRegistry(),
 	}
 
 	return s, nil
@@ -105,6 +113,8 @@ type dbEventRecord struct {
 	Version     int
 	Timestamp   time.Time
 	Payload     map[string]*dynamodb.AttributeValue
+	Data        []byte
+	Compression string
 	Event       eh.Event
 }
 
@@ -136,6 +146,17 @@ func (e eventRecord) String() string {
 
 // Save appends all events in the event stream to the database.
 func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.SaveContext(context.Background(), events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface. The DynamoDB
+// requests are canceled with the context.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if len(events) == 0 {
 		return eh.ErrNoEventsToAppend
 	}
@@ -150,20 +171,29 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 			return ErrInvalidEvent
 		}
 
-		// Marshal event payload.
-		payload, err := dynamodbattribute.MarshalMap(event)
-		if err != nil {
-			// return ErrCouldNotMarshalEvent
-			return err
-		}
-
 		// Create the event record with current version and timestamp.
 		eventRecords[i] = dbEventRecord{
 			AggregateID: event.AggregateID().String(),
 			Version:     1 + originalVersion + i,
 			Timestamp:   time.Now(),
 			EventType:   event.EventType(),
-			Payload:     payload,
+		}
+
+		// Marshal event payload, either as attributes or as binary data.
+		data, name, err := s.marshal(event)
+		if err != nil {
+			return ErrCouldNotMarshalEvent
+		}
+		if data != nil {
+			eventRecords[i].Data = data
+			eventRecords[i].Compression = name
+		} else {
+			payload, err := dynamodbattribute.MarshalMap(event)
+			if err != nil {
+				// return ErrCouldNotMarshalEvent
+				return err
+			}
+			eventRecords[i].Payload = payload
 		}
 	}
 
@@ -180,9 +210,12 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 			ConditionExpression: aws.String("attribute_not_exists(AggregateID) AND attribute_not_exists(Version)"),
 			Item:                item,
 		}
-		if _, err = s.service.PutItem(putParams); err != nil {
+		if _, err = s.service.PutItemWithContext(ctx, putParams); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			if err, ok := err.(awserr.RequestFailure); ok && err.Code() == "ConditionalCheckFailedException" {
-				return ErrCouldNotSaveAggregate
+				return eh.ErrIncorrectAggregateVersion
 			}
 			return err
 		}
@@ -194,6 +227,17 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 // Load loads all events for the aggregate id from the database.
 // Returns ErrNoEventsFound if no events can be found.
 func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface. The DynamoDB
+// requests are canceled with the context.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	params := &dynamodb.QueryInput{
 		TableName:              aws.String(s.config.Table),
 		KeyConditionExpression: aws.String("AggregateID = :id"),
@@ -202,8 +246,11 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 		},
 		ConsistentRead: aws.Bool(true),
 	}
-	resp, err := s.service.Query(params)
+	resp, err := s.service.QueryWithContext(ctx, params)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		return nil, err
 	}
 
@@ -223,12 +270,29 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 	eventRecords := make([]eh.EventRecord, len(dbEventRecords))
 	for i, record := range dbEventRecords {
 		// Create an event of the correct type.
-		event, err := eh.CreateEvent(record.EventType)
+		event, err := s.registry.CreateEvent(record.EventType)
 		if err != nil {
 			return nil, err
 		}
 
-		if err := dynamodbattribute.UnmarshalMap(record.Payload, event); err != nil {
+		// Decode the data with the codec, or the attributes if the record was
+		// not saved with a codec. Compressed data without a codec is JSON.
+		if record.Data != nil {
+			data, err := compression.Decompress(s.compressor, record.Compression, record.Data)
+			if err != nil {
+				return nil, ErrCouldNotUnmarshalEvent
+			}
+			if s.codec != nil {
+				err = s.codec.Unmarshal(data, event)
+			} else if record.Compression != "" {
+				err = json.Unmarshal(data, event)
+			} else {
+				err = ErrCouldNotUnmarshalEvent
+			}
+			if err != nil {
+				return nil, ErrCouldNotUnmarshalEvent
+			}
+		} else if err := dynamodbattribute.UnmarshalMap(record.Payload, event); err != nil {
 			// 	return nil, ErrCouldNotUnmarshalEvent
 			return nil, err
 		}
@@ -238,6 +302,8 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 		// Set conrcete event and zero out the decoded event.
 		record.Event = event
 		record.Payload = nil
+		record.Data = nil
+		record.Compression = ""
 
 		eventRecords[i] = eventRecord{dbEventRecord: record}
 	}
@@ -245,6 +311,114 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 	return eventRecords, nil
 }
 
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that the table exists.
+func (s *EventStore) Start(ctx context.Context) error {
+	_, err := s.describeTable(ctx)
+	return err
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It describes the table and reports its
+// status.
+func (s *EventStore) CheckHealth(ctx context.Context) (eh.Health, error) {
+	start := time.Now()
+	table, err := s.describeTable(ctx)
+	health := eh.Health{Latency: time.Since(start)}
+	if err != nil {
+		return health, err
+	}
+	health.Details = map[string]interface{}{
+		"table_status": aws.StringValue(table.TableStatus),
+	}
+	return health, nil
+}
+
+func (s *EventStore) describeTable(ctx context.Context) (*dynamodb.TableDescription, error) {
+	out, err := s.service.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.config.Table),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Table, nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// The DynamoDB service has no connections to close.
+func (s *EventStore) Close(ctx context.Context) error {
+	return nil
+}
+
+// SetCodec sets the codec to use for event payloads. By default events are
+// stored as DynamoDB attributes, with a codec they are instead stored as
+// binary data.
+func (s *EventStore) SetCodec(codec eh.Codec) {
+	s.codec = codec
+}
+
+// SetCompressor sets a compressor to use for events of at least threshold
+// bytes, which helps to keep large events below the DynamoDB item size limit.
+// Compressed events are stored as binary data together with the name of the
+// compression, as JSON if no codec is set.
+func (s *EventStore) SetCompressor(compressor compression.Compressor, threshold int) {
+	s.compressor = compressor
+	s.threshold = threshold
+}
+
+// SetRegistry sets the registry used to create the loaded events, the
+// default is eventhorizon.DefaultRegistry.
+func (s *EventStore) SetRegistry(registry *eh.Registry) {
+	s.registry = registry
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface. The events of a tenant are
+// stored in a separate table, named as the table of the store with the tenant
+// ID as suffix, which must be created with CreateTable before use.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	config := *s.config
+	config.Table = s.config.Table + "_" + string(tenantID)
+
+	tenant := *s
+	tenant.config = &config
+	return &tenant, nil
+}
+
+// marshal marshals the event with the codec and compresses it if needed.
+// Returns nil data if the event should be stored as attributes.
+func (s *EventStore) marshal(event eh.Event) ([]byte, string, error) {
+	if s.codec == nil && s.compressor == nil {
+		return nil, "", nil
+	}
+
+	var data []byte
+	var err error
+	if s.codec != nil {
+		data, err = s.codec.Marshal(event)
+	} else {
+		data, err = json.Marshal(event)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	data, name, err := compression.Compress(s.compressor, s.threshold, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Small events without a codec are still stored as attributes.
+	if s.codec == nil && name == "" {
+		return nil, "", nil
+	}
+	return data, name, nil
+}
+
 // CreateTable creates the table if it is not allready existing and correct.
 func (s *EventStore) CreateTable() error {
 	attributeDefinitions := []*dynamodb.AttributeDefinition{{
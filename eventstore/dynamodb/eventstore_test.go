@@ -49,4 +49,6 @@ func DoTestEventStore(t *testing.T, url string) {
 
 	// Run the actual test suite.
 	testutil.EventStoreCommonTests(t, store)
+	testutil.EventStoreContextTests(t, store)
+	testutil.EventStoreVersionTests(t, store)
 }
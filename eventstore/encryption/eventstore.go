@@ -0,0 +1,537 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoEventStoreDefined is if no event store has been defined.
+var ErrNoEventStoreDefined = errors.New("no event store defined")
+
+// ErrNoKeyStoreDefined is if no key store has been defined.
+var ErrNoKeyStoreDefined = errors.New("no key store defined")
+
+// ErrUnsupportedField is when a field tagged as pii can not be encrypted,
+// or when the field tagged as encrypted is not a map[string]string.
+var ErrUnsupportedField = errors.New("unsupported pii field")
+
+// ErrCouldNotDecrypt is when a field could not be decrypted with the key of
+// its subject.
+var ErrCouldNotDecrypt = errors.New("could not decrypt")
+
+// prefix marks a field value as encrypted. It is followed by the ID of the
+// key and the base64 encoded ciphertext, separated by a colon.
+const prefix = "eh:enc:"
+
+func init() {
+	// The tag options are not validation rules of commands.
+	eh.IgnoreTagOption("pii")
+	eh.IgnoreTagOption("encrypted")
+}
+
+// SubjectEvent is an event with personal data about another subject than the
+// aggregate it belongs to. The data is encrypted with the key of that subject.
+type SubjectEvent interface {
+	DataSubject() eh.UUID
+}
+
+// EventStore wraps an EventStore and encrypts all event fields tagged with
+// `eh:"pii"` using a per-subject key from a KeyStore. The subject is the
+// aggregate ID of the event, or the DataSubject of events implementing
+// SubjectEvent.
+//
+// String and []byte fields are encrypted in place. Fields of other kinds are
+// encoded with the codec and encrypted into a map[string]string field of the
+// event tagged with `eh:"encrypted"`, as they can not hold the encrypted
+// value, and are stored as zero values.
+//
+// When the key of a subject is deleted the encrypted fields are loaded as
+// empty values, which makes the personal data unreadable without rewriting
+// the events. The ID of the key is stored with the data, so that data that was
+// encrypted with a deleted key is still loaded as empty values after new data
+// of the subject has been saved with a new key. Loading data that can not be
+// decrypted with the key of its subject returns ErrCouldNotDecrypt.
+type EventStore struct {
+	eventStore eh.EventStore
+	keyStore   KeyStore
+	codec      eh.Codec
+}
+
+// NewEventStore creates a new EventStore.
+func NewEventStore(eventStore eh.EventStore, keyStore KeyStore) (*EventStore, error) {
+	if eventStore == nil {
+		return nil, ErrNoEventStoreDefined
+	}
+
+	if keyStore == nil {
+		return nil, ErrNoKeyStoreDefined
+	}
+
+	s := &EventStore{
+		eventStore: eventStore,
+		keyStore:   keyStore,
+	}
+	return s, nil
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface. The store of a tenant uses the
+// store of the tenant of the base store, if it is multi tenant, and the same
+// key store.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	eventStore := s.eventStore
+	if m, ok := eventStore.(eh.MultiTenantEventStore); ok {
+		var err error
+		if eventStore, err = m.ForTenant(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	tenant, err := NewEventStore(eventStore, s.keyStore)
+	if err != nil {
+		return nil, err
+	}
+	tenant.codec = s.codec
+	return tenant, nil
+}
+
+// SetCodec sets the codec to use for encoding pii fields that are not strings
+// or byte slices before encrypting them, the default is JSON.
+func (s *EventStore) SetCodec(codec eh.Codec) {
+	s.codec = codec
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts the wrapped store, if supported.
+func (s *EventStore) Start(ctx context.Context) error {
+	if l, ok := s.eventStore.(eh.Lifecycle); ok {
+		return l.Start(ctx)
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It checks the wrapped store, if supported.
+func (s *EventStore) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if c, ok := s.eventStore.(eh.HealthChecker); ok {
+		return c.CheckHealth(ctx)
+	}
+	return eh.Health{}, nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the wrapped store, if supported.
+func (s *EventStore) Close(ctx context.Context) error {
+	if l, ok := s.eventStore.(eh.Lifecycle); ok {
+		return l.Close(ctx)
+	}
+	return nil
+}
+
+// Save encrypts the pii fields of the events and appends them to the base
+// store. The events passed in are not modified.
+func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.SaveContext(context.Background(), events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	encrypted := make([]eh.Event, len(events))
+	for i, event := range events {
+		e, err := s.transform(event, true)
+		if err != nil {
+			return err
+		}
+		encrypted[i] = e
+	}
+
+	return eh.AdaptEventStore(s.eventStore).SaveContext(ctx, encrypted, originalVersion)
+}
+
+// Load loads all events for the aggregate id from the base store and decrypts
+// their pii fields.
+func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	records, err := eh.AdaptEventStore(s.eventStore).LoadContext(ctx, aggregateType, id)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := make([]eh.EventRecord, len(records))
+	for i, record := range records {
+		event, err := s.transform(record.Event(), false)
+		if err != nil {
+			return nil, err
+		}
+		decrypted[i] = eventRecord{record, event}
+	}
+
+	return decrypted, nil
+}
+
+// transform returns a copy of the event with all pii fields encrypted or
+// decrypted. Events without pii fields are returned as is.
+func (s *EventStore) transform(event eh.Event, encrypt bool) (eh.Event, error) {
+	v := reflect.ValueOf(event)
+	isPtr := v.Kind() == reflect.Ptr
+	if isPtr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct || !hasPIIFields(v.Type()) {
+		return event, nil
+	}
+
+	subject := event.AggregateID()
+	if e, ok := event.(SubjectEvent); ok {
+		subject = e.DataSubject()
+	}
+
+	var keyID string
+	var key []byte
+	var err error
+	if encrypt {
+		keyID, key, err = s.keyStore.CreateKey(subject)
+	} else {
+		keyID, key, err = s.keyStore.Key(subject)
+		if err == ErrKeyNotFound {
+			keyID, key, err = "", nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := reflect.New(v.Type())
+	c.Elem().Set(v)
+
+	t := &fieldTransform{
+		keyID:   keyID,
+		key:     key,
+		encrypt: encrypt,
+		codec:   s.codec,
+	}
+
+	// The map of encrypted values is replaced, never modified, as it is
+	// shared with the event that was copied.
+	holder, err := encryptedField(c.Elem())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", event.EventType(), err)
+	}
+	if holder.IsValid() {
+		if encrypt {
+			t.encrypted = map[string]string{}
+		} else {
+			t.encrypted = holder.Interface().(map[string]string)
+		}
+	}
+
+	if err := t.fields(c.Elem(), ""); err != nil {
+		return nil, fmt.Errorf("%s: %s", event.EventType(), err)
+	}
+
+	if holder.IsValid() {
+		if encrypt && len(t.encrypted) > 0 {
+			holder.Set(reflect.ValueOf(t.encrypted))
+		} else {
+			holder.Set(reflect.Zero(holder.Type()))
+		}
+	}
+
+	if isPtr {
+		return c.Interface().(eh.Event), nil
+	}
+	return c.Elem().Interface().(eh.Event), nil
+}
+
+// hasPIIFields returns true if the struct type has fields tagged as pii.
+func hasPIIFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if hasOption(f, "pii") {
+			return true
+		}
+		if f.Type.Kind() == reflect.Struct && hasPIIFields(f.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasOption returns true if the field has the option in its eh tag.
+func hasOption(f reflect.StructField, option string) bool {
+	for _, o := range strings.Split(f.Tag.Get("eh"), ",") {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// encryptedField returns the field of the struct value tagged as encrypted,
+// or an invalid value if there is none.
+func encryptedField(v reflect.Value) (reflect.Value, error) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !hasOption(sf, "encrypted") {
+			continue
+		}
+		if sf.Type != reflect.TypeOf(map[string]string{}) || !v.Field(i).CanSet() {
+			return reflect.Value{}, fmt.Errorf("%s: %s", ErrUnsupportedField, sf.Name)
+		}
+		return v.Field(i), nil
+	}
+	return reflect.Value{}, nil
+}
+
+// fieldTransform encrypts or decrypts the pii fields of an event.
+type fieldTransform struct {
+	keyID   string
+	key     []byte
+	encrypt bool
+	codec   eh.Codec
+
+	// encrypted is the encrypted values of pii fields that are not strings
+	// or byte slices, by field path. It is nil if the event has no field
+	// tagged as encrypted.
+	encrypted map[string]string
+}
+
+// fields encrypts or decrypts all pii fields of the struct value in place,
+// including the fields of nested structs. When decrypting fields that were
+// encrypted with a deleted key the fields are zeroed.
+func (t *fieldTransform) fields(v reflect.Value, path string) error {
+	st := v.Type()
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		f := v.Field(i)
+		fieldPath := path + sf.Name
+
+		if !hasOption(sf, "pii") {
+			if sf.Type.Kind() == reflect.Struct && f.CanSet() {
+				if err := t.fields(f, fieldPath+"/"); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !f.CanSet() {
+			return fmt.Errorf("%s: %s", ErrUnsupportedField, fieldPath)
+		}
+
+		switch {
+		case f.Kind() == reflect.String:
+			data, err := t.value([]byte(f.String()))
+			if err != nil {
+				return fmt.Errorf("%s: %s", err, fieldPath)
+			}
+			f.SetString(string(data))
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.Uint8:
+			if f.IsNil() {
+				continue
+			}
+			data, err := t.value(f.Bytes())
+			if err != nil {
+				return fmt.Errorf("%s: %s", err, fieldPath)
+			}
+			f.SetBytes(data)
+		case t.encrypted != nil:
+			if err := t.encoded(f, fieldPath); err != nil {
+				return fmt.Errorf("%s: %s", err, fieldPath)
+			}
+		default:
+			return fmt.Errorf("%s: %s", ErrUnsupportedField, fieldPath)
+		}
+	}
+	return nil
+}
+
+// encoded encrypts or decrypts a field of any other kind than string or byte
+// slice. The field is encoded with the codec and stored encrypted in the
+// field tagged as encrypted, as it can not hold the encrypted value itself.
+// The field is left as its zero value in the stored event.
+func (t *fieldTransform) encoded(f reflect.Value, path string) error {
+	if t.encrypt {
+		if f.IsZero() {
+			return nil
+		}
+		data, err := t.marshal(f.Interface())
+		if err != nil {
+			return err
+		}
+		if data, err = t.value(data); err != nil {
+			return err
+		}
+		t.encrypted[path] = string(data)
+		f.Set(reflect.Zero(f.Type()))
+		return nil
+	}
+
+	value, ok := t.encrypted[path]
+	if !ok {
+		return nil
+	}
+	data, err := t.value([]byte(value))
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	p := reflect.New(f.Type())
+	if err := t.unmarshal(data, p.Interface()); err != nil {
+		return ErrCouldNotDecrypt
+	}
+	f.Set(p.Elem())
+	return nil
+}
+
+func (t *fieldTransform) marshal(v interface{}) ([]byte, error) {
+	if t.codec != nil {
+		return t.codec.Marshal(v)
+	}
+	return json.Marshal(v)
+}
+
+func (t *fieldTransform) unmarshal(data []byte, v interface{}) error {
+	if t.codec != nil {
+		return t.codec.Unmarshal(data, v)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// value encrypts or decrypts a single value. Encrypted values are stored as a
+// prefixed string with the key ID and the base64 encoded ciphertext, values
+// without the prefix are loaded as is.
+func (t *fieldTransform) value(data []byte) ([]byte, error) {
+	if t.encrypt {
+		if len(data) == 0 {
+			return data, nil
+		}
+		ciphertext, err := seal(data, t.key, []byte(t.keyID))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(prefix + t.keyID + ":" + base64.StdEncoding.EncodeToString(ciphertext)), nil
+	}
+
+	if !strings.HasPrefix(string(data), prefix) {
+		return data, nil
+	}
+
+	keyID, encoded, ok := strings.Cut(string(data[len(prefix):]), ":")
+	if !ok {
+		return nil, ErrCouldNotDecrypt
+	}
+
+	// The key has been deleted, the data is gone. The subject may have a new
+	// key, which is not the key that the data was encrypted with.
+	if t.key == nil || keyID != t.keyID {
+		return data[:0], nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrCouldNotDecrypt
+	}
+	plaintext, err := open(ciphertext, t.key, []byte(keyID))
+	if err != nil {
+		// Either the data has been tampered with or it was encrypted with
+		// another key than the one of the subject.
+		return nil, ErrCouldNotDecrypt
+	}
+	return plaintext, nil
+}
+
+// seal encrypts the data with AES-GCM, prepending the nonce. The additional
+// data is authenticated but not encrypted.
+func seal(data, key, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// open decrypts data encrypted by seal, with the same additional data.
+func open(data, key, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrCouldNotDecrypt
+	}
+
+	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// eventRecord is an EventRecord with a decrypted event.
+type eventRecord struct {
+	eh.EventRecord
+	event eh.Event
+}
+
+// Event implements the Event method of the EventRecord interface.
+func (r eventRecord) Event() eh.Event {
+	return r.event
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"strings"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/eventstore/testutil"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestEventStore(t *testing.T) {
+	baseStore := memory.NewEventStore()
+	keyStore := NewMemoryKeyStore()
+	store, err := NewEventStore(baseStore, keyStore)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	// Run the actual test suite, the mock events have no pii fields.
+	testutil.EventStoreCommonTests(t, store)
+
+	t.Log("save event with pii fields")
+	id := eh.NewUUID()
+	event := &PersonalEvent{
+		ID:      id,
+		Name:    "Alice",
+		Email:   []byte("alice@example.com"),
+		Comment: "public",
+		Address: Address{Street: "Main Street 1"},
+	}
+	if err := store.Save([]eh.Event{event}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if event.Name != "Alice" || string(event.Email) != "alice@example.com" ||
+		event.Address.Street != "Main Street 1" {
+		t.Error("the saved event should not be modified:", event)
+	}
+
+	t.Log("the base store should have the pii fields encrypted")
+	records, err := baseStore.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	stored, ok := records[0].Event().(*PersonalEvent)
+	if !ok {
+		t.Fatal("the stored event should be correct:", records[0].Event())
+	}
+	if !strings.HasPrefix(stored.Name, prefix) || strings.Contains(stored.Name, "Alice") {
+		t.Error("the name should be encrypted:", stored.Name)
+	}
+	if !strings.HasPrefix(string(stored.Email), prefix) {
+		t.Error("the email should be encrypted:", string(stored.Email))
+	}
+	if !strings.HasPrefix(stored.Address.Street, prefix) {
+		t.Error("the nested street should be encrypted:", stored.Address.Street)
+	}
+	if stored.Comment != "public" {
+		t.Error("the comment should not be encrypted:", stored.Comment)
+	}
+
+	t.Log("load event with pii fields")
+	records, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	loaded, ok := records[0].Event().(*PersonalEvent)
+	if !ok {
+		t.Fatal("the loaded event should be correct:", records[0].Event())
+	}
+	if loaded.Name != "Alice" || string(loaded.Email) != "alice@example.com" ||
+		loaded.Address.Street != "Main Street 1" || loaded.Comment != "public" {
+		t.Error("the loaded event should be decrypted:", loaded)
+	}
+	if !strings.HasPrefix(stored.Name, prefix) {
+		t.Error("the stored event should not be modified by loading:", stored.Name)
+	}
+
+	t.Log("load event after deleting the key")
+	if err := keyStore.DeleteKey(id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	records, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	loaded = records[0].Event().(*PersonalEvent)
+	if loaded.Name != "" || len(loaded.Email) != 0 || loaded.Address.Street != "" {
+		t.Error("the pii fields should be unreadable:", loaded)
+	}
+	if loaded.Comment != "public" {
+		t.Error("the comment should still be readable:", loaded.Comment)
+	}
+
+	t.Log("load events after saving with a new key")
+	event2 := &PersonalEvent{ID: id, Name: "Alice Smith", Address: Address{Street: "Main Street 2"}}
+	if err := store.Save([]eh.Event{event2}, 1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	records, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if len(records) != 2 {
+		t.Fatal("there should be two events:", len(records))
+	}
+	loaded = records[0].Event().(*PersonalEvent)
+	if loaded.Name != "" || len(loaded.Email) != 0 || loaded.Address.Street != "" {
+		t.Error("the pii fields of the deleted key should be unreadable:", loaded)
+	}
+	loaded = records[1].Event().(*PersonalEvent)
+	if loaded.Name != "Alice Smith" || loaded.Address.Street != "Main Street 2" {
+		t.Error("the pii fields of the new key should be decrypted:", loaded)
+	}
+
+	t.Log("save event for another data subject")
+	subject := eh.NewUUID()
+	subjectEvent := &SubjectPersonalEvent{ID: eh.NewUUID(), Subject: subject, Name: "Bob"}
+	if err := store.Save([]eh.Event{subjectEvent}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, _, err := keyStore.Key(subject); err != nil {
+		t.Error("there should be a key for the data subject:", err)
+	}
+	if _, _, err := keyStore.Key(subjectEvent.ID); err != ErrKeyNotFound {
+		t.Error("there should be no key for the aggregate:", err)
+	}
+
+	t.Log("save event with unsupported pii field")
+	err = store.Save([]eh.Event{&UnsupportedEvent{ID: eh.NewUUID(), Age: 42}}, 0)
+	if err == nil || !strings.Contains(err.Error(), ErrUnsupportedField.Error()) {
+		t.Error("there should be a ErrUnsupportedField error:", err)
+	}
+}
+
+func TestEventStoreEncodedFields(t *testing.T) {
+	baseStore := memory.NewEventStore()
+	keyStore := NewMemoryKeyStore()
+	store, err := NewEventStore(baseStore, keyStore)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("save event with non string pii fields")
+	id := eh.NewUUID()
+	event := &EncodedEvent{ID: id, Age: 42, Tags: []string{"a", "b"}, Name: "Alice"}
+	if err := store.Save([]eh.Event{event}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if event.Age != 42 || event.Encrypted != nil {
+		t.Error("the saved event should not be modified:", event)
+	}
+
+	t.Log("the base store should have the pii fields encrypted")
+	records, err := baseStore.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	stored := records[0].Event().(*EncodedEvent)
+	if stored.Age != 0 || stored.Tags != nil {
+		t.Error("the pii fields should be zero values:", stored)
+	}
+	if !strings.HasPrefix(stored.Encrypted["Age"], prefix) ||
+		!strings.HasPrefix(stored.Encrypted["Tags"], prefix) {
+		t.Error("the pii fields should be encrypted:", stored.Encrypted)
+	}
+	if !strings.HasPrefix(stored.Name, prefix) {
+		t.Error("the name should be encrypted in place:", stored.Name)
+	}
+
+	t.Log("load event with non string pii fields")
+	records, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	loaded := records[0].Event().(*EncodedEvent)
+	if loaded.Age != 42 || len(loaded.Tags) != 2 || loaded.Tags[1] != "b" ||
+		loaded.Name != "Alice" || loaded.Encrypted != nil {
+		t.Error("the loaded event should be decrypted:", loaded)
+	}
+	if !strings.HasPrefix(stored.Encrypted["Age"], prefix) {
+		t.Error("the stored event should not be modified by loading:", stored.Encrypted)
+	}
+
+	t.Log("load event with a wrong key")
+	keyStore.keysMu.Lock()
+	keyStore.keys[id] = memoryKey{id: keyStore.keys[id].id, key: make([]byte, 32)}
+	keyStore.keysMu.Unlock()
+	if _, err := store.Load(mocks.AggregateType, id); err == nil ||
+		!strings.Contains(err.Error(), ErrCouldNotDecrypt.Error()) {
+		t.Error("there should be a ErrCouldNotDecrypt error:", err)
+	}
+
+	t.Log("load event after deleting the key")
+	if err := keyStore.DeleteKey(id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	records, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	loaded = records[0].Event().(*EncodedEvent)
+	if loaded.Age != 0 || loaded.Tags != nil || loaded.Name != "" {
+		t.Error("the pii fields should be unreadable:", loaded)
+	}
+}
+
+func TestEventStoreTenants(t *testing.T) {
+	store, err := NewEventStore(memory.NewEventStore(), NewMemoryKeyStore())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.EventStoreTenantTests(t, store)
+}
+
+func TestNewEventStore(t *testing.T) {
+	if _, err := NewEventStore(nil, NewMemoryKeyStore()); err != ErrNoEventStoreDefined {
+		t.Error("there should be a ErrNoEventStoreDefined error:", err)
+	}
+	if _, err := NewEventStore(memory.NewEventStore(), nil); err != ErrNoKeyStoreDefined {
+		t.Error("there should be a ErrNoKeyStoreDefined error:", err)
+	}
+}
+
+type Address struct {
+	Street string `eh:"pii"`
+}
+
+type PersonalEvent struct {
+	ID      eh.UUID
+	Name    string `eh:"pii"`
+	Email   []byte `eh:"pii"`
+	Comment string
+	Address Address
+}
+
+func (e PersonalEvent) AggregateID() eh.UUID            { return e.ID }
+func (e PersonalEvent) AggregateType() eh.AggregateType { return mocks.AggregateType }
+func (e PersonalEvent) EventType() eh.EventType         { return eh.EventType("PersonalEvent") }
+
+type SubjectPersonalEvent struct {
+	ID      eh.UUID
+	Subject eh.UUID
+	Name    string `eh:"pii"`
+}
+
+func (e SubjectPersonalEvent) AggregateID() eh.UUID            { return e.ID }
+func (e SubjectPersonalEvent) AggregateType() eh.AggregateType { return mocks.AggregateType }
+func (e SubjectPersonalEvent) EventType() eh.EventType         { return eh.EventType("SubjectPersonalEvent") }
+func (e SubjectPersonalEvent) DataSubject() eh.UUID            { return e.Subject }
+
+type EncodedEvent struct {
+	ID        eh.UUID
+	Name      string            `eh:"pii"`
+	Age       int               `eh:"pii"`
+	Tags      []string          `eh:"pii"`
+	Encrypted map[string]string `eh:"encrypted"`
+}
+
+func (e EncodedEvent) AggregateID() eh.UUID            { return e.ID }
+func (e EncodedEvent) AggregateType() eh.AggregateType { return mocks.AggregateType }
+func (e EncodedEvent) EventType() eh.EventType         { return eh.EventType("EncodedEvent") }
+
+type UnsupportedEvent struct {
+	ID  eh.UUID
+	Age int `eh:"pii"`
+}
+
+func (e UnsupportedEvent) AggregateID() eh.UUID            { return e.ID }
+func (e UnsupportedEvent) AggregateType() eh.AggregateType { return mocks.AggregateType }
+func (e UnsupportedEvent) EventType() eh.EventType         { return eh.EventType("UnsupportedEvent") }
+
+func TestEventStoreContext(t *testing.T) {
+	store, err := NewEventStore(memory.NewEventStore(), NewMemoryKeyStore())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.EventStoreContextTests(t, store)
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package encryption
+
+import (
+	"crypto/rand"
+	"errors"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrKeyNotFound is when there is no key for a subject, for example when it
+// has been deleted.
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyStore is a store of per-subject data keys. Deleting the key of a subject
+// makes all data encrypted with it unreadable, which is crypto-shredding.
+//
+// Each key has an ID that is stored with the data encrypted by it. A key that
+// is created after deleting the key of a subject must get a new ID, so that
+// the data encrypted with the deleted key stays unreadable.
+type KeyStore interface {
+	// Key returns the data key for a subject and its ID. Returns
+	// ErrKeyNotFound if the subject has no key.
+	Key(subject eh.UUID) (string, []byte, error)
+
+	// CreateKey returns the data key for a subject and its ID, creating a new
+	// key if the subject has none.
+	CreateKey(subject eh.UUID) (string, []byte, error)
+
+	// DeleteKey deletes the data key for a subject.
+	DeleteKey(subject eh.UUID) error
+}
+
+// MemoryKeyStore is a KeyStore that keeps the keys in memory, useful for
+// testing and development.
+type MemoryKeyStore struct {
+	keys   map[eh.UUID]memoryKey
+	keysMu sync.RWMutex
+}
+
+// memoryKey is a key with its ID, which is a new UUID for each key.
+type memoryKey struct {
+	id  string
+	key []byte
+}
+
+// NewMemoryKeyStore creates a new MemoryKeyStore.
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys: make(map[eh.UUID]memoryKey),
+	}
+}
+
+// Key implements the Key method of the KeyStore interface.
+func (s *MemoryKeyStore) Key(subject eh.UUID) (string, []byte, error) {
+	s.keysMu.RLock()
+	defer s.keysMu.RUnlock()
+
+	if k, ok := s.keys[subject]; ok {
+		return k.id, k.key, nil
+	}
+	return "", nil, ErrKeyNotFound
+}
+
+// CreateKey implements the CreateKey method of the KeyStore interface.
+func (s *MemoryKeyStore) CreateKey(subject eh.UUID) (string, []byte, error) {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+
+	if k, ok := s.keys[subject]; ok {
+		return k.id, k.key, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", nil, err
+	}
+	k := memoryKey{id: eh.NewUUID().String(), key: key}
+	s.keys[subject] = k
+
+	return k.id, k.key, nil
+}
+
+// DeleteKey implements the DeleteKey method of the KeyStore interface.
+func (s *MemoryKeyStore) DeleteKey(subject eh.UUID) error {
+	s.keysMu.Lock()
+	defer s.keysMu.Unlock()
+
+	if _, ok := s.keys[subject]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(s.keys, subject)
+
+	return nil
+}
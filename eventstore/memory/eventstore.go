@@ -15,6 +15,7 @@
 package memory
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -24,6 +25,10 @@ import (
 )
 
 // ErrCouldNotSaveAggregate is when an aggregate could not be saved.
+//
+// Deprecated: it is no longer returned, the store returns
+// eventhorizon.ErrIncorrectAggregateVersion when the version of an aggregate
+// does not match.
 var ErrCouldNotSaveAggregate = errors.New("could not save aggregate")
 
 // ErrInvalidEvent is when an event does not implement the Event interface.
@@ -33,21 +38,54 @@ var ErrInvalidEvent = errors.New("invalid event")
 type EventStore struct {
 	aggregateRecords   map[eh.UUID]aggregateRecord
 	aggregateRecordsMu sync.RWMutex
+
+	// closed is set when the store is closed, guarded by aggregateRecordsMu.
+	closed bool
+
+	tenants   map[eh.TenantID]*EventStore
+	tenantsMu sync.Mutex
 }
 
 // NewEventStore creates a new EventStore.
 func NewEventStore() *EventStore {
 	s := &EventStore{
 		aggregateRecords: make(map[eh.UUID]aggregateRecord),
+		tenants:          make(map[eh.TenantID]*EventStore),
 	}
 	return s
 }
 
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface. Each tenant has its own
+// separate memory store.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.aggregateRecordsMu.RLock()
+	closed := s.closed
+	s.aggregateRecordsMu.RUnlock()
+	if closed {
+		return nil, eh.ErrClosed
+	}
+
+	s.tenantsMu.Lock()
+	defer s.tenantsMu.Unlock()
+
+	if _, ok := s.tenants[tenantID]; !ok {
+		s.tenants[tenantID] = NewEventStore()
+	}
+
+	return s.tenants[tenantID], nil
+}
+
 type aggregateRecord struct {
 	AggregateID eh.UUID
 	Version     int
 	Events      []dbEventRecord
-	// Snapshot    eh.Aggregate
+	Closed      bool
+	Snapshot    *eh.Snapshot
 }
 
 // dbEventRecord is the internal event record for the memory event store.
@@ -84,8 +122,41 @@ func (e eventRecord) String() string {
 	return fmt.Sprintf("%s@%d", e.dbEventRecord.EventType, e.dbEventRecord.Version)
 }
 
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// The store is in memory and needs no starting.
+func (s *EventStore) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// The store is in memory and has nothing to release, but all methods return
+// eventhorizon.ErrClosed after closing. The stores of tenants are also closed.
+func (s *EventStore) Close(ctx context.Context) error {
+	s.aggregateRecordsMu.Lock()
+	s.closed = true
+	s.aggregateRecordsMu.Unlock()
+
+	s.tenantsMu.Lock()
+	defer s.tenantsMu.Unlock()
+
+	for _, tenant := range s.tenants {
+		tenant.Close(ctx)
+	}
+	return nil
+}
+
 // Save appends all events in the event stream to the memory store.
 func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.SaveContext(context.Background(), events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if len(events) == 0 {
 		return eh.ErrNoEventsToAppend
 	}
@@ -108,11 +179,61 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 		}
 	}
 
+	return s.save(aggregateID, eventRecords, originalVersion)
+}
+
+// Import implements the Import method of the
+// eventhorizon.ImportEventStore interface. The event records are saved with
+// their versions and timestamps.
+func (s *EventStore) Import(ctx context.Context, eventRecords []eh.EventRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(eventRecords) == 0 {
+		return eh.ErrNoEventsToAppend
+	}
+
+	// Only accept consecutive event records belonging to the same aggregate.
+	records := make([]dbEventRecord, len(eventRecords))
+	aggregateID := eventRecords[0].Event().AggregateID()
+	originalVersion := eventRecords[0].Version() - 1
+	for i, eventRecord := range eventRecords {
+		if eventRecord.Event().AggregateID() != aggregateID ||
+			eventRecord.Version() != 1+originalVersion+i {
+			return ErrInvalidEvent
+		}
+
+		records[i] = dbEventRecord{
+			EventType: eventRecord.Event().EventType(),
+			Version:   eventRecord.Version(),
+			Timestamp: eventRecord.Timestamp(),
+			Event:     eventRecord.Event(),
+		}
+	}
+
+	return s.save(aggregateID, records, originalVersion)
+}
+
+// save saves event records to the stream of an aggregate.
+func (s *EventStore) save(aggregateID eh.UUID, eventRecords []dbEventRecord, originalVersion int) error {
 	s.aggregateRecordsMu.Lock()
 	defer s.aggregateRecordsMu.Unlock()
 
+	if s.closed {
+		return eh.ErrClosed
+	}
+
+	if aggregate, ok := s.aggregateRecords[aggregateID]; ok && aggregate.Closed {
+		return eh.ErrStreamClosed
+	}
+
 	// Either insert a new aggregate or append to an existing.
 	if originalVersion == 0 {
+		if _, ok := s.aggregateRecords[aggregateID]; ok {
+			return eh.ErrIncorrectAggregateVersion
+		}
+
 		aggregate := aggregateRecord{
 			AggregateID: aggregateID,
 			Version:     len(eventRecords),
@@ -124,16 +245,15 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 		// Increment aggregate version on insert of new event record, and
 		// only insert if version of aggregate is matching (ie not changed
 		// since loading the aggregate).
-		if aggregate, ok := s.aggregateRecords[aggregateID]; ok {
-			if aggregate.Version != originalVersion {
-				return ErrCouldNotSaveAggregate
-			}
+		aggregate, ok := s.aggregateRecords[aggregateID]
+		if !ok || aggregate.Version != originalVersion {
+			return eh.ErrIncorrectAggregateVersion
+		}
 
-			aggregate.Version += len(eventRecords)
-			aggregate.Events = append(aggregate.Events, eventRecords...)
+		aggregate.Version += len(eventRecords)
+		aggregate.Events = append(aggregate.Events, eventRecords...)
 
-			s.aggregateRecords[aggregateID] = aggregate
-		}
+		s.aggregateRecords[aggregateID] = aggregate
 	}
 
 	return nil
@@ -142,9 +262,23 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 // Load loads all events for the aggregate id from the memory store.
 // Returns ErrNoEventsFound if no events can be found.
 func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	s.aggregateRecordsMu.RLock()
 	defer s.aggregateRecordsMu.RUnlock()
 
+	if s.closed {
+		return nil, eh.ErrClosed
+	}
+
 	aggregate, ok := s.aggregateRecords[id]
 	if !ok {
 		return []eh.EventRecord{}, nil
@@ -157,3 +291,198 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 
 	return eventRecords, nil
 }
+
+// LoadAfter implements the LoadAfter method of the
+// eventhorizon.IncrementalEventStore interface.
+func (s *EventStore) LoadAfter(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID, version int) ([]eh.EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.aggregateRecordsMu.RLock()
+	defer s.aggregateRecordsMu.RUnlock()
+
+	if s.closed {
+		return nil, eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok {
+		return []eh.EventRecord{}, nil
+	}
+
+	eventRecords := []eh.EventRecord{}
+	for _, record := range aggregate.Events {
+		if record.Version > version {
+			eventRecords = append(eventRecords, eventRecord{dbEventRecord: record})
+		}
+	}
+
+	return eventRecords, nil
+}
+
+// StreamInfo implements the StreamInfo method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) StreamInfo(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.StreamInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return eh.StreamInfo{}, err
+	}
+
+	s.aggregateRecordsMu.RLock()
+	defer s.aggregateRecordsMu.RUnlock()
+
+	if s.closed {
+		return eh.StreamInfo{}, eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok {
+		return eh.StreamInfo{}, eh.ErrStreamNotFound
+	}
+
+	info := eh.StreamInfo{
+		Version: aggregate.Version,
+		Closed:  aggregate.Closed,
+	}
+	if aggregate.Snapshot != nil {
+		info.SnapshotVersion = aggregate.Snapshot.Version
+	}
+
+	return info, nil
+}
+
+// CloseStream implements the CloseStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) CloseStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.aggregateRecordsMu.Lock()
+	defer s.aggregateRecordsMu.Unlock()
+
+	if s.closed {
+		return eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok {
+		aggregate = aggregateRecord{AggregateID: id}
+	}
+	aggregate.Closed = true
+	s.aggregateRecords[id] = aggregate
+
+	return nil
+}
+
+// TruncateStream implements the TruncateStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) TruncateStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.aggregateRecordsMu.Lock()
+	defer s.aggregateRecordsMu.Unlock()
+
+	if s.closed {
+		return eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok {
+		return eh.ErrStreamNotFound
+	}
+	if aggregate.Snapshot == nil {
+		return eh.ErrNoSnapshot
+	}
+
+	var events []dbEventRecord
+	for _, record := range aggregate.Events {
+		if record.Version > aggregate.Snapshot.Version {
+			events = append(events, record)
+		}
+	}
+	aggregate.Events = events
+	s.aggregateRecords[id] = aggregate
+
+	return nil
+}
+
+// DeleteStream implements the DeleteStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) DeleteStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.aggregateRecordsMu.Lock()
+	defer s.aggregateRecordsMu.Unlock()
+
+	if s.closed {
+		return eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok {
+		return eh.ErrStreamNotFound
+	}
+	if !aggregate.Closed {
+		return eh.ErrStreamNotClosed
+	}
+	delete(s.aggregateRecords, id)
+
+	return nil
+}
+
+// SaveSnapshot implements the SaveSnapshot method of the
+// eventhorizon.SnapshotEventStore interface.
+func (s *EventStore) SaveSnapshot(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID, snapshot eh.Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.aggregateRecordsMu.Lock()
+	defer s.aggregateRecordsMu.Unlock()
+
+	if s.closed {
+		return eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok {
+		aggregate = aggregateRecord{
+			AggregateID: id,
+			Version:     snapshot.Version,
+		}
+	} else if snapshot.Version > aggregate.Version {
+		return eh.ErrInvalidSnapshot
+	}
+	aggregate.Snapshot = &snapshot
+	s.aggregateRecords[id] = aggregate
+
+	return nil
+}
+
+// LoadSnapshot implements the LoadSnapshot method of the
+// eventhorizon.SnapshotEventStore interface.
+func (s *EventStore) LoadSnapshot(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (*eh.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.aggregateRecordsMu.RLock()
+	defer s.aggregateRecordsMu.RUnlock()
+
+	if s.closed {
+		return nil, eh.ErrClosed
+	}
+
+	aggregate, ok := s.aggregateRecords[id]
+	if !ok || aggregate.Snapshot == nil {
+		return nil, nil
+	}
+
+	snapshot := *aggregate.Snapshot
+	return &snapshot, nil
+}
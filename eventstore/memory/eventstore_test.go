@@ -29,3 +29,57 @@ func TestEventStore(t *testing.T) {
 	// Run the actual test suite.
 	testutil.EventStoreCommonTests(t, store)
 }
+
+func TestEventStoreTenants(t *testing.T) {
+	store := NewEventStore()
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreTenantTests(t, store)
+}
+
+func TestEventStoreContext(t *testing.T) {
+	store := NewEventStore()
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreContextTests(t, store)
+}
+
+func TestEventStoreClose(t *testing.T) {
+	store := NewEventStore()
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreCloseTests(t, store)
+}
+
+func TestEventStoreIncremental(t *testing.T) {
+	store := NewEventStore()
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreIncrementalTests(t, store)
+}
+
+func TestEventStoreStreams(t *testing.T) {
+	store := NewEventStore()
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreStreamTests(t, store)
+}
+
+func TestEventStoreVersions(t *testing.T) {
+	store := NewEventStore()
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreVersionTests(t, store)
+}
@@ -15,14 +15,17 @@
 package mongodb
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
 	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/compression"
 )
 
 // ErrCouldNotDialDB is when the database could not be dialed.
@@ -51,8 +54,13 @@ var ErrInvalidEvent = errors.New("invalid event")
 
 // EventStore implements an EventStore for MongoDB.
 type EventStore struct {
-	session *mgo.Session
-	db      string
+	session    *mgo.Session
+	guard      *sessionGuard
+	db         string
+	codec      eh.Codec
+	compressor compression.Compressor
+	threshold  int
+	registry   *eh.Registry
 }
 
 // NewEventStore creates a new EventStore.
@@ -75,8 +83,10 @@ func NewEventStoreWithSession(session *mgo.Session, database string) (*EventStor
 	}
 
 	s := &EventStore{
-		session: session,
-		db:      database,
+		session:  session,
+		guard:    &sessionGuard{},
+		db:       database,
+		registry: eh.DefaultRegistry(),
 	}
 
 	return s, nil
@@ -86,18 +96,28 @@ type aggregateRecord struct {
 	AggregateID string          `bson:"_id"`
 	Version     int             `bson:"version"`
 	Events      []dbEventRecord `bson:"events"`
+	Closed      bool            `bson:"closed,omitempty"`
+	Snapshot    *dbSnapshot     `bson:"snapshot,omitempty"`
 	// Type        string        `bson:"type"`
-	// Snapshot    bson.Raw      `bson:"snapshot"`
+}
+
+// dbSnapshot is the internal snapshot record for the MongoDB event store.
+type dbSnapshot struct {
+	Version   int       `bson:"version"`
+	Timestamp time.Time `bson:"timestamp"`
+	State     []byte    `bson:"state"`
 }
 
 // dbEventRecord is the internal event record for the MongoDB event store used
 // to save and load events from the DB.
 type dbEventRecord struct {
-	EventType eh.EventType `bson:"type"`
-	Version   int          `bson:"version"`
-	Timestamp time.Time    `bson:"timestamp"`
-	Event     eh.Event     `bson:"-"`
-	Data      bson.Raw     `bson:"data"`
+	EventType   eh.EventType `bson:"type"`
+	Version     int          `bson:"version"`
+	Timestamp   time.Time    `bson:"timestamp"`
+	Event       eh.Event     `bson:"-"`
+	Data        bson.Raw     `bson:"data,omitempty"`
+	Payload     []byte       `bson:"payload,omitempty"`
+	Compression string       `bson:"compression,omitempty"`
 }
 
 // eventRecord is the private implementation of the eventhorizon.EventRecord
@@ -128,11 +148,25 @@ func (e eventRecord) String() string {
 
 // Save appends all events in the event stream to the database.
 func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.SaveContext(context.Background(), events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface. The deadline of
+// the context is used as socket timeout, as mgo can not cancel operations.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if len(events) == 0 {
 		return eh.ErrNoEventsToAppend
 	}
 
-	sess := s.session.Copy()
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
 	defer sess.Close()
 
 	// Build all event records, with incrementing versions starting from the
@@ -145,21 +179,93 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 			return ErrInvalidEvent
 		}
 
-		// Marshal event data.
-		data, err := bson.Marshal(event)
+		// Create the event record with timestamp.
+		record, err := s.newEventRecord(event, 1+originalVersion+i, time.Now())
 		if err != nil {
-			return ErrCouldNotMarshalEvent
+			return err
 		}
+		eventRecords[i] = record
+	}
 
-		// Create the event record with timestamp.
-		eventRecords[i] = dbEventRecord{
-			EventType: event.EventType(),
-			Version:   1 + originalVersion + i,
-			Timestamp: time.Now(),
-			Data:      bson.Raw{3, data},
+	return s.save(sess, aggregateID, eventRecords, originalVersion)
+}
+
+// Import implements the Import method of the
+// eventhorizon.ImportEventStore interface. The event records are saved with
+// their versions and timestamps.
+func (s *EventStore) Import(ctx context.Context, eventRecords []eh.EventRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(eventRecords) == 0 {
+		return eh.ErrNoEventsToAppend
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	// Only accept consecutive event records belonging to the same aggregate.
+	records := make([]dbEventRecord, len(eventRecords))
+	aggregateID := eventRecords[0].Event().AggregateID()
+	originalVersion := eventRecords[0].Version() - 1
+	for i, eventRecord := range eventRecords {
+		if eventRecord.Event().AggregateID() != aggregateID ||
+			eventRecord.Version() != 1+originalVersion+i {
+			return ErrInvalidEvent
 		}
+
+		record, err := s.newEventRecord(eventRecord.Event(), eventRecord.Version(), eventRecord.Timestamp())
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+
+	return s.save(sess, aggregateID, records, originalVersion)
+}
+
+// newEventRecord creates the event record of an event, with its data
+// marshaled.
+func (s *EventStore) newEventRecord(event eh.Event, version int, timestamp time.Time) (dbEventRecord, error) {
+	record := dbEventRecord{
+		EventType: event.EventType(),
+		Version:   version,
+		Timestamp: timestamp,
+	}
+
+	// Marshal event data, either as a BSON document or with the codec.
+	var data []byte
+	var err error
+	if s.codec != nil {
+		data, err = s.codec.Marshal(event)
+	} else {
+		data, err = bson.Marshal(event)
+	}
+	if err != nil {
+		return dbEventRecord{}, ErrCouldNotMarshalEvent
 	}
 
+	// Compress large events, which are always stored as binary data.
+	data, name, err := compression.Compress(s.compressor, s.threshold, data)
+	if err != nil {
+		return dbEventRecord{}, ErrCouldNotMarshalEvent
+	}
+	record.Compression = name
+	if s.codec != nil || name != "" {
+		record.Payload = data
+	} else {
+		record.Data = bson.Raw{Kind: 3, Data: data}
+	}
+
+	return record, nil
+}
+
+// save saves event records to the stream of an aggregate.
+func (s *EventStore) save(sess *mgo.Session, aggregateID eh.UUID, eventRecords []dbEventRecord, originalVersion int) error {
 	// Either insert a new aggregate or append to an existing.
 	if originalVersion == 0 {
 		aggregate := aggregateRecord{
@@ -168,7 +274,11 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 			Events:      eventRecords,
 		}
 
-		if err := sess.DB(s.db).C("events").Insert(aggregate); err != nil {
+		// Inserting fails with a duplicate key error if the aggregate was
+		// saved since it was loaded.
+		if err := sess.DB(s.db).C("events").Insert(aggregate); mgo.IsDup(err) {
+			return s.versionError(sess, aggregateID)
+		} else if err != nil {
 			return ErrCouldNotSaveAggregate
 		}
 	} else {
@@ -179,12 +289,15 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 			bson.M{
 				"_id":     aggregateID.String(),
 				"version": originalVersion,
+				"closed":  bson.M{"$ne": true},
 			},
 			bson.M{
 				"$push": bson.M{"events": bson.M{"$each": eventRecords}},
 				"$inc":  bson.M{"version": len(eventRecords)},
 			},
-		); err != nil {
+		); err == mgo.ErrNotFound {
+			return s.versionError(sess, aggregateID)
+		} else if err != nil {
 			return ErrCouldNotSaveAggregate
 		}
 	}
@@ -192,14 +305,66 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 	return nil
 }
 
+// versionError returns eventhorizon.ErrStreamClosed if events could not be
+// saved because the stream is closed, and
+// eventhorizon.ErrIncorrectAggregateVersion otherwise.
+func (s *EventStore) versionError(sess *mgo.Session, id eh.UUID) error {
+	var aggregate aggregateRecord
+	if err := sess.DB(s.db).C("events").FindId(id.String()).
+		Select(bson.M{"closed": 1}).One(&aggregate); err == nil && aggregate.Closed {
+		return eh.ErrStreamClosed
+	}
+	return eh.ErrIncorrectAggregateVersion
+}
+
 // Load loads all events for the aggregate id from the database.
 // Returns ErrNoEventsFound if no events can be found.
 func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
-	sess := s.session.Copy()
+	return s.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface. The deadline of
+// the context is used as socket timeout, as mgo can not cancel operations.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.load(ctx, id, 0)
+}
+
+// LoadAfter implements the LoadAfter method of the
+// eventhorizon.IncrementalEventStore interface. Only the newest events are
+// fetched from the database.
+func (s *EventStore) LoadAfter(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID, version int) ([]eh.EventRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return s.load(ctx, id, version)
+}
+
+// load loads the events of an aggregate after a version, the events are
+// stored in order of version starting at 1.
+func (s *EventStore) load(ctx context.Context, id eh.UUID, version int) ([]eh.EventRecord, error) {
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer sess.Close()
 
+	// Filter on the version of the events instead of their position, as the
+	// stream could have been truncated.
 	var aggregate aggregateRecord
-	err := sess.DB(s.db).C("events").FindId(id.String()).One(&aggregate)
+	err = sess.DB(s.db).C("events").Pipe([]bson.M{
+		{"$match": bson.M{"_id": id.String()}},
+		{"$project": bson.M{"events": bson.M{"$filter": bson.M{
+			"input": "$events",
+			"as":    "event",
+			"cond":  bson.M{"$gt": []interface{}{"$$event.version", version}},
+		}}}},
+	}).One(&aggregate)
 	if err == mgo.ErrNotFound {
 		return []eh.EventRecord{}, nil
 	} else if err != nil {
@@ -209,19 +374,38 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 	eventRecords := make([]eh.EventRecord, len(aggregate.Events))
 	for i, record := range aggregate.Events {
 		// Create an event of the correct type.
-		event, err := eh.CreateEvent(record.EventType)
+		event, err := s.registry.CreateEvent(record.EventType)
 		if err != nil {
 			return nil, err
 		}
 
-		// Manually decode the raw BSON event.
-		if err := record.Data.Unmarshal(event); err != nil {
+		// Decode the payload with the codec, or manually decode the raw BSON
+		// event if the record was not saved with a codec. Compressed payloads
+		// without a codec are BSON documents.
+		if record.Payload != nil {
+			data, err := compression.Decompress(s.compressor, record.Compression, record.Payload)
+			if err != nil {
+				return nil, ErrCouldNotUnmarshalEvent
+			}
+			if s.codec != nil {
+				err = s.codec.Unmarshal(data, event)
+			} else if record.Compression != "" {
+				err = bson.Unmarshal(data, event)
+			} else {
+				err = ErrCouldNotUnmarshalEvent
+			}
+			if err != nil {
+				return nil, ErrCouldNotUnmarshalEvent
+			}
+		} else if err := record.Data.Unmarshal(event); err != nil {
 			return nil, ErrCouldNotUnmarshalEvent
 		}
 
 		// Set conrcete event and zero out the decoded event.
 		record.Event = event
 		record.Data = bson.Raw{}
+		record.Payload = nil
+		record.Compression = ""
 
 		eventRecords[i] = eventRecord{dbEventRecord: record}
 	}
@@ -229,20 +413,305 @@ func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.Even
 	return eventRecords, nil
 }
 
+// StreamInfo implements the StreamInfo method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) StreamInfo(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.StreamInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return eh.StreamInfo{}, err
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return eh.StreamInfo{}, err
+	}
+	defer sess.Close()
+
+	var aggregate aggregateRecord
+	err = sess.DB(s.db).C("events").FindId(id.String()).
+		Select(bson.M{"version": 1, "closed": 1, "snapshot.version": 1}).One(&aggregate)
+	if err == mgo.ErrNotFound {
+		return eh.StreamInfo{}, eh.ErrStreamNotFound
+	} else if err != nil {
+		return eh.StreamInfo{}, err
+	}
+
+	info := eh.StreamInfo{
+		Version: aggregate.Version,
+		Closed:  aggregate.Closed,
+	}
+	if aggregate.Snapshot != nil {
+		info.SnapshotVersion = aggregate.Snapshot.Version
+	}
+
+	return info, nil
+}
+
+// CloseStream implements the CloseStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) CloseStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	if _, err := sess.DB(s.db).C("events").UpsertId(id.String(), bson.M{
+		"$set":         bson.M{"closed": true},
+		"$setOnInsert": bson.M{"version": 0, "events": []dbEventRecord{}},
+	}); err != nil {
+		return ErrCouldNotSaveAggregate
+	}
+
+	return nil
+}
+
+// TruncateStream implements the TruncateStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) TruncateStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	info, err := s.StreamInfo(ctx, aggregateType, id)
+	if err != nil {
+		return err
+	}
+	if info.SnapshotVersion == 0 {
+		return eh.ErrNoSnapshot
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	if err := sess.DB(s.db).C("events").UpdateId(id.String(), bson.M{
+		"$pull": bson.M{"events": bson.M{"version": bson.M{"$lte": info.SnapshotVersion}}},
+	}); err != nil {
+		return ErrCouldNotSaveAggregate
+	}
+
+	return nil
+}
+
+// DeleteStream implements the DeleteStream method of the
+// eventhorizon.StreamEventStore interface.
+func (s *EventStore) DeleteStream(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	err = sess.DB(s.db).C("events").Remove(bson.M{"_id": id.String(), "closed": true})
+	if err == mgo.ErrNotFound {
+		if _, err := s.StreamInfo(ctx, aggregateType, id); err != nil {
+			return err
+		}
+		return eh.ErrStreamNotClosed
+	} else if err != nil {
+		return ErrCouldNotSaveAggregate
+	}
+
+	return nil
+}
+
+// SaveSnapshot implements the SaveSnapshot method of the
+// eventhorizon.SnapshotEventStore interface.
+func (s *EventStore) SaveSnapshot(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID, snapshot eh.Snapshot) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	record := dbSnapshot{
+		Version:   snapshot.Version,
+		Timestamp: snapshot.Timestamp,
+		State:     snapshot.State,
+	}
+	err = sess.DB(s.db).C("events").Update(
+		bson.M{
+			"_id":     id.String(),
+			"version": bson.M{"$gte": snapshot.Version},
+		},
+		bson.M{"$set": bson.M{"snapshot": record}},
+	)
+	if err == mgo.ErrNotFound {
+		// Create a stream at the version of the snapshot if there is none,
+		// otherwise the snapshot is after the last event.
+		err = sess.DB(s.db).C("events").Insert(aggregateRecord{
+			AggregateID: id.String(),
+			Version:     snapshot.Version,
+			Events:      []dbEventRecord{},
+			Snapshot:    &record,
+		})
+		if mgo.IsDup(err) {
+			return eh.ErrInvalidSnapshot
+		}
+	}
+	if err != nil {
+		return ErrCouldNotSaveAggregate
+	}
+
+	return nil
+}
+
+// LoadSnapshot implements the LoadSnapshot method of the
+// eventhorizon.SnapshotEventStore interface.
+func (s *EventStore) LoadSnapshot(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (*eh.Snapshot, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	var aggregate aggregateRecord
+	err = sess.DB(s.db).C("events").FindId(id.String()).
+		Select(bson.M{"snapshot": 1}).One(&aggregate)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	if aggregate.Snapshot == nil {
+		return nil, nil
+	}
+
+	return &eh.Snapshot{
+		Version:   aggregate.Snapshot.Version,
+		Timestamp: aggregate.Snapshot.Timestamp,
+		State:     aggregate.Snapshot.State,
+	}, nil
+}
+
+// copySession copies the session, with the time left until the deadline of
+// the context as socket timeout. Returns eventhorizon.ErrClosed after closing.
+func (s *EventStore) copySession(ctx context.Context) (*mgo.Session, error) {
+	s.guard.RLock()
+	defer s.guard.RUnlock()
+
+	if s.guard.closed {
+		return nil, eh.ErrClosed
+	}
+
+	sess := s.session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		sess.SetSocketTimeout(time.Until(deadline))
+	}
+	return sess, nil
+}
+
+// SetCodec sets the codec to use for event data. By default events are stored
+// as BSON documents, with a codec they are instead stored as binary data.
+func (s *EventStore) SetCodec(codec eh.Codec) {
+	s.codec = codec
+}
+
+// SetCompressor sets a compressor to use for events of at least threshold
+// bytes. Compressed events are stored as binary data together with the name
+// of the compression, uncompressed events are stored as before.
+func (s *EventStore) SetCompressor(compressor compression.Compressor, threshold int) {
+	s.compressor = compressor
+	s.threshold = threshold
+}
+
+// SetRegistry sets the registry used to create the loaded events, the
+// default is eventhorizon.DefaultRegistry.
+func (s *EventStore) SetRegistry(registry *eh.Registry) {
+	s.registry = registry
+}
+
 // SetDB sets the database session.
 func (s *EventStore) SetDB(db string) {
 	s.db = db
 }
 
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface. The events of a tenant are
+// stored in a separate database, named as the database of the store with the
+// tenant ID as suffix. The store of the tenant shares the session and should
+// not be closed.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	tenant := *s
+	tenant.db = s.db + "_" + string(tenantID)
+	return &tenant, nil
+}
+
 // Clear clears the event storge.
 func (s *EventStore) Clear() error {
-	if err := s.session.DB(s.db).C("events").DropCollection(); err != nil {
+	sess, err := s.copySession(context.Background())
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	if err := sess.DB(s.db).C("events").DropCollection(); err != nil {
 		return ErrCouldNotClearDB
 	}
 	return nil
 }
 
-// Close closes the database session.
-func (s *EventStore) Close() {
-	s.session.Close()
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that the database can be reached.
+func (s *EventStore) Start(ctx context.Context) error {
+	return s.ping(ctx)
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It pings the database.
+func (s *EventStore) CheckHealth(ctx context.Context) (eh.Health, error) {
+	start := time.Now()
+	err := s.ping(ctx)
+	return eh.Health{Latency: time.Since(start)}, err
+}
+
+func (s *EventStore) ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := s.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Ping()
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the database session, after which all methods return
+// eventhorizon.ErrClosed.
+func (s *EventStore) Close(ctx context.Context) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+
+	if !s.guard.closed {
+		s.guard.closed = true
+		s.session.Close()
+	}
+	return nil
+}
+
+// sessionGuard guards the session, which is shared with the tenants, from
+// being used after it is closed.
+type sessionGuard struct {
+	sync.RWMutex
+	closed bool
 }
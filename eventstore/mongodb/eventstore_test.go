@@ -15,31 +15,70 @@
 package mongodb
 
 import (
+	"context"
 	"os"
 	"testing"
 
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/codec/json"
+	"github.com/looplab/eventhorizon/compression/gzip"
 	"github.com/looplab/eventhorizon/eventstore/testutil"
 )
 
 func TestEventStore(t *testing.T) {
-	// Support Wercker testing with MongoDB.
-	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
-	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
 
-	url := "localhost"
-	if host != "" && port != "" {
-		url = host + ":" + port
+	defer store.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	// Run the actual test suite.
+	testutil.EventStoreCommonTests(t, store)
+}
+
+func TestEventStoreWithCodec(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
 	}
+	store.SetCodec(json.NewCodec())
 
-	store, err := NewEventStore(url, "test")
+	defer store.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	// Run the actual test suite.
+	testutil.EventStoreCommonTests(t, store)
+}
+
+func TestEventStoreWithCompression(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
 	if err != nil {
 		t.Fatal("there should be no error:", err)
 	}
 	if store == nil {
 		t.Fatal("there should be a store")
 	}
+	store.SetCompressor(gzip.NewCompressor(), 0)
 
-	defer store.Close()
+	defer store.Close(context.Background())
 	defer func() {
 		t.Log("clearing collection")
 		if err = store.Clear(); err != nil {
@@ -50,3 +89,137 @@ func TestEventStore(t *testing.T) {
 	// Run the actual test suite.
 	testutil.EventStoreCommonTests(t, store)
 }
+
+func TestEventStoreTenants(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	defer store.Close(context.Background())
+
+	storeA, storeB := testutil.EventStoreTenantTests(t, store)
+
+	t.Log("clearing collections")
+	for _, s := range []eh.EventStore{store, storeA, storeB} {
+		if err = s.(*EventStore).Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+}
+
+func TestEventStoreContext(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	defer store.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.EventStoreContextTests(t, store)
+}
+
+func TestEventStoreIncremental(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	defer store.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.EventStoreIncrementalTests(t, store)
+}
+
+func TestEventStoreStreams(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	defer store.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.EventStoreStreamTests(t, store)
+}
+
+func TestEventStoreVersions(t *testing.T) {
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	defer store.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.EventStoreVersionTests(t, store)
+}
+
+func TestEventStoreClose(t *testing.T) {
+	defer func() {
+		t.Log("clearing collection")
+		store, err := NewEventStore(testURL(), "test")
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		defer store.Close(context.Background())
+		if err = store.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	store, err := NewEventStore(testURL(), "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.EventStoreCloseTests(t, store)
+}
+
+func testURL() string {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
+	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+
+	url := "localhost"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+	return url
+}
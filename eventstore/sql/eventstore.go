@@ -17,9 +17,11 @@
 package sql
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"sync/atomic"
 	"time"
 
 	"database/sql/driver"
@@ -27,6 +29,8 @@ import (
 	"encoding/json"
 
 	"github.com/jinzhu/gorm"
+
+	"github.com/looplab/eventhorizon/compression"
 )
 
 // Error returned when the database could not be dialed.
@@ -64,11 +68,23 @@ type SqlEventStore struct {
 	eventBus  EventBus
 	db        gorm.DB
 	factories map[string]func() Event
+	codec     Codec
+
+	compressor compression.Compressor
+	threshold  int
+
+	// tenantID is the tenant of the events, empty for the default tenant.
+	tenantID TenantID
+
+	// closed is shared with the stores of tenants, which use the same
+	// database.
+	closed *atomic.Bool
 }
 
 type sqlAggregateRecord struct {
 	ID      UUID `gorm:"primary_key"`
 	Version int
+	Tenant  string `sql:"size:64;index"`
 	// Type        string        `bson:"type"`
 	// Snapshot    bson.Raw      `bson:"snapshot"`
 }
@@ -79,7 +95,14 @@ type sqlEventRecord struct {
 	Type      string `sql:"size:128"`
 	Timestamp time.Time
 	Event     Event  `sql:"-"`
-	Payload   string `sql:"size:2048"`
+	Tenant    string `sql:"size:64;index"`
+
+	// Payload is stored as binary data as the output of codecs such as
+	// protobuf, msgpack or BSON is neither text nor bounded in size.
+	Payload []byte
+
+	// Compression is the name of the compression of the payload, if any.
+	Compression string `sql:"size:16"`
 }
 
 // for UUID to sql column,which is needed by gorm
@@ -102,53 +125,103 @@ func NewSqlEventStore(eventBus EventBus, db gorm.DB) (*SqlEventStore, error) {
 		eventBus:  eventBus,
 		factories: make(map[string]func() Event),
 		db:        db,
+		closed:    &atomic.Bool{},
 	}
 	return s, nil
 }
 
 // Save appends all events in the event stream to the database.
 func (s *SqlEventStore) Save(events []Event) error {
+	return s.SaveContext(context.Background(), events)
+}
+
+// SaveContext appends all events in the event stream to the database, in a
+// transaction of the context which is rolled back if the context is done.
+// The events are published after the transaction has been committed.
+// Returns ErrIncorrectAggregateVersion if the aggregate was changed by another
+// transaction.
+func (s *SqlEventStore) SaveContext(ctx context.Context, events []Event) error {
 	if len(events) == 0 {
 		return ErrNoEventsToAppend
 	}
 
+	err := s.transaction(ctx, func(db *gorm.DB) error {
+		return s.save(db, events)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Publish events on the bus.
+	if s.eventBus != nil {
+		for _, event := range events {
+			s.eventBus.PublishEvent(event)
+		}
+	}
+
+	return nil
+}
+
+func (s *SqlEventStore) save(db *gorm.DB, events []Event) error {
 	for _, event := range events {
 		// Get an existing aggregate, if any.
 		aggregateRecord := sqlAggregateRecord{}
-		s.db.Where("ID = ?", event.AggregateID().String()).First(&aggregateRecord)
+		db.Where("ID = ? AND tenant = ?", event.AggregateID().String(), string(s.tenantID)).First(&aggregateRecord)
 
 		isNew := false
 		if aggregateRecord.ID.String() == "" {
 			isNew = true
-			aggregateRecord = sqlAggregateRecord{ID: event.AggregateID()}
+			aggregateRecord = sqlAggregateRecord{
+				ID:     event.AggregateID(),
+				Tenant: string(s.tenantID),
+			}
 		}
 
-		payload, err := json.Marshal(event)
+		payload, name, err := s.marshal(event)
 		if err != nil {
 			return ErrCouldNotMarshalEvent
 		}
 
-		aggregateRecord.Version = aggregateRecord.Version + 1
+		version := aggregateRecord.Version + 1
 		// Create the event record with timestamp.
 		eventRecord := sqlEventRecord{
 			ID:        event.AggregateID(),
 			Type:      event.EventType(),
-			Version:   aggregateRecord.Version,
+			Version:   version,
 			Timestamp: time.Now(),
-			Payload:   string(payload),
+			Payload:   payload,
+			Tenant:    string(s.tenantID),
+
+			Compression: name,
 		}
 
 		if isNew {
-			s.db.Create(&aggregateRecord)
+			aggregateRecord.Version = version
+			if err := db.Create(&aggregateRecord).Error; err != nil {
+				// The aggregate was created by another transaction.
+				existing := sqlAggregateRecord{}
+				if s.db.Where("ID = ? AND tenant = ?", event.AggregateID().String(), string(s.tenantID)).First(&existing).Error == nil {
+					return ErrIncorrectAggregateVersion
+				}
+				return ErrCouldNotSaveAggregate
+			}
 		} else {
-			s.db.Save(&aggregateRecord)
+			// Only update the version if it was not changed by another
+			// transaction since it was read.
+			result := db.Model(&sqlAggregateRecord{}).
+				Where("ID = ? AND tenant = ? AND version = ?", event.AggregateID().String(), string(s.tenantID), aggregateRecord.Version).
+				Update("version", version)
+			if result.Error != nil {
+				return ErrCouldNotSaveAggregate
+			}
+			if result.RowsAffected == 0 {
+				return ErrIncorrectAggregateVersion
+			}
+			aggregateRecord.Version = version
 		}
 		// save event
-		s.db.Create(&eventRecord)
-
-		// Publish event on the bus.
-		if s.eventBus != nil {
-			s.eventBus.PublishEvent(event)
+		if err := db.Create(&eventRecord).Error; err != nil {
+			return ErrCouldNotSaveAggregate
 		}
 	}
 
@@ -158,8 +231,19 @@ func (s *SqlEventStore) Save(events []Event) error {
 // Load loads all events for the aggregate id from the database.
 // Returns ErrNoEventsFound if no events can be found.
 func (s *SqlEventStore) Load(id UUID) ([]Event, error) {
+	return s.LoadContext(context.Background(), id)
+}
+
+// LoadContext loads all events for the aggregate id from the database, in a
+// transaction of the context.
+func (s *SqlEventStore) LoadContext(ctx context.Context, id UUID) ([]Event, error) {
 	var eventRecords []sqlEventRecord
-	s.db.Where("ID = ?", id.String()).Find(&eventRecords)
+	err := s.transaction(ctx, func(db *gorm.DB) error {
+		return db.Where("ID = ? AND tenant = ?", id.String(), string(s.tenantID)).Find(&eventRecords).Error
+	})
+	if err != nil {
+		return nil, ErrCouldNotLoadAggregate
+	}
 	fmt.Println("eventRecords.type", reflect.TypeOf(eventRecords), reflect.TypeOf(eventRecords).Size())
 	v := reflect.ValueOf(eventRecords)
 	fmt.Println("eventRecords v.Type", v.Type(), v.Type().Size())
@@ -175,7 +259,7 @@ func (s *SqlEventStore) Load(id UUID) ([]Event, error) {
 
 		// Manually decode the payload string to event.
 		event := f()
-		if err := json.Unmarshal([]byte(eventRecord.Payload), &event); err != nil {
+		if err := s.unmarshal(eventRecord.Payload, eventRecord.Compression, event); err != nil {
 			return nil, ErrCouldNotUnmarshalEvent
 		}
 		if events[i], ok = event.(Event); !ok {
@@ -186,6 +270,127 @@ func (s *SqlEventStore) Load(id UUID) ([]Event, error) {
 	return events, nil
 }
 
+// ForTenant returns the event store of a tenant, which stores its events in
+// the same tables with the tenant ID in a separate column.
+func (s *SqlEventStore) ForTenant(tenantID TenantID) (*SqlEventStore, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+	if s.closed.Load() {
+		return nil, ErrClosed
+	}
+
+	tenant := *s
+	tenant.tenantID = tenantID
+	return &tenant, nil
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that the database can be reached.
+func (s *SqlEventStore) Start(ctx context.Context) error {
+	if s.closed.Load() {
+		return ErrClosed
+	}
+	return s.db.DB().PingContext(ctx)
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It pings the database.
+func (s *SqlEventStore) CheckHealth(ctx context.Context) (Health, error) {
+	if s.closed.Load() {
+		return Health{}, ErrClosed
+	}
+
+	start := time.Now()
+	err := s.db.DB().PingContext(ctx)
+	return Health{Latency: time.Since(start)}, err
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the database, which is shared with the stores of tenants, after
+// which all methods return eventhorizon.ErrClosed.
+func (s *SqlEventStore) Close(ctx context.Context) error {
+	if s.closed.Swap(true) {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// transaction runs f in a transaction of the context, as gorm can not cancel
+// single statements. The transaction is rolled back by the sql package when
+// the context is done, after which no more statements can be run in it.
+func (s *SqlEventStore) transaction(ctx context.Context, f func(*gorm.DB) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if s.closed.Load() {
+		return ErrClosed
+	}
+
+	tx := s.db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+// SetCodec sets the codec to use for event payloads, the default is JSON.
+func (s *SqlEventStore) SetCodec(codec Codec) {
+	s.codec = codec
+}
+
+// SetCompressor sets a compressor to use for event payloads of at least
+// threshold bytes. Compressed payloads are stored together with the name of
+// the compression.
+func (s *SqlEventStore) SetCompressor(compressor compression.Compressor, threshold int) {
+	s.compressor = compressor
+	s.threshold = threshold
+}
+
+func (s *SqlEventStore) marshal(event Event) ([]byte, string, error) {
+	var data []byte
+	var err error
+	if s.codec != nil {
+		data, err = s.codec.Marshal(event)
+	} else {
+		data, err = json.Marshal(event)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	return compression.Compress(s.compressor, s.threshold, data)
+}
+
+func (s *SqlEventStore) unmarshal(data []byte, name string, event Event) error {
+	if name != "" {
+		var err error
+		if data, err = compression.Decompress(s.compressor, name, data); err != nil {
+			return err
+		}
+	}
+
+	if s.codec != nil {
+		return s.codec.Unmarshal(data, event)
+	}
+	return json.Unmarshal(data, event)
+}
+
 // RegisterEventType registers an event factory for a event type. The factory is
 // used to create concrete event types when loading from the database.
 //
@@ -0,0 +1,58 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// ClosableStore is a context aware event store that can be closed.
+type ClosableStore interface {
+	eh.ContextEventStore
+	eh.Lifecycle
+}
+
+// EventStoreCloseTests are test cases that are common to all event stores that
+// can be closed. The store is closed by the tests.
+func EventStoreCloseTests(t *testing.T, store ClosableStore) {
+	ctx := context.Background()
+	id := eh.NewUUID()
+
+	if err := store.SaveContext(ctx, []eh.Event{&mocks.Event{id, "event1"}}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("close store")
+	if err := store.Close(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("use closed store")
+	if err := store.SaveContext(ctx, []eh.Event{&mocks.Event{id, "event2"}}, 1); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if _, err := store.LoadContext(ctx, mocks.AggregateType, id); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+
+	t.Log("close closed store")
+	if err := store.Close(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// EventStoreContextTests are test cases that are common to all context aware
+// event stores. The store should be empty before the tests.
+func EventStoreContextTests(t *testing.T, store eh.ContextEventStore) {
+	id := eh.NewUUID()
+	event1 := &mocks.Event{id, "event1"}
+
+	t.Log("save event with canceled context")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.SaveContext(ctx, []eh.Event{event1}, 0); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+
+	t.Log("load events with canceled context")
+	if _, err := store.LoadContext(ctx, mocks.AggregateType, id); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+
+	t.Log("save and load event with deadline")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := store.SaveContext(ctx, []eh.Event{event1}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	eventRecords, err := store.LoadContext(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 1 {
+		t.Error("there should be one event loaded:", len(eventRecords))
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// EventStoreIncrementalTests are test cases that are common to all event
+// stores that can load only the newest events. The store should be empty
+// before the tests.
+func EventStoreIncrementalTests(t *testing.T, store eh.IncrementalEventStore) {
+	ctx := context.Background()
+	id := eh.NewUUID()
+
+	t.Log("load events of missing aggregate")
+	eventRecords, err := store.LoadAfter(ctx, mocks.AggregateType, id, 0)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 0 {
+		t.Error("there should be no events loaded:", len(eventRecords))
+	}
+
+	event1 := &mocks.Event{id, "event1"}
+	event2 := &mocks.Event{id, "event2"}
+	event3 := &mocks.Event{id, "event3"}
+	if err := store.Save([]eh.Event{event1, event2}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save([]eh.Event{event3}, 2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("load events after version")
+	eventRecords, err = store.LoadAfter(ctx, mocks.AggregateType, id, 1)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 2 {
+		t.Fatal("there should be two events loaded:", len(eventRecords))
+	}
+	if eventRecords[0].Version() != 2 || eventRecords[1].Version() != 3 {
+		t.Error("the versions should be correct:", eventRecords[0].Version(), eventRecords[1].Version())
+	}
+	if eventRecords[1].Event().(*mocks.Event).Content != "event3" {
+		t.Error("the event should be correct:", eventRecords[1].Event())
+	}
+
+	t.Log("load events after last version")
+	eventRecords, err = store.LoadAfter(ctx, mocks.AggregateType, id, 3)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 0 {
+		t.Error("there should be no events loaded:", len(eventRecords))
+	}
+
+	t.Log("load events with canceled context")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := store.LoadAfter(ctx, mocks.AggregateType, id, 0); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// StreamStore is an event store that can close and truncate streams with
+// snapshots.
+type StreamStore interface {
+	eh.StreamEventStore
+	eh.SnapshotEventStore
+}
+
+// EventStoreStreamTests are test cases that are common to all event stores
+// that can close and truncate streams. The store should be empty before the
+// tests.
+func EventStoreStreamTests(t *testing.T, store StreamStore) {
+	ctx := context.Background()
+	id := eh.NewUUID()
+
+	t.Log("stream info of missing stream")
+	if _, err := store.StreamInfo(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("there should be a ErrStreamNotFound error:", err)
+	}
+	if err := store.TruncateStream(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("there should be a ErrStreamNotFound error:", err)
+	}
+	if err := store.DeleteStream(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("there should be a ErrStreamNotFound error:", err)
+	}
+	snapshot, err := store.LoadSnapshot(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot != nil {
+		t.Error("there should be no snapshot:", snapshot)
+	}
+
+	event1 := &mocks.Event{id, "event1"}
+	event2 := &mocks.Event{id, "event2"}
+	event3 := &mocks.Event{id, "event3"}
+	if err := store.Save([]eh.Event{event1, event2}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("truncate stream without snapshot")
+	if err := store.TruncateStream(ctx, mocks.AggregateType, id); err != eh.ErrNoSnapshot {
+		t.Error("there should be a ErrNoSnapshot error:", err)
+	}
+
+	t.Log("save snapshot")
+	if err := store.SaveSnapshot(ctx, mocks.AggregateType, id, eh.Snapshot{Version: 3}); err != eh.ErrInvalidSnapshot {
+		t.Error("there should be a ErrInvalidSnapshot error:", err)
+	}
+	timestamp := time.Now().Round(time.Millisecond)
+	if err := store.SaveSnapshot(ctx, mocks.AggregateType, id, eh.Snapshot{
+		Version:   1,
+		Timestamp: timestamp,
+		State:     []byte("state"),
+	}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	snapshot, err = store.LoadSnapshot(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if snapshot == nil || snapshot.Version != 1 || string(snapshot.State) != "state" ||
+		!snapshot.Timestamp.Equal(timestamp) {
+		t.Error("the snapshot should be correct:", snapshot)
+	}
+
+	t.Log("truncate stream")
+	if err := store.TruncateStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	eventRecords, err := store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 1 || eventRecords[0].Version() != 2 {
+		t.Error("the events before the snapshot should be removed:", eventRecords)
+	}
+	if store, ok := store.(eh.IncrementalEventStore); ok {
+		t.Log("load after version in truncated stream")
+		eventRecords, err := store.LoadAfter(ctx, mocks.AggregateType, id, 1)
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if len(eventRecords) != 1 || eventRecords[0].Version() != 2 {
+			t.Error("the events after the version should be loaded:", eventRecords)
+		}
+		eventRecords, err = store.LoadAfter(ctx, mocks.AggregateType, id, 2)
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if len(eventRecords) != 0 {
+			t.Error("there should be no events after the version:", eventRecords)
+		}
+	}
+	if err := store.Save([]eh.Event{event3}, 2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("delete open stream")
+	if err := store.DeleteStream(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotClosed {
+		t.Error("there should be a ErrStreamNotClosed error:", err)
+	}
+
+	t.Log("close stream")
+	if err := store.CloseStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	info, err := store.StreamInfo(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if info != (eh.StreamInfo{Version: 3, Closed: true, SnapshotVersion: 1}) {
+		t.Error("the stream info should be correct:", info)
+	}
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event4"}}, 3); err != eh.ErrStreamClosed {
+		t.Error("there should be a ErrStreamClosed error:", err)
+	}
+	eventRecords, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 2 {
+		t.Error("the events should be loaded from the closed stream:", eventRecords)
+	}
+
+	t.Log("delete closed stream")
+	if err := store.DeleteStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := store.StreamInfo(ctx, mocks.AggregateType, id); err != eh.ErrStreamNotFound {
+		t.Error("there should be a ErrStreamNotFound error:", err)
+	}
+
+	t.Log("close missing stream as tombstone")
+	id = eh.NewUUID()
+	if err := store.CloseStream(ctx, mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event1"}}, 0); err != eh.ErrStreamClosed {
+		t.Error("there should be a ErrStreamClosed error:", err)
+	}
+
+	t.Log("save snapshot of missing stream")
+	id = eh.NewUUID()
+	if err := store.SaveSnapshot(ctx, mocks.AggregateType, id, eh.Snapshot{Version: 2}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event3"}}, 2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	info, err = store.StreamInfo(ctx, mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if info != (eh.StreamInfo{Version: 3, SnapshotVersion: 2}) {
+		t.Error("the stream info should be correct:", info)
+	}
+}
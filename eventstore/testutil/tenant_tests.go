@@ -0,0 +1,99 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// EventStoreTenantTests are test cases that are common to all multi tenant
+// event stores. The stores of the tenants "a" and "b" are returned to be
+// cleared by the caller.
+func EventStoreTenantTests(t *testing.T, store eh.MultiTenantEventStore) (eh.EventStore, eh.EventStore) {
+	t.Log("store for invalid tenant")
+	if _, err := store.ForTenant("a/b"); err != eh.ErrInvalidTenant {
+		t.Error("there should be a ErrInvalidTenant error:", err)
+	}
+
+	storeA, err := store.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	storeB, err := store.ForTenant("b")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("save event for tenant")
+	id := eh.NewUUID()
+	event1 := &mocks.Event{id, "event1"}
+	if err := storeA.Save([]eh.Event{event1}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	records, err := storeA.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(records) != 1 {
+		t.Error("there should be one event:", len(records))
+	}
+
+	t.Log("load event of other tenant")
+	records, err = storeB.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(records) != 0 {
+		t.Error("there should be no events:", len(records))
+	}
+	records, err = store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(records) != 0 {
+		t.Error("there should be no events:", len(records))
+	}
+
+	t.Log("save event with same ID for other tenant")
+	event2 := &mocks.Event{id, "event2"}
+	if err := storeB.Save([]eh.Event{event2}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	records, err = storeB.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(records) != 1 {
+		t.Error("there should be one event:", len(records))
+	}
+
+	t.Log("load event with new store for tenant")
+	storeA, err = store.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	records, err = storeA.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(records) != 1 {
+		t.Error("there should be one event:", len(records))
+	}
+
+	return storeA, storeB
+}
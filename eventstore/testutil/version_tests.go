@@ -0,0 +1,59 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// EventStoreVersionTests are test cases that are common to all event stores
+// that check the version of the aggregate when saving.
+func EventStoreVersionTests(t *testing.T, store eh.EventStore) {
+	id := eh.NewUUID()
+	event1 := &mocks.Event{id, "event1"}
+	if err := store.Save([]eh.Event{event1}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save([]eh.Event{event1}, 1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("save event with an old version")
+	if err := store.Save([]eh.Event{event1}, 1); err != eh.ErrIncorrectAggregateVersion {
+		t.Error("there should be a ErrIncorrectAggregateVersion error:", err)
+	}
+
+	t.Log("save event with a future version")
+	if err := store.Save([]eh.Event{event1}, 3); err != eh.ErrIncorrectAggregateVersion {
+		t.Error("there should be a ErrIncorrectAggregateVersion error:", err)
+	}
+
+	t.Log("save new aggregate that exists")
+	if err := store.Save([]eh.Event{event1}, 0); err != eh.ErrIncorrectAggregateVersion {
+		t.Error("there should be a ErrIncorrectAggregateVersion error:", err)
+	}
+
+	t.Log("load events after incorrect versions")
+	eventRecords, err := store.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(eventRecords) != 2 {
+		t.Error("there should be two events:", len(eventRecords))
+	}
+}
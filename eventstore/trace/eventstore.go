@@ -15,6 +15,7 @@
 package trace
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -41,8 +42,81 @@ func NewEventStore(eventStore eh.EventStore) *EventStore {
 	return s
 }
 
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts the traced store, if supported.
+func (s *EventStore) Start(ctx context.Context) error {
+	if l, ok := s.eventStore.(eh.Lifecycle); ok {
+		return l.Start(ctx)
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It checks the traced store, if supported.
+func (s *EventStore) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if c, ok := s.eventStore.(eh.HealthChecker); ok {
+		return c.CheckHealth(ctx)
+	}
+	return eh.Health{}, nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the traced store, if supported.
+func (s *EventStore) Close(ctx context.Context) error {
+	if l, ok := s.eventStore.(eh.Lifecycle); ok {
+		return l.Close(ctx)
+	}
+	return nil
+}
+
 // Save appends all events to the base store and trace them if enabled.
 func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.save(context.Background(), s.eventStore, events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	return s.save(ctx, s.eventStore, events, originalVersion)
+}
+
+// Load loads all events for the aggregate id from the base store.
+// Returns ErrNoEventStoreDefined if no event store could be found.
+func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.load(context.Background(), s.eventStore, aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.load(ctx, s.eventStore, aggregateType, id)
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface. The store of a tenant uses the
+// store of the tenant of the base store, if it is multi tenant, and traces
+// events to the trace of this store.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	eventStore := s.eventStore
+	if m, ok := eventStore.(eh.MultiTenantEventStore); ok {
+		var err error
+		if eventStore, err = m.ForTenant(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &tenantEventStore{parent: s, eventStore: eventStore}, nil
+}
+
+func (s *EventStore) save(ctx context.Context, eventStore eh.EventStore, events []eh.Event, originalVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.traceMu.Lock()
 	defer s.traceMu.Unlock()
 
@@ -50,18 +124,16 @@ func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
 		s.trace = append(s.trace, events...)
 	}
 
-	if s.eventStore != nil {
-		return s.eventStore.Save(events, originalVersion)
+	if eventStore != nil {
+		return eh.AdaptEventStore(eventStore).SaveContext(ctx, events, originalVersion)
 	}
 
 	return nil
 }
 
-// Load loads all events for the aggregate id from the base store.
-// Returns ErrNoEventStoreDefined if no event store could be found.
-func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
-	if s.eventStore != nil {
-		return s.eventStore.Load(aggregateType, id)
+func (s *EventStore) load(ctx context.Context, eventStore eh.EventStore, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	if eventStore != nil {
+		return eh.AdaptEventStore(eventStore).LoadContext(ctx, aggregateType, id)
 	}
 
 	return nil, ErrNoEventStoreDefined
@@ -98,3 +170,32 @@ func (s *EventStore) ResetTrace() {
 
 	s.trace = make([]eh.Event, 0)
 }
+
+// tenantEventStore is the event store of a tenant, which traces events to the
+// trace of its parent store.
+type tenantEventStore struct {
+	parent     *EventStore
+	eventStore eh.EventStore
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *tenantEventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.parent.save(context.Background(), s.eventStore, events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *tenantEventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	return s.parent.save(ctx, s.eventStore, events, originalVersion)
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *tenantEventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.parent.load(context.Background(), s.eventStore, aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *tenantEventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.parent.load(ctx, s.eventStore, aggregateType, id)
+}
@@ -77,3 +77,24 @@ func TestEventStore(t *testing.T) {
 		t.Error("the loaded events should be correct:", events)
 	}
 }
+
+func TestEventStoreTenants(t *testing.T) {
+	store := NewEventStore(memory.NewEventStore())
+
+	store.StartTracing()
+	testutil.EventStoreTenantTests(t, store)
+	store.StopTracing()
+
+	if trace := store.GetTrace(); len(trace) != 2 {
+		t.Error("there should be events of the tenants traced:", trace)
+	}
+}
+
+func TestEventStoreContext(t *testing.T) {
+	store := NewEventStore(memory.NewEventStore())
+	if store == nil {
+		t.Fatal("there should be a store")
+	}
+
+	testutil.EventStoreContextTests(t, store)
+}
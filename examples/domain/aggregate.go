@@ -47,11 +47,33 @@ type InvitationAggregate struct {
 	denied    bool
 }
 
-// NewInvitationAggregate creates a new InvitationAggregate with an ID.
+// NewInvitationAggregate creates a new InvitationAggregate with an ID. The
+// events are applied by the apply funcs set per event type, which are also
+// used when storing events.
 func NewInvitationAggregate(id eh.UUID) *InvitationAggregate {
-	return &InvitationAggregate{
+	i := &InvitationAggregate{
 		AggregateBase: eh.NewAggregateBase(id),
 	}
+
+	eh.OnEvent(i.AggregateBase, InviteCreatedEvent, func(event *InviteCreated) {
+		i.name = event.Name
+		i.age = event.Age
+	})
+	eh.OnEvent(i.AggregateBase, InviteAcceptedEvent, func(*InviteAccepted) {
+		i.accepted = true
+	})
+	eh.OnEvent(i.AggregateBase, InviteDeclinedEvent, func(*InviteDeclined) {
+		i.declined = true
+	})
+	eh.OnEvent(i.AggregateBase, InviteConfirmedEvent, func(*InviteConfirmed) {
+		i.confirmed = true
+	})
+	eh.OnEvent(i.AggregateBase, InviteDeniedEvent, func(*InviteDenied) {
+		i.denied = true
+	})
+	i.EnableApplyOnStore(nil)
+
+	return i
 }
 
 // AggregateType implements the AggregateType method of the Aggregate interface.
@@ -63,7 +85,11 @@ func (i *InvitationAggregate) AggregateType() eh.AggregateType {
 func (i *InvitationAggregate) HandleCommand(command eh.Command) error {
 	switch command := command.(type) {
 	case *CreateInvite:
-		i.StoreEvent(&InviteCreated{command.InvitationID, command.Name, command.Age})
+		i.StoreEvent(&InviteCreated{
+			InvitationID: command.InvitationID,
+			Name:         command.Name,
+			Age:          command.Age,
+		})
 		return nil
 
 	case *AcceptInvite:
@@ -79,7 +105,7 @@ func (i *InvitationAggregate) HandleCommand(command eh.Command) error {
 			return nil
 		}
 
-		i.StoreEvent(&InviteAccepted{i.AggregateID()})
+		i.StoreEvent(&InviteAccepted{InvitationID: i.AggregateID()})
 		return nil
 
 	case *DeclineInvite:
@@ -95,7 +121,7 @@ func (i *InvitationAggregate) HandleCommand(command eh.Command) error {
 			return nil
 		}
 
-		i.StoreEvent(&InviteDeclined{i.AggregateID()})
+		i.StoreEvent(&InviteDeclined{InvitationID: i.AggregateID()})
 		return nil
 
 	case *ConfirmInvite:
@@ -107,7 +133,7 @@ func (i *InvitationAggregate) HandleCommand(command eh.Command) error {
 			return fmt.Errorf("only accepted invites can be confirmed")
 		}
 
-		i.StoreEvent(&InviteConfirmed{i.AggregateID()})
+		i.StoreEvent(&InviteConfirmed{InvitationID: i.AggregateID()})
 		return nil
 
 	case *DenyInvite:
@@ -119,25 +145,8 @@ func (i *InvitationAggregate) HandleCommand(command eh.Command) error {
 			return fmt.Errorf("only accepted invites can be denied")
 		}
 
-		i.StoreEvent(&InviteDenied{i.AggregateID()})
+		i.StoreEvent(&InviteDenied{InvitationID: i.AggregateID()})
 		return nil
 	}
 	return fmt.Errorf("couldn't handle command")
 }
-
-// ApplyEvent implements the ApplyEvent method of the Aggregate interface.
-func (i *InvitationAggregate) ApplyEvent(event eh.Event) {
-	switch event := event.(type) {
-	case *InviteCreated:
-		i.name = event.Name
-		i.age = event.Age
-	case *InviteAccepted:
-		i.accepted = true
-	case *InviteDeclined:
-		i.declined = true
-	case *InviteConfirmed:
-		i.confirmed = true
-	case *InviteDenied:
-		i.denied = true
-	}
-}
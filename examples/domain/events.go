@@ -39,8 +39,11 @@ const (
 // InviteCreated is an event for when an invite has been created.
 type InviteCreated struct {
 	InvitationID eh.UUID `bson:"invitation_id"`
-	Name         string  `bson:"name"`
-	Age          int     `bson:"age"`
+	Name         string  `bson:"name" eh:"pii"`
+	Age          int     `bson:"age" eh:"pii"`
+
+	// Encrypted holds the encrypted age when stored in an encryption store.
+	Encrypted map[string]string `bson:"encrypted,omitempty" eh:"encrypted"`
 }
 
 func (c InviteCreated) AggregateID() eh.UUID            { return c.InvitationID }
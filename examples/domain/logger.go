@@ -15,15 +15,35 @@
 package domain
 
 import (
-	"log"
+	"encoding/json"
 
 	eh "github.com/looplab/eventhorizon"
 )
 
-// Logger is a simple event handler for logging all events.
-type Logger struct{}
+// Logger is a simple event observer for logging all events.
+type Logger struct {
+	// Logger is the logger to use, the default is eventhorizon.DefaultLogger.
+	// The events are logged as payloads if enabled with
+	// eventhorizon.WithPayloads.
+	Logger eh.Logger
+}
 
-// Notify implements the HandleEvent method of the EventHandler interface.
+// Notify implements the Notify method of the EventObserver interface.
 func (l *Logger) Notify(event eh.Event) {
-	log.Printf("event: %#v\n", event)
+	logger := l.Logger
+	if logger == nil {
+		logger = eh.DefaultLogger()
+	}
+
+	logger.Info("event",
+		eh.F("event_type", event.EventType()),
+		eh.F("aggregate_id", event.AggregateID()),
+	)
+	// Only encode the event if the payload is logged.
+	if !eh.PayloadsEnabled(logger) {
+		return
+	}
+	if data, err := json.Marshal(event); err == nil {
+		eh.LogPayload(logger, "event", data, eh.F("event_type", event.EventType()))
+	}
 }
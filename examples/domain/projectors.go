@@ -15,53 +15,65 @@
 package domain
 
 import (
-	"log"
-	"sync"
+	"errors"
 
 	eh "github.com/looplab/eventhorizon"
 )
 
+// ErrIncorrectModel is when a read model is of incorrect type.
+var ErrIncorrectModel = errors.New("model is of incorrect type")
+
 // Invitation is a read model object for an invitation.
 type Invitation struct {
-	ID     eh.UUID
-	Name   string
-	Age    int
-	Status string
+	ID               eh.UUID
+	Version          int
+	AggregateVersion int
+	Name             string
+	Age              int
+	Status           string
 }
 
+// ModelVersion implements the ModelVersion method of the Versionable interface.
+func (i *Invitation) ModelVersion() int { return i.Version }
+
+// SetModelVersion implements the SetModelVersion method of the Versionable interface.
+func (i *Invitation) SetModelVersion(v int) { i.Version = v }
+
+// ProjectedVersion implements the ProjectedVersion method of the Projectable interface.
+func (i *Invitation) ProjectedVersion() int { return i.AggregateVersion }
+
+// SetProjectedVersion implements the SetProjectedVersion method of the Projectable interface.
+func (i *Invitation) SetProjectedVersion(v int) { i.AggregateVersion = v }
+
 // InvitationProjector is a projector that updates the invitations.
 type InvitationProjector struct {
-	repository eh.ReadRepository
+	*eh.ProjectorBase
 }
 
-// NewInvitationProjector creates a new InvitationProjector.
-func NewInvitationProjector(repository eh.ReadRepository) *InvitationProjector {
-	p := &InvitationProjector{
-		repository: repository,
-	}
+// NewInvitationProjector creates a new InvitationProjector. Events that
+// arrive out of order are buffered until they can be projected in order.
+func NewInvitationProjector(repository eh.VersionedReadRepository) *InvitationProjector {
+	p := &InvitationProjector{}
+	p.ProjectorBase = eh.NewProjectorBase(repository, p)
+	p.SetGapHandlingStrategy(eh.BufferGapHandlingStrategy)
+
 	return p
 }
 
-// HandlerType implements the HandlerType method of the EventHandler interface.
-func (p *InvitationProjector) HandlerType() eh.EventHandlerType {
-	return eh.EventHandlerType("InvitationProjector")
+// ProjectorType implements the ProjectorType method of the Projector interface.
+func (p *InvitationProjector) ProjectorType() eh.ProjectorType {
+	return eh.ProjectorType("InvitationProjector")
 }
 
-// HandleEvent implements the HandleEvent method of the EventHandler interface.
-func (p *InvitationProjector) HandleEvent(event eh.Event) {
-	// Load or create the model.
-	var i *Invitation
-	if m, _ := p.repository.Find(event.AggregateID()); m != nil {
-		var ok bool
-		if i, ok = m.(*Invitation); !ok {
-			log.Println("error: model is of incorrect type")
-			return
-		}
-	} else {
-		i = &Invitation{
-			ID:     event.AggregateID(),
-			Status: "created",
-		}
+// Project implements the Project method of the Projector interface.
+func (p *InvitationProjector) Project(event eh.Event, model interface{}) error {
+	i, ok := model.(*Invitation)
+	if !ok {
+		return ErrIncorrectModel
+	}
+	if i.AggregateVersion == 0 {
+		i.ID = event.AggregateID()
+		i.Status = "created"
 	}
 
 	// Apply the changes for the event.
@@ -70,30 +82,21 @@ func (p *InvitationProjector) HandleEvent(event eh.Event) {
 		i.Name = e.Name
 		i.Age = e.Age
 	case *InviteAccepted:
-		// NOTE: Temp fix for events that arrive out of order.
-		if i.Status != "confirmed" && i.Status != "denied" {
-			i.Status = "accepted"
-		}
+		i.Status = "accepted"
 	case *InviteDeclined:
-		// NOTE: Temp fix for events that arrive out of order.
-		if i.Status != "confirmed" && i.Status != "denied" {
-			i.Status = "declined"
-		}
+		i.Status = "declined"
 	case *InviteConfirmed:
 		i.Status = "confirmed"
 	case *InviteDenied:
 		i.Status = "denied"
 	}
-
-	// Save it back, same for new and updated models.
-	if err := p.repository.Save(event.AggregateID(), i); err != nil {
-		log.Println("error: could not save model: ", err)
-	}
+	return nil
 }
 
 // GuestList is a read model object for the guest list.
 type GuestList struct {
 	ID           eh.UUID
+	Version      int
 	NumGuests    int
 	NumAccepted  int
 	NumDeclined  int
@@ -101,15 +104,20 @@ type GuestList struct {
 	NumDenied    int
 }
 
+// ModelVersion implements the ModelVersion method of the Versionable interface.
+func (g *GuestList) ModelVersion() int { return g.Version }
+
+// SetModelVersion implements the SetModelVersion method of the Versionable interface.
+func (g *GuestList) SetModelVersion(v int) { g.Version = v }
+
 // GuestListProjector is a projector that updates the guest list.
 type GuestListProjector struct {
-	repository   eh.ReadRepository
-	repositoryMu sync.Mutex
-	eventID      eh.UUID
+	repository eh.VersionedReadRepository
+	eventID    eh.UUID
 }
 
 // NewGuestListProjector creates a new GuestListProjector.
-func NewGuestListProjector(repository eh.ReadRepository, eventID eh.UUID) *GuestListProjector {
+func NewGuestListProjector(repository eh.VersionedReadRepository, eventID eh.UUID) *GuestListProjector {
 	p := &GuestListProjector{
 		repository: repository,
 		eventID:    eventID,
@@ -124,33 +132,33 @@ func (p *GuestListProjector) HandlerType() eh.EventHandlerType {
 
 // HandleEvent implements the HandleEvent method of the EventHandler interface.
 func (p *GuestListProjector) HandleEvent(event eh.Event) {
-	// NOTE: Temp fix because we need to count the guests atomically.
-	p.repositoryMu.Lock()
-	defer p.repositoryMu.Unlock()
-
-	// Load or create the guest list.
-	var g *GuestList
-	if m, _ := p.repository.Find(p.eventID); m != nil {
-		g = m.(*GuestList)
-	} else {
-		g = &GuestList{
-			ID: p.eventID,
+	// Count the guests atomically, creating the guest list if it is new.
+	err := p.repository.Update(p.eventID, func(m interface{}) error {
+		g, ok := m.(*GuestList)
+		if !ok {
+			return ErrIncorrectModel
 		}
+		g.ID = p.eventID
+
+		// Apply the count of the guests.
+		switch event.(type) {
+		case *InviteAccepted:
+			g.NumAccepted++
+			g.NumGuests++
+		case *InviteDeclined:
+			g.NumDeclined++
+			g.NumGuests++
+		case *InviteConfirmed:
+			g.NumConfirmed++
+		case *InviteDenied:
+			g.NumDenied++
+		}
+		return nil
+	})
+	if err != nil {
+		eh.DefaultLogger().Error("could not save model",
+			eh.F("aggregate_id", event.AggregateID()),
+			eh.F("error", err),
+		)
 	}
-
-	// Apply the count of the guests.
-	switch event.(type) {
-	case *InviteAccepted:
-		g.NumAccepted++
-		g.NumGuests++
-	case *InviteDeclined:
-		g.NumDeclined++
-		g.NumGuests++
-	case *InviteConfirmed:
-		g.NumConfirmed++
-	case *InviteDenied:
-		g.NumDenied++
-	}
-
-	p.repository.Save(p.eventID, g)
 }
@@ -68,6 +68,7 @@ func Example() {
 
 	// Create and register a read model for individual invitations.
 	invitationRepository := readrepository.NewReadRepository()
+	invitationRepository.SetModel(func() interface{} { return &domain.Invitation{} })
 	invitationProjector := domain.NewInvitationProjector(invitationRepository)
 	eventBus.AddHandler(invitationProjector, domain.InviteCreatedEvent)
 	eventBus.AddHandler(invitationProjector, domain.InviteAcceptedEvent)
@@ -78,6 +79,7 @@ func Example() {
 	// Create and register a read model for a guest list.
 	eventID := eh.NewUUID()
 	guestListRepository := readrepository.NewReadRepository()
+	guestListRepository.SetModel(func() interface{} { return &domain.GuestList{} })
 	guestListProjector := domain.NewGuestListProjector(guestListRepository, eventID)
 	eventBus.AddHandler(guestListProjector, domain.InviteCreatedEvent)
 	eventBus.AddHandler(guestListProjector, domain.InviteAcceptedEvent)
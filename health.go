@@ -0,0 +1,40 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"time"
+)
+
+// Health is the health of a bus, store or read repository, as reported by a
+// HealthChecker.
+type Health struct {
+	// Latency is the round trip time to the backend when checked.
+	Latency time.Duration
+
+	// Details has backend specific state, for example if a bus is subscribed.
+	Details map[string]interface{}
+}
+
+// HealthChecker is implemented by buses, stores and read repositories that
+// can check their connection to the backend, for example for the readiness
+// probes of the health package.
+type HealthChecker interface {
+	// CheckHealth checks the connection to the backend. It returns an error
+	// if the component is not able to work, in which case the health may still
+	// have details about why.
+	CheckHealth(context.Context) (Health, error)
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health exposes the health of buses, stores and read repositories
+// over HTTP, for liveness and readiness probes.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultTimeout is the default timeout of each check.
+const DefaultTimeout = 5 * time.Second
+
+// The statuses of a report and its checks.
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+)
+
+// ErrNoHealthCheckerDefined is when adding a nil health checker.
+var ErrNoHealthCheckerDefined = errors.New("no health checker defined")
+
+// ErrDuplicateName is when adding a health checker with a name that is
+// already used.
+var ErrDuplicateName = errors.New("duplicate name")
+
+// Report is the result of checking all components.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// Result is the result of checking one component.
+type Result struct {
+	Status    string                 `json:"status"`
+	LatencyMs float64                `json:"latency_ms"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+}
+
+// Handler is a http.Handler for readiness probes. It checks all components
+// concurrently and responds with the report as JSON, with the status 200 if
+// all components are healthy and 503 otherwise.
+type Handler struct {
+	checkers map[string]eh.HealthChecker
+	timeout  time.Duration
+	mu       sync.RWMutex
+}
+
+// NewHandler creates a Handler without components.
+func NewHandler() *Handler {
+	return &Handler{
+		checkers: make(map[string]eh.HealthChecker),
+		timeout:  DefaultTimeout,
+	}
+}
+
+// SetTimeout sets the timeout of each check, the default is DefaultTimeout.
+// Checks that time out are unhealthy.
+func (h *Handler) SetTimeout(timeout time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.timeout = timeout
+}
+
+// Add adds a component to check, with a name used in the report.
+func (h *Handler) Add(name string, checker eh.HealthChecker) error {
+	if checker == nil {
+		return ErrNoHealthCheckerDefined
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.checkers[name]; ok {
+		return ErrDuplicateName
+	}
+	h.checkers[name] = checker
+	return nil
+}
+
+// Check checks all components concurrently and returns the report.
+func (h *Handler) Check(ctx context.Context) Report {
+	h.mu.RLock()
+	checkers := make(map[string]eh.HealthChecker, len(h.checkers))
+	for name, checker := range h.checkers {
+		checkers[name] = checker
+	}
+	timeout := h.timeout
+	h.mu.RUnlock()
+
+	report := Report{
+		Status: StatusOK,
+		Checks: make(map[string]Result, len(checkers)),
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	for name, checker := range checkers {
+		wg.Add(1)
+		go func(name string, checker eh.HealthChecker) {
+			defer wg.Done()
+			result := check(ctx, checker, timeout)
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if result.Status != StatusOK {
+				report.Status = StatusUnavailable
+			}
+		}(name, checker)
+	}
+	wg.Wait()
+
+	return report
+}
+
+// ServeHTTP implements the ServeHTTP method of the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := h.Check(r.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// Live is a http.HandlerFunc for liveness probes. It responds with the status
+// 200 as long as the process serves HTTP. The components are not checked, as
+// restarting the process does not fix an unavailable backend.
+func Live(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+func check(ctx context.Context, checker eh.HealthChecker, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Run the check in its own goroutine to not wait for checkers that do not
+	// respect the context.
+	type checked struct {
+		health eh.Health
+		err    error
+	}
+	done := make(chan checked, 1)
+	go func() {
+		health, err := checker.CheckHealth(ctx)
+		done <- checked{health, err}
+	}()
+
+	var c checked
+	select {
+	case c = <-done:
+	case <-ctx.Done():
+		c.err = ctx.Err()
+	}
+
+	result := Result{
+		Status:    StatusOK,
+		LatencyMs: float64(c.health.Latency) / float64(time.Millisecond),
+		Details:   c.health.Details,
+	}
+	if c.err != nil {
+		result.Status = StatusUnavailable
+		result.Error = c.err.Error()
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, status int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(report)
+}
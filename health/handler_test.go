@@ -0,0 +1,132 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// checker returns a health and an error, or blocks until the context is done
+// if block is set.
+type checker struct {
+	health eh.Health
+	err    error
+	block  bool
+}
+
+func (c *checker) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if c.block {
+		<-ctx.Done()
+		return eh.Health{}, ctx.Err()
+	}
+	return c.health, c.err
+}
+
+func TestHandler(t *testing.T) {
+	h := NewHandler()
+	if err := h.Add("nil", nil); err != ErrNoHealthCheckerDefined {
+		t.Error("there should be a ErrNoHealthCheckerDefined error:", err)
+	}
+	store := &checker{health: eh.Health{Latency: 2 * time.Millisecond}}
+	if err := h.Add("store", store); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.Add("store", store); err != ErrDuplicateName {
+		t.Error("there should be a ErrDuplicateName error:", err)
+	}
+	bus := &checker{health: eh.Health{Details: map[string]interface{}{"subscribed": true}}}
+	if err := h.Add("bus", bus); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("all healthy")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusOK {
+		t.Error("the status should be OK:", rec.Code)
+	}
+	var report Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	expected := Report{
+		Status: StatusOK,
+		Checks: map[string]Result{
+			"store": {Status: StatusOK, LatencyMs: 2},
+			"bus":   {Status: StatusOK, Details: map[string]interface{}{"subscribed": true}},
+		},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Error("the report should be correct:", report)
+	}
+
+	t.Log("one unhealthy")
+	bus.health.Details["subscribed"] = false
+	bus.err = errors.New("not subscribed")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/ready", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Error("the status should be ServiceUnavailable:", rec.Code)
+	}
+	report = Report{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if report.Status != StatusUnavailable {
+		t.Error("the status should be unavailable:", report.Status)
+	}
+	if r := report.Checks["bus"]; r.Status != StatusUnavailable || r.Error != "not subscribed" {
+		t.Error("the result should be correct:", r)
+	}
+	if r := report.Checks["store"]; r.Status != StatusOK {
+		t.Error("the result should be correct:", r)
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	h := NewHandler()
+	h.SetTimeout(10 * time.Millisecond)
+	if err := h.Add("slow", &checker{block: true}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	report := h.Check(context.Background())
+	if report.Status != StatusUnavailable {
+		t.Error("the status should be unavailable:", report.Status)
+	}
+	if r := report.Checks["slow"]; r.Error != context.DeadlineExceeded.Error() {
+		t.Error("the error should be correct:", r.Error)
+	}
+}
+
+func TestLive(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Live(rec, httptest.NewRequest("GET", "/live", nil))
+	if rec.Code != http.StatusOK {
+		t.Error("the status should be OK:", rec.Code)
+	}
+	if body := rec.Body.String(); body != "{\"status\":\"ok\"}\n" {
+		t.Error("the body should be correct:", body)
+	}
+}
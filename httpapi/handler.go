@@ -0,0 +1,264 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package httpapi exposes the handling of commands over HTTP with JSON.
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// DefaultPrefix is the default path prefix of the command types.
+const DefaultPrefix = "/commands/"
+
+// DefaultMaxBodySize is the default max size of a request body, 1 MiB.
+const DefaultMaxBodySize = 1 << 20
+
+// ErrNoCommandBusDefined is when creating a handler without a command bus.
+var ErrNoCommandBusDefined = errors.New("no command bus defined")
+
+// ErrorResponse is the JSON body of an error response. Fields has the invalid
+// fields when a command is not valid.
+type ErrorResponse struct {
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"`
+}
+
+// FieldError is an invalid field of a command, see eventhorizon.CommandFieldError.
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Handler is a http.Handler that handles commands posted as JSON to
+// POST /commands/{CommandType}. The command is created with the registry,
+// decoded from the body, validated with eventhorizon.ValidateCommand and
+// handled on the command bus with the context of the request, which can carry
+// the tenant or other values set by a middleware.
+//
+// A handled command responds with 204 No Content. Errors respond with a JSON
+// ErrorResponse and a status code:
+//
+//	400 Bad Request               the body is not valid JSON for the command
+//	403 Forbidden                 the command is for another tenant
+//	404 Not Found                 the command type or its handler is not found
+//	405 Method Not Allowed        the method is not POST
+//	409 Conflict                  the aggregate or model was changed or the stream is closed
+//	413 Request Entity Too Large  the body is larger than the max body size
+//	422 Unprocessable Entity      the command is not valid
+//	503 Service Unavailable       the command bus is closed
+//	504 Gateway Timeout           the deadline of the request was exceeded
+//	500 Internal Server Error     any other error, which is logged but not sent
+//
+// Other errors, like the errors of a specific event store, can be mapped to a
+// status with SetErrorStatus.
+type Handler struct {
+	bus         eh.ContextCommandHandler
+	registry    *eh.Registry
+	prefix      string
+	maxBodySize int64
+	logger      eh.Logger
+
+	// statuses are checked in order with errors.Is, the ones that are set are
+	// prepended to take precedence over the defaults.
+	statuses   []errorStatus
+	statusesMu sync.RWMutex
+}
+
+type errorStatus struct {
+	err    error
+	status int
+}
+
+// NewHandler creates a Handler that handles commands on a command bus.
+func NewHandler(bus eh.CommandBus) (*Handler, error) {
+	if bus == nil {
+		return nil, ErrNoCommandBusDefined
+	}
+
+	return &Handler{
+		bus:         eh.AdaptCommandHandler(bus),
+		registry:    eh.DefaultRegistry(),
+		prefix:      DefaultPrefix,
+		maxBodySize: DefaultMaxBodySize,
+		logger:      eh.DefaultLogger(),
+		statuses: []errorStatus{
+			{eh.ErrCommandNotRegistered, http.StatusNotFound},
+			{eh.ErrHandlerNotFound, http.StatusNotFound},
+			{eh.ErrAggregateNotFound, http.StatusNotFound},
+			{eh.ErrIncorrectAggregateVersion, http.StatusConflict},
+			{eh.ErrIncorrectModelVersion, http.StatusConflict},
+			{eh.ErrStreamClosed, http.StatusConflict},
+			{eh.ErrInvalidTenant, http.StatusBadRequest},
+			{eh.ErrCrossTenantAccess, http.StatusForbidden},
+			{eh.ErrClosed, http.StatusServiceUnavailable},
+			{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		},
+	}, nil
+}
+
+// SetRegistry sets the registry used to create the commands, the default is
+// eventhorizon.DefaultRegistry.
+func (h *Handler) SetRegistry(registry *eh.Registry) {
+	h.registry = registry
+}
+
+// SetPrefix sets the path prefix before the command type, the default is
+// DefaultPrefix.
+func (h *Handler) SetPrefix(prefix string) {
+	h.prefix = prefix
+}
+
+// SetMaxBodySize sets the max size of request bodies, the default is
+// DefaultMaxBodySize.
+func (h *Handler) SetMaxBodySize(size int64) {
+	h.maxBodySize = size
+}
+
+// SetLogger sets the logger used for internal errors, the default is
+// eventhorizon.DefaultLogger.
+func (h *Handler) SetLogger(logger eh.Logger) {
+	h.logger = logger
+}
+
+// SetErrorStatus sets the status code of responses for errors that match err
+// with errors.Is, for example to respond with 503 Service Unavailable for the
+// errors that a specific store returns when it can not be reached.
+func (h *Handler) SetErrorStatus(err error, status int) {
+	h.statusesMu.Lock()
+	defer h.statusesMu.Unlock()
+
+	h.statuses = append([]errorStatus{{err, status}}, h.statuses...)
+}
+
+// ServeHTTP implements the ServeHTTP method of the http.Handler interface.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		h.writeError(w, http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"})
+		return
+	}
+
+	commandType := strings.TrimPrefix(r.URL.Path, h.prefix)
+	if commandType == r.URL.Path || commandType == "" || strings.Contains(commandType, "/") {
+		h.writeError(w, http.StatusNotFound, ErrorResponse{Error: "not found"})
+		return
+	}
+
+	command, err := h.registry.CreateCommand(eh.CommandType(commandType))
+	if err != nil {
+		h.handleError(w, err)
+		return
+	}
+
+	if err := h.decode(w, r, command); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			h.writeError(w, http.StatusRequestEntityTooLarge, ErrorResponse{Error: "request body too large"})
+			return
+		}
+		h.writeError(w, http.StatusBadRequest, ErrorResponse{Error: "invalid body: " + err.Error()})
+		return
+	}
+
+	// Errors of Validate methods are also invalid commands, even without
+	// CommandFieldErrors.
+	if err := eh.ValidateCommand(command); err != nil {
+		if !h.writeFieldErrors(w, err) {
+			h.writeError(w, http.StatusUnprocessableEntity, ErrorResponse{Error: err.Error()})
+		}
+		return
+	}
+
+	if err := h.bus.HandleCommandContext(r.Context(), command); err != nil {
+		h.handleError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// decode decodes the body into the command, an empty body leaves the command
+// as created.
+func (h *Handler) decode(w http.ResponseWriter, r *http.Request, command eh.Command) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, h.maxBodySize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(command); err != nil && err != io.EOF {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after the command")
+	}
+	return nil
+}
+
+func (h *Handler) handleError(w http.ResponseWriter, err error) {
+	if h.writeFieldErrors(w, err) {
+		return
+	}
+
+	h.statusesMu.RLock()
+	status := http.StatusInternalServerError
+	for _, s := range h.statuses {
+		if errors.Is(err, s.err) {
+			status = s.status
+			break
+		}
+	}
+	h.statusesMu.RUnlock()
+
+	if status == http.StatusInternalServerError {
+		h.logger.Error("httpapi: could not handle command", eh.F("error", err))
+		h.writeError(w, status, ErrorResponse{Error: http.StatusText(status)})
+		return
+	}
+	h.writeError(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// writeFieldErrors writes the invalid fields if the error has any.
+func (h *Handler) writeFieldErrors(w http.ResponseWriter, err error) bool {
+	var fieldErrs eh.CommandFieldErrors
+	var fieldErr eh.CommandFieldError
+	if !errors.As(err, &fieldErrs) {
+		if !errors.As(err, &fieldErr) {
+			return false
+		}
+		fieldErrs = eh.CommandFieldErrors{fieldErr}
+	}
+
+	res := ErrorResponse{Error: "invalid command"}
+	for _, e := range fieldErrs {
+		reason := e.Reason
+		if reason == "" {
+			reason = "missing"
+		}
+		res.Fields = append(res.Fields, FieldError{Field: e.Field, Reason: reason})
+	}
+	h.writeError(w, http.StatusUnprocessableEntity, res)
+	return true
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, status int, res ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/commandbus/local"
+)
+
+const createUserType eh.CommandType = "CreateUser"
+
+type createUser struct {
+	ID   eh.UUID
+	Name string `eh:"maxlen=5"`
+	Age  int    `eh:"min=18"`
+}
+
+func (c *createUser) AggregateID() eh.UUID            { return c.ID }
+func (c *createUser) AggregateType() eh.AggregateType { return "User" }
+func (c *createUser) CommandType() eh.CommandType     { return createUserType }
+
+// commandHandler records the handled command and context, and returns err.
+type commandHandler struct {
+	command eh.Command
+	ctx     context.Context
+	err     error
+}
+
+func (h *commandHandler) HandleCommand(command eh.Command) error {
+	return h.HandleCommandContext(context.Background(), command)
+}
+
+func (h *commandHandler) HandleCommandContext(ctx context.Context, command eh.Command) error {
+	h.command = command
+	h.ctx = ctx
+	return h.err
+}
+
+func TestHandler(t *testing.T) {
+	if _, err := NewHandler(nil); err != ErrNoCommandBusDefined {
+		t.Error("there should be a ErrNoCommandBusDefined error:", err)
+	}
+
+	registry := eh.NewRegistry()
+	if err := registry.RegisterCommand(func() eh.Command { return &createUser{} }); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	bus := local.NewCommandBus()
+	handler := &commandHandler{}
+	if err := bus.SetHandler(handler, createUserType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	h, err := NewHandler(bus)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	h.SetRegistry(registry)
+	h.SetLogger(eh.NopLogger{})
+	h.SetMaxBodySize(100)
+	conflictErr := errors.New("could not save aggregate")
+	h.SetErrorStatus(conflictErr, http.StatusConflict)
+
+	id := eh.NewUUID()
+	body := `{"ID":"` + id.String() + `","Name":"Bob","Age":42}`
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		handlerErr error
+		status     int
+		res        ErrorResponse
+	}{
+		{"method", "GET", "/commands/CreateUser", "", nil,
+			http.StatusMethodNotAllowed, ErrorResponse{Error: "method not allowed"}},
+		{"no type", "POST", "/commands/", body, nil,
+			http.StatusNotFound, ErrorResponse{Error: "not found"}},
+		{"other path", "POST", "/other/CreateUser", body, nil,
+			http.StatusNotFound, ErrorResponse{Error: "not found"}},
+		{"unknown type", "POST", "/commands/Unknown", body, nil,
+			http.StatusNotFound, ErrorResponse{Error: eh.ErrCommandNotRegistered.Error()}},
+		{"invalid JSON", "POST", "/commands/CreateUser", `{"Name":`, nil,
+			http.StatusBadRequest, ErrorResponse{Error: "invalid body: unexpected EOF"}},
+		{"unknown field", "POST", "/commands/CreateUser", `{"Other":1}`, nil,
+			http.StatusBadRequest, ErrorResponse{Error: `invalid body: json: unknown field "Other"`}},
+		{"too large", "POST", "/commands/CreateUser", `{"Name":"` + strings.Repeat("a", 100) + `"}`, nil,
+			http.StatusRequestEntityTooLarge, ErrorResponse{Error: "request body too large"}},
+		{"invalid fields", "POST", "/commands/CreateUser", `{"Name":"Robert","Age":3}`, nil,
+			http.StatusUnprocessableEntity, ErrorResponse{Error: "invalid command", Fields: []FieldError{
+				{Field: "ID", Reason: "missing"},
+				{Field: "Name", Reason: "length must be at most 5"},
+				{Field: "Age", Reason: "must be at least 18"},
+			}}},
+		{"handler field error", "POST", "/commands/CreateUser", body, eh.CommandFieldError{Field: "Name", Reason: "taken"},
+			http.StatusUnprocessableEntity, ErrorResponse{Error: "invalid command", Fields: []FieldError{
+				{Field: "Name", Reason: "taken"},
+			}}},
+		{"aggregate version", "POST", "/commands/CreateUser", body, eh.ErrIncorrectAggregateVersion,
+			http.StatusConflict, ErrorResponse{Error: eh.ErrIncorrectAggregateVersion.Error()}},
+		{"model version", "POST", "/commands/CreateUser", body, eh.ErrIncorrectModelVersion,
+			http.StatusConflict, ErrorResponse{Error: eh.ErrIncorrectModelVersion.Error()}},
+		{"set error status", "POST", "/commands/CreateUser", body, conflictErr,
+			http.StatusConflict, ErrorResponse{Error: conflictErr.Error()}},
+		{"internal error", "POST", "/commands/CreateUser", body, errors.New("db error"),
+			http.StatusInternalServerError, ErrorResponse{Error: "Internal Server Error"}},
+	}
+	for _, c := range cases {
+		t.Log(c.name)
+		handler.err = c.handlerErr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if rec.Code != c.status {
+			t.Error("the status should be correct:", c.name, rec.Code)
+		}
+		var res ErrorResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Error("there should be no error:", c.name, err)
+		}
+		if !reflect.DeepEqual(res, c.res) {
+			t.Error("the response should be correct:", c.name, res)
+		}
+	}
+
+	t.Log("handle command")
+	handler.err = nil
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/commands/CreateUser", strings.NewReader(body))
+	req = req.WithContext(eh.WithTenantID(req.Context(), "a"))
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Error("the status should be NoContent:", rec.Code, rec.Body.String())
+	}
+	expected := &createUser{ID: id, Name: "Bob", Age: 42}
+	if !reflect.DeepEqual(handler.command, expected) {
+		t.Error("the command should be correct:", handler.command)
+	}
+	if tenantID, _ := eh.TenantIDFromContext(handler.ctx); tenantID != "a" {
+		t.Error("the context of the request should be used:", tenantID)
+	}
+}
+
+func TestHandlerNoHandler(t *testing.T) {
+	registry := eh.NewRegistry()
+	if err := registry.RegisterCommand(func() eh.Command { return &createUser{} }); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	h, err := NewHandler(local.NewCommandBus())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	h.SetRegistry(registry)
+
+	body := `{"ID":"` + eh.NewUUID().String() + `","Name":"Bob","Age":42}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/commands/CreateUser", strings.NewReader(body)))
+	if rec.Code != http.StatusNotFound {
+		t.Error("the status should be NotFound:", rec.Code)
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package handling runs the handling of events with the event handling
+// strategies, for the event buses.
+package handling
+
+import (
+	"context"
+	"hash/fnv"
+	"runtime"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// workerKey is the context key that is set for funcs run on a worker.
+type workerKey struct{}
+
+// DefaultQueueSize is the default size of the queues of the pooled and
+// ordered strategies.
+const DefaultQueueSize = 1000
+
+// DefaultWorkers returns the default number of workers of the pooled and
+// ordered strategies, the number of CPUs.
+func DefaultWorkers() int {
+	return runtime.NumCPU()
+}
+
+// Executor runs funcs with an event handling strategy, and keeps track of the
+// funcs that are run asynchronously to be able to wait for them.
+type Executor struct {
+	strategy  eh.EventHandlingStrategy
+	workers   int
+	queueSize int
+
+	// queues are the queues of the workers, one shared by all workers for the
+	// pooled strategy and one per worker for the ordered strategy.
+	queues  []*queue
+	start   sync.Once
+	closed  bool
+	queueMu sync.RWMutex
+
+	pending   int
+	pendingMu sync.Mutex
+	idle      *sync.Cond
+}
+
+// NewExecutor creates an Executor with a strategy, and the number of workers
+// and queue size for the pooled and ordered strategies. The workers are
+// started when first used.
+func NewExecutor(strategy eh.EventHandlingStrategy, workers, queueSize int) *Executor {
+	if workers <= 0 {
+		workers = DefaultWorkers()
+	}
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+
+	e := &Executor{
+		strategy:  strategy,
+		workers:   workers,
+		queueSize: queueSize,
+	}
+	e.idle = sync.NewCond(&e.pendingMu)
+	return e
+}
+
+// Async returns true if funcs are run asynchronously.
+func (e *Executor) Async() bool {
+	return e.strategy != eh.SimpleEventHandlingStrategy
+}
+
+// Run runs the funcs for an event of an aggregate with the strategy, with the
+// context. Funcs are run directly with the simple strategy, and after the
+// executor is closed.
+//
+// The funcs are queued together with the pooled and ordered strategies, so
+// that funcs they run can not queue funcs before them. Runs wait for room in
+// the queues, but not when run from a func on a worker of any executor (as
+// the worker could otherwise wait for itself or another waiting worker), then
+// the funcs are queued after the queue is full instead, to keep their order.
+func (e *Executor) Run(ctx context.Context, id eh.UUID, fs ...func(context.Context)) {
+	if !e.Async() {
+		for _, f := range fs {
+			f(ctx)
+		}
+		return
+	}
+
+	// Funcs that are added before closing are waited for by Close, which
+	// keeps the queues open for them without holding the lock while queuing.
+	e.queueMu.RLock()
+	closed := e.closed
+	if !closed {
+		e.add(len(fs))
+	}
+	e.queueMu.RUnlock()
+
+	if closed {
+		for _, f := range fs {
+			f(ctx)
+		}
+		return
+	}
+
+	var q *queue
+	switch e.strategy {
+	case eh.PooledEventHandlingStrategy:
+		e.start.Do(e.startWorkers)
+		q = e.queues[0]
+	case eh.OrderedEventHandlingStrategy:
+		e.start.Do(e.startWorkers)
+		q = e.queues[queueIndex(id, len(e.queues))]
+	default:
+		for _, f := range fs {
+			go func(f func(context.Context)) {
+				defer e.done()
+				f(ctx)
+			}(f)
+		}
+		return
+	}
+
+	workerCtx := context.WithValue(ctx, workerKey{}, true)
+	tasks := make([]func(), len(fs))
+	for i, f := range fs {
+		f := f
+		tasks[i] = func() {
+			defer e.done()
+			f(workerCtx)
+		}
+	}
+
+	onWorker, _ := ctx.Value(workerKey{}).(bool)
+	q.push(tasks, !onWorker)
+}
+
+// queueIndex returns the index of the queue of an aggregate for the ordered
+// strategy.
+func queueIndex(id eh.UUID, queues int) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(queues))
+}
+
+// Wait waits until all funcs that are run asynchronously are done, including
+// funcs that are started while waiting.
+func (e *Executor) Wait() {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+
+	for e.pending > 0 {
+		e.idle.Wait()
+	}
+}
+
+// Close waits for the funcs that are run asynchronously and stops the
+// workers.
+func (e *Executor) Close() {
+	e.CloseContext(context.Background())
+}
+
+// CloseContext is like Close but waits for the funcs only until the context is
+// done, then the error of the context is returned. The workers are stopped
+// when the funcs are done, also after the context is done.
+func (e *Executor) CloseContext(ctx context.Context) error {
+	e.queueMu.Lock()
+	if e.closed {
+		e.queueMu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.queueMu.Unlock()
+
+	// Funcs that publish events while waiting run them directly, as the
+	// executor is closed, and nothing is queued after the pending funcs.
+	drained := make(chan struct{})
+	go func() {
+		e.Wait()
+		for _, q := range e.queues {
+			q.close()
+		}
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *Executor) startWorkers() {
+	if e.strategy == eh.PooledEventHandlingStrategy {
+		q := newQueue(e.queueSize)
+		e.queues = []*queue{q}
+		for i := 0; i < e.workers; i++ {
+			go work(q)
+		}
+		return
+	}
+
+	e.queues = make([]*queue, e.workers)
+	for i := range e.queues {
+		e.queues[i] = newQueue(e.queueSize)
+		go work(e.queues[i])
+	}
+}
+
+func work(q *queue) {
+	for {
+		f, ok := q.pop()
+		if !ok {
+			return
+		}
+		f()
+	}
+}
+
+func (e *Executor) add(n int) {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+
+	e.pending += n
+}
+
+func (e *Executor) done() {
+	e.pendingMu.Lock()
+	defer e.pendingMu.Unlock()
+
+	e.pending--
+	if e.pending == 0 {
+		e.idle.Broadcast()
+	}
+}
+
+// queue is a FIFO queue of funcs for workers. It is bounded for pushes that
+// wait for room, other pushes are always added to not block the caller.
+type queue struct {
+	tasks    []func()
+	size     int
+	closed   bool
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+}
+
+func newQueue(size int) *queue {
+	q := &queue{size: size}
+	q.notEmpty = sync.NewCond(&q.mu)
+	q.notFull = sync.NewCond(&q.mu)
+	return q
+}
+
+// push adds funcs last in the queue, waiting for room first if wait is true.
+// The funcs are added together and can exceed the size of the queue.
+func (q *queue) push(fs []func(), wait bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for wait && len(q.tasks) >= q.size {
+		q.notFull.Wait()
+	}
+	q.tasks = append(q.tasks, fs...)
+	q.notEmpty.Broadcast()
+}
+
+// pop removes and returns the first func in the queue, waiting for one if the
+// queue is empty. It returns false when the queue is closed and empty.
+func (q *queue) pop() (func(), bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.tasks) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if len(q.tasks) == 0 {
+		return nil, false
+	}
+
+	f := q.tasks[0]
+	q.tasks[0] = nil
+	q.tasks = q.tasks[1:]
+	q.notFull.Broadcast()
+	return f, true
+}
+
+// close makes the workers return when the queue is empty.
+func (q *queue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.notEmpty.Broadcast()
+}
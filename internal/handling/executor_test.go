@@ -0,0 +1,248 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handling
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestExecutorSimple(t *testing.T) {
+	e := NewExecutor(eh.SimpleEventHandlingStrategy, 0, 0)
+	if e.Async() {
+		t.Error("the executor should not be async")
+	}
+	ran := false
+	e.Run(context.Background(), eh.NewUUID(), func(context.Context) { ran = true })
+	if !ran {
+		t.Error("the func should run directly")
+	}
+}
+
+func TestExecutorAsync(t *testing.T) {
+	for _, strategy := range []eh.EventHandlingStrategy{
+		eh.AsyncEventHandlingStrategy,
+		eh.PooledEventHandlingStrategy,
+		eh.OrderedEventHandlingStrategy,
+	} {
+		e := NewExecutor(strategy, 4, 10)
+		if !e.Async() {
+			t.Error("the executor should be async:", strategy)
+		}
+
+		var mu sync.Mutex
+		count := 0
+		for i := 0; i < 100; i++ {
+			e.Run(context.Background(), eh.NewUUID(), func(context.Context) {
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				count++
+				mu.Unlock()
+			})
+		}
+		e.Wait()
+		mu.Lock()
+		if count != 100 {
+			t.Error("all funcs should be run after waiting:", strategy, count)
+		}
+		mu.Unlock()
+
+		e.Close()
+		ran := false
+		e.Run(context.Background(), eh.NewUUID(), func(context.Context) { ran = true })
+		if !ran {
+			t.Error("the func should run directly after closing:", strategy)
+		}
+	}
+}
+
+func TestExecutorOrdered(t *testing.T) {
+	e := NewExecutor(eh.OrderedEventHandlingStrategy, 4, 10)
+	defer e.Close()
+
+	ids := []eh.UUID{eh.NewUUID(), eh.NewUUID(), eh.NewUUID()}
+	var mu sync.Mutex
+	handled := map[eh.UUID][]int{}
+	for i := 0; i < 50; i++ {
+		for _, id := range ids {
+			id, i := id, i
+			e.Run(context.Background(), id, func(context.Context) {
+				mu.Lock()
+				handled[id] = append(handled[id], i)
+				mu.Unlock()
+			})
+		}
+	}
+	e.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range ids {
+		if len(handled[id]) != 50 {
+			t.Fatal("all funcs should be run:", len(handled[id]))
+		}
+		for i, n := range handled[id] {
+			if n != i {
+				t.Fatal("the funcs of an aggregate should be run in order:", handled[id])
+			}
+		}
+	}
+}
+
+func TestExecutorPooledBounded(t *testing.T) {
+	e := NewExecutor(eh.PooledEventHandlingStrategy, 1, 1)
+	defer e.Close()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	e.Run(context.Background(), eh.NewUUID(), func(context.Context) {
+		close(started)
+		<-release
+	})
+	<-started
+	e.Run(context.Background(), eh.NewUUID(), func(context.Context) {})
+
+	// The queue is full, the next run should block until released.
+	done := make(chan struct{})
+	go func() {
+		e.Run(context.Background(), eh.NewUUID(), func(context.Context) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("the run should block when the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("the run should not block when the queue has room")
+	}
+	e.Wait()
+}
+
+func TestExecutorCloseContext(t *testing.T) {
+	e := NewExecutor(eh.PooledEventHandlingStrategy, 1, 1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	e.Run(context.Background(), eh.NewUUID(), func(context.Context) {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := e.CloseContext(ctx); err != context.DeadlineExceeded {
+		t.Error("the error should be correct:", err)
+	}
+
+	close(release)
+	e.Wait()
+	if err := e.CloseContext(context.Background()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestExecutorRunFromWorker(t *testing.T) {
+	e := NewExecutor(eh.OrderedEventHandlingStrategy, 1, 1)
+	defer e.Close()
+
+	// A func on the worker fills its own queue, the next run should not wait
+	// for the worker but queue the funcs after the full queue.
+	var mu sync.Mutex
+	count := 0
+	done := make(chan struct{})
+	e.Run(context.Background(), eh.NewUUID(), func(ctx context.Context) {
+		for i := 0; i < 3; i++ {
+			e.Run(ctx, eh.NewUUID(), func(context.Context) {
+				mu.Lock()
+				count++
+				mu.Unlock()
+			})
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the run from the worker should not block")
+	}
+	e.Wait()
+	mu.Lock()
+	if count != 3 {
+		t.Error("all funcs should be run:", count)
+	}
+	mu.Unlock()
+}
+
+func TestExecutorRunFromWorkerOrdered(t *testing.T) {
+	e := NewExecutor(eh.OrderedEventHandlingStrategy, 2, 1)
+	defer e.Close()
+
+	// Get aggregates on different workers.
+	x, y := eh.NewUUID(), eh.NewUUID()
+	for queueIndex(x, 2) == queueIndex(y, 2) {
+		y = eh.NewUUID()
+	}
+
+	var mu sync.Mutex
+	order := []int{}
+	record := func(n int) func(context.Context) {
+		return func(context.Context) {
+			mu.Lock()
+			order = append(order, n)
+			mu.Unlock()
+		}
+	}
+
+	// Block the worker of X and fill its queue with the first event of X.
+	release := make(chan struct{})
+	started := make(chan struct{})
+	e.Run(context.Background(), x, func(context.Context) {
+		close(started)
+		<-release
+	})
+	<-started
+	e.Run(context.Background(), x, record(1))
+
+	// The second event of X is run from the worker of Y, it should not block
+	// and not be run before the first event.
+	done := make(chan struct{})
+	e.Run(context.Background(), y, func(ctx context.Context) {
+		e.Run(ctx, x, record(2))
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the run from the worker should not block")
+	}
+
+	close(release)
+	e.Wait()
+	mu.Lock()
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Error("the funcs of an aggregate should be run in order:", order)
+	}
+	mu.Unlock()
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package message frames message payloads with headers, for transports that
+// only carry a single binary payload such as Redis pub/sub.
+package message
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrInvalidMessage is when a framed message could not be decoded.
+var ErrInvalidMessage = errors.New("invalid message")
+
+// magic starts all framed messages. Payloads encoded with any of the codecs
+// can not start with four zero bytes, which keeps unframed messages readable.
+var magic = []byte("\x00\x00\x00\x00EH1")
+
+// Encode frames the data with the headers. Data without headers is returned
+// as is.
+func Encode(headers map[string]string, data []byte) []byte {
+	if len(headers) == 0 {
+		return data
+	}
+
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(data)+64))
+	buf.Write(magic)
+	writeUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		writeString(buf, k)
+		writeString(buf, headers[k])
+	}
+	buf.Write(data)
+
+	return buf.Bytes()
+}
+
+// Decode returns the headers and data of a message. Messages that are not
+// framed are returned as is, without headers.
+func Decode(msg []byte) (map[string]string, []byte, error) {
+	if !bytes.HasPrefix(msg, magic) {
+		return nil, msg, nil
+	}
+
+	r := bytes.NewReader(msg[len(magic):])
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return nil, nil, ErrInvalidMessage
+	}
+
+	headers := make(map[string]string, n)
+	for i := uint64(0); i < n; i++ {
+		k, err := readString(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		v, err := readString(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		headers[k] = v
+	}
+
+	return headers, msg[len(msg)-r.Len():], nil
+}
+
+// contextHeaderPrefix prefixes the headers with the marshaled values of a
+// context.
+const contextHeaderPrefix = "context:"
+
+// ContextHeaders adds the values of the context, marshaled with the registered
+// eventhorizon.ContextMarshalFunc, to the headers. The headers are created if
+// nil.
+func ContextHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	for k, v := range eh.MarshalContext(ctx) {
+		headers[contextHeaderPrefix+k] = v
+	}
+	return headers
+}
+
+// Context returns a new context with the values of the context headers.
+func Context(headers map[string]string) context.Context {
+	values := map[string]string{}
+	for k, v := range headers {
+		if strings.HasPrefix(k, contextHeaderPrefix) {
+			values[strings.TrimPrefix(k, contextHeaderPrefix)] = v
+		}
+	}
+	return eh.UnmarshalContext(values)
+}
+
+// sentAtHeader is the header with the time a message was sent, in nanoseconds
+// since the Unix epoch.
+const sentAtHeader = "sent_at"
+
+// SetSentAt sets the time the message is sent in the headers.
+func SetSentAt(headers map[string]string, t time.Time) {
+	headers[sentAtHeader] = strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// SentAt returns the time the message was sent, if set in the headers.
+func SentAt(headers map[string]string) (time.Time, bool) {
+	v, ok := headers[sentAtHeader]
+	if !ok {
+		return time.Time{}, false
+	}
+	ns, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", ErrInvalidMessage
+	}
+	b := make([]byte, n)
+	if _, err := r.Read(b); err != nil {
+		return "", ErrInvalidMessage
+	}
+	return string(b), nil
+}
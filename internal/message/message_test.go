@@ -0,0 +1,75 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package message
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestMessage(t *testing.T) {
+	data := []byte(`{"content":"event1"}`)
+
+	t.Log("encode without headers")
+	msg := Encode(nil, data)
+	if !bytes.Equal(msg, data) {
+		t.Error("the message should not be framed:", msg)
+	}
+
+	t.Log("decode unframed message")
+	headers, decoded, err := Decode(data)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if headers != nil || !bytes.Equal(decoded, data) {
+		t.Error("the message should be returned as is:", headers, decoded)
+	}
+
+	t.Log("encode and decode with headers")
+	h := map[string]string{"compression": "gzip", "key": ""}
+	msg = Encode(h, data)
+	headers, decoded, err = Decode(msg)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(headers, h) {
+		t.Error("the headers should be correct:", headers)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Error("the data should be correct:", decoded)
+	}
+
+	t.Log("decode truncated message")
+	if _, _, err := Decode(msg[:len(magic)+3]); err != ErrInvalidMessage {
+		t.Error("there should be a ErrInvalidMessage error:", err)
+	}
+}
+
+func TestContextHeaders(t *testing.T) {
+	ctx := eh.WithTenantID(context.Background(), "a")
+	headers := ContextHeaders(ctx, map[string]string{"compression": "gzip"})
+	if headers["compression"] != "gzip" || len(headers) != 2 {
+		t.Error("the headers should be correct:", headers)
+	}
+
+	ctx = Context(headers)
+	if tenantID, _ := eh.TenantIDFromContext(ctx); tenantID != "a" {
+		t.Error("the context values should be correct:", tenantID)
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrClosed is when using a bus, store or read repository after it is closed.
+var ErrClosed = errors.New("closed")
+
+// Lifecycle is implemented by buses, stores and read repositories that must be
+// started before they are used and closed when the application shuts down.
+// The app package can start and close them in order.
+type Lifecycle interface {
+	// Start starts the component, for example by connecting to a server or by
+	// receiving messages. It returns an error if the component could not be
+	// started before the context is done.
+	Start(context.Context) error
+
+	// Close stops accepting new work, waits for the work in progress until
+	// the context is done and releases the resources of the component. The
+	// error of the context is returned if the work was not done in time.
+	Close(context.Context) error
+}
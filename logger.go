@@ -0,0 +1,265 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	// DebugLevel is for verbose entries useful when debugging, such as the
+	// routing of every message.
+	DebugLevel Level = iota
+	// InfoLevel is for normal operation, such as connecting to a server.
+	InfoLevel
+	// WarnLevel is for errors that are recovered from, such as reconnecting.
+	WarnLevel
+	// ErrorLevel is for errors that make an event or command get lost.
+	ErrorLevel
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "debug"
+	case InfoLevel:
+		return "info"
+	case WarnLevel:
+		return "warn"
+	case ErrorLevel:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// Field is a key value pair added to a structured log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F creates a new Field.
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger is an interface for structured, leveled logging. Buses, stores, sagas
+// and projectors that log accept a logger with SetLogger, and use a StdLogger
+// at InfoLevel by default.
+//
+// There are adapters for logrus and zap in the logger package.
+type Logger interface {
+	// Debug logs a message at DebugLevel.
+	Debug(msg string, fields ...Field)
+	// Info logs a message at InfoLevel.
+	Info(msg string, fields ...Field)
+	// Warn logs a message at WarnLevel.
+	Warn(msg string, fields ...Field)
+	// Error logs a message at ErrorLevel.
+	Error(msg string, fields ...Field)
+
+	// With returns a logger that adds the fields to all entries.
+	With(fields ...Field) Logger
+}
+
+// StdLogger is a Logger that writes entries with a minimum level to a logger
+// of the standard log package, as the message followed by key=value pairs.
+type StdLogger struct {
+	logger *log.Logger
+	level  Level
+	fields []Field
+}
+
+// NewStdLogger creates a new StdLogger. The standard logger of the log package
+// is used if the logger is nil.
+func NewStdLogger(logger *log.Logger, level Level) *StdLogger {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &StdLogger{
+		logger: logger,
+		level:  level,
+	}
+}
+
+// Debug implements the Debug method of the Logger interface.
+func (l *StdLogger) Debug(msg string, fields ...Field) {
+	l.log(DebugLevel, msg, fields)
+}
+
+// Info implements the Info method of the Logger interface.
+func (l *StdLogger) Info(msg string, fields ...Field) {
+	l.log(InfoLevel, msg, fields)
+}
+
+// Warn implements the Warn method of the Logger interface.
+func (l *StdLogger) Warn(msg string, fields ...Field) {
+	l.log(WarnLevel, msg, fields)
+}
+
+// Error implements the Error method of the Logger interface.
+func (l *StdLogger) Error(msg string, fields ...Field) {
+	l.log(ErrorLevel, msg, fields)
+}
+
+// With implements the With method of the Logger interface.
+func (l *StdLogger) With(fields ...Field) Logger {
+	return &StdLogger{
+		logger: l.logger,
+		level:  l.level,
+		fields: append(append([]Field{}, l.fields...), fields...),
+	}
+}
+
+func (l *StdLogger) log(level Level, msg string, fields []Field) {
+	if level < l.level {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteString(": ")
+	b.WriteString(msg)
+	for _, f := range l.fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	l.logger.Output(3, b.String())
+}
+
+// defaultLogger is used by components that have no logger set.
+var defaultLogger Logger = NewStdLogger(nil, InfoLevel)
+
+// DefaultLogger returns the logger used by buses, stores, sagas and projectors
+// until a logger is set with SetLogger on them.
+func DefaultLogger() Logger {
+	return defaultLogger
+}
+
+// NopLogger is a Logger that discards all entries.
+type NopLogger struct{}
+
+// Debug implements the Debug method of the Logger interface.
+func (NopLogger) Debug(msg string, fields ...Field) {}
+
+// Info implements the Info method of the Logger interface.
+func (NopLogger) Info(msg string, fields ...Field) {}
+
+// Warn implements the Warn method of the Logger interface.
+func (NopLogger) Warn(msg string, fields ...Field) {}
+
+// Error implements the Error method of the Logger interface.
+func (NopLogger) Error(msg string, fields ...Field) {}
+
+// With implements the With method of the Logger interface.
+func (l NopLogger) With(fields ...Field) Logger { return l }
+
+// RedactFunc redacts a payload before it is logged.
+type RedactFunc func(payload []byte) []byte
+
+// payloadLogger is a Logger that has payload logging enabled.
+type payloadLogger struct {
+	Logger
+	redact RedactFunc
+}
+
+// With implements the With method of the Logger interface.
+func (l *payloadLogger) With(fields ...Field) Logger {
+	return &payloadLogger{l.Logger.With(fields...), l.redact}
+}
+
+// WithPayloads returns a logger that also logs the payloads of the events and
+// commands sent and received by buses, at DebugLevel. Payloads are not logged
+// by default since they can contain personal data; a redact func, such as
+// RedactJSON, should be used to remove it. Payloads are logged as is if the
+// redact func is nil.
+func WithPayloads(logger Logger, redact RedactFunc) Logger {
+	return &payloadLogger{logger, redact}
+}
+
+// PayloadsEnabled returns true if payload logging has been enabled for the
+// logger with WithPayloads. It can be used to only encode payloads that are
+// logged.
+func PayloadsEnabled(logger Logger) bool {
+	_, ok := logger.(*payloadLogger)
+	return ok
+}
+
+// LogPayload logs a payload with the message at DebugLevel, if payload
+// logging has been enabled for the logger with WithPayloads.
+func LogPayload(logger Logger, msg string, payload []byte, fields ...Field) {
+	l, ok := logger.(*payloadLogger)
+	if !ok {
+		return
+	}
+	if l.redact != nil {
+		payload = l.redact(payload)
+	}
+	l.Debug(msg, append(fields, F("payload", string(payload)))...)
+}
+
+// Redacted is the value of redacted fields.
+const Redacted = "[REDACTED]"
+
+// RedactJSON returns a RedactFunc that replaces the values of the keys in JSON
+// payloads with Redacted, in objects at any depth. Payloads that are not JSON
+// are replaced completely, since it is not known what they contain.
+func RedactJSON(keys ...string) RedactFunc {
+	redacted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		redacted[k] = true
+	}
+
+	var redact func(v interface{}) interface{}
+	redact = func(v interface{}) interface{} {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for k, val := range v {
+				if redacted[k] {
+					v[k] = Redacted
+				} else {
+					v[k] = redact(val)
+				}
+			}
+		case []interface{}:
+			for i, val := range v {
+				v[i] = redact(val)
+			}
+		}
+		return v
+	}
+
+	return func(payload []byte) []byte {
+		var v interface{}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return []byte(Redacted)
+		}
+		data, err := json.Marshal(redact(v))
+		if err != nil {
+			return []byte(Redacted)
+		}
+		return data
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logrus is an adapter for using a logrus logger as an
+// eventhorizon.Logger.
+package logrus
+
+import (
+	"github.com/sirupsen/logrus"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Logger is an eventhorizon.Logger that logs with logrus.
+type Logger struct {
+	logger logrus.FieldLogger
+}
+
+// NewLogger creates a new Logger, which logs with a logrus.Logger or
+// logrus.Entry.
+func NewLogger(logger logrus.FieldLogger) *Logger {
+	return &Logger{
+		logger: logger,
+	}
+}
+
+// Debug implements the Debug method of the eventhorizon.Logger interface.
+func (l *Logger) Debug(msg string, fields ...eh.Field) {
+	l.entry(fields).Debug(msg)
+}
+
+// Info implements the Info method of the eventhorizon.Logger interface.
+func (l *Logger) Info(msg string, fields ...eh.Field) {
+	l.entry(fields).Info(msg)
+}
+
+// Warn implements the Warn method of the eventhorizon.Logger interface.
+func (l *Logger) Warn(msg string, fields ...eh.Field) {
+	l.entry(fields).Warn(msg)
+}
+
+// Error implements the Error method of the eventhorizon.Logger interface.
+func (l *Logger) Error(msg string, fields ...eh.Field) {
+	l.entry(fields).Error(msg)
+}
+
+// With implements the With method of the eventhorizon.Logger interface.
+func (l *Logger) With(fields ...eh.Field) eh.Logger {
+	return &Logger{
+		logger: l.entry(fields),
+	}
+}
+
+func (l *Logger) entry(fields []eh.Field) logrus.FieldLogger {
+	if len(fields) == 0 {
+		return l.logger
+	}
+
+	f := make(logrus.Fields, len(fields))
+	for _, field := range fields {
+		f[field.Key] = field.Value
+	}
+	return l.logger.WithFields(f)
+}
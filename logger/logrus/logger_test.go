@@ -0,0 +1,52 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logrus
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestLogger(t *testing.T) {
+	l, hook := test.NewNullLogger()
+	l.SetLevel(logrus.InfoLevel)
+	var logger eh.Logger = NewLogger(l)
+
+	logger.Debug("debug")
+	if len(hook.Entries) != 0 {
+		t.Error("there should be no debug entries:", hook.Entries)
+	}
+
+	err := errors.New("error")
+	logger.With(eh.F("bus", "redis")).Error("failed", eh.F("error", err))
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("there should be an entry")
+	}
+	if entry.Level != logrus.ErrorLevel {
+		t.Error("the level should be error:", entry.Level)
+	}
+	if entry.Message != "failed" {
+		t.Error("the message should be correct:", entry.Message)
+	}
+	if entry.Data["bus"] != "redis" || entry.Data["error"] != err {
+		t.Error("the fields should be correct:", entry.Data)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zap is an adapter for using a zap logger as an eventhorizon.Logger.
+package zap
+
+import (
+	"go.uber.org/zap"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Logger is an eventhorizon.Logger that logs with zap.
+type Logger struct {
+	logger *zap.Logger
+}
+
+// NewLogger creates a new Logger.
+func NewLogger(logger *zap.Logger) *Logger {
+	return &Logger{
+		logger: logger,
+	}
+}
+
+// Debug implements the Debug method of the eventhorizon.Logger interface.
+func (l *Logger) Debug(msg string, fields ...eh.Field) {
+	l.logger.Debug(msg, zapFields(fields)...)
+}
+
+// Info implements the Info method of the eventhorizon.Logger interface.
+func (l *Logger) Info(msg string, fields ...eh.Field) {
+	l.logger.Info(msg, zapFields(fields)...)
+}
+
+// Warn implements the Warn method of the eventhorizon.Logger interface.
+func (l *Logger) Warn(msg string, fields ...eh.Field) {
+	l.logger.Warn(msg, zapFields(fields)...)
+}
+
+// Error implements the Error method of the eventhorizon.Logger interface.
+func (l *Logger) Error(msg string, fields ...eh.Field) {
+	l.logger.Error(msg, zapFields(fields)...)
+}
+
+// With implements the With method of the eventhorizon.Logger interface.
+func (l *Logger) With(fields ...eh.Field) eh.Logger {
+	return &Logger{
+		logger: l.logger.With(zapFields(fields)...),
+	}
+}
+
+func zapFields(fields []eh.Field) []zap.Field {
+	f := make([]zap.Field, len(fields))
+	for i, field := range fields {
+		if err, ok := field.Value.(error); ok {
+			f[i] = zap.NamedError(field.Key, err)
+			continue
+		}
+		f[i] = zap.Any(field.Key, field.Value)
+	}
+	return f
+}
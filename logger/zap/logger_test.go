@@ -0,0 +1,52 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zap
+
+import (
+	"errors"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+func TestLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	var logger eh.Logger = NewLogger(zap.New(core))
+
+	logger.Debug("debug")
+	if logs.Len() != 0 {
+		t.Error("there should be no debug entries:", logs.All())
+	}
+
+	logger.With(eh.F("bus", "redis")).Error("failed", eh.F("error", errors.New("error")))
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatal("there should be one entry:", entries)
+	}
+	if entries[0].Level != zapcore.ErrorLevel {
+		t.Error("the level should be error:", entries[0].Level)
+	}
+	if entries[0].Message != "failed" {
+		t.Error("the message should be correct:", entries[0].Message)
+	}
+	fields := entries[0].ContextMap()
+	if fields["bus"] != "redis" || fields["error"] != "error" {
+		t.Error("the fields should be correct:", fields)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), InfoLevel)
+
+	logger.Debug("debug")
+	if buf.Len() != 0 {
+		t.Error("there should be no debug entries:", buf.String())
+	}
+
+	logger.With(F("bus", "redis")).Warn("reconnecting", F("delay", "1s"))
+	if buf.String() != "warn: reconnecting bus=redis delay=1s\n" {
+		t.Error("the entry should be correct:", buf.String())
+	}
+}
+
+func TestLogPayload(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewStdLogger(log.New(&buf, "", 0), DebugLevel)
+
+	if PayloadsEnabled(logger) {
+		t.Error("payloads should not be enabled by default")
+	}
+	LogPayload(logger, "received", []byte(`{"name":"a"}`))
+	if buf.Len() != 0 {
+		t.Error("payloads should not be logged by default:", buf.String())
+	}
+
+	if !PayloadsEnabled(WithPayloads(logger, nil).With(F("bus", "redis"))) {
+		t.Error("payloads should be enabled")
+	}
+
+	LogPayload(WithPayloads(logger, RedactJSON("name")).With(F("bus", "redis")),
+		"received", []byte(`{"name":"a","id":1}`))
+	if buf.String() != `debug: received bus=redis payload={"id":1,"name":"[REDACTED]"}`+"\n" {
+		t.Error("the payload should be redacted:", buf.String())
+	}
+}
+
+func TestRedactJSON(t *testing.T) {
+	redact := RedactJSON("email", "ssn")
+	testCases := map[string]struct {
+		payload  string
+		expected string
+	}{
+		"no keys": {
+			`{"id":1}`,
+			`{"id":1}`,
+		},
+		"nested": {
+			`{"user":{"email":"a@b.c","name":"a"},"list":[{"ssn":"123"}]}`,
+			`{"list":[{"ssn":"[REDACTED]"}],"user":{"email":"[REDACTED]","name":"a"}}`,
+		},
+		"not json": {
+			"\x01\x02",
+			Redacted,
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if out := string(redact([]byte(tc.payload))); out != tc.expected {
+				t.Error("the payload should be correct:", out)
+			}
+		})
+	}
+}
+
+func TestProjectorBaseLogger(t *testing.T) {
+	var buf bytes.Buffer
+	repo := &MockVersionedReadRepository{models: map[UUID]*TestProjectedModel{}}
+	projector := &TestProjector{}
+	projector.ProjectorBase = NewProjectorBase(repo, projector)
+	projector.SetLogger(NewStdLogger(log.New(&buf, "", 0), InfoLevel))
+
+	// A gap in the event versions is rejected and logged.
+	projector.HandleEventContext(WithEventVersion(context.Background(), 2), &TestEvent{NewUUID(), "event"})
+	if !strings.HasPrefix(buf.String(), "error: could not project event projector_type=TestProjector") {
+		t.Error("the error should be logged:", buf.String())
+	}
+}
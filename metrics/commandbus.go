@@ -0,0 +1,60 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// CommandBus is a command bus that records the number, duration and errors of
+// the handled commands by command and aggregate type.
+type CommandBus struct {
+	bus       eh.CommandBus
+	handler   eh.ContextCommandHandler
+	collector *Collector
+}
+
+// NewCommandBus creates a new CommandBus.
+func NewCommandBus(bus eh.CommandBus, collector *Collector) *CommandBus {
+	return &CommandBus{
+		bus:       bus,
+		handler:   eh.AdaptCommandHandler(bus),
+		collector: collector,
+	}
+}
+
+// HandleCommand implements the HandleCommand method of the
+// eventhorizon.CommandBus interface.
+func (b *CommandBus) HandleCommand(command eh.Command) error {
+	return b.HandleCommandContext(context.Background(), command)
+}
+
+// HandleCommandContext implements the HandleCommandContext method of the
+// eventhorizon.ContextCommandBus interface.
+func (b *CommandBus) HandleCommandContext(ctx context.Context, command eh.Command) error {
+	start := time.Now()
+	err := b.handler.HandleCommandContext(ctx, command)
+	b.collector.observeCommand(command, start, err)
+	return err
+}
+
+// SetHandler implements the SetHandler method of the eventhorizon.CommandBus
+// interface.
+func (b *CommandBus) SetHandler(handler eh.CommandHandler, commandType eh.CommandType) error {
+	return b.bus.SetHandler(handler, commandType)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// observerType is the handler type label of events notified to observers.
+const observerType = "observer"
+
+// EventBus is an event bus that records the number, duration and errors of
+// the published events by event and aggregate type, and the number and
+// duration of the handling of them by handler type. Observers are recorded
+// with the handler type "observer".
+type EventBus struct {
+	eventBus  eh.ContextEventBus
+	collector *Collector
+}
+
+// NewEventBus creates a new EventBus.
+func NewEventBus(eventBus eh.EventBus, collector *Collector) *EventBus {
+	return &EventBus{
+		eventBus:  eh.AdaptEventBus(eventBus),
+		collector: collector,
+	}
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventBus interface. The bus is shared by all tenants
+// if the wrapped bus is not multi tenant.
+func (b *EventBus) ForTenant(tenantID eh.TenantID) (eh.EventBus, error) {
+	m, ok := b.eventBus.(eh.MultiTenantEventBus)
+	if !ok {
+		return b, nil
+	}
+
+	eventBus, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEventBus(eventBus, b.collector), nil
+}
+
+// PublishEvent implements the PublishEvent method of the
+// eventhorizon.EventBus interface.
+func (b *EventBus) PublishEvent(event eh.Event) {
+	b.PublishEventContext(context.Background(), event)
+}
+
+// PublishEventContext implements the PublishEventContext method of the
+// eventhorizon.ContextEventBus interface.
+func (b *EventBus) PublishEventContext(ctx context.Context, event eh.Event) error {
+	start := time.Now()
+	err := b.eventBus.PublishEventContext(ctx, event)
+	b.collector.observePublish(event, start, err)
+	return err
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus
+// interface.
+func (b *EventBus) AddHandler(handler eh.EventHandler, eventType eh.EventType) {
+	b.eventBus.AddHandler(&eventHandler{eh.AdaptEventHandler(handler), b.collector}, eventType)
+}
+
+// AddObserver implements the AddObserver method of the eventhorizon.EventBus
+// interface.
+func (b *EventBus) AddObserver(observer eh.EventObserver) {
+	b.eventBus.AddObserver(&eventObserver{eh.AdaptEventObserver(observer), b.collector})
+}
+
+// SetHandlingStrategy implements the SetHandlingStrategy method of the
+// eventhorizon.EventBus interface.
+func (b *EventBus) SetHandlingStrategy(strategy eh.EventHandlingStrategy) {
+	b.eventBus.SetHandlingStrategy(strategy)
+}
+
+// eventHandler records the handling of events.
+type eventHandler struct {
+	eh.ContextEventHandler
+	collector *Collector
+}
+
+// HandleEvent implements the HandleEvent method of the
+// eventhorizon.EventHandler interface.
+func (h *eventHandler) HandleEvent(event eh.Event) {
+	h.HandleEventContext(context.Background(), event)
+}
+
+// HandleEventContext implements the HandleEventContext method of the
+// eventhorizon.ContextEventHandler interface.
+func (h *eventHandler) HandleEventContext(ctx context.Context, event eh.Event) {
+	start := time.Now()
+	h.ContextEventHandler.HandleEventContext(ctx, event)
+	h.collector.observeHandle(event, string(h.HandlerType()), start)
+}
+
+// eventObserver records the notifications of events.
+type eventObserver struct {
+	eh.ContextEventObserver
+	collector *Collector
+}
+
+// Notify implements the Notify method of the eventhorizon.EventObserver
+// interface.
+func (o *eventObserver) Notify(event eh.Event) {
+	o.NotifyContext(context.Background(), event)
+}
+
+// NotifyContext implements the NotifyContext method of the
+// eventhorizon.ContextEventObserver interface.
+func (o *eventObserver) NotifyContext(ctx context.Context, event eh.Event) {
+	start := time.Now()
+	o.ContextEventObserver.NotifyContext(ctx, event)
+	o.collector.observeHandle(event, observerType, start)
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// EventStore is an event store that records the number, duration and errors
+// of the saves and loads by aggregate type.
+type EventStore struct {
+	eventStore eh.ContextEventStore
+	collector  *Collector
+}
+
+// NewEventStore creates a new EventStore.
+func NewEventStore(eventStore eh.EventStore, collector *Collector) *EventStore {
+	return &EventStore{
+		eventStore: eh.AdaptEventStore(eventStore),
+		collector:  collector,
+	}
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventStore interface. The store is shared by all
+// tenants if the wrapped store is not multi tenant.
+func (s *EventStore) ForTenant(tenantID eh.TenantID) (eh.EventStore, error) {
+	m, ok := s.eventStore.(eh.MultiTenantEventStore)
+	if !ok {
+		return s, nil
+	}
+
+	eventStore, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEventStore(eventStore, s.collector), nil
+}
+
+// Save implements the Save method of the eventhorizon.EventStore interface.
+func (s *EventStore) Save(events []eh.Event, originalVersion int) error {
+	return s.SaveContext(context.Background(), events, originalVersion)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) SaveContext(ctx context.Context, events []eh.Event, originalVersion int) error {
+	start := time.Now()
+	err := s.eventStore.SaveContext(ctx, events, originalVersion)
+	var aggregateType eh.AggregateType
+	if len(events) > 0 {
+		aggregateType = events[0].AggregateType()
+	}
+	s.collector.observeStore("save", aggregateType, start, err)
+	return err
+}
+
+// Load implements the Load method of the eventhorizon.EventStore interface.
+func (s *EventStore) Load(aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	return s.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextEventStore interface.
+func (s *EventStore) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) ([]eh.EventRecord, error) {
+	start := time.Now()
+	eventRecords, err := s.eventStore.LoadContext(ctx, aggregateType, id)
+	s.collector.observeStore("load", aggregateType, start, err)
+	return eventRecords, err
+}
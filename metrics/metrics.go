@@ -0,0 +1,207 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics records the throughput, latency and errors of commands,
+// events, event stores and read repositories, and the lag and reconnects of
+// remote buses, with a Prometheus collector.
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+const namespace = "eventhorizon"
+
+// Collector is a prometheus.Collector with all metrics recorded by the
+// decorators of this package. Register it with a prometheus.Registerer to
+// expose the metrics, one collector can be shared by all decorators.
+type Collector struct {
+	commands        *prometheus.CounterVec
+	commandErrors   *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+
+	eventsPublished *prometheus.CounterVec
+	publishErrors   *prometheus.CounterVec
+	publishDuration *prometheus.HistogramVec
+	eventsHandled   *prometheus.CounterVec
+	handleDuration  *prometheus.HistogramVec
+
+	storeOperations *prometheus.CounterVec
+	storeErrors     *prometheus.CounterVec
+	storeDuration   *prometheus.HistogramVec
+
+	repoOperations *prometheus.CounterVec
+	repoErrors     *prometheus.CounterVec
+	repoDuration   *prometheus.HistogramVec
+
+	busLag        *prometheus.HistogramVec
+	busReconnects *prometheus.CounterVec
+}
+
+// NewCollector creates a new Collector.
+func NewCollector() *Collector {
+	counter := func(subsystem, name, help string, labels ...string) *prometheus.CounterVec {
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		}, labels)
+	}
+	histogram := func(subsystem, name, help string, labels ...string) *prometheus.HistogramVec {
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+			Buckets:   prometheus.DefBuckets,
+		}, labels)
+	}
+
+	return &Collector{
+		commands: counter("commands", "handled_total",
+			"Number of handled commands.", "command_type", "aggregate_type"),
+		commandErrors: counter("commands", "errors_total",
+			"Number of commands that failed.", "command_type", "aggregate_type"),
+		commandDuration: histogram("commands", "duration_seconds",
+			"Time to handle commands.", "command_type", "aggregate_type"),
+
+		eventsPublished: counter("events", "published_total",
+			"Number of published events.", "event_type", "aggregate_type"),
+		publishErrors: counter("events", "publish_errors_total",
+			"Number of events that failed to be published.", "event_type", "aggregate_type"),
+		publishDuration: histogram("events", "publish_duration_seconds",
+			"Time to publish events.", "event_type", "aggregate_type"),
+		eventsHandled: counter("events", "handled_total",
+			"Number of events handled by handlers and observers.", "event_type", "handler_type"),
+		handleDuration: histogram("events", "handle_duration_seconds",
+			"Time to handle events by handlers and observers.", "event_type", "handler_type"),
+
+		storeOperations: counter("eventstore", "operations_total",
+			"Number of event store operations.", "operation", "aggregate_type"),
+		storeErrors: counter("eventstore", "errors_total",
+			"Number of event store operations that failed.", "operation", "aggregate_type"),
+		storeDuration: histogram("eventstore", "duration_seconds",
+			"Time of event store operations.", "operation", "aggregate_type"),
+
+		repoOperations: counter("readrepository", "operations_total",
+			"Number of read repository operations.", "repository", "operation"),
+		repoErrors: counter("readrepository", "errors_total",
+			"Number of read repository operations that failed.", "repository", "operation"),
+		repoDuration: histogram("readrepository", "duration_seconds",
+			"Time of read repository operations.", "repository", "operation"),
+
+		busLag: histogram("bus", "lag_seconds",
+			"Time from sending to receiving events and commands on remote buses.", "bus", "type"),
+		busReconnects: counter("bus", "reconnects_total",
+			"Number of reconnects of remote buses.", "bus"),
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.commands, c.commandErrors, c.commandDuration,
+		c.eventsPublished, c.publishErrors, c.publishDuration,
+		c.eventsHandled, c.handleDuration,
+		c.storeOperations, c.storeErrors, c.storeDuration,
+		c.repoOperations, c.repoErrors, c.repoDuration,
+		c.busLag, c.busReconnects,
+	}
+}
+
+// Describe implements the Describe method of the prometheus.Collector
+// interface.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements the Collect method of the prometheus.Collector
+// interface.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// BusMonitor returns a monitor that records the lag and reconnects of a remote
+// bus, to be set with the SetMonitor method of the Redis and MQTT buses. The
+// name is used as the bus label of the metrics.
+func (c *Collector) BusMonitor(bus string) eh.BusMonitor {
+	return &busMonitor{c, bus}
+}
+
+type busMonitor struct {
+	c   *Collector
+	bus string
+}
+
+// Received implements the Received method of the eventhorizon.BusMonitor
+// interface.
+func (m *busMonitor) Received(messageType string, lag time.Duration) {
+	m.c.busLag.WithLabelValues(m.bus, messageType).Observe(lag.Seconds())
+}
+
+// Reconnected implements the Reconnected method of the
+// eventhorizon.BusMonitor interface.
+func (m *busMonitor) Reconnected() {
+	m.c.busReconnects.WithLabelValues(m.bus).Inc()
+}
+
+func (c *Collector) observeCommand(command eh.Command, start time.Time, err error) {
+	labels := []string{string(command.CommandType()), string(command.AggregateType())}
+	c.commands.WithLabelValues(labels...).Inc()
+	if err != nil {
+		c.commandErrors.WithLabelValues(labels...).Inc()
+	}
+	c.commandDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+func (c *Collector) observePublish(event eh.Event, start time.Time, err error) {
+	labels := []string{string(event.EventType()), string(event.AggregateType())}
+	c.eventsPublished.WithLabelValues(labels...).Inc()
+	if err != nil {
+		c.publishErrors.WithLabelValues(labels...).Inc()
+	}
+	c.publishDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+func (c *Collector) observeHandle(event eh.Event, handlerType string, start time.Time) {
+	labels := []string{string(event.EventType()), handlerType}
+	c.eventsHandled.WithLabelValues(labels...).Inc()
+	c.handleDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+func (c *Collector) observeStore(operation string, aggregateType eh.AggregateType, start time.Time, err error) {
+	labels := []string{operation, string(aggregateType)}
+	c.storeOperations.WithLabelValues(labels...).Inc()
+	if err != nil {
+		c.storeErrors.WithLabelValues(labels...).Inc()
+	}
+	c.storeDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+func (c *Collector) observeRepo(repository, operation string, start time.Time, err error) {
+	labels := []string{repository, operation}
+	c.repoOperations.WithLabelValues(labels...).Inc()
+	if err != nil {
+		c.repoErrors.WithLabelValues(labels...).Inc()
+	}
+	c.repoDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
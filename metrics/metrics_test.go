@@ -0,0 +1,170 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/commandbus/local"
+	localbus "github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/memory"
+)
+
+func TestCollector(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	m := c.BusMonitor("redis")
+	m.Received(string(mocks.EventType), 2*time.Second)
+	m.Reconnected()
+	if n := testutil.ToFloat64(c.busReconnects.WithLabelValues("redis")); n != 1 {
+		t.Error("there should be 1 reconnect:", n)
+	}
+	if n := testutil.CollectAndCount(c.busLag); n != 1 {
+		t.Error("there should be 1 lag metric:", n)
+	}
+	if _, err := registry.Gather(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
+
+func TestCommandBus(t *testing.T) {
+	c := NewCollector()
+	bus := NewCommandBus(local.NewCommandBus(), c)
+	handler := &mocks.CommandHandler{}
+	if err := bus.SetHandler(handler, mocks.CommandType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := eh.NewUUID()
+	if err := bus.HandleCommand(mocks.Command{ID: id}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if handler.Command == nil {
+		t.Error("the command should have been handled")
+	}
+	if err := bus.HandleCommand(mocks.CommandOther{ID: id}); err != eh.ErrHandlerNotFound {
+		t.Error("there should be a handler not found error:", err)
+	}
+
+	labels := []string{string(mocks.CommandType), string(mocks.AggregateType)}
+	if n := testutil.ToFloat64(c.commands.WithLabelValues(labels...)); n != 1 {
+		t.Error("there should be 1 handled command:", n)
+	}
+	if n := testutil.ToFloat64(c.commandErrors.WithLabelValues(labels...)); n != 0 {
+		t.Error("there should be no failed commands:", n)
+	}
+	otherLabels := []string{string(mocks.CommandOtherType), string(mocks.AggregateType)}
+	if n := testutil.ToFloat64(c.commandErrors.WithLabelValues(otherLabels...)); n != 1 {
+		t.Error("there should be 1 failed command:", n)
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	c := NewCollector()
+	bus := NewEventBus(localbus.NewEventBus(), c)
+	handler := mocks.NewEventHandler("testHandler")
+	observer := mocks.NewEventObserver()
+	bus.AddHandler(handler, mocks.EventType)
+	bus.AddObserver(observer)
+
+	bus.PublishEvent(mocks.Event{ID: eh.NewUUID()})
+	if len(handler.Events) != 1 || len(observer.Events) != 1 {
+		t.Fatal("the event should have been handled and observed")
+	}
+
+	if n := testutil.ToFloat64(c.eventsPublished.WithLabelValues(
+		string(mocks.EventType), string(mocks.AggregateType))); n != 1 {
+		t.Error("there should be 1 published event:", n)
+	}
+	if n := testutil.ToFloat64(c.eventsHandled.WithLabelValues(
+		string(mocks.EventType), "testHandler")); n != 1 {
+		t.Error("there should be 1 handled event:", n)
+	}
+	if n := testutil.ToFloat64(c.eventsHandled.WithLabelValues(
+		string(mocks.EventType), observerType)); n != 1 {
+		t.Error("there should be 1 observed event:", n)
+	}
+}
+
+func TestEventStore(t *testing.T) {
+	c := NewCollector()
+	inner := &mocks.EventStore{}
+	store := NewEventStore(inner, c)
+
+	id := eh.NewUUID()
+	if err := store.Save([]eh.Event{mocks.Event{ID: id}}, 0); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := store.Load(mocks.AggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	inner.Err = errors.New("error")
+	if _, err := store.Load(mocks.AggregateType, id); err != inner.Err {
+		t.Error("there should be an error:", err)
+	}
+
+	if n := testutil.ToFloat64(c.storeOperations.WithLabelValues(
+		"save", string(mocks.AggregateType))); n != 1 {
+		t.Error("there should be 1 save:", n)
+	}
+	if n := testutil.ToFloat64(c.storeOperations.WithLabelValues(
+		"load", string(mocks.AggregateType))); n != 2 {
+		t.Error("there should be 2 loads:", n)
+	}
+	if n := testutil.ToFloat64(c.storeErrors.WithLabelValues(
+		"load", string(mocks.AggregateType))); n != 1 {
+		t.Error("there should be 1 failed load:", n)
+	}
+}
+
+func TestReadRepository(t *testing.T) {
+	c := NewCollector()
+	repo := NewReadRepository(memory.NewReadRepository(), c, "model")
+
+	id := eh.NewUUID()
+	if err := repo.Save(id, &mocks.Model{ID: id}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.Find(id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.Find(eh.NewUUID()); err != eh.ErrModelNotFound {
+		t.Error("there should be a model not found error:", err)
+	}
+
+	// Hide the versioning of the memory repository.
+	unversioned := NewReadRepository(struct{ eh.ReadRepository }{memory.NewReadRepository()}, c, "other")
+	if err := unversioned.Update(id, nil); err != ErrUnsupported {
+		t.Error("there should be a ErrUnsupported error:", err)
+	}
+
+	if n := testutil.ToFloat64(c.repoOperations.WithLabelValues("model", "find")); n != 2 {
+		t.Error("there should be 2 finds:", n)
+	}
+	if n := testutil.ToFloat64(c.repoErrors.WithLabelValues("model", "find")); n != 0 {
+		t.Error("there should be no failed finds:", n)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrUnsupported is when the wrapped read repository does not support an
+// operation, for example Update on a repository that is not versioned.
+var ErrUnsupported = errors.New("unsupported by read repository")
+
+// ReadRepository is a read repository that records the number, duration and
+// errors of all operations. Finding a model that does not exist is not
+// counted as an error. Query, CompareAndSave and Update are forwarded if the
+// wrapped repository supports them.
+type ReadRepository struct {
+	repo      eh.ReadRepository
+	name      string
+	collector *Collector
+}
+
+// NewReadRepository creates a new ReadRepository. The name is used as the
+// repository label of the metrics, typically the name of the read model.
+func NewReadRepository(repo eh.ReadRepository, collector *Collector, name string) *ReadRepository {
+	return &ReadRepository{
+		repo:      repo,
+		name:      name,
+		collector: collector,
+	}
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. The repository is shared
+// by all tenants if the wrapped repository is not multi tenant.
+func (r *ReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	m, ok := r.repo.(eh.MultiTenantReadRepository)
+	if !ok {
+		return r, nil
+	}
+
+	repo, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReadRepository(repo, r.collector, r.name), nil
+}
+
+// Save implements the Save method of the eventhorizon.ReadRepository interface.
+func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
+	return r.SaveContext(context.Background(), id, model)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	start := time.Now()
+	err := eh.AdaptReadRepository(r.repo).SaveContext(ctx, id, model)
+	r.observe("save", start, err)
+	return err
+}
+
+// Find implements the Find method of the eventhorizon.ReadRepository interface.
+func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
+	start := time.Now()
+	model, err := eh.AdaptReadRepository(r.repo).FindContext(ctx, id)
+	r.observe("find", start, err)
+	return model, err
+}
+
+// FindAll implements the FindAll method of the eventhorizon.ReadRepository
+// interface.
+func (r *ReadRepository) FindAll() ([]interface{}, error) {
+	return r.FindAllContext(context.Background())
+}
+
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	start := time.Now()
+	models, err := eh.AdaptReadRepository(r.repo).FindAllContext(ctx)
+	r.observe("find_all", start, err)
+	return models, err
+}
+
+// Remove implements the Remove method of the eventhorizon.ReadRepository
+// interface.
+func (r *ReadRepository) Remove(id eh.UUID) error {
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
+	start := time.Now()
+	err := eh.AdaptReadRepository(r.repo).RemoveContext(ctx, id)
+	r.observe("remove", start, err)
+	return err
+}
+
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface by forwarding it to the wrapped repository.
+func (r *ReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	repo, ok := r.repo.(eh.QueryableReadRepository)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	start := time.Now()
+	result, err := repo.Query(query)
+	r.observe("query", start, err)
+	return result, err
+}
+
+// CompareAndSave implements the CompareAndSave method of the
+// eventhorizon.VersionedReadRepository interface by forwarding it to the
+// wrapped repository.
+func (r *ReadRepository) CompareAndSave(id eh.UUID, model eh.Versionable) error {
+	repo, ok := r.repo.(eh.VersionedReadRepository)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	err := repo.CompareAndSave(id, model)
+	r.observe("compare_and_save", start, err)
+	return err
+}
+
+// Update implements the Update method of the
+// eventhorizon.VersionedReadRepository interface by forwarding it to the
+// wrapped repository.
+func (r *ReadRepository) Update(id eh.UUID, update func(interface{}) error) error {
+	repo, ok := r.repo.(eh.VersionedReadRepository)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	start := time.Now()
+	err := repo.Update(id, update)
+	r.observe("update", start, err)
+	return err
+}
+
+func (r *ReadRepository) observe(operation string, start time.Time, err error) {
+	if err == eh.ErrModelNotFound {
+		err = nil
+	}
+	r.collector.observeRepo(r.name, operation, start, err)
+}
@@ -15,6 +15,7 @@
 package mocks
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -136,6 +137,19 @@ type Model struct {
 	CreatedAt time.Time `json:"created_at" bson:"created_at"`
 }
 
+// VersionedModel is a mocked read model with a version, useful in testing.
+type VersionedModel struct {
+	ID      eh.UUID `json:"id"      bson:"_id"`
+	Version int     `json:"version" bson:"version"`
+	Content string  `json:"content" bson:"content"`
+}
+
+// ModelVersion implements the ModelVersion method of the eventhorizon.Versionable interface.
+func (m *VersionedModel) ModelVersion() int { return m.Version }
+
+// SetModelVersion implements the SetModelVersion method of the eventhorizon.Versionable interface.
+func (m *VersionedModel) SetModelVersion(v int) { m.Version = v }
+
 // CommandHandler is a mocked eventhorizon.CommandHandler, useful in testing.
 type CommandHandler struct {
 	Command eh.Command
@@ -216,6 +230,27 @@ func (m *EventObserver) WaitForEvent(t *testing.T) {
 	}
 }
 
+// ContextEventObserver is a mocked eventhorizon.ContextEventObserver, useful
+// in testing.
+type ContextEventObserver struct {
+	*EventObserver
+	Contexts []context.Context
+}
+
+// NewContextEventObserver creates a new ContextEventObserver.
+func NewContextEventObserver() *ContextEventObserver {
+	return &ContextEventObserver{
+		EventObserver: NewEventObserver(),
+	}
+}
+
+// NotifyContext implements the NotifyContext method of the
+// eventhorizon.ContextEventObserver interface.
+func (m *ContextEventObserver) NotifyContext(ctx context.Context, event eh.Event) {
+	m.Contexts = append(m.Contexts, ctx)
+	m.Notify(event)
+}
+
 // Repository is a mocked Repository, useful in testing.
 type Repository struct {
 	Aggregates map[eh.UUID]eh.Aggregate
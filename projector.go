@@ -0,0 +1,311 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrEventVersionGap is when a projector gets an event with a higher version
+// than the next version of the read model, which means that events are missing.
+var ErrEventVersionGap = errors.New("gap in event versions")
+
+// ErrGapBufferFull is when a projector can not buffer an event after a gap as
+// its buffer is full.
+var ErrGapBufferFull = errors.New("gap buffer is full")
+
+// ErrModelNotProjectable is when a model does not implement Projectable.
+var ErrModelNotProjectable = errors.New("model is not projectable")
+
+// errDuplicateEvent is used internally to abort updates for duplicate events.
+var errDuplicateEvent = errors.New("duplicate event")
+
+// DefaultGapBufferSize is the default maximum number of events that a
+// projector buffers after gaps, for all aggregates.
+const DefaultGapBufferSize = 1000
+
+// Projector is an interface for a projector of events to read models, with one
+// read model per aggregate. It is used together with ProjectorBase.
+type Projector interface {
+	// ProjectorType returns the type of the projector.
+	ProjectorType() ProjectorType
+
+	// Project applies an event to a read model. The read model is new if its
+	// projected version is zero. The projection may be retried and should
+	// thus only modify the model.
+	Project(event Event, model interface{}) error
+}
+
+// ProjectorType is the type of a projector, used as its unique identifier.
+type ProjectorType string
+
+// Projectable is a read model that keeps the version of the last event of its
+// aggregate that was projected to it. It is stored in its own field, next to
+// the version of the model itself, as the model can also be saved without
+// projecting events.
+type Projectable interface {
+	Versionable
+
+	// ProjectedVersion returns the version of the aggregate after the last
+	// projected event, zero if no event has been projected.
+	ProjectedVersion() int
+
+	// SetProjectedVersion sets the version of the aggregate after the last
+	// projected event.
+	SetProjectedVersion(int)
+}
+
+// GapHandlingStrategy is the strategy to use when a projector gets an event
+// that is not the next event for the read model.
+type GapHandlingStrategy int
+
+const (
+	// RejectGapHandlingStrategy will drop the event with ErrEventVersionGap.
+	RejectGapHandlingStrategy GapHandlingStrategy = iota
+	// BufferGapHandlingStrategy will keep the event in memory and project it
+	// when the missing events have been projected. The buffer is bounded and
+	// not persisted, events that are rejected when it is full or lost on
+	// restart must be delivered again.
+	BufferGapHandlingStrategy
+)
+
+// ProjectorBase is a CQRS projector base to embed in domain specific
+// projectors. It makes projections idempotent by keeping the version of the
+// last projected event in the read model: duplicate events are skipped and
+// gaps are rejected or buffered. The version of the events is taken from
+// their context, as set by the EventSourcingRepository when publishing, and
+// the read models must implement Projectable. Events without a version, such
+// as events handled with HandleEvent, are projected as the next event of the
+// read model without detecting duplicates or gaps.
+//
+// A typical projector example:
+//   type OrderProjector struct {
+//       *eventhorizon.ProjectorBase
+//   }
+//
+// The implementing projector must set itself as the projector in the projector
+// base.
+type ProjectorBase struct {
+	projector  Projector
+	repository VersionedReadRepository
+	strategy   GapHandlingStrategy
+	logger     Logger
+
+	// buffers has the buffer of each aggregate that is being projected or
+	// has buffered events, which also serializes projections per aggregate.
+	buffers    map[UUID]*gapBuffer
+	buffersMu  sync.Mutex
+	buffered   atomic.Int64
+	bufferSize int
+}
+
+// gapBuffer is the buffered events of an aggregate.
+type gapBuffer struct {
+	mu     sync.Mutex
+	events map[int]Event
+
+	// refs is the number of projections using the buffer, guarded by the
+	// buffersMu of the projector.
+	refs int
+}
+
+// NewProjectorBase creates a new ProjectorBase.
+func NewProjectorBase(repository VersionedReadRepository, projector Projector) *ProjectorBase {
+	return &ProjectorBase{
+		projector:  projector,
+		repository: repository,
+		logger:     DefaultLogger(),
+		buffers:    make(map[UUID]*gapBuffer),
+		bufferSize: DefaultGapBufferSize,
+	}
+}
+
+// SetGapHandlingStrategy sets the strategy to use for gaps in event versions,
+// the default is to reject events.
+func (p *ProjectorBase) SetGapHandlingStrategy(strategy GapHandlingStrategy) {
+	p.strategy = strategy
+}
+
+// SetGapBufferSize sets the maximum number of events to buffer after gaps,
+// for all aggregates. The default is DefaultGapBufferSize.
+func (p *ProjectorBase) SetGapBufferSize(size int) {
+	p.bufferSize = size
+}
+
+// SetLogger sets the logger used for events that could not be projected.
+func (p *ProjectorBase) SetLogger(logger Logger) {
+	p.logger = logger
+}
+
+// HandleEvent implements the HandleEvent method of the EventHandler interface.
+// Events handled without a context are not versioned and are projected as the
+// next event of the read model.
+func (p *ProjectorBase) HandleEvent(event Event) {
+	p.HandleEventContext(context.Background(), event)
+}
+
+// HandleEventContext implements the HandleEventContext method of the
+// ContextEventHandler interface.
+func (p *ProjectorBase) HandleEventContext(ctx context.Context, event Event) {
+	if err := p.ProjectEvent(ctx, event); err != nil {
+		// TODO: Better error handling.
+		p.logger.Error("could not project event",
+			F("projector_type", p.projector.ProjectorType()),
+			F("event_type", event.EventType()),
+			F("aggregate_id", event.AggregateID()),
+			F("error", err),
+		)
+	}
+}
+
+// HandlerType implements the HandlerType method of the EventHandler
+// interface.
+func (p *ProjectorBase) HandlerType() EventHandlerType {
+	return EventHandlerType(p.projector.ProjectorType())
+}
+
+// ProjectEvent projects an event to the read model of its aggregate and
+// returns any error, such as ErrEventVersionGap when rejecting gaps.
+// Duplicate events are skipped without an error. The version of the event is
+// taken from the context, see WithEventVersion, an event without a version is
+// projected as the next event of the read model.
+func (p *ProjectorBase) ProjectEvent(ctx context.Context, event Event) error {
+	id := event.AggregateID()
+	b := p.acquireBuffer(id)
+	defer p.releaseBuffer(id, b)
+
+	version, ok := EventVersionFromContext(ctx)
+	if !ok {
+		if err := p.project(event, 0); err != nil {
+			return err
+		}
+		return p.projectBuffered(b)
+	}
+
+	if err := p.project(event, version); err == ErrEventVersionGap && p.strategy == BufferGapHandlingStrategy {
+		if err := p.bufferEvent(b, event, version); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	return p.projectBuffered(b)
+}
+
+// acquireBuffer returns the locked buffer of an aggregate, creating it if
+// needed.
+func (p *ProjectorBase) acquireBuffer(id UUID) *gapBuffer {
+	p.buffersMu.Lock()
+	b, ok := p.buffers[id]
+	if !ok {
+		b = &gapBuffer{events: make(map[int]Event)}
+		p.buffers[id] = b
+	}
+	b.refs++
+	p.buffersMu.Unlock()
+
+	b.mu.Lock()
+	return b
+}
+
+// releaseBuffer unlocks the buffer of an aggregate and removes it if it is
+// empty and not used.
+func (p *ProjectorBase) releaseBuffer(id UUID, b *gapBuffer) {
+	b.mu.Unlock()
+
+	p.buffersMu.Lock()
+	defer p.buffersMu.Unlock()
+
+	b.refs--
+	if b.refs == 0 && len(b.events) == 0 {
+		delete(p.buffers, id)
+	}
+}
+
+// bufferEvent keeps an event until the missing events have been projected.
+func (p *ProjectorBase) bufferEvent(b *gapBuffer, event Event, version int) error {
+	if _, ok := b.events[version]; ok {
+		return nil
+	}
+
+	if p.buffered.Add(1) > int64(p.bufferSize) {
+		p.buffered.Add(-1)
+		return ErrGapBufferFull
+	}
+
+	b.events[version] = event
+	return nil
+}
+
+// project projects a single event if it is the next event for the model. An
+// event with version zero is always projected as the next event.
+func (p *ProjectorBase) project(event Event, version int) error {
+	err := p.repository.Update(event.AggregateID(), func(model interface{}) error {
+		m, ok := model.(Projectable)
+		if !ok {
+			return ErrModelNotProjectable
+		}
+
+		current := m.ProjectedVersion()
+		if version == 0 {
+			version = current + 1
+		}
+		switch {
+		case version <= current:
+			return errDuplicateEvent
+		case version > current+1:
+			return ErrEventVersionGap
+		}
+
+		if err := p.projector.Project(event, model); err != nil {
+			return err
+		}
+		m.SetProjectedVersion(version)
+		return nil
+	})
+	if err == errDuplicateEvent {
+		return nil
+	}
+	return err
+}
+
+// projectBuffered projects the buffered events of an aggregate in order,
+// until there is a gap.
+func (p *ProjectorBase) projectBuffered(b *gapBuffer) error {
+	versions := make([]int, 0, len(b.events))
+	for v := range b.events {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+
+	for _, v := range versions {
+		err := p.project(b.events[v], v)
+		if err == ErrEventVersionGap {
+			break
+		}
+		delete(b.events, v)
+		p.buffered.Add(-1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
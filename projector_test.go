@@ -0,0 +1,254 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestProjectorBase(t *testing.T) {
+	repo := &MockVersionedReadRepository{models: map[UUID]*TestProjectedModel{}}
+	projector := &TestProjector{}
+	projector.ProjectorBase = NewProjectorBase(repo, projector)
+
+	if projector.HandlerType() != EventHandlerType("TestProjector") {
+		t.Error("the handler type should be correct:", projector.HandlerType())
+	}
+
+	id := NewUUID()
+	project := func(version int, content string) error {
+		ctx := WithEventVersion(context.Background(), version)
+		return projector.ProjectEvent(ctx, &TestEvent{id, content})
+	}
+
+	t.Log("project events in order")
+	for i, c := range []string{"a", "b"} {
+		if err := project(i+1, c); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if m := repo.models[id]; m == nil || m.Content != "ab" || m.AggregateVersion != 2 {
+		t.Error("the model should be correct:", m)
+	}
+
+	t.Log("project duplicate event")
+	if err := project(2, "b"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := repo.models[id]; m.Content != "ab" || m.AggregateVersion != 2 {
+		t.Error("the duplicate event should be skipped:", m)
+	}
+
+	t.Log("save the model without projecting")
+	if err := repo.Update(id, func(m interface{}) error { return nil }); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := repo.models[id]; m.Version != 3 || m.AggregateVersion != 2 {
+		t.Error("only the model version should be incremented:", m)
+	}
+
+	t.Log("project event after a gap")
+	if err := project(4, "d"); err != ErrEventVersionGap {
+		t.Error("there should be a ErrEventVersionGap error:", err)
+	}
+	if m := repo.models[id]; m.Content != "ab" || m.AggregateVersion != 2 {
+		t.Error("the event should be rejected:", m)
+	}
+
+	t.Log("buffer events after a gap")
+	projector.SetGapHandlingStrategy(BufferGapHandlingStrategy)
+	if err := project(5, "e"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := project(4, "d"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := repo.models[id]; m.Content != "ab" || m.AggregateVersion != 2 {
+		t.Error("the events should be buffered:", m)
+	}
+	if err := project(3, "c"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := repo.models[id]; m.Content != "abcde" || m.AggregateVersion != 5 {
+		t.Error("the buffered events should be projected:", m)
+	}
+	if len(projector.buffers) != 0 || projector.buffered.Load() != 0 {
+		t.Error("the buffer should be empty:", projector.buffers)
+	}
+
+	t.Log("buffer events when the buffer is full")
+	projector.SetGapBufferSize(1)
+	if err := project(7, "g"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := project(8, "h"); err != ErrGapBufferFull {
+		t.Error("there should be a ErrGapBufferFull error:", err)
+	}
+	if err := project(6, "f"); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := repo.models[id]; m.Content != "abcdefg" || m.AggregateVersion != 7 {
+		t.Error("the buffered event should be projected:", m)
+	}
+
+	t.Log("project event that is not versioned")
+	if err := projector.ProjectEvent(context.Background(), &TestEvent{id, "x"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if m := repo.models[id]; m.Content != "abcdefgx" || m.AggregateVersion != 8 {
+		t.Error("the event should be projected as the next event:", m)
+	}
+
+	t.Log("handle event without context")
+	projector.HandleEvent(&TestEvent{id, "y"})
+	if m := repo.models[id]; m.Content != "abcdefgxy" || m.AggregateVersion != 9 {
+		t.Error("the event should be projected as the next event:", m)
+	}
+}
+
+func TestProjectorBaseConcurrent(t *testing.T) {
+	repo := &MockVersionedReadRepository{models: map[UUID]*TestProjectedModel{}}
+	projector := &TestProjector{}
+	projector.ProjectorBase = NewProjectorBase(repo, projector)
+	projector.SetGapHandlingStrategy(BufferGapHandlingStrategy)
+
+	ids := []UUID{NewUUID(), NewUUID()}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		for v := 5; v > 0; v-- {
+			wg.Add(1)
+			go func(id UUID, v int) {
+				defer wg.Done()
+				ctx := WithEventVersion(context.Background(), v)
+				if err := projector.ProjectEvent(ctx, &TestEvent{id, "x"}); err != nil {
+					t.Error("there should be no error:", err)
+				}
+			}(id, v)
+		}
+	}
+	wg.Wait()
+
+	for _, id := range ids {
+		if m := repo.models[id]; m == nil || m.Content != "xxxxx" || m.AggregateVersion != 5 {
+			t.Error("all events should be projected:", m)
+		}
+	}
+	if len(projector.buffers) != 0 {
+		t.Error("the buffers should be removed:", projector.buffers)
+	}
+}
+
+func TestEventSourcingRepositoryEventVersions(t *testing.T) {
+	store := &MockEventStore{Events: make([]EventRecord, 0)}
+	bus := &MockContextEventBus{}
+	repo, err := NewEventSourcingRepository(store, bus)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := NewUUID()
+	agg, _ := CreateAggregate(TestAggregateType, id)
+	agg.StoreEvent(&TestEvent{id, "a"})
+	agg.StoreEvent(&TestEvent{id, "b"})
+	if err := repo.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	agg.StoreEvent(&TestEvent{id, "c"})
+	if err := repo.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if len(bus.Contexts) != 3 {
+		t.Fatal("the events should be published with a context:", len(bus.Contexts))
+	}
+	for i, ctx := range bus.Contexts {
+		if version, ok := EventVersionFromContext(ctx); !ok || version != i+1 {
+			t.Error("the event version should be in the context:", version, ok)
+		}
+	}
+}
+
+type TestProjector struct {
+	*ProjectorBase
+}
+
+func (p *TestProjector) ProjectorType() ProjectorType { return "TestProjector" }
+
+func (p *TestProjector) Project(event Event, model interface{}) error {
+	m := model.(*TestProjectedModel)
+	m.ID = event.AggregateID()
+	m.Content += event.(*TestEvent).Content
+	return nil
+}
+
+type TestProjectedModel struct {
+	ID               UUID
+	Version          int
+	AggregateVersion int
+	Content          string
+}
+
+func (m *TestProjectedModel) ModelVersion() int         { return m.Version }
+func (m *TestProjectedModel) SetModelVersion(v int)     { m.Version = v }
+func (m *TestProjectedModel) ProjectedVersion() int     { return m.AggregateVersion }
+func (m *TestProjectedModel) SetProjectedVersion(v int) { m.AggregateVersion = v }
+
+type MockVersionedReadRepository struct {
+	models map[UUID]*TestProjectedModel
+	mu     sync.Mutex
+}
+
+func (r *MockVersionedReadRepository) Save(id UUID, model interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := *model.(*TestProjectedModel)
+	r.models[id] = &m
+	return nil
+}
+
+func (r *MockVersionedReadRepository) Find(id UUID) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m, ok := r.models[id]; ok {
+		c := *m
+		return &c, nil
+	}
+	return nil, ErrModelNotFound
+}
+
+func (r *MockVersionedReadRepository) FindAll() ([]interface{}, error) { return nil, nil }
+func (r *MockVersionedReadRepository) Remove(id UUID) error            { return nil }
+
+func (r *MockVersionedReadRepository) CompareAndSave(id UUID, model Versionable) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current := 0
+	if m, ok := r.models[id]; ok {
+		current = m.Version
+	}
+	if current != model.ModelVersion() {
+		return ErrIncorrectModelVersion
+	}
+	model.SetModelVersion(current + 1)
+	m := *model.(*TestProjectedModel)
+	r.models[id] = &m
+	return nil
+}
+
+func (r *MockVersionedReadRepository) Update(id UUID, update func(interface{}) error) error {
+	return UpdateWithRetry(r, func() interface{} { return &TestProjectedModel{} }, id, update)
+}
@@ -0,0 +1,398 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery is when a query can not be used with the models, for
+// example when filtering on a field that does not exist.
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrInvalidCursor is when a query cursor could not be decoded.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Operator is a comparison operator used in query filters.
+type Operator string
+
+const (
+	// OperatorEqual matches fields equal to the value.
+	OperatorEqual Operator = "eq"
+	// OperatorNotEqual matches fields not equal to the value.
+	OperatorNotEqual Operator = "ne"
+	// OperatorGreaterThan matches fields greater than the value.
+	OperatorGreaterThan Operator = "gt"
+	// OperatorGreaterThanOrEqual matches fields greater than or equal to the value.
+	OperatorGreaterThanOrEqual Operator = "gte"
+	// OperatorLessThan matches fields less than the value.
+	OperatorLessThan Operator = "lt"
+	// OperatorLessThanOrEqual matches fields less than or equal to the value.
+	OperatorLessThanOrEqual Operator = "lte"
+	// OperatorIn matches fields equal to any of the values, which must be a
+	// []interface{}.
+	OperatorIn Operator = "in"
+)
+
+// Filter is a condition on a field of a read model.
+type Filter struct {
+	Field    string
+	Operator Operator
+	Value    interface{}
+}
+
+// Sort is a sort order on a field of a read model.
+type Sort struct {
+	Field      string
+	Descending bool
+}
+
+// Query is a backend-neutral query for read models. Fields are referred to by
+// their Go struct field names, which each read repository maps to its own
+// names (for example the BSON names in MongoDB). Nested fields are separated
+// by dots. All filters must match for a model to be returned.
+//
+// An example would be:
+//     q := NewQuery().Equal("Status", "active").SortByDesc("Age").WithLimit(10)
+type Query struct {
+	Filters []Filter
+	Sorts   []Sort
+
+	// Limit is the max number of models to return, zero means no limit.
+	Limit int
+
+	// Cursor is where to continue from a previous query result.
+	Cursor string
+}
+
+// NewQuery creates a new empty Query matching all models.
+func NewQuery() *Query {
+	return &Query{}
+}
+
+// Where adds a filter with any operator.
+func (q *Query) Where(field string, operator Operator, value interface{}) *Query {
+	q.Filters = append(q.Filters, Filter{field, operator, value})
+	return q
+}
+
+// Equal adds a filter for fields equal to the value.
+func (q *Query) Equal(field string, value interface{}) *Query {
+	return q.Where(field, OperatorEqual, value)
+}
+
+// In adds a filter for fields equal to any of the values.
+func (q *Query) In(field string, values ...interface{}) *Query {
+	return q.Where(field, OperatorIn, values)
+}
+
+// Range adds filters for fields in the range [from, to). A nil bound is
+// left open.
+func (q *Query) Range(field string, from, to interface{}) *Query {
+	if from != nil {
+		q.Where(field, OperatorGreaterThanOrEqual, from)
+	}
+	if to != nil {
+		q.Where(field, OperatorLessThan, to)
+	}
+	return q
+}
+
+// SortBy adds an ascending sort order on the field.
+func (q *Query) SortBy(field string) *Query {
+	q.Sorts = append(q.Sorts, Sort{field, false})
+	return q
+}
+
+// SortByDesc adds a descending sort order on the field.
+func (q *Query) SortByDesc(field string) *Query {
+	q.Sorts = append(q.Sorts, Sort{field, true})
+	return q
+}
+
+// WithLimit sets the max number of models to return.
+func (q *Query) WithLimit(limit int) *Query {
+	q.Limit = limit
+	return q
+}
+
+// WithCursor sets the cursor to continue from, as returned in a QueryResult.
+func (q *Query) WithCursor(cursor string) *Query {
+	q.Cursor = cursor
+	return q
+}
+
+// QueryResult is a page of read models returned by a query.
+type QueryResult struct {
+	Models []interface{}
+
+	// Cursor is used to get the next page of models, it is empty when there
+	// are no more models.
+	Cursor string
+}
+
+// EncodeCursor encodes an offset into an opaque cursor, for use by read
+// repositories.
+func EncodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("offset:" + strconv.Itoa(offset)))
+}
+
+// DecodeCursor decodes a cursor created by EncodeCursor. An empty cursor is
+// offset zero.
+func DecodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(data), "offset:") {
+		return 0, ErrInvalidCursor
+	}
+
+	offset, err := strconv.Atoi(strings.TrimPrefix(string(data), "offset:"))
+	if err != nil || offset < 0 {
+		return 0, ErrInvalidCursor
+	}
+
+	return offset, nil
+}
+
+// Apply runs the query on models in memory and returns the matching page. It
+// is used by read repositories that can not run queries natively.
+func (q *Query) Apply(models []interface{}) (*QueryResult, error) {
+	offset, err := DecodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []interface{}{}
+	for _, model := range models {
+		ok, err := q.Match(model)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matching = append(matching, model)
+		}
+	}
+
+	if len(q.Sorts) > 0 {
+		var sortErr error
+		sort.SliceStable(matching, func(i, j int) bool {
+			less, err := q.less(matching[i], matching[j])
+			if err != nil {
+				sortErr = err
+			}
+			return less
+		})
+		if sortErr != nil {
+			return nil, sortErr
+		}
+	}
+
+	result := &QueryResult{Models: []interface{}{}}
+	if offset >= len(matching) {
+		return result, nil
+	}
+	end := len(matching)
+	if q.Limit > 0 && offset+q.Limit < end {
+		end = offset + q.Limit
+		result.Cursor = EncodeCursor(end)
+	}
+	result.Models = matching[offset:end]
+
+	return result, nil
+}
+
+// Match returns true if the model matches all filters of the query.
+func (q *Query) Match(model interface{}) (bool, error) {
+	for _, f := range q.Filters {
+		v, err := fieldValue(model, f.Field)
+		if err != nil {
+			return false, err
+		}
+
+		if f.Operator == OperatorIn {
+			values, ok := f.Value.([]interface{})
+			if !ok {
+				return false, fmt.Errorf("%s: %s is not a list", ErrInvalidQuery, f.Field)
+			}
+			found := false
+			for _, value := range values {
+				if c, err := compareValues(v, value); err == nil && c == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, nil
+			}
+			continue
+		}
+
+		c, err := compareValues(v, f.Value)
+		if err != nil {
+			if f.Operator == OperatorEqual || f.Operator == OperatorNotEqual {
+				c = 1
+				if reflect.DeepEqual(v, f.Value) {
+					c = 0
+				}
+			} else {
+				return false, fmt.Errorf("%s: %s", err, f.Field)
+			}
+		}
+
+		var ok bool
+		switch f.Operator {
+		case OperatorEqual:
+			ok = c == 0
+		case OperatorNotEqual:
+			ok = c != 0
+		case OperatorGreaterThan:
+			ok = c > 0
+		case OperatorGreaterThanOrEqual:
+			ok = c >= 0
+		case OperatorLessThan:
+			ok = c < 0
+		case OperatorLessThanOrEqual:
+			ok = c <= 0
+		default:
+			return false, fmt.Errorf("%s: unknown operator %s", ErrInvalidQuery, f.Operator)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// less compares two models by the sort orders of the query.
+func (q *Query) less(a, b interface{}) (bool, error) {
+	for _, s := range q.Sorts {
+		va, err := fieldValue(a, s.Field)
+		if err != nil {
+			return false, err
+		}
+		vb, err := fieldValue(b, s.Field)
+		if err != nil {
+			return false, err
+		}
+
+		c, err := compareValues(va, vb)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s", err, s.Field)
+		}
+		if c == 0 {
+			continue
+		}
+		if s.Descending {
+			return c > 0, nil
+		}
+		return c < 0, nil
+	}
+	return false, nil
+}
+
+// fieldValue returns the value of a (nested) field of a model.
+func fieldValue(model interface{}, field string) (interface{}, error) {
+	v := reflect.ValueOf(model)
+	for _, name := range strings.Split(field, ".") {
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil, nil
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return nil, fmt.Errorf("%s: no field %s", ErrInvalidQuery, field)
+		}
+		v = v.FieldByName(name)
+		if !v.IsValid() {
+			return nil, fmt.Errorf("%s: no field %s", ErrInvalidQuery, field)
+		}
+	}
+	return v.Interface(), nil
+}
+
+// compareValues compares two values of comparable kinds, numbers of
+// different types are compared by value.
+func compareValues(a, b interface{}) (int, error) {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		if !ok {
+			return 0, ErrInvalidQuery
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, nil
+		case ta.After(tb):
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch {
+	case isNumber(va) && isNumber(vb):
+		fa, fb := toFloat(va), toFloat(vb)
+		switch {
+		case fa < fb:
+			return -1, nil
+		case fa > fb:
+			return 1, nil
+		}
+		return 0, nil
+	case va.Kind() == reflect.String && vb.Kind() == reflect.String:
+		return strings.Compare(va.String(), vb.String()), nil
+	case va.Kind() == reflect.Bool && vb.Kind() == reflect.Bool:
+		switch {
+		case va.Bool() == vb.Bool():
+			return 0, nil
+		case vb.Bool():
+			return -1, nil
+		}
+		return 1, nil
+	}
+
+	return 0, ErrInvalidQuery
+}
+
+func isNumber(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCursor(t *testing.T) {
+	offset, err := DecodeCursor("")
+	if err != nil || offset != 0 {
+		t.Error("an empty cursor should be offset zero:", offset, err)
+	}
+
+	offset, err = DecodeCursor(EncodeCursor(42))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if offset != 42 {
+		t.Error("the offset should be correct:", offset)
+	}
+
+	if _, err := DecodeCursor("???"); err != ErrInvalidCursor {
+		t.Error("there should be a ErrInvalidCursor error:", err)
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	type nested struct {
+		Score float64
+	}
+	type model struct {
+		Name    string
+		Age     int
+		Active  bool
+		Created time.Time
+		Nested  *nested
+	}
+	now := time.Now()
+	m := &model{"a", 30, true, now, &nested{1.5}}
+
+	cases := []struct {
+		query *Query
+		match bool
+	}{
+		{NewQuery(), true},
+		{NewQuery().Equal("Name", "a"), true},
+		{NewQuery().Equal("Name", "b"), false},
+		{NewQuery().Equal("Age", 30), true},
+		{NewQuery().Equal("Age", 30.0), true},
+		{NewQuery().Equal("Active", true), true},
+		{NewQuery().Where("Age", OperatorGreaterThan, 29), true},
+		{NewQuery().Where("Age", OperatorLessThanOrEqual, 29), false},
+		{NewQuery().Range("Age", 30, 31), true},
+		{NewQuery().Range("Age", nil, 30), false},
+		{NewQuery().In("Age", 1, 30), true},
+		{NewQuery().In("Name", "b", "c"), false},
+		{NewQuery().Range("Created", now.Add(-time.Hour), now.Add(time.Hour)), true},
+		{NewQuery().Where("Nested.Score", OperatorGreaterThan, 1), true},
+		{NewQuery().Equal("Name", "a").Equal("Age", 31), false},
+	}
+	for i, c := range cases {
+		match, err := c.query.Match(m)
+		if err != nil {
+			t.Error("there should be no error:", i, err)
+		}
+		if match != c.match {
+			t.Error("the match should be correct:", i, match)
+		}
+	}
+
+	if _, err := NewQuery().Equal("Unknown", 1).Match(m); err == nil {
+		t.Error("there should be an error for unknown fields")
+	}
+	if _, err := NewQuery().Where("Name", OperatorGreaterThan, 1).Match(m); err == nil {
+		t.Error("there should be an error for incomparable values")
+	}
+}
@@ -24,6 +24,17 @@ var ErrCouldNotSaveModel = errors.New("could not save model")
 // ErrModelNotFound is when a model could not be found.
 var ErrModelNotFound = errors.New("could not find model")
 
+// ErrIncorrectModelVersion is when a model could not be saved because it has
+// been changed since it was loaded.
+var ErrIncorrectModelVersion = errors.New("incorrect model version")
+
+// ErrModelNotVersionable is when a model does not implement Versionable.
+var ErrModelNotVersionable = errors.New("model is not versionable")
+
+// UpdateRetries is the number of times Update is retried when the model has
+// been changed concurrently.
+var UpdateRetries = 10
+
 // ReadRepository is a storage for read models.
 type ReadRepository interface {
 	// Save saves a read model with id to the repository.
@@ -38,3 +49,72 @@ type ReadRepository interface {
 	// Remove removes a read model with id from the repository.
 	Remove(UUID) error
 }
+
+// QueryableReadRepository is a ReadRepository that can find read models with
+// a backend-neutral Query.
+type QueryableReadRepository interface {
+	ReadRepository
+
+	// Query returns the read models matching the query, one page at a time.
+	Query(*Query) (*QueryResult, error)
+}
+
+// Versionable is a read model with a version, used for optimistic concurrency
+// control. The version should be stored in a field named Version, which the
+// read repositories also use in their queries.
+type Versionable interface {
+	// ModelVersion returns the version of the model, zero if not yet saved.
+	ModelVersion() int
+
+	// SetModelVersion sets the version of the model.
+	SetModelVersion(int)
+}
+
+// VersionedReadRepository is a ReadRepository that can save Versionable read
+// models conditionally, to safely update models from multiple projectors.
+type VersionedReadRepository interface {
+	ReadRepository
+
+	// CompareAndSave saves the model only if the saved model has the same
+	// version as the model, or if there is no saved model and the version is
+	// zero. The version of the model is incremented when saved. Returns
+	// ErrIncorrectModelVersion if the saved model has another version.
+	CompareAndSave(UUID, Versionable) error
+
+	// Update loads the model with id, or creates a new model if there is
+	// none, and saves it after applying the update function. The update is
+	// retried if the model was changed concurrently, the function should thus
+	// only modify the model. The update is aborted if the function returns an
+	// error.
+	Update(UUID, func(interface{}) error) error
+}
+
+// UpdateWithRetry implements the Update method of the VersionedReadRepository
+// interface using Find and CompareAndSave, for read repositories that can not
+// update models atomically. The factory creates new models.
+func UpdateWithRetry(repo VersionedReadRepository, factory func() interface{},
+	id UUID, update func(interface{}) error) error {
+	for i := 0; i < UpdateRetries; i++ {
+		model, err := repo.Find(id)
+		if err == ErrModelNotFound {
+			model = factory()
+		} else if err != nil {
+			return err
+		}
+
+		m, ok := model.(Versionable)
+		if !ok {
+			return ErrModelNotVersionable
+		}
+
+		if err := update(model); err != nil {
+			return err
+		}
+
+		if err := repo.CompareAndSave(id, m); err != ErrIncorrectModelVersion {
+			return err
+		}
+	}
+
+	return ErrIncorrectModelVersion
+}
@@ -0,0 +1,461 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoReadRepositoryDefined is if no read repository has been defined.
+var ErrNoReadRepositoryDefined = errors.New("no read repository defined")
+
+// ErrInvalidSize is when the size of the cache is not positive.
+var ErrInvalidSize = errors.New("invalid cache size")
+
+// ErrUnsupported is when the wrapped read repository does not support an
+// operation, for example Update on a repository that is not versioned.
+var ErrUnsupported = errors.New("unsupported by read repository")
+
+// Stats are the statistics of a cache.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Size is the current number of models in the cache.
+	Size int
+}
+
+// ReadRepository wraps a ReadRepository with an in process LRU cache of the
+// models found by ID. Models are invalidated when they are saved or removed
+// through the cache, and when an event about them is observed with Notify,
+// which is used to invalidate the caches of other instances by adding the
+// cache as an observer to a distributed event bus.
+//
+// The cache keeps copies of the models and returns a new copy on each Find,
+// as callers such as projectors may modify the models they find. The default
+// copy is a deep copy of the exported fields, see SetCopyFunc.
+//
+// FindAll and Query are not cached. Update, CompareAndSave and Query are
+// forwarded if the wrapped repository supports them, which makes it possible
+// to use the cache in projectors.
+type ReadRepository struct {
+	repo eh.ReadRepository
+	size int
+	ttl  time.Duration
+
+	items map[eh.UUID]*list.Element
+	lru   *list.List
+	// generation is increased on all invalidations, and is used to not cache
+	// models that were invalidated while being loaded.
+	generation uint64
+	stats      Stats
+	mu         sync.Mutex
+
+	invalidationFunc func(eh.Event) []eh.UUID
+	copyFunc         func(interface{}) interface{}
+
+	tenants   map[eh.TenantID]*ReadRepository
+	tenantsMu sync.Mutex
+}
+
+type entry struct {
+	id      eh.UUID
+	model   interface{}
+	expires time.Time
+}
+
+// NewReadRepository creates a new ReadRepository, which caches up to size
+// models. Models expire after the TTL, or never if it is zero.
+func NewReadRepository(repo eh.ReadRepository, size int, ttl time.Duration) (*ReadRepository, error) {
+	if repo == nil {
+		return nil, ErrNoReadRepositoryDefined
+	}
+
+	if size <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	r := &ReadRepository{
+		repo:  repo,
+		size:  size,
+		ttl:   ttl,
+		items: make(map[eh.UUID]*list.Element),
+		lru:   list.New(),
+		invalidationFunc: func(event eh.Event) []eh.UUID {
+			return []eh.UUID{event.AggregateID()}
+		},
+		copyFunc: copyModel,
+		tenants:  make(map[eh.TenantID]*ReadRepository),
+	}
+	return r, nil
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. Each tenant has its own
+// cache with the same settings, of the repository of the tenant of the wrapped
+// repository if it is multi tenant.
+func (r *ReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.tenantsMu.Lock()
+	defer r.tenantsMu.Unlock()
+
+	if tenant, ok := r.tenants[tenantID]; ok {
+		return tenant, nil
+	}
+
+	repo := r.repo
+	if m, ok := repo.(eh.MultiTenantReadRepository); ok {
+		var err error
+		if repo, err = m.ForTenant(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	tenant, err := NewReadRepository(repo, r.size, r.ttl)
+	if err != nil {
+		return nil, err
+	}
+	tenant.SetInvalidationFunc(r.invalidationFunc)
+	tenant.SetCopyFunc(r.copyFunc)
+	r.tenants[tenantID] = tenant
+
+	return tenant, nil
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It starts the cached repository, if supported.
+func (r *ReadRepository) Start(ctx context.Context) error {
+	if l, ok := r.repo.(eh.Lifecycle); ok {
+		return l.Start(ctx)
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It checks the cached repository, if
+// supported, and reports the number of cached models.
+func (r *ReadRepository) CheckHealth(ctx context.Context) (eh.Health, error) {
+	var health eh.Health
+	var err error
+	if c, ok := r.repo.(eh.HealthChecker); ok {
+		health, err = c.CheckHealth(ctx)
+	}
+
+	details := map[string]interface{}{"cached": r.Stats().Size}
+	for k, v := range health.Details {
+		details[k] = v
+	}
+	health.Details = details
+	return health, err
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// The cache is purged and the cached repository is closed, if supported.
+func (r *ReadRepository) Close(ctx context.Context) error {
+	r.Purge()
+	if l, ok := r.repo.(eh.Lifecycle); ok {
+		return l.Close(ctx)
+	}
+	return nil
+}
+
+// SetInvalidationFunc sets the function used by Notify to get the IDs of the
+// models to invalidate for an event. The default is the aggregate ID of the
+// event, which must be changed for models that are not keyed by it.
+func (r *ReadRepository) SetInvalidationFunc(f func(eh.Event) []eh.UUID) {
+	r.invalidationFunc = f
+}
+
+// SetCopyFunc sets the function used to copy models when they are cached and
+// found. The default makes a deep copy of the exported fields of the models,
+// and copies unexported fields as is; models with unexported fields that are
+// modified after being found must use their own copy function.
+func (r *ReadRepository) SetCopyFunc(f func(interface{}) interface{}) {
+	r.copyFunc = f
+}
+
+// Save saves a read model with id to the repository and invalidates it.
+func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
+	return r.SaveContext(context.Background(), id, model)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	defer r.Invalidate(id)
+	return eh.AdaptReadRepository(r.repo).SaveContext(ctx, id, model)
+}
+
+// Find returns one read model with using an id, from the cache if possible.
+// Returns ErrModelNotFound if no model could be found.
+func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface. Cached models
+// are returned also for done contexts.
+func (r *ReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
+	r.mu.Lock()
+	if e, ok := r.items[id]; ok {
+		item := e.Value.(*entry)
+		if r.ttl == 0 || time.Now().Before(item.expires) {
+			r.lru.MoveToFront(e)
+			r.stats.Hits++
+			r.mu.Unlock()
+			return r.copyFunc(item.model), nil
+		}
+		r.remove(e)
+	}
+	r.stats.Misses++
+	generation := r.generation
+	r.mu.Unlock()
+
+	model, err := eh.AdaptReadRepository(r.repo).FindContext(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generation == generation {
+		r.add(id, r.copyFunc(model))
+	}
+
+	return model, nil
+}
+
+// FindAll returns all read models in the wrapped repository.
+func (r *ReadRepository) FindAll() ([]interface{}, error) {
+	return r.FindAllContext(context.Background())
+}
+
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	return eh.AdaptReadRepository(r.repo).FindAllContext(ctx)
+}
+
+// Remove removes a read model with id from the repository and invalidates it.
+// Returns ErrModelNotFound if no model could be found.
+func (r *ReadRepository) Remove(id eh.UUID) error {
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
+	defer r.Invalidate(id)
+	return eh.AdaptReadRepository(r.repo).RemoveContext(ctx, id)
+}
+
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface by forwarding it to the wrapped repository.
+func (r *ReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	repo, ok := r.repo.(eh.QueryableReadRepository)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+
+	return repo.Query(query)
+}
+
+// CompareAndSave implements the CompareAndSave method of the
+// eventhorizon.VersionedReadRepository interface by forwarding it to the
+// wrapped repository, and invalidates the model.
+func (r *ReadRepository) CompareAndSave(id eh.UUID, model eh.Versionable) error {
+	repo, ok := r.repo.(eh.VersionedReadRepository)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	defer r.Invalidate(id)
+	return repo.CompareAndSave(id, model)
+}
+
+// Update implements the Update method of the eventhorizon.VersionedReadRepository
+// interface by forwarding it to the wrapped repository, and invalidates the
+// model.
+func (r *ReadRepository) Update(id eh.UUID, update func(interface{}) error) error {
+	repo, ok := r.repo.(eh.VersionedReadRepository)
+	if !ok {
+		return ErrUnsupported
+	}
+
+	defer r.Invalidate(id)
+	return repo.Update(id, update)
+}
+
+// Notify implements the Notify method of the eventhorizon.EventObserver
+// interface, and invalidates the models of the event. The models of events
+// implementing eventhorizon.TenantEvent are invalidated in the cache of the
+// tenant.
+func (r *ReadRepository) Notify(event eh.Event) {
+	if e, ok := event.(eh.TenantEvent); ok && e.TenantID() != "" {
+		r.tenantsMu.Lock()
+		tenant, ok := r.tenants[e.TenantID()]
+		r.tenantsMu.Unlock()
+		if ok {
+			tenant.invalidateEvent(event)
+		}
+		return
+	}
+
+	r.invalidateEvent(event)
+}
+
+func (r *ReadRepository) invalidateEvent(event eh.Event) {
+	for _, id := range r.invalidationFunc(event) {
+		r.Invalidate(id)
+	}
+}
+
+// Invalidate removes a model from the cache.
+func (r *ReadRepository) Invalidate(id eh.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	if e, ok := r.items[id]; ok {
+		r.remove(e)
+	}
+}
+
+// Purge removes all models from the cache.
+func (r *ReadRepository) Purge() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	r.items = make(map[eh.UUID]*list.Element)
+	r.lru.Init()
+}
+
+// Stats returns the statistics of the cache.
+func (r *ReadRepository) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Size = r.lru.Len()
+	return stats
+}
+
+// add adds a model to the cache and evicts the least recently used model if
+// the cache is full, the lock must be held.
+func (r *ReadRepository) add(id eh.UUID, model interface{}) {
+	if e, ok := r.items[id]; ok {
+		r.remove(e)
+	}
+
+	item := &entry{id: id, model: model}
+	if r.ttl > 0 {
+		item.expires = time.Now().Add(r.ttl)
+	}
+	r.items[id] = r.lru.PushFront(item)
+
+	if r.lru.Len() > r.size {
+		r.remove(r.lru.Back())
+		r.stats.Evictions++
+	}
+}
+
+// remove removes an element from the cache, the lock must be held.
+func (r *ReadRepository) remove(e *list.Element) {
+	r.lru.Remove(e)
+	delete(r.items, e.Value.(*entry).id)
+}
+
+// copyModel returns a deep copy of a model, following the pointers, slices,
+// maps and interfaces of exported fields. Unexported fields are copied as is.
+func copyModel(model interface{}) interface{} {
+	if model == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(model)
+	c := reflect.New(v.Type()).Elem()
+	copyValue(c, v)
+	return c.Interface()
+}
+
+// copyValue deep copies src to dst, which must be settable and either the
+// zero value or equal to src.
+func copyValue(dst, src reflect.Value) {
+	switch src.Kind() {
+	case reflect.Ptr:
+		if src.IsNil() {
+			return
+		}
+		p := reflect.New(src.Type().Elem())
+		copyValue(p.Elem(), src.Elem())
+		dst.Set(p)
+	case reflect.Interface:
+		if src.IsNil() {
+			return
+		}
+		e := reflect.New(src.Elem().Type()).Elem()
+		copyValue(e, src.Elem())
+		dst.Set(e)
+	case reflect.Slice:
+		if src.IsNil() {
+			return
+		}
+		s := reflect.MakeSlice(src.Type(), src.Len(), src.Len())
+		for i := 0; i < src.Len(); i++ {
+			copyValue(s.Index(i), src.Index(i))
+		}
+		dst.Set(s)
+	case reflect.Map:
+		if src.IsNil() {
+			return
+		}
+		m := reflect.MakeMapWithSize(src.Type(), src.Len())
+		iter := src.MapRange()
+		for iter.Next() {
+			e := reflect.New(src.Type().Elem()).Elem()
+			copyValue(e, iter.Value())
+			m.SetMapIndex(iter.Key(), e)
+		}
+		dst.Set(m)
+	case reflect.Array:
+		for i := 0; i < src.Len(); i++ {
+			copyValue(dst.Index(i), src.Index(i))
+		}
+	case reflect.Struct:
+		dst.Set(src)
+		t := src.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			copyValue(dst.Field(i), src.Field(i))
+		}
+	default:
+		dst.Set(src)
+	}
+}
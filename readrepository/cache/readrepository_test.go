@@ -0,0 +1,404 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/memory"
+	"github.com/looplab/eventhorizon/readrepository/testutil"
+)
+
+func TestReadRepository(t *testing.T) {
+	if _, err := NewReadRepository(nil, 10, 0); err != ErrNoReadRepositoryDefined {
+		t.Error("there should be a ErrNoReadRepositoryDefined error:", err)
+	}
+	if _, err := NewReadRepository(memory.NewReadRepository(), 0, 0); err != ErrInvalidSize {
+		t.Error("there should be a ErrInvalidSize error:", err)
+	}
+
+	baseRepo := memory.NewReadRepository()
+	repo, err := NewReadRepository(baseRepo, 2, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("Find non-existing item")
+	if _, err := repo.Find(eh.NewUUID()); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+
+	t.Log("Find item twice")
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	for i := 0; i < 2; i++ {
+		model, err := repo.Find(model1.ID)
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if !reflect.DeepEqual(model, model1) {
+			t.Error("the item should be correct:", model)
+		}
+	}
+	if stats := repo.Stats(); stats != (Stats{Hits: 1, Misses: 2, Size: 1}) {
+		t.Error("the stats should be correct:", stats)
+	}
+
+	t.Log("Find after save")
+	model1Alt := &mocks.Model{model1.ID, "model1Alt", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model1Alt.ID, model1Alt); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err := repo.Find(model1.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1Alt) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("Find after eviction")
+	model2 := &mocks.Model{eh.NewUUID(), "model2", time.Now().Round(time.Millisecond)}
+	model3 := &mocks.Model{eh.NewUUID(), "model3", time.Now().Round(time.Millisecond)}
+	for _, m := range []*mocks.Model{model2, model3} {
+		if err := repo.Save(m.ID, m); err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if _, err := repo.Find(m.ID); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if stats := repo.Stats(); stats != (Stats{Hits: 1, Misses: 5, Evictions: 1, Size: 2}) {
+		t.Error("the stats should be correct:", stats)
+	}
+	if _, err := repo.Find(model1.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := repo.Stats(); stats.Misses != 6 {
+		t.Error("there should be a miss:", stats)
+	}
+
+	t.Log("Find after remove")
+	if err := repo.Remove(model1.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.Find(model1.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+
+	t.Log("Find after purge")
+	repo.Purge()
+	if stats := repo.Stats(); stats.Size != 0 {
+		t.Error("the cache should be empty:", stats)
+	}
+}
+
+func TestReadRepositoryCopies(t *testing.T) {
+	repo, err := NewReadRepository(memory.NewReadRepository(), 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	model := &mocks.Model{eh.NewUUID(), "model", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model.ID, model); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if _, err := repo.Find(model.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("modify found items")
+	for i := 0; i < 2; i++ {
+		m, err := repo.Find(model.ID)
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if !reflect.DeepEqual(m, model) {
+			t.Error("the item should be correct:", m)
+		}
+		m.(*mocks.Model).Content = "modified"
+	}
+	if stats := repo.Stats(); stats.Hits != 2 {
+		t.Error("the item should be cached:", stats)
+	}
+
+	t.Log("copy nested values")
+	type nested struct {
+		Values []int
+		Map    map[string]*int
+		Any    interface{}
+		hidden []int
+	}
+	one := 1
+	original := &nested{[]int{1}, map[string]*int{"one": &one}, []string{"a"}, []int{2}}
+	c := copyModel(original).(*nested)
+	if !reflect.DeepEqual(c, original) {
+		t.Error("the copy should be equal:", c)
+	}
+	c.Values[0], *c.Map["one"], c.Any.([]string)[0] = 2, 2, "b"
+	if original.Values[0] != 1 || one != 1 || original.Any.([]string)[0] != "a" {
+		t.Error("the original should not be modified:", original)
+	}
+	if &c.hidden[0] != &original.hidden[0] {
+		t.Error("unexported fields should be copied as is")
+	}
+	if copyModel(nil) != nil {
+		t.Error("a nil model should be copied as nil")
+	}
+}
+
+func TestReadRepositoryContext(t *testing.T) {
+	repo, err := NewReadRepository(memory.NewReadRepository(), 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	testutil.ContextCommonTests(t, repo)
+}
+
+func TestReadRepositoryTTL(t *testing.T) {
+	repo, err := NewReadRepository(memory.NewReadRepository(), 10, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	model := &mocks.Model{eh.NewUUID(), "model", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model.ID, model); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	repo.Find(model.ID)
+	repo.Find(model.ID)
+	time.Sleep(100 * time.Millisecond)
+	repo.Find(model.ID)
+	if stats := repo.Stats(); stats != (Stats{Hits: 1, Misses: 2, Size: 1}) {
+		t.Error("the stats should be correct:", stats)
+	}
+}
+
+func TestReadRepositoryNotify(t *testing.T) {
+	baseRepo := memory.NewReadRepository()
+	repo1, err := NewReadRepository(baseRepo, 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	repo2, err := NewReadRepository(baseRepo, 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	model := &mocks.Model{eh.NewUUID(), "model", time.Now().Round(time.Millisecond)}
+	if err := repo1.Save(model.ID, model); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	repo2.Find(model.ID)
+
+	t.Log("Find stale item in other instance")
+	modelAlt := &mocks.Model{model.ID, "modelAlt", time.Now().Round(time.Millisecond)}
+	if err := repo1.Save(modelAlt.ID, modelAlt); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	m, err := repo2.Find(model.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(m, model) {
+		t.Error("the item should be cached:", m)
+	}
+
+	t.Log("Find item in other instance after notify")
+	repo2.Notify(mocks.Event{model.ID, "event"})
+	m, err = repo2.Find(model.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(m, modelAlt) {
+		t.Error("the item should be correct:", m)
+	}
+
+	t.Log("Notify with custom invalidation func")
+	otherID := eh.NewUUID()
+	repo2.SetInvalidationFunc(func(event eh.Event) []eh.UUID {
+		return []eh.UUID{otherID}
+	})
+	repo2.Notify(mocks.Event{model.ID, "event"})
+	if stats := repo2.Stats(); stats.Size != 1 {
+		t.Error("the item should still be cached:", stats)
+	}
+}
+
+func TestReadRepositoryVersioned(t *testing.T) {
+	baseRepo := memory.NewReadRepository()
+	baseRepo.SetModel(func() interface{} {
+		return &mocks.VersionedModel{}
+	})
+	repo, err := NewReadRepository(baseRepo, 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	id := eh.NewUUID()
+	for _, content := range []string{"a", "b"} {
+		err := repo.Update(id, func(model interface{}) error {
+			model.(*mocks.VersionedModel).Content = content
+			return nil
+		})
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+
+		model, err := repo.Find(id)
+		if err != nil {
+			t.Error("there should be no error:", err)
+		}
+		if m := model.(*mocks.VersionedModel); m.Content != content {
+			t.Error("the item should be correct:", m)
+		}
+	}
+
+	t.Log("Update on unversioned repository")
+	repo, err = NewReadRepository(unversionedRepository{baseRepo}, 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := repo.Update(id, nil); err != ErrUnsupported {
+		t.Error("there should be a ErrUnsupported error:", err)
+	}
+}
+
+func TestReadRepositoryTenants(t *testing.T) {
+	baseRepo := memory.NewReadRepository()
+	repo, err := NewReadRepository(baseRepo, 10, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	repoA, _ := testutil.TenantCommonTests(t, repo)
+
+	t.Log("Find item of tenant after notify")
+	baseRepoA, err := baseRepo.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	model := &mocks.Model{eh.NewUUID(), "model", time.Now().Round(time.Millisecond)}
+	if err := repoA.Save(model.ID, model); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	repoA.Find(model.ID)
+	modelAlt := &mocks.Model{model.ID, "modelAlt", time.Now().Round(time.Millisecond)}
+	if err := baseRepoA.Save(modelAlt.ID, modelAlt); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	repo.Notify(&tenantEvent{mocks.Event{model.ID, "event"}, "a"})
+	m, err := repoA.Find(model.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(m, modelAlt) {
+		t.Error("the item should be correct:", m)
+	}
+}
+
+// tenantEvent is an event of a tenant.
+func TestReadRepositoryLifecycle(t *testing.T) {
+	ctx := context.Background()
+	baseRepo := &lifecycleRepository{ReadRepository: memory.NewReadRepository()}
+	repo, err := NewReadRepository(baseRepo, 2, 0)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if err := repo.Start(ctx); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if !baseRepo.started {
+		t.Error("the cached repository should be started")
+	}
+
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.Find(model1.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("check the health")
+	health, err := repo.CheckHealth(ctx)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	expected := eh.Health{
+		Latency: time.Millisecond,
+		Details: map[string]interface{}{"cached": 1, "backend": "memory"},
+	}
+	if !reflect.DeepEqual(health, expected) {
+		t.Error("the health should be correct:", health)
+	}
+
+	t.Log("close the cached repository")
+	baseRepo.closeErr = errors.New("close error")
+	if err := repo.Close(ctx); err != baseRepo.closeErr {
+		t.Error("the error should be correct:", err)
+	}
+	if stats := repo.Stats(); stats.Size != 0 {
+		t.Error("the cache should be purged:", stats.Size)
+	}
+}
+
+// lifecycleRepository records that it is started, returns an error when closed
+// and is always healthy.
+type lifecycleRepository struct {
+	eh.ReadRepository
+	started  bool
+	closeErr error
+}
+
+func (r *lifecycleRepository) Start(ctx context.Context) error {
+	r.started = true
+	return nil
+}
+
+func (r *lifecycleRepository) Close(ctx context.Context) error {
+	return r.closeErr
+}
+
+func (r *lifecycleRepository) CheckHealth(ctx context.Context) (eh.Health, error) {
+	return eh.Health{
+		Latency: time.Millisecond,
+		Details: map[string]interface{}{"backend": "memory"},
+	}, nil
+}
+
+type tenantEvent struct {
+	mocks.Event
+	tenantID eh.TenantID
+}
+
+func (e *tenantEvent) TenantID() eh.TenantID            { return e.tenantID }
+func (e *tenantEvent) SetTenantID(tenantID eh.TenantID) { e.tenantID = tenantID }
+
+// unversionedRepository hides the versioned methods of a read repository.
+type unversionedRepository struct {
+	eh.ReadRepository
+}
@@ -0,0 +1,724 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrCouldNotCreateIndex is when the index could not be created.
+var ErrCouldNotCreateIndex = errors.New("could not create index")
+
+// ErrCouldNotClearIndex is when the index could not be cleared.
+var ErrCouldNotClearIndex = errors.New("could not clear index")
+
+// ErrCouldNotSearch is when a search could not be done.
+var ErrCouldNotSearch = errors.New("could not search")
+
+// ErrCouldNotConnect is when Elasticsearch could not be reached.
+var ErrCouldNotConnect = errors.New("could not connect")
+
+// ErrClusterUnhealthy is when the status of the Elasticsearch cluster is red.
+var ErrClusterUnhealthy = errors.New("cluster is unhealthy")
+
+// ErrModelNotSet is when an model is not set on a read repository.
+var ErrModelNotSet = errors.New("model not set")
+
+// maxResults is the max number of models returned from searches without a
+// size, which is the default max result window of Elasticsearch.
+const maxResults = 10000
+
+// ReadRepository implements an Elasticsearch repository of read models, with
+// full-text search. Models are stored as JSON documents in an index with
+// dynamic mapping, where string fields are searchable as text and can be
+// filtered, sorted and faceted on by their exact value.
+type ReadRepository struct {
+	client  *http.Client
+	url     string
+	index   string
+	factory func() interface{}
+
+	// refresh makes saves visible to searches before returning.
+	refresh bool
+
+	bulk      bool
+	bulkSize  int
+	bulkCount int
+	bulkBody  bytes.Buffer
+	bulkMu    sync.Mutex
+
+	tenants   map[eh.TenantID]*ReadRepository
+	tenantsMu sync.Mutex
+
+	// closed is set when the repository is closed, after flushing the bulk
+	// buffer with the bulk lock held.
+	closed atomic.Bool
+}
+
+// NewReadRepository creates a new ReadRepository, the index is created if it
+// does not exist.
+func NewReadRepository(url, index string) (*ReadRepository, error) {
+	return NewReadRepositoryWithClient(&http.Client{}, url, index)
+}
+
+// NewReadRepositoryWithClient creates a new ReadRepository with a HTTP client.
+func NewReadRepositoryWithClient(client *http.Client, url, index string) (*ReadRepository, error) {
+	r := &ReadRepository{
+		client:  client,
+		url:     strings.TrimRight(url, "/"),
+		index:   index,
+		tenants: make(map[eh.TenantID]*ReadRepository),
+	}
+
+	if err := r.createIndex(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// SetModel sets a factory function that creates concrete model types.
+func (r *ReadRepository) SetModel(factory func() interface{}) {
+	r.factory = factory
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. The models of a tenant are
+// stored in a separate index, named as the index of the repository with the
+// lower case tenant ID as suffix, which is created if needed. The repository
+// of a tenant has the same model and refresh setting, but its own bulk mode.
+func (r *ReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	r.tenantsMu.Lock()
+	defer r.tenantsMu.Unlock()
+
+	if tenant, ok := r.tenants[tenantID]; ok {
+		return tenant, nil
+	}
+
+	index := r.index + "-" + strings.ToLower(string(tenantID))
+	tenant, err := NewReadRepositoryWithClient(r.client, r.url, index)
+	if err != nil {
+		return nil, err
+	}
+	tenant.SetModel(r.factory)
+	tenant.SetRefresh(r.refresh)
+	r.tenants[tenantID] = tenant
+
+	return tenant, nil
+}
+
+// SetRefresh sets if saves and removes should wait for the changes to be
+// visible to searches, which is slower but useful in testing. Finding a model
+// by ID is always up to date.
+func (r *ReadRepository) SetRefresh(refresh bool) {
+	r.refresh = refresh
+}
+
+// StartBulk starts the bulk indexing mode, used when rebuilding projections.
+// Saves and removes are buffered and sent in batches of size, until StopBulk
+// is called. Removes in bulk mode never return ErrModelNotFound.
+func (r *ReadRepository) StartBulk(size int) {
+	r.bulkMu.Lock()
+	defer r.bulkMu.Unlock()
+
+	r.bulk = true
+	r.bulkSize = size
+}
+
+// StopBulk flushes the buffered changes and stops the bulk indexing mode.
+func (r *ReadRepository) StopBulk() error {
+	r.bulkMu.Lock()
+	defer r.bulkMu.Unlock()
+
+	r.bulk = false
+	return r.flush(context.Background())
+}
+
+// Flush sends the changes buffered in bulk mode.
+func (r *ReadRepository) Flush() error {
+	r.bulkMu.Lock()
+	defer r.bulkMu.Unlock()
+
+	return r.flush(context.Background())
+}
+
+// Save saves a read model with id to the repository.
+func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
+	return r.SaveContext(context.Background(), id, model)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface. The requests to Elasticsearch
+// are canceled with the context, except when buffered in bulk mode.
+func (r *ReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+
+	data, err := json.Marshal(model)
+	if err != nil {
+		return eh.ErrCouldNotSaveModel
+	}
+
+	if added, err := r.addToBulk("index", id, data); added || err != nil {
+		return err
+	}
+
+	status, _, err := r.request(ctx, "PUT", r.docPath(id)+r.refreshParam(), bytes.NewReader(data))
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil || (status != http.StatusOK && status != http.StatusCreated) {
+		return eh.ErrCouldNotSaveModel
+	}
+
+	return nil
+}
+
+// Find returns one read model with using an id. Returns
+// ErrModelNotFound if no model could be found.
+func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	status, body, err := r.request(ctx, "GET", r.docPath(id), nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, eh.ErrModelNotFound
+	} else if status != http.StatusOK {
+		return nil, fmt.Errorf("could not find model: %s", body)
+	}
+
+	var doc struct {
+		Source json.RawMessage `json:"_source"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	model := r.factory()
+	if err := json.Unmarshal(doc.Source, model); err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
+
+// FindAll returns all read models in the repository, up to 10000 models.
+func (r *ReadRepository) FindAll() ([]interface{}, error) {
+	return r.FindAllContext(context.Background())
+}
+
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	res, err := r.search(ctx, map[string]interface{}{
+		"query": map[string]interface{}{"match_all": map[string]interface{}{}},
+		"sort":  []interface{}{"_doc"},
+		"size":  maxResults,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	for _, hit := range res.Hits.Hits {
+		model := r.factory()
+		if err := json.Unmarshal(hit.Source, model); err != nil {
+			return nil, err
+		}
+		result = append(result, model)
+	}
+
+	return result, nil
+}
+
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface. The Go field names in the query are mapped to the JSON names of
+// the model set with SetModel.
+func (r *ReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	offset, err := eh.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	modelType := reflect.TypeOf(r.factory())
+	filter, mustNot, err := filterClauses(modelType, query.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	// Always sort by document order last to get stable pages.
+	sorts := []interface{}{}
+	for _, s := range query.Sorts {
+		name, err := fieldName(modelType, s.Field, true)
+		if err != nil {
+			return nil, err
+		}
+		order := "asc"
+		if s.Descending {
+			order = "desc"
+		}
+		sorts = append(sorts, map[string]interface{}{name: order})
+	}
+	sorts = append(sorts, "_doc")
+
+	size := maxResults - offset
+	if query.Limit > 0 {
+		// Get one extra model to know if there is a next page.
+		size = query.Limit + 1
+	}
+
+	res, err := r.search(context.Background(), map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"filter":   filter,
+			"must_not": mustNot,
+		}},
+		"sort": sorts,
+		"from": offset,
+		"size": size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &eh.QueryResult{Models: []interface{}{}}
+	for _, hit := range res.Hits.Hits {
+		model := r.factory()
+		if err := json.Unmarshal(hit.Source, model); err != nil {
+			return nil, err
+		}
+		result.Models = append(result.Models, model)
+	}
+
+	if query.Limit > 0 && len(result.Models) > query.Limit {
+		result.Models = result.Models[:query.Limit]
+		result.Cursor = eh.EncodeCursor(offset + query.Limit)
+	}
+
+	return result, nil
+}
+
+// Remove removes a read model with id from the repository. Returns
+// ErrModelNotFound if no model could be found.
+func (r *ReadRepository) Remove(id eh.UUID) error {
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+
+	if added, err := r.addToBulk("delete", id, nil); added || err != nil {
+		return err
+	}
+
+	status, body, err := r.request(ctx, "DELETE", r.docPath(id)+r.refreshParam(), nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		return eh.ErrModelNotFound
+	} else if status != http.StatusOK {
+		return fmt.Errorf("could not remove model: %s", body)
+	}
+
+	return nil
+}
+
+// Clear clears the read model database.
+func (r *ReadRepository) Clear() error {
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+
+	status, _, err := r.request(context.Background(), "DELETE", "/"+r.index, nil)
+	if err != nil || (status != http.StatusOK && status != http.StatusNotFound) {
+		return ErrCouldNotClearIndex
+	}
+
+	return r.createIndex()
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that Elasticsearch can be reached.
+func (r *ReadRepository) Start(ctx context.Context) error {
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+
+	status, body, err := r.request(ctx, "GET", "/", nil)
+	if err != nil {
+		return fmt.Errorf("%s: %s", ErrCouldNotConnect, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("%s: %s", ErrCouldNotConnect, body)
+	}
+	return nil
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It gets the health of the cluster,
+// which is unhealthy if its status is red.
+func (r *ReadRepository) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if r.closed.Load() {
+		return eh.Health{}, eh.ErrClosed
+	}
+
+	start := time.Now()
+	status, body, err := r.request(ctx, "GET", "/_cluster/health", nil)
+	health := eh.Health{Latency: time.Since(start)}
+	if err != nil {
+		return health, fmt.Errorf("%s: %s", ErrCouldNotConnect, err)
+	}
+	if status != http.StatusOK {
+		return health, fmt.Errorf("%s: %s", ErrCouldNotConnect, body)
+	}
+
+	var res struct {
+		Status string `json:"status"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return health, fmt.Errorf("%s: %s", ErrCouldNotConnect, err)
+	}
+	health.Details = map[string]interface{}{"cluster_status": res.Status}
+	if res.Status == "red" {
+		return health, ErrClusterUnhealthy
+	}
+	return health, nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It flushes any changes buffered in bulk mode, also for the repositories of
+// tenants, until the context is done. All methods return eventhorizon.ErrClosed
+// after closing.
+func (r *ReadRepository) Close(ctx context.Context) error {
+	r.tenantsMu.Lock()
+	repos := []*ReadRepository{r}
+	for _, tenant := range r.tenants {
+		repos = append(repos, tenant)
+	}
+	r.tenantsMu.Unlock()
+
+	var errs []error
+	for _, repo := range repos {
+		repo.bulkMu.Lock()
+		err := repo.flush(ctx)
+		repo.closed.Store(true)
+		repo.bulkMu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// createIndex creates the index if it does not exist.
+func (r *ReadRepository) createIndex() error {
+	status, body, err := r.request(context.Background(), "PUT", "/"+r.index, nil)
+	if err != nil {
+		return ErrCouldNotCreateIndex
+	}
+	if status != http.StatusOK && !bytes.Contains(body, []byte("resource_already_exists_exception")) {
+		return fmt.Errorf("%s: %s", ErrCouldNotCreateIndex, body)
+	}
+
+	return nil
+}
+
+// addToBulk adds an action to the bulk buffer if in bulk mode, and flushes
+// the buffer when it is full. Returns false if not in bulk mode.
+func (r *ReadRepository) addToBulk(action string, id eh.UUID, data []byte) (bool, error) {
+	r.bulkMu.Lock()
+	defer r.bulkMu.Unlock()
+
+	if r.closed.Load() {
+		return true, eh.ErrClosed
+	}
+	if !r.bulk {
+		return false, nil
+	}
+
+	meta, err := json.Marshal(map[string]interface{}{
+		action: map[string]interface{}{"_index": r.index, "_id": id.String()},
+	})
+	if err != nil {
+		return true, err
+	}
+	r.bulkBody.Write(meta)
+	r.bulkBody.WriteByte('\n')
+	if data != nil {
+		r.bulkBody.Write(data)
+		r.bulkBody.WriteByte('\n')
+	}
+	r.bulkCount++
+
+	if r.bulkCount >= r.bulkSize {
+		return true, r.flush(context.Background())
+	}
+
+	return true, nil
+}
+
+// flush sends the bulk buffer, the bulk lock must be held.
+func (r *ReadRepository) flush(ctx context.Context) error {
+	if r.bulkCount == 0 {
+		return nil
+	}
+
+	status, body, err := r.request(ctx, "POST", "/_bulk"+r.refreshParam(), &r.bulkBody)
+	r.bulkBody.Reset()
+	r.bulkCount = 0
+	if err != nil || status != http.StatusOK {
+		return eh.ErrCouldNotSaveModel
+	}
+
+	var res struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.Errors {
+		return eh.ErrCouldNotSaveModel
+	}
+
+	return nil
+}
+
+// searchResponse is the part of a search response that is used.
+type searchResponse struct {
+	Hits struct {
+		Total json.RawMessage `json:"total"`
+		Hits  []struct {
+			ID        string              `json:"_id"`
+			Score     float64             `json:"_score"`
+			Source    json.RawMessage     `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]struct {
+		Buckets []struct {
+			Key      interface{} `json:"key"`
+			DocCount int         `json:"doc_count"`
+		} `json:"buckets"`
+	} `json:"aggregations"`
+}
+
+// total returns the total number of hits, which is an object since
+// Elasticsearch 7 and a number before that.
+func (res *searchResponse) total() int {
+	var total struct {
+		Value int `json:"value"`
+	}
+	if err := json.Unmarshal(res.Hits.Total, &total); err == nil {
+		return total.Value
+	}
+	var value int
+	json.Unmarshal(res.Hits.Total, &value)
+	return value
+}
+
+func (r *ReadRepository) search(ctx context.Context, query map[string]interface{}) (*searchResponse, error) {
+	data, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	status, body, err := r.request(ctx, "POST", "/"+r.index+"/_search", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", ErrCouldNotSearch, body)
+	}
+
+	res := &searchResponse{}
+	if err := json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (r *ReadRepository) request(ctx context.Context, method, path string, body io.Reader) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		if strings.HasPrefix(path, "/_bulk") {
+			req.Header.Set("Content-Type", "application/x-ndjson")
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	}
+
+	resp, err := r.client.Do(req)
+	if ctx.Err() != nil {
+		return 0, nil, ctx.Err()
+	} else if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, data, nil
+}
+
+func (r *ReadRepository) docPath(id eh.UUID) string {
+	return "/" + r.index + "/_doc/" + id.String()
+}
+
+func (r *ReadRepository) refreshParam() string {
+	if r.refresh {
+		return "?refresh=wait_for"
+	}
+	return ""
+}
+
+// filterClauses maps query filters to the filter and must not clauses of a
+// bool query.
+func filterClauses(t reflect.Type, filters []eh.Filter) ([]interface{}, []interface{}, error) {
+	filter := []interface{}{}
+	mustNot := []interface{}{}
+	for _, f := range filters {
+		name, err := fieldName(t, f.Field, true)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch f.Operator {
+		case eh.OperatorEqual:
+			filter = append(filter, term("term", name, f.Value))
+		case eh.OperatorNotEqual:
+			mustNot = append(mustNot, term("term", name, f.Value))
+		case eh.OperatorIn:
+			filter = append(filter, term("terms", name, f.Value))
+		case eh.OperatorGreaterThan, eh.OperatorGreaterThanOrEqual,
+			eh.OperatorLessThan, eh.OperatorLessThanOrEqual:
+			filter = append(filter, term("range", name, map[string]interface{}{
+				rangeOperators[f.Operator]: f.Value,
+			}))
+		default:
+			return nil, nil, fmt.Errorf("%s: unknown operator %s", eh.ErrInvalidQuery, f.Operator)
+		}
+	}
+	return filter, mustNot, nil
+}
+
+var rangeOperators = map[eh.Operator]string{
+	eh.OperatorGreaterThan:        "gt",
+	eh.OperatorGreaterThanOrEqual: "gte",
+	eh.OperatorLessThan:           "lt",
+	eh.OperatorLessThanOrEqual:    "lte",
+}
+
+func term(kind, field string, value interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		kind: map[string]interface{}{field: value},
+	}
+}
+
+// fieldName maps a (nested) Go field name of the model type to its JSON name.
+// If exact is set the keyword sub field of string fields is used, which holds
+// the exact value instead of the analyzed text.
+func fieldName(t reflect.Type, field string, exact bool) (string, error) {
+	names := []string{}
+	for _, name := range strings.Split(field, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+		}
+		sf, ok := t.FieldByName(name)
+		if !ok {
+			return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+		}
+
+		tag := strings.Split(sf.Tag.Get("json"), ",")[0]
+		switch tag {
+		case "-":
+			return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+		case "":
+			tag = sf.Name
+		}
+		names = append(names, tag)
+		t = sf.Type
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if exact && t.Kind() == reflect.String {
+		names = append(names, "keyword")
+	}
+
+	return strings.Join(names, "."), nil
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/testutil"
+)
+
+func TestReadRepository(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing index")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	t.Log("FindAll with no items")
+	result, err := repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 0 {
+		t.Error("there should be no items:", len(result))
+	}
+
+	t.Log("Save one item")
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+	if err = repo.Save(model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err := repo.Find(model1.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("Save and overwrite with same ID")
+	model1Alt := &mocks.Model{model1.ID, "model1Alt", time.Now().Round(time.Millisecond)}
+	if err = repo.Save(model1Alt.ID, model1Alt); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err = repo.Find(model1Alt.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1Alt) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("Save with another ID")
+	model2 := &mocks.Model{eh.NewUUID(), "model2", time.Now().Round(time.Millisecond)}
+	if err = repo.Save(model2.ID, model2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("FindAll with two items")
+	result, err = repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 2 {
+		t.Error("there should be two items:", len(result))
+	}
+	if (!reflect.DeepEqual(result[0], model1Alt) || !reflect.DeepEqual(result[1], model2)) &&
+		(!reflect.DeepEqual(result[0], model2) || !reflect.DeepEqual(result[1], model1Alt)) {
+		t.Error("the items should be correct:", result)
+	}
+
+	t.Log("Remove one item")
+	if err = repo.Remove(model1Alt.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err = repo.Find(model1Alt.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+
+	t.Log("Remove non-existing item")
+	if err = repo.Remove(model1Alt.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+}
+
+func TestReadRepositorySearch(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing index")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	now := time.Now().Round(time.Millisecond)
+	model1 := &mocks.Model{eh.NewUUID(), "the quick brown fox", now}
+	model2 := &mocks.Model{eh.NewUUID(), "the lazy dog", now.Add(time.Hour)}
+	model3 := &mocks.Model{eh.NewUUID(), "the lazy dog", now.Add(2 * time.Hour)}
+	for _, m := range []*mocks.Model{model1, model2, model3} {
+		if err := repo.Save(m.ID, m); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	t.Log("search with text and highlights")
+	result, err := repo.Search(&SearchQuery{
+		Text:       "fox",
+		Fields:     []string{"Content"},
+		Highlights: []string{"Content"},
+	})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if result.Total != 1 || len(result.Hits) != 1 {
+		t.Fatal("there should be one hit:", result.Total)
+	}
+	if result.Hits[0].ID != model1.ID || !reflect.DeepEqual(result.Hits[0].Model, model1) {
+		t.Error("the hit should be correct:", result.Hits[0])
+	}
+	if h := result.Hits[0].Highlights["Content"]; len(h) != 1 || h[0] != "the quick brown <em>fox</em>" {
+		t.Error("the highlights should be correct:", h)
+	}
+
+	t.Log("search with text and filters")
+	result, err = repo.Search(&SearchQuery{
+		Text: "lazy",
+		Filters: []eh.Filter{
+			{Field: "CreatedAt", Operator: eh.OperatorGreaterThan, Value: now.Add(time.Hour)},
+		},
+	})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result.Hits) != 1 || result.Hits[0].ID != model3.ID {
+		t.Error("the hits should be correct:", result.Hits)
+	}
+
+	t.Log("search with facets")
+	result, err = repo.Search(&SearchQuery{
+		Facets: []string{"Content"},
+	})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result.Hits) != 3 {
+		t.Error("there should be three hits:", len(result.Hits))
+	}
+	expected := []*FacetValue{
+		{Value: "the lazy dog", Count: 2},
+		{Value: "the quick brown fox", Count: 1},
+	}
+	if !reflect.DeepEqual(result.Facets["Content"], expected) {
+		t.Error("the facets should be correct:", result.Facets)
+	}
+
+	t.Log("search with unknown field")
+	if _, err = repo.Search(&SearchQuery{Facets: []string{"Unknown"}}); err == nil {
+		t.Error("there should be an error")
+	}
+}
+
+func TestReadRepositoryBulk(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing index")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	repo.StartBulk(2)
+	for i := 0; i < 5; i++ {
+		model := &mocks.Model{eh.NewUUID(), "model", time.Now().Round(time.Millisecond)}
+		if err := repo.Save(model.ID, model); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	t.Log("FindAll with a partial batch buffered")
+	result, err := repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 4 {
+		t.Error("there should be four items:", len(result))
+	}
+
+	if err := repo.StopBulk(); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("FindAll after stopping the bulk mode")
+	result, err = repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 5 {
+		t.Error("there should be five items:", len(result))
+	}
+}
+
+func TestReadRepositoryQuery(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing index")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.QueryCommonTests(t, repo)
+}
+
+func TestReadRepositoryTenants(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+
+	repoA, repoB := testutil.TenantCommonTests(t, repo)
+
+	t.Log("clearing indices")
+	for _, r := range []eh.ReadRepository{repo, repoA, repoB} {
+		if err := r.(*ReadRepository).Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+}
+
+func TestReadRepositoryContext(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing index")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.ContextCommonTests(t, repo)
+}
+
+func TestReadRepositoryClose(t *testing.T) {
+	defer func() {
+		t.Log("clearing index")
+		repo := newTestReadRepository(t)
+		defer repo.Close(context.Background())
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.CloseCommonTests(t, newTestReadRepository(t))
+}
+
+func newTestReadRepository(t *testing.T) *ReadRepository {
+	// Support Wercker testing with Elasticsearch.
+	host := os.Getenv("ELASTICSEARCH_PORT_9200_TCP_ADDR")
+	port := os.Getenv("ELASTICSEARCH_PORT_9200_TCP_PORT")
+
+	url := "http://localhost:9200"
+	if host != "" && port != "" {
+		url = "http://" + host + ":" + port
+	}
+
+	repo, err := NewReadRepository(url, "test")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	repo.SetModel(func() interface{} {
+		return &mocks.Model{}
+	})
+
+	// Wait for changes to be searchable.
+	repo.SetRefresh(true)
+
+	return repo
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elasticsearch
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// SearchQuery is a full-text search for read models. All field names are Go
+// field names of the model, with dots for nested fields.
+type SearchQuery struct {
+	// Text is matched against the text of the Fields, or all fields if there
+	// are none. An empty text matches all models.
+	Text   string
+	Fields []string
+
+	// Filters are exact filters that the models must match, with the same
+	// operators as eventhorizon.Query.
+	Filters []eh.Filter
+
+	// Facets are fields to count the models per distinct value of.
+	Facets []string
+
+	// Highlights are fields to return highlighted text matches of.
+	Highlights []string
+
+	// From and Size are used to get pages of the results, a size of zero
+	// returns up to 10000 models.
+	From int
+	Size int
+}
+
+// SearchResult is the result of a search.
+type SearchResult struct {
+	// Hits are the matching models, ordered by relevance.
+	Hits []*SearchHit
+
+	// Total is the number of matching models, including other pages.
+	Total int
+
+	// Facets are the counts per value of the facet fields.
+	Facets map[string][]*FacetValue
+}
+
+// SearchHit is a matching model in a search result.
+type SearchHit struct {
+	ID    eh.UUID
+	Model interface{}
+	Score float64
+
+	// Highlights are the highlighted fragments of matching text per field,
+	// where the matches are wrapped in <em> tags.
+	Highlights map[string][]string
+}
+
+// FacetValue is the number of models with a value of a facet field.
+type FacetValue struct {
+	Value interface{}
+	Count int
+}
+
+// Search searches the read models with a full-text query.
+func (r *ReadRepository) Search(query *SearchQuery) (*SearchResult, error) {
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	modelType := reflect.TypeOf(r.factory())
+
+	must := []interface{}{}
+	if query.Text != "" {
+		match := map[string]interface{}{"query": query.Text}
+		if len(query.Fields) > 0 {
+			fields := []string{}
+			for _, f := range query.Fields {
+				name, err := fieldName(modelType, f, false)
+				if err != nil {
+					return nil, err
+				}
+				fields = append(fields, name)
+			}
+			match["fields"] = fields
+		}
+		must = append(must, map[string]interface{}{"multi_match": match})
+	}
+
+	filter, mustNot, err := filterClauses(modelType, query.Filters)
+	if err != nil {
+		return nil, err
+	}
+
+	size := query.Size
+	if size == 0 {
+		size = maxResults - query.From
+	}
+
+	body := map[string]interface{}{
+		"query": map[string]interface{}{"bool": map[string]interface{}{
+			"must":     must,
+			"filter":   filter,
+			"must_not": mustNot,
+		}},
+		"from": query.From,
+		"size": size,
+	}
+
+	if len(query.Facets) > 0 {
+		aggs := map[string]interface{}{}
+		for _, f := range query.Facets {
+			name, err := fieldName(modelType, f, true)
+			if err != nil {
+				return nil, err
+			}
+			aggs[f] = map[string]interface{}{
+				"terms": map[string]interface{}{"field": name},
+			}
+		}
+		body["aggs"] = aggs
+	}
+
+	// Highlights are returned by JSON name, map them back to Go field names.
+	highlightFields := map[string]string{}
+	if len(query.Highlights) > 0 {
+		fields := map[string]interface{}{}
+		for _, f := range query.Highlights {
+			name, err := fieldName(modelType, f, false)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = map[string]interface{}{}
+			highlightFields[name] = f
+		}
+		body["highlight"] = map[string]interface{}{"fields": fields}
+	}
+
+	res, err := r.search(context.Background(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SearchResult{
+		Hits:   []*SearchHit{},
+		Total:  res.total(),
+		Facets: map[string][]*FacetValue{},
+	}
+	for _, hit := range res.Hits.Hits {
+		model := r.factory()
+		if err := json.Unmarshal(hit.Source, model); err != nil {
+			return nil, err
+		}
+
+		highlights := map[string][]string{}
+		for name, fragments := range hit.Highlight {
+			highlights[highlightFields[name]] = fragments
+		}
+
+		result.Hits = append(result.Hits, &SearchHit{
+			ID:         eh.UUID(hit.ID),
+			Model:      model,
+			Score:      hit.Score,
+			Highlights: highlights,
+		})
+	}
+	for _, f := range query.Facets {
+		values := []*FacetValue{}
+		for _, b := range res.Aggregations[f].Buckets {
+			values = append(values, &FacetValue{Value: b.Key, Count: b.DocCount})
+		}
+		result.Facets[f] = values
+	}
+
+	return result, nil
+}
@@ -15,16 +15,30 @@
 package memory
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"sync"
 
 	eh "github.com/looplab/eventhorizon"
 )
 
+// ErrModelNotSet is when an model is not set on a read repository.
+var ErrModelNotSet = errors.New("model not set")
+
 // ReadRepository implements an in memory repository of read models.
 type ReadRepository struct {
 	allData  []interface{}
 	dataByID map[eh.UUID]interface{}
+	versions map[eh.UUID]int
 	dataMu   sync.RWMutex
+	factory  func() interface{}
+
+	// closed is set when the repository is closed, guarded by dataMu.
+	closed bool
+
+	tenants   map[eh.TenantID]*ReadRepository
+	tenantsMu sync.Mutex
 }
 
 // NewReadRepository creates a new ReadRepository.
@@ -32,15 +46,154 @@ func NewReadRepository() *ReadRepository {
 	r := &ReadRepository{
 		allData:  make([]interface{}, 0),
 		dataByID: make(map[eh.UUID]interface{}),
+		versions: make(map[eh.UUID]int),
+		tenants:  make(map[eh.TenantID]*ReadRepository),
 	}
 	return r
 }
 
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. Each tenant has its own
+// separate memory repository, with the same model factory.
+func (r *ReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.dataMu.RLock()
+	closed := r.closed
+	r.dataMu.RUnlock()
+	if closed {
+		return nil, eh.ErrClosed
+	}
+
+	r.tenantsMu.Lock()
+	defer r.tenantsMu.Unlock()
+
+	if _, ok := r.tenants[tenantID]; !ok {
+		tenant := NewReadRepository()
+		tenant.SetModel(r.factory)
+		r.tenants[tenantID] = tenant
+	}
+
+	return r.tenants[tenantID], nil
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// The repository is in memory and needs no starting.
+func (r *ReadRepository) Start(ctx context.Context) error {
+	return nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// The repository is in memory and has nothing to release, but all methods
+// return eventhorizon.ErrClosed after closing. The repositories of tenants are
+// also closed.
+func (r *ReadRepository) Close(ctx context.Context) error {
+	r.dataMu.Lock()
+	r.closed = true
+	r.dataMu.Unlock()
+
+	r.tenantsMu.Lock()
+	defer r.tenantsMu.Unlock()
+
+	for _, tenant := range r.tenants {
+		tenant.Close(ctx)
+	}
+	return nil
+}
+
 // Save saves a read model with id to the repository.
 func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
+	return r.SaveContext(context.Background(), id, model)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+
+	if r.closed {
+		return eh.ErrClosed
+	}
+
+	return r.save(id, model)
+}
+
+// CompareAndSave implements the CompareAndSave method of the
+// eventhorizon.VersionedReadRepository interface.
+func (r *ReadRepository) CompareAndSave(id eh.UUID, model eh.Versionable) error {
 	r.dataMu.Lock()
 	defer r.dataMu.Unlock()
 
+	if r.closed {
+		return eh.ErrClosed
+	}
+
+	return r.compareAndSave(id, model)
+}
+
+// Update implements the Update method of the eventhorizon.VersionedReadRepository
+// interface. The update is done atomically on a copy of the saved model, and is
+// thus never retried. Returns ErrModelNotSet if a new model is needed but no
+// model factory is set.
+func (r *ReadRepository) Update(id eh.UUID, update func(interface{}) error) error {
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+
+	if r.closed {
+		return eh.ErrClosed
+	}
+
+	var model interface{}
+	if m, ok := r.dataByID[id]; ok {
+		model = copyModel(m)
+	} else if r.factory != nil {
+		model = r.factory()
+	} else {
+		return ErrModelNotSet
+	}
+
+	m, ok := model.(eh.Versionable)
+	if !ok {
+		return eh.ErrModelNotVersionable
+	}
+
+	if err := update(model); err != nil {
+		return err
+	}
+
+	return r.compareAndSave(id, m)
+}
+
+// SetModel sets a factory function that creates concrete model types, used
+// by Update to create new models.
+func (r *ReadRepository) SetModel(factory func() interface{}) {
+	r.factory = factory
+}
+
+func (r *ReadRepository) compareAndSave(id eh.UUID, model eh.Versionable) error {
+	version := model.ModelVersion()
+	if r.versions[id] != version {
+		return eh.ErrIncorrectModelVersion
+	}
+
+	// Save a copy to not share the versioned model with the caller.
+	model.SetModelVersion(version + 1)
+	if err := r.save(id, copyModel(model)); err != nil {
+		model.SetModelVersion(version)
+		return err
+	}
+
+	return nil
+}
+
+func (r *ReadRepository) save(id eh.UUID, model interface{}) error {
 	if oldModel, ok := r.dataByID[id]; ok {
 		// Find index and overwrite in allData.
 		index := r.indexOfModel(oldModel)
@@ -54,6 +207,9 @@ func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
 	}
 
 	r.dataByID[id] = model
+	if m, ok := model.(eh.Versionable); ok {
+		r.versions[id] = m.ModelVersion()
+	}
 
 	return nil
 }
@@ -61,9 +217,23 @@ func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
 // Find returns one read model with using an id. Returns
 // ErrModelNotFound if no model could be found.
 func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	r.dataMu.RLock()
 	defer r.dataMu.RUnlock()
 
+	if r.closed {
+		return nil, eh.ErrClosed
+	}
+
 	if model, ok := r.dataByID[id]; ok {
 		return model, nil
 	}
@@ -73,20 +243,49 @@ func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
 
 // FindAll returns all read models in the repository.
 func (r *ReadRepository) FindAll() ([]interface{}, error) {
+	return r.FindAllContext(context.Background())
+}
+
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	r.dataMu.RLock()
 	defer r.dataMu.RUnlock()
 
+	if r.closed {
+		return nil, eh.ErrClosed
+	}
+
 	return r.allData, nil
 }
 
 // Remove removes a read model with id from the repository. Returns
 // ErrModelNotFound if no model could be found.
 func (r *ReadRepository) Remove(id eh.UUID) error {
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	r.dataMu.Lock()
 	defer r.dataMu.Unlock()
 
+	if r.closed {
+		return eh.ErrClosed
+	}
+
 	if model, ok := r.dataByID[id]; ok {
 		delete(r.dataByID, id)
+		delete(r.versions, id)
 
 		// Find index and remove from allData.
 		index := r.indexOfModel(model)
@@ -101,6 +300,19 @@ func (r *ReadRepository) Remove(id eh.UUID) error {
 	return eh.ErrModelNotFound
 }
 
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface. Fields are matched by their Go struct field names.
+func (r *ReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	r.dataMu.RLock()
+	defer r.dataMu.RUnlock()
+
+	if r.closed {
+		return nil, eh.ErrClosed
+	}
+
+	return query.Apply(r.allData)
+}
+
 func (r *ReadRepository) indexOfModel(model interface{}) int {
 	for i, m := range r.allData {
 		if m == model {
@@ -109,3 +321,16 @@ func (r *ReadRepository) indexOfModel(model interface{}) int {
 	}
 	return -1
 }
+
+// copyModel returns a shallow copy of a model that is a pointer to a struct,
+// other models are returned as is.
+func copyModel(model interface{}) interface{} {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return model
+	}
+
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	return c.Interface()
+}
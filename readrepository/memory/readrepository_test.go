@@ -21,6 +21,7 @@ import (
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/testutil"
 )
 
 func TestReadRepository(t *testing.T) {
@@ -125,3 +126,51 @@ func TestReadRepository(t *testing.T) {
 		t.Error("there should be a ErrModelNotFound error:", err)
 	}
 }
+
+func TestReadRepositoryQuery(t *testing.T) {
+	repo := NewReadRepository()
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	testutil.QueryCommonTests(t, repo)
+}
+
+func TestReadRepositoryVersioned(t *testing.T) {
+	repo := NewReadRepository()
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+	repo.SetModel(func() interface{} {
+		return &mocks.VersionedModel{}
+	})
+
+	testutil.VersionedCommonTests(t, repo)
+}
+
+func TestReadRepositoryTenants(t *testing.T) {
+	repo := NewReadRepository()
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	testutil.TenantCommonTests(t, repo)
+}
+
+func TestReadRepositoryContext(t *testing.T) {
+	repo := NewReadRepository()
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	testutil.ContextCommonTests(t, repo)
+}
+
+func TestReadRepositoryClose(t *testing.T) {
+	repo := NewReadRepository()
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	testutil.CloseCommonTests(t, repo)
+}
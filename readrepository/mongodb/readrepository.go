@@ -15,9 +15,16 @@
 package mongodb
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
 
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 
 	eh "github.com/looplab/eventhorizon"
 )
@@ -40,6 +47,7 @@ var ErrInvalidQuery = errors.New("invalid query")
 // ReadRepository implements an MongoDB repository of read models.
 type ReadRepository struct {
 	session    *mgo.Session
+	guard      *sessionGuard
 	db         string
 	collection string
 	factory    func() interface{}
@@ -66,6 +74,7 @@ func NewReadRepositoryWithSession(session *mgo.Session, database, collection str
 
 	r := &ReadRepository{
 		session:    session,
+		guard:      &sessionGuard{},
 		db:         database,
 		collection: collection,
 	}
@@ -75,7 +84,21 @@ func NewReadRepositoryWithSession(session *mgo.Session, database, collection str
 
 // Save saves a read model with id to the repository.
 func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
-	sess := r.session.Copy()
+	return r.SaveContext(context.Background(), id, model)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface. The deadline of the context
+// is used as socket timeout, as mgo can not cancel operations.
+func (r *ReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := r.copySession(ctx)
+	if err != nil {
+		return err
+	}
 	defer sess.Close()
 
 	if _, err := sess.DB(r.db).C(r.collection).UpsertId(id, model); err != nil {
@@ -84,10 +107,76 @@ func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
 	return nil
 }
 
+// CompareAndSave implements the CompareAndSave method of the
+// eventhorizon.VersionedReadRepository interface. The model must store its
+// version in the BSON field "version".
+func (r *ReadRepository) CompareAndSave(id eh.UUID, model eh.Versionable) error {
+	sess, err := r.copySession(context.Background())
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	version := model.ModelVersion()
+	model.SetModelVersion(version + 1)
+
+	// New models are inserted if there is no model with the id, which fails
+	// with a duplicate key error if another version was saved.
+	c := sess.DB(r.db).C(r.collection)
+	if version == 0 {
+		_, err = c.Upsert(bson.M{
+			"_id":     id,
+			"version": bson.M{"$in": []interface{}{0, nil}},
+		}, model)
+		if mgo.IsDup(err) {
+			err = eh.ErrIncorrectModelVersion
+		}
+	} else {
+		err = c.Update(bson.M{"_id": id, "version": version}, model)
+		if err == mgo.ErrNotFound {
+			err = eh.ErrIncorrectModelVersion
+		}
+	}
+
+	if err != nil {
+		model.SetModelVersion(version)
+		if err != eh.ErrIncorrectModelVersion {
+			return eh.ErrCouldNotSaveModel
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Update implements the Update method of the eventhorizon.VersionedReadRepository
+// interface, by retrying CompareAndSave when the model was changed.
+func (r *ReadRepository) Update(id eh.UUID, update func(interface{}) error) error {
+	if r.factory == nil {
+		return ErrModelNotSet
+	}
+
+	return eh.UpdateWithRetry(r, r.factory, id, update)
+}
+
 // Find returns one read model with using an id. Returns
 // ErrModelNotFound if no model could be found.
 func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
-	sess := r.session.Copy()
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface. The deadline of the context
+// is used as socket timeout, as mgo can not cancel operations.
+func (r *ReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sess, err := r.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer sess.Close()
 
 	if r.factory == nil {
@@ -95,7 +184,7 @@ func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
 	}
 
 	model := r.factory()
-	err := sess.DB(r.db).C(r.collection).FindId(id).One(model)
+	err = sess.DB(r.db).C(r.collection).FindId(id).One(model)
 	if err != nil {
 		return nil, eh.ErrModelNotFound
 	}
@@ -109,7 +198,10 @@ func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
 // the same query in FindCustom. Expect a ErrInvalidQuery if returning a nil
 // query from the callback.
 func (r *ReadRepository) FindCustom(callback func(*mgo.Collection) *mgo.Query) ([]interface{}, error) {
-	sess := r.session.Copy()
+	sess, err := r.copySession(context.Background())
+	if err != nil {
+		return nil, err
+	}
 	defer sess.Close()
 
 	if r.factory == nil {
@@ -138,7 +230,21 @@ func (r *ReadRepository) FindCustom(callback func(*mgo.Collection) *mgo.Query) (
 
 // FindAll returns all read models in the repository.
 func (r *ReadRepository) FindAll() ([]interface{}, error) {
-	sess := r.session.Copy()
+	return r.FindAllContext(context.Background())
+}
+
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface. The deadline of the context
+// is used as socket timeout, as mgo can not cancel operations.
+func (r *ReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	sess, err := r.copySession(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer sess.Close()
 
 	if r.factory == nil {
@@ -159,13 +265,141 @@ func (r *ReadRepository) FindAll() ([]interface{}, error) {
 	return result, nil
 }
 
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface. The Go field names in the query are mapped to the BSON names of
+// the model set with SetModel.
+func (r *ReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	sess, err := r.copySession(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	offset, err := eh.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	modelType := reflect.TypeOf(r.factory())
+	conditions := []bson.M{}
+	for _, f := range query.Filters {
+		name, err := bsonName(modelType, f.Field)
+		if err != nil {
+			return nil, err
+		}
+		op, ok := mongoOperators[f.Operator]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown operator %s", eh.ErrInvalidQuery, f.Operator)
+		}
+		conditions = append(conditions, bson.M{name: bson.M{op: f.Value}})
+	}
+	selector := bson.M{}
+	if len(conditions) > 0 {
+		selector["$and"] = conditions
+	}
+
+	// Always sort by ID last to get stable pages.
+	sorts := []string{}
+	for _, s := range query.Sorts {
+		name, err := bsonName(modelType, s.Field)
+		if err != nil {
+			return nil, err
+		}
+		if s.Descending {
+			name = "-" + name
+		}
+		sorts = append(sorts, name)
+	}
+	sorts = append(sorts, "_id")
+
+	q := sess.DB(r.db).C(r.collection).Find(selector).Sort(sorts...).Skip(offset)
+	if query.Limit > 0 {
+		// Get one extra model to know if there is a next page.
+		q = q.Limit(query.Limit + 1)
+	}
+
+	iter := q.Iter()
+	result := &eh.QueryResult{Models: []interface{}{}}
+	model := r.factory()
+	for iter.Next(model) {
+		result.Models = append(result.Models, model)
+		model = r.factory()
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	if query.Limit > 0 && len(result.Models) > query.Limit {
+		result.Models = result.Models[:query.Limit]
+		result.Cursor = eh.EncodeCursor(offset + query.Limit)
+	}
+
+	return result, nil
+}
+
+var mongoOperators = map[eh.Operator]string{
+	eh.OperatorEqual:              "$eq",
+	eh.OperatorNotEqual:           "$ne",
+	eh.OperatorGreaterThan:        "$gt",
+	eh.OperatorGreaterThanOrEqual: "$gte",
+	eh.OperatorLessThan:           "$lt",
+	eh.OperatorLessThanOrEqual:    "$lte",
+	eh.OperatorIn:                 "$in",
+}
+
+// bsonName maps a (nested) Go field name of the model type to its BSON name.
+func bsonName(t reflect.Type, field string) (string, error) {
+	names := []string{}
+	for _, name := range strings.Split(field, ".") {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+		}
+		sf, ok := t.FieldByName(name)
+		if !ok {
+			return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+		}
+
+		tag := strings.Split(sf.Tag.Get("bson"), ",")[0]
+		switch tag {
+		case "-":
+			return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+		case "":
+			tag = strings.ToLower(sf.Name)
+		}
+		names = append(names, tag)
+		t = sf.Type
+	}
+	return strings.Join(names, "."), nil
+}
+
 // Remove removes a read model with id from the repository. Returns
 // ErrModelNotFound if no model could be found.
 func (r *ReadRepository) Remove(id eh.UUID) error {
-	sess := r.session.Copy()
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface. The deadline of the context
+// is used as socket timeout, as mgo can not cancel operations.
+func (r *ReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := r.copySession(ctx)
+	if err != nil {
+		return err
+	}
 	defer sess.Close()
 
-	err := sess.DB(r.db).C(r.collection).RemoveId(id)
+	err = sess.DB(r.db).C(r.collection).RemoveId(id)
 	if err != nil {
 		return eh.ErrModelNotFound
 	}
@@ -183,15 +417,97 @@ func (r *ReadRepository) SetDB(db string) {
 	r.db = db
 }
 
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. The models of a tenant are
+// stored in a separate database, named as the database of the repository with
+// the tenant ID as suffix. The repository of the tenant shares the session and
+// should not be closed.
+func (r *ReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	tenant := *r
+	tenant.db = r.db + "_" + string(tenantID)
+	return &tenant, nil
+}
+
 // Clear clears the read model database.
 func (r *ReadRepository) Clear() error {
-	if err := r.session.DB(r.db).C(r.collection).DropCollection(); err != nil {
+	sess, err := r.copySession(context.Background())
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	if err := sess.DB(r.db).C(r.collection).DropCollection(); err != nil {
 		return ErrCouldNotClearDB
 	}
 	return nil
 }
 
-// Close closes a database session.
-func (r *ReadRepository) Close() {
-	r.session.Close()
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that the database can be reached.
+func (r *ReadRepository) Start(ctx context.Context) error {
+	return r.ping(ctx)
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It pings the database.
+func (r *ReadRepository) CheckHealth(ctx context.Context) (eh.Health, error) {
+	start := time.Now()
+	err := r.ping(ctx)
+	return eh.Health{Latency: time.Since(start)}, err
+}
+
+func (r *ReadRepository) ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	sess, err := r.copySession(ctx)
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+
+	return sess.Ping()
+}
+
+// copySession copies the session, with the time left until the deadline of
+// the context as socket timeout. Returns eventhorizon.ErrClosed after closing.
+func (r *ReadRepository) copySession(ctx context.Context) (*mgo.Session, error) {
+	r.guard.RLock()
+	defer r.guard.RUnlock()
+
+	if r.guard.closed {
+		return nil, eh.ErrClosed
+	}
+
+	sess := r.session.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		sess.SetSocketTimeout(time.Until(deadline))
+	}
+	return sess, nil
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the database session, after which all methods return
+// eventhorizon.ErrClosed.
+func (r *ReadRepository) Close(ctx context.Context) error {
+	r.guard.Lock()
+	defer r.guard.Unlock()
+
+	if !r.guard.closed {
+		r.guard.closed = true
+		r.session.Close()
+	}
+	return nil
+}
+
+// sessionGuard guards the session, which is shared with the tenants, from
+// being used after it is closed.
+type sessionGuard struct {
+	sync.RWMutex
+	closed bool
 }
@@ -15,6 +15,7 @@
 package mongodb
 
 import (
+	"context"
 	"os"
 	"reflect"
 	"testing"
@@ -25,6 +26,7 @@ import (
 
 	eh "github.com/looplab/eventhorizon"
 	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/testutil"
 )
 
 func TestReadRepository(t *testing.T) {
@@ -49,7 +51,7 @@ func TestReadRepository(t *testing.T) {
 		return &mocks.Model{}
 	})
 
-	defer repo.Close()
+	defer repo.Close(context.Background())
 	defer func() {
 		t.Log("clearing collection")
 		if err = repo.Clear(); err != nil {
@@ -190,3 +192,157 @@ func TestReadRepository(t *testing.T) {
 		t.Error("there should be a ErrModelNotFound error:", err)
 	}
 }
+
+func TestReadRepositoryQuery(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
+	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+
+	url := "localhost"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	repo, err := NewReadRepository(url, "test", "mocks.TestModel")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	repo.SetModel(func() interface{} {
+		return &mocks.Model{}
+	})
+
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.QueryCommonTests(t, repo)
+}
+
+func TestReadRepositoryVersioned(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
+	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+
+	url := "localhost"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	repo, err := NewReadRepository(url, "test", "mocks.VersionedModel")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	repo.SetModel(func() interface{} {
+		return &mocks.VersionedModel{}
+	})
+
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.VersionedCommonTests(t, repo)
+}
+
+func TestReadRepositoryTenants(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
+	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+
+	url := "localhost"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	repo, err := NewReadRepository(url, "test", "mocks.TestModel")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	repo.SetModel(func() interface{} {
+		return &mocks.Model{}
+	})
+
+	defer repo.Close(context.Background())
+
+	repoA, repoB := testutil.TenantCommonTests(t, repo)
+
+	t.Log("clearing collections")
+	for _, r := range []eh.ReadRepository{repo, repoA, repoB} {
+		if err = r.(*ReadRepository).Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+}
+
+func TestReadRepositoryContext(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
+	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+
+	url := "localhost"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	repo, err := NewReadRepository(url, "test", "mocks.TestModel")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	repo.SetModel(func() interface{} {
+		return &mocks.Model{}
+	})
+
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing collection")
+		if err = repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.ContextCommonTests(t, repo)
+}
+
+func TestReadRepositoryClose(t *testing.T) {
+	// Support Wercker testing with MongoDB.
+	host := os.Getenv("MONGO_PORT_27017_TCP_ADDR")
+	port := os.Getenv("MONGO_PORT_27017_TCP_PORT")
+
+	url := "localhost"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	newRepo := func() *ReadRepository {
+		repo, err := NewReadRepository(url, "test", "mocks.TestModel")
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		repo.SetModel(func() interface{} {
+			return &mocks.Model{}
+		})
+		return repo
+	}
+
+	defer func() {
+		t.Log("clearing collection")
+		repo := newRepo()
+		defer repo.Close(context.Background())
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.CloseCommonTests(t, newRepo())
+}
@@ -0,0 +1,515 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/codec/bson"
+)
+
+// ErrModelNotSet is when an model is not set on a read repository.
+var ErrModelNotSet = errors.New("model not set")
+
+// ErrCouldNotMarshalModel is when a model could not be marshaled.
+var ErrCouldNotMarshalModel = errors.New("could not marshal model")
+
+// ErrCouldNotUnmarshalModel is when a model could not be unmarshaled.
+var ErrCouldNotUnmarshalModel = errors.New("could not unmarshal model")
+
+// ErrIndexNotFound is when a lookup is done on a field without an index.
+var ErrIndexNotFound = errors.New("index not found")
+
+// ErrInvalidIndex is when an indexed field does not exist in a model.
+var ErrInvalidIndex = errors.New("invalid index")
+
+// saveRetries is the number of times a save is retried if the indexes were
+// changed concurrently.
+const saveRetries = 10
+
+// ReadRepository implements a Redis repository of read models. Models are
+// stored with a codec, optionally with a TTL, and can be looked up by the
+// values of indexed fields.
+type ReadRepository struct {
+	pool       *redis.Pool
+	appID      string
+	collection string
+	prefix     string
+	factory    func() interface{}
+
+	// codec is used to encode and decode the models.
+	codec eh.Codec
+
+	// ttl is the default time to live of saved models, zero for no expiry.
+	ttl time.Duration
+
+	// indexes are the Go field names of the indexed fields.
+	indexes []string
+
+	// closed is shared with the repositories of tenants, which use the same
+	// pool.
+	closed *atomic.Bool
+}
+
+// NewReadRepository creates a new ReadRepository.
+func NewReadRepository(appID, collection, server, password string) (*ReadRepository, error) {
+	pool := &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			c, err := redis.Dial("tcp", server)
+			if err != nil {
+				return nil, err
+			}
+			if password != "" {
+				if _, err := c.Do("AUTH", password); err != nil {
+					c.Close()
+					return nil, err
+				}
+			}
+			return c, err
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	return NewReadRepositoryWithPool(appID, collection, pool)
+}
+
+// NewReadRepositoryWithPool creates a new ReadRepository with a pool.
+func NewReadRepositoryWithPool(appID, collection string, pool *redis.Pool) (*ReadRepository, error) {
+	r := &ReadRepository{
+		pool:       pool,
+		closed:     &atomic.Bool{},
+		appID:      appID,
+		collection: collection,
+		prefix:     appID + ":readmodels:" + collection + ":",
+		codec:      bson.NewCodec(),
+	}
+
+	return r, nil
+}
+
+// SetModel sets a factory function that creates concrete model types.
+func (r *ReadRepository) SetModel(factory func() interface{}) {
+	r.factory = factory
+}
+
+// SetCodec sets the codec to use for the models, the default is BSON.
+func (r *ReadRepository) SetCodec(codec eh.Codec) {
+	r.codec = codec
+}
+
+// SetTTL sets the default time to live for saved models, zero means that the
+// models never expire.
+func (r *ReadRepository) SetTTL(ttl time.Duration) {
+	r.ttl = ttl
+}
+
+// AddIndex adds a secondary index for a field of the model, using its Go
+// field name. Only models saved after adding the index are indexed.
+func (r *ReadRepository) AddIndex(field string) {
+	r.indexes = append(r.indexes, field)
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. The keys of the models of
+// a tenant are prefixed with both the app ID and the tenant ID. The repository
+// of a tenant shares the pool and settings of this repository, and should not
+// be closed.
+func (r *ReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	tenant := *r
+	tenant.prefix = r.appID + ":tenants:" + string(tenantID) + ":readmodels:" + r.collection + ":"
+	tenant.indexes = append([]string{}, r.indexes...)
+	return &tenant, nil
+}
+
+// Save saves a read model with id to the repository, using the default TTL.
+func (r *ReadRepository) Save(id eh.UUID, model interface{}) error {
+	return r.SaveContext(context.Background(), id, model)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface, using the default TTL.
+func (r *ReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	return r.saveWithTTL(ctx, id, model, r.ttl)
+}
+
+// SaveWithTTL saves a read model with id to the repository, which expires
+// after the TTL. A zero TTL means that the model never expires.
+func (r *ReadRepository) SaveWithTTL(id eh.UUID, model interface{}, ttl time.Duration) error {
+	return r.saveWithTTL(context.Background(), id, model, ttl)
+}
+
+func (r *ReadRepository) saveWithTTL(ctx context.Context, id eh.UUID, model interface{}, ttl time.Duration) error {
+	data, err := r.codec.Marshal(model)
+	if err != nil {
+		return ErrCouldNotMarshalModel
+	}
+
+	values, err := r.indexValues(model)
+	if err != nil {
+		return err
+	}
+
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Watch the indexed values of the model to be able to update the indexes
+	// atomically, retry if they were changed concurrently.
+	for i := 0; i < saveRetries; i++ {
+		if _, err := conn.Do("WATCH", r.indexedKey(id)); err != nil {
+			return err
+		}
+		oldValues, err := redis.StringMap(conn.Do("HGETALL", r.indexedKey(id)))
+		if err != nil {
+			conn.Do("UNWATCH")
+			return err
+		}
+
+		conn.Send("MULTI")
+		if ttl > 0 {
+			conn.Send("SET", r.modelKey(id), data, "PX", int64(ttl/time.Millisecond))
+		} else {
+			conn.Send("SET", r.modelKey(id), data)
+		}
+		conn.Send("SADD", r.idsKey(), id.String())
+		for field, value := range oldValues {
+			conn.Send("SREM", r.indexKey(field, value), id.String())
+		}
+		conn.Send("DEL", r.indexedKey(id))
+		if len(values) > 0 {
+			for field, value := range values {
+				conn.Send("SADD", r.indexKey(field, value), id.String())
+			}
+			conn.Send("HMSET", redis.Args{}.Add(r.indexedKey(id)).AddFlat(values)...)
+			if ttl > 0 {
+				conn.Send("PEXPIRE", r.indexedKey(id), int64(ttl/time.Millisecond))
+			}
+		}
+
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return eh.ErrCouldNotSaveModel
+		}
+		if reply != nil {
+			return nil
+		}
+	}
+
+	return eh.ErrCouldNotSaveModel
+}
+
+// Find returns one read model with using an id. Returns
+// ErrModelNotFound if no model could be found.
+func (r *ReadRepository) Find(id eh.UUID) (interface{}, error) {
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", r.modelKey(id)))
+	if err == redis.ErrNil {
+		return nil, eh.ErrModelNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	model := r.factory()
+	if err := r.codec.Unmarshal(data, model); err != nil {
+		return nil, ErrCouldNotUnmarshalModel
+	}
+
+	return model, nil
+}
+
+// FindAll returns all read models in the repository, in no specific order.
+func (r *ReadRepository) FindAll() ([]interface{}, error) {
+	return r.FindAllContext(context.Background())
+}
+
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	return r.findInSet(ctx, r.idsKey())
+}
+
+// FindByIndex returns all read models with a value of an indexed field, in no
+// specific order. Returns ErrIndexNotFound if the field is not indexed.
+func (r *ReadRepository) FindByIndex(field string, value interface{}) ([]interface{}, error) {
+	for _, index := range r.indexes {
+		if index == field {
+			return r.findInSet(context.Background(), r.indexKey(field, fmt.Sprint(value)))
+		}
+	}
+
+	return nil, ErrIndexNotFound
+}
+
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface. The query is run in memory on all models.
+func (r *ReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	models, err := r.FindAll()
+	if err != nil {
+		return nil, err
+	}
+
+	return query.Apply(models)
+}
+
+// Remove removes a read model with id from the repository. Returns
+// ErrModelNotFound if no model could be found.
+func (r *ReadRepository) Remove(id eh.UUID) error {
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *ReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	values, err := redis.StringMap(conn.Do("HGETALL", r.indexedKey(id)))
+	if err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", r.modelKey(id))
+	conn.Send("SREM", r.idsKey(), id.String())
+	for field, value := range values {
+		conn.Send("SREM", r.indexKey(field, value), id.String())
+	}
+	conn.Send("DEL", r.indexedKey(id))
+	reply, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+
+	if n, _ := redis.Int(reply[0], nil); n == 0 {
+		return eh.ErrModelNotFound
+	}
+
+	return nil
+}
+
+// Clear clears the read model database.
+func (r *ReadRepository) Clear() error {
+	conn, err := r.conn(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	keys, err := redis.Strings(conn.Do("KEYS", r.prefix+"*"))
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err = conn.Do("DEL", redis.Args{}.AddFlat(keys)...)
+	return err
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that Redis can be reached.
+func (r *ReadRepository) Start(ctx context.Context) error {
+	return r.ping(ctx)
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It pings Redis.
+func (r *ReadRepository) CheckHealth(ctx context.Context) (eh.Health, error) {
+	start := time.Now()
+	err := r.ping(ctx)
+	return eh.Health{Latency: time.Since(start)}, err
+}
+
+func (r *ReadRepository) ping(ctx context.Context) error {
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("PING")
+	return err
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the connection pool, which is shared with the repositories of
+// tenants, after which all methods return eventhorizon.ErrClosed.
+func (r *ReadRepository) Close(ctx context.Context) error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	return r.pool.Close()
+}
+
+// findInSet returns the models with the IDs in a set. IDs of models that have
+// expired are removed from the set.
+func (r *ReadRepository) findInSet(ctx context.Context, key string) ([]interface{}, error) {
+	if r.factory == nil {
+		return nil, ErrModelNotSet
+	}
+
+	conn, err := r.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", key))
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return []interface{}{}, nil
+	}
+
+	args := redis.Args{}
+	for _, id := range ids {
+		args = args.Add(r.modelKey(eh.UUID(id)))
+	}
+	data, err := redis.ByteSlices(conn.Do("MGET", args...))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []interface{}{}
+	for i, d := range data {
+		if d == nil {
+			conn.Do("SREM", key, ids[i])
+			continue
+		}
+
+		model := r.factory()
+		if err := r.codec.Unmarshal(d, model); err != nil {
+			return nil, ErrCouldNotUnmarshalModel
+		}
+		result = append(result, model)
+	}
+
+	return result, nil
+}
+
+// conn gets a connection from the pool, waiting at most until the context is
+// done, or returns eventhorizon.ErrClosed after closing. Commands on the connection fail when the context is done, and use the
+// time left until the deadline of the context as timeout.
+func (r *ReadRepository) conn(ctx context.Context) (redis.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	conn, err := r.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return contextConn{conn, ctx}, nil
+}
+
+// contextConn is a connection that runs commands with a context.
+type contextConn struct {
+	redis.Conn
+	ctx context.Context
+}
+
+// Do implements the Do method of the redis.Conn interface.
+func (c contextConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if err := c.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if deadline, ok := c.ctx.Deadline(); ok {
+		return redis.DoWithTimeout(c.Conn, time.Until(deadline), cmd, args...)
+	}
+	return c.Conn.Do(cmd, args...)
+}
+
+// indexValues returns the values of the indexed fields of a model.
+func (r *ReadRepository) indexValues(model interface{}) (map[string]string, error) {
+	values := map[string]string{}
+	if len(r.indexes) == 0 {
+		return values, nil
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() != reflect.Struct {
+		return nil, ErrInvalidIndex
+	}
+	for _, field := range r.indexes {
+		f := v.FieldByName(field)
+		if !f.IsValid() {
+			return nil, ErrInvalidIndex
+		}
+		values[field] = fmt.Sprint(f.Interface())
+	}
+
+	return values, nil
+}
+
+func (r *ReadRepository) modelKey(id eh.UUID) string {
+	return r.prefix + "model:" + id.String()
+}
+
+func (r *ReadRepository) idsKey() string {
+	return r.prefix + "ids"
+}
+
+func (r *ReadRepository) indexedKey(id eh.UUID) string {
+	return r.prefix + "indexed:" + id.String()
+}
+
+func (r *ReadRepository) indexKey(field, value string) string {
+	return r.prefix + "index:" + field + ":" + value
+}
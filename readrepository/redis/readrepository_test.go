@@ -0,0 +1,308 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redis
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/testutil"
+)
+
+func TestReadRepository(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing repository")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	t.Log("FindAll with no items")
+	result, err := repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 0 {
+		t.Error("there should be no items:", len(result))
+	}
+
+	t.Log("Find non-existing item")
+	if _, err := repo.Find(eh.NewUUID()); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+
+	t.Log("Save one item")
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+	if err = repo.Save(model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err := repo.Find(model1.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("Save and overwrite with same ID")
+	model1Alt := &mocks.Model{model1.ID, "model1Alt", time.Now().Round(time.Millisecond)}
+	if err = repo.Save(model1Alt.ID, model1Alt); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err = repo.Find(model1Alt.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1Alt) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("Save with another ID")
+	model2 := &mocks.Model{eh.NewUUID(), "model2", time.Now().Round(time.Millisecond)}
+	if err = repo.Save(model2.ID, model2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("FindAll with two items")
+	result, err = repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 2 {
+		t.Error("there should be two items:", len(result))
+	}
+	if (!reflect.DeepEqual(result[0], model1Alt) || !reflect.DeepEqual(result[1], model2)) &&
+		(!reflect.DeepEqual(result[0], model2) || !reflect.DeepEqual(result[1], model1Alt)) {
+		t.Error("the items should be correct:", result)
+	}
+
+	t.Log("Remove one item")
+	if err = repo.Remove(model1Alt.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	result, err = repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 1 {
+		t.Error("there should be one item:", len(result))
+	}
+	if !reflect.DeepEqual(result[0], model2) {
+		t.Error("the item should be correct:", result[0])
+	}
+
+	t.Log("Remove non-existing item")
+	if err = repo.Remove(model1Alt.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+}
+
+func TestReadRepositoryIndexes(t *testing.T) {
+	repo := newTestReadRepository(t)
+	repo.AddIndex("Content")
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing repository")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	t.Log("FindByIndex with a non-indexed field")
+	if _, err := repo.FindByIndex("CreatedAt", time.Now()); err != ErrIndexNotFound {
+		t.Error("there should be a ErrIndexNotFound error:", err)
+	}
+
+	model1 := &mocks.Model{eh.NewUUID(), "a", time.Now().Round(time.Millisecond)}
+	model2 := &mocks.Model{eh.NewUUID(), "a", time.Now().Round(time.Millisecond)}
+	model3 := &mocks.Model{eh.NewUUID(), "b", time.Now().Round(time.Millisecond)}
+	for _, m := range []*mocks.Model{model1, model2, model3} {
+		if err := repo.Save(m.ID, m); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	t.Log("FindByIndex with two items")
+	result, err := repo.FindByIndex("Content", "a")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 2 {
+		t.Error("there should be two items:", len(result))
+	}
+
+	t.Log("FindByIndex after changing the indexed value")
+	model2.Content = "b"
+	if err := repo.Save(model2.ID, model2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	result, err = repo.FindByIndex("Content", "a")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 1 || !reflect.DeepEqual(result[0], model1) {
+		t.Error("the items should be correct:", result)
+	}
+	result, err = repo.FindByIndex("Content", "b")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 2 {
+		t.Error("there should be two items:", len(result))
+	}
+
+	t.Log("FindByIndex after removing an item")
+	if err := repo.Remove(model3.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	result, err = repo.FindByIndex("Content", "b")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 1 || !reflect.DeepEqual(result[0], model2) {
+		t.Error("the items should be correct:", result)
+	}
+}
+
+func TestReadRepositoryTTL(t *testing.T) {
+	repo := newTestReadRepository(t)
+	repo.AddIndex("Content")
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing repository")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	model1 := &mocks.Model{eh.NewUUID(), "a", time.Now().Round(time.Millisecond)}
+	if err := repo.SaveWithTTL(model1.ID, model1, 100*time.Millisecond); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model2 := &mocks.Model{eh.NewUUID(), "a", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model2.ID, model2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	if _, err := repo.Find(model1.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	t.Log("Find expired item")
+	if _, err := repo.Find(model1.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+	result, err := repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 1 || !reflect.DeepEqual(result[0], model2) {
+		t.Error("the items should be correct:", result)
+	}
+	result, err = repo.FindByIndex("Content", "a")
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 1 || !reflect.DeepEqual(result[0], model2) {
+		t.Error("the items should be correct:", result)
+	}
+}
+
+func TestReadRepositoryQuery(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing repository")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.QueryCommonTests(t, repo)
+}
+
+func TestReadRepositoryTenants(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+
+	repoA, repoB := testutil.TenantCommonTests(t, repo)
+
+	t.Log("clearing repositories")
+	for _, r := range []eh.ReadRepository{repo, repoA, repoB} {
+		if err := r.(*ReadRepository).Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}
+}
+
+func TestReadRepositoryContext(t *testing.T) {
+	repo := newTestReadRepository(t)
+	defer repo.Close(context.Background())
+	defer func() {
+		t.Log("clearing repository")
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.ContextCommonTests(t, repo)
+}
+
+func TestReadRepositoryClose(t *testing.T) {
+	defer func() {
+		t.Log("clearing repository")
+		repo := newTestReadRepository(t)
+		defer repo.Close(context.Background())
+		if err := repo.Clear(); err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+	}()
+
+	testutil.CloseCommonTests(t, newTestReadRepository(t))
+}
+
+func newTestReadRepository(t *testing.T) *ReadRepository {
+	// Support Wercker testing with Redis.
+	host := os.Getenv("REDIS_PORT_6379_TCP_ADDR")
+	port := os.Getenv("REDIS_PORT_6379_TCP_PORT")
+
+	url := ":6379"
+	if host != "" && port != "" {
+		url = host + ":" + port
+	}
+
+	repo, err := NewReadRepository("test", "models", url, "")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+
+	repo.SetModel(func() interface{} {
+		return &mocks.Model{}
+	})
+
+	return repo
+}
@@ -15,7 +15,12 @@
 package sql
 
 import (
+	"context"
+	"fmt"
 	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -25,8 +30,15 @@ import (
 
 // MongoReadRepository implements an MongoDB repository of read models.
 type SqlReadRepository struct {
-	db      gorm.DB
+	db      *gorm.DB
 	factory func() interface{}
+
+	// closed is shared with the repositories of tenants, which use the same
+	// database.
+	closed *atomic.Bool
+
+	tenants   map[eh.TenantID]*SqlReadRepository
+	tenantsMu sync.Mutex
 }
 
 type SqlBaseEntry struct {
@@ -37,10 +49,12 @@ type SqlBaseEntry struct {
 }
 
 // NewMongoReadRepository creates a new MongoReadRepository.
-func NewSqlReadRepository(db gorm.DB, factory func() interface{}) (*SqlReadRepository, error) {
+func NewSqlReadRepository(db *gorm.DB, factory func() interface{}) (*SqlReadRepository, error) {
 	r := &SqlReadRepository{
 		db:      db,
 		factory: factory,
+		closed:  &atomic.Bool{},
+		tenants: make(map[eh.TenantID]*SqlReadRepository),
 	}
 
 	model := factory()
@@ -52,29 +66,123 @@ func NewSqlReadRepository(db gorm.DB, factory func() interface{}) (*SqlReadRepos
 
 // Save saves a read model with id to the repository.
 func (r *SqlReadRepository) Save(id eh.UUID, model interface{}) error {
-	m := r.factory()
+	return r.SaveContext(context.Background(), id, model)
+}
 
-	var c uint
-	r.db.Model(m).Where("id =?", id).Count(&c)
-	if c > 0 {
-		r.db.Save(model)
-	} else {
-		r.db.Create(model)
-	}
-	return nil
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextReadRepository interface. The model is saved in a
+// transaction of the context, which is rolled back if the context is done.
+func (r *SqlReadRepository) SaveContext(ctx context.Context, id eh.UUID, model interface{}) error {
+	return r.transaction(ctx, func(db *gorm.DB) error {
+		var c uint
+		if err := db.Model(r.factory()).Where("id =?", id).Count(&c).Error; err != nil {
+			return eh.ErrCouldNotSaveModel
+		}
+		var result *gorm.DB
+		if c > 0 {
+			result = db.Save(model)
+		} else {
+			result = db.Create(model)
+		}
+		if result.Error != nil {
+			return eh.ErrCouldNotSaveModel
+		}
+		return nil
+	})
 }
 
 // Find returns one read model with using an id. Returns
 // ErrModelNotFound if no model could be found.
 func (r *SqlReadRepository) Find(id eh.UUID) (interface{}, error) {
+	return r.FindContext(context.Background(), id)
+}
+
+// FindContext implements the FindContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *SqlReadRepository) FindContext(ctx context.Context, id eh.UUID) (interface{}, error) {
 	model := r.factory()
 
-	r.db.Where("id =?", id).First(model)
+	err := r.transaction(ctx, func(db *gorm.DB) error {
+		if err := db.Where("id =?", id).First(model).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				return eh.ErrModelNotFound
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
 	return model, nil
 }
 
+// CompareAndSave implements the CompareAndSave method of the
+// eventhorizon.VersionedReadRepository interface. The model must store its
+// version in the column "version".
+func (r *SqlReadRepository) CompareAndSave(id eh.UUID, model eh.Versionable) error {
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+
+	version := model.ModelVersion()
+	model.SetModelVersion(version + 1)
+
+	if err := r.compareAndSave(id, model, version); err != nil {
+		model.SetModelVersion(version)
+		return err
+	}
+	return nil
+}
+
+func (r *SqlReadRepository) compareAndSave(id eh.UUID, model eh.Versionable, version int) error {
+	fields := map[string]interface{}{}
+	for _, f := range r.db.NewScope(model).Fields() {
+		if f.IsNormal && !f.IsIgnored && !f.IsPrimaryKey {
+			fields[f.DBName] = f.Field.Interface()
+		}
+	}
+
+	// Only update the model if it has the expected version.
+	cond := "id = ? AND version = ?"
+	if version == 0 {
+		cond = "id = ? AND (version = ? OR version IS NULL)"
+	}
+	db := r.db.Model(r.factory()).Where(cond, id, version).Updates(fields)
+	if db.Error != nil {
+		return eh.ErrCouldNotSaveModel
+	}
+	if db.RowsAffected > 0 {
+		return nil
+	}
+	if version != 0 {
+		return eh.ErrIncorrectModelVersion
+	}
+
+	// Insert new models, which fails on the primary key if the model was
+	// saved concurrently.
+	if err := r.db.Create(model).Error; err != nil {
+		var c uint
+		r.db.Model(r.factory()).Where("id = ?", id).Count(&c)
+		if c > 0 {
+			return eh.ErrIncorrectModelVersion
+		}
+		return eh.ErrCouldNotSaveModel
+	}
+	return nil
+}
+
+// Update implements the Update method of the eventhorizon.VersionedReadRepository
+// interface, by retrying CompareAndSave when the model was changed.
+func (r *SqlReadRepository) Update(id eh.UUID, update func(interface{}) error) error {
+	return eh.UpdateWithRetry(r, r.factory, id, update)
+}
+
 // FindCustom uses a callback to specify a custom query.
 func (r *SqlReadRepository) FindPage(query, order string, page, size uint) ([]interface{}, uint, error) {
+	if r.closed.Load() {
+		return nil, 0, eh.ErrClosed
+	}
 
 	m := r.factory()
 
@@ -121,12 +229,174 @@ func (r *SqlReadRepository) FindPage(query, order string, page, size uint) ([]in
 	return ret, allcount, nil
 }
 
+// Query implements the Query method of the eventhorizon.QueryableReadRepository
+// interface. The Go field names in the query are mapped to the column names
+// of the model, nested fields are not supported.
+func (r *SqlReadRepository) Query(query *eh.Query) (*eh.QueryResult, error) {
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	m := r.factory()
+
+	offset, err := eh.DecodeCursor(query.Cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	db := r.db.Model(m)
+	scope := r.db.NewScope(m)
+	for _, f := range query.Filters {
+		column, err := columnName(scope, f.Field)
+		if err != nil {
+			return nil, err
+		}
+		op, ok := sqlOperators[f.Operator]
+		if !ok {
+			return nil, fmt.Errorf("%s: unknown operator %s", eh.ErrInvalidQuery, f.Operator)
+		}
+		db = db.Where(column+" "+op, f.Value)
+	}
+
+	// Always sort by ID last to get stable pages.
+	for _, s := range query.Sorts {
+		column, err := columnName(scope, s.Field)
+		if err != nil {
+			return nil, err
+		}
+		if s.Descending {
+			column += " desc"
+		}
+		db = db.Order(column)
+	}
+	db = db.Order("id")
+	if query.Limit > 0 {
+		// Get one extra model to know if there is a next page.
+		db = db.Limit(query.Limit + 1)
+	}
+	if offset > 0 {
+		db = db.Offset(offset)
+	}
+
+	v := reflect.ValueOf(m).Elem()
+	result := reflect.New(reflect.SliceOf(v.Type())).Interface()
+	if err := db.Find(result).Error; err != nil {
+		return nil, err
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(result))
+	models := make([]interface{}, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		models[i] = rv.Index(i).Addr().Interface()
+	}
+
+	res := &eh.QueryResult{Models: models}
+	if query.Limit > 0 && len(models) > query.Limit {
+		res.Models = models[:query.Limit]
+		res.Cursor = eh.EncodeCursor(offset + query.Limit)
+	}
+
+	return res, nil
+}
+
+var sqlOperators = map[eh.Operator]string{
+	eh.OperatorEqual:              "= ?",
+	eh.OperatorNotEqual:           "<> ?",
+	eh.OperatorGreaterThan:        "> ?",
+	eh.OperatorGreaterThanOrEqual: ">= ?",
+	eh.OperatorLessThan:           "< ?",
+	eh.OperatorLessThanOrEqual:    "<= ?",
+	eh.OperatorIn:                 "IN (?)",
+}
+
+// columnName maps a Go field name of the model to its column name.
+func columnName(scope *gorm.Scope, field string) (string, error) {
+	if strings.Contains(field, ".") {
+		return "", fmt.Errorf("%s: nested field %s", eh.ErrInvalidQuery, field)
+	}
+	f, ok := scope.FieldByName(field)
+	if !ok || f.IsIgnored {
+		return "", fmt.Errorf("%s: no field %s", eh.ErrInvalidQuery, field)
+	}
+	return scope.Quote(f.DBName), nil
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantReadRepository interface. The models of a tenant are
+// stored in a separate table, named as the table of the model with the tenant
+// ID as suffix, which is created if needed.
+func (r *SqlReadRepository) ForTenant(tenantID eh.TenantID) (eh.ReadRepository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+	if r.closed.Load() {
+		return nil, eh.ErrClosed
+	}
+
+	r.tenantsMu.Lock()
+	defer r.tenantsMu.Unlock()
+
+	if tenant, ok := r.tenants[tenantID]; ok {
+		return tenant, nil
+	}
+
+	m := r.factory()
+	db := r.db.Table(r.db.NewScope(m).TableName() + "_" + string(tenantID))
+	if err := db.AutoMigrate(m).Error; err != nil {
+		return nil, err
+	}
+
+	tenant := &SqlReadRepository{
+		db:      db,
+		factory: r.factory,
+		closed:  r.closed,
+		tenants: make(map[eh.TenantID]*SqlReadRepository),
+	}
+	r.tenants[tenantID] = tenant
+
+	return tenant, nil
+}
+
+// Start implements the Start method of the eventhorizon.Lifecycle interface.
+// It checks that the database can be reached.
+func (r *SqlReadRepository) Start(ctx context.Context) error {
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+	return r.db.DB().PingContext(ctx)
+}
+
+// CheckHealth implements the CheckHealth method of the
+// eventhorizon.HealthChecker interface. It pings the database.
+func (r *SqlReadRepository) CheckHealth(ctx context.Context) (eh.Health, error) {
+	if r.closed.Load() {
+		return eh.Health{}, eh.ErrClosed
+	}
+
+	start := time.Now()
+	err := r.db.DB().PingContext(ctx)
+	return eh.Health{Latency: time.Since(start)}, err
+}
+
+// Close implements the Close method of the eventhorizon.Lifecycle interface.
+// It closes the database, which is shared with the repositories of tenants,
+// after which all methods return eventhorizon.ErrClosed.
+func (r *SqlReadRepository) Close(ctx context.Context) error {
+	if r.closed.Swap(true) {
+		return nil
+	}
+	return r.db.Close()
+}
+
 // FindAll returns all read models in the repository.
 func (r *SqlReadRepository) FindAll() ([]interface{}, error) {
-	m := r.factory()
+	return r.FindAllContext(context.Background())
+}
 
-	var allcount uint
-	r.db.Model(m).Count(&allcount)
+// FindAllContext implements the FindAllContext method of the
+// eventhorizon.ContextReadRepository interface.
+func (r *SqlReadRepository) FindAllContext(ctx context.Context) ([]interface{}, error) {
+	m := r.factory()
 
 	v := reflect.ValueOf(m).Elem()
 	sv := reflect.MakeSlice(reflect.SliceOf(v.Type()), 0, 0)
@@ -134,7 +404,12 @@ func (r *SqlReadRepository) FindAll() ([]interface{}, error) {
 	snv := reflect.New(sv.Type())
 	result := snv.Interface()
 
-	r.db.Find(result)
+	err := r.transaction(ctx, func(db *gorm.DB) error {
+		return db.Find(result).Error
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	rv := reflect.Indirect(reflect.ValueOf(result))
 
@@ -149,9 +424,56 @@ func (r *SqlReadRepository) FindAll() ([]interface{}, error) {
 // Remove removes a read model with id from the repository. Returns
 // ErrModelNotFound if no model could be found.
 func (r *SqlReadRepository) Remove(id eh.UUID) error {
+	return r.RemoveContext(context.Background(), id)
+}
+
+// RemoveContext implements the RemoveContext method of the
+// eventhorizon.ContextReadRepository interface. The model is removed in a
+// transaction of the context, which is rolled back if the context is done.
+func (r *SqlReadRepository) RemoveContext(ctx context.Context, id eh.UUID) error {
 	m := r.factory()
 
-	r.db.Where("id =", id).Delete(m)
+	return r.transaction(ctx, func(db *gorm.DB) error {
+		result := db.Where("id = ?", id).Delete(m)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return eh.ErrModelNotFound
+		}
+		return nil
+	})
+}
+
+// transaction runs f in a transaction of the context, as gorm can not cancel
+// single statements. The transaction is rolled back by the sql package when
+// the context is done, after which no more statements can be run in it.
+func (r *SqlReadRepository) transaction(ctx context.Context, f func(*gorm.DB) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.closed.Load() {
+		return eh.ErrClosed
+	}
+
+	tx := r.db.BeginTx(ctx, nil)
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
 
+	if err := tx.Commit().Error; err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,168 @@
+// Copyright (c) 2015 - Max Persson <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sql
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+	"github.com/looplab/eventhorizon/readrepository/testutil"
+)
+
+func TestReadRepository(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.Model{}
+	})
+	defer repo.Close(context.Background())
+
+	t.Log("Save one item")
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err := repo.Find(model1.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("Save and overwrite with same ID")
+	model1Alt := &mocks.Model{model1.ID, "model1Alt", time.Now().Round(time.Millisecond)}
+	if err := repo.Save(model1Alt.ID, model1Alt); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err = repo.Find(model1Alt.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1Alt) {
+		t.Error("the item should be correct:", model)
+	}
+
+	t.Log("FindAll with one item")
+	result, err := repo.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(result) != 1 {
+		t.Error("there should be one item:", len(result))
+	}
+
+	t.Log("Remove one item")
+	if err := repo.Remove(model1Alt.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.Find(model1Alt.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+
+	t.Log("Remove non-existing item")
+	if err := repo.Remove(model1Alt.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+}
+
+func TestReadRepositoryQuery(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.Model{}
+	})
+	defer repo.Close(context.Background())
+
+	testutil.QueryCommonTests(t, repo)
+}
+
+func TestReadRepositoryQueryOperators(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.Model{}
+	})
+	defer repo.Close(context.Background())
+
+	t.Log("query with unknown operator")
+	if _, err := repo.Query(eh.NewQuery().Where("Content", "like", "a")); err == nil {
+		t.Error("there should be an error")
+	}
+
+	t.Log("query with nested field")
+	if _, err := repo.Query(eh.NewQuery().Equal("Content.Value", "a")); err == nil {
+		t.Error("there should be an error")
+	}
+
+}
+
+func TestReadRepositoryVersioned(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.VersionedModel{}
+	})
+	defer repo.Close(context.Background())
+
+	testutil.VersionedCommonTests(t, repo)
+}
+
+func TestReadRepositoryTenants(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.Model{}
+	})
+	defer repo.Close(context.Background())
+
+	testutil.TenantCommonTests(t, repo)
+}
+
+func TestReadRepositoryContext(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.Model{}
+	})
+	defer repo.Close(context.Background())
+
+	testutil.ContextCommonTests(t, repo)
+}
+
+func TestReadRepositoryClose(t *testing.T) {
+	repo := newTestRepository(t, func() interface{} {
+		return &mocks.Model{}
+	})
+
+	testutil.CloseCommonTests(t, repo)
+}
+
+// newTestRepository creates a repository in a new SQLite database, which loads
+// times in the local time zone and only uses one connection to not lock itself
+// when updating concurrently.
+func newTestRepository(t *testing.T, factory func() interface{}) *SqlReadRepository {
+	db, err := gorm.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_loc=auto")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	db.DB().SetMaxOpenConns(1)
+	db.LogMode(false)
+
+	repo, err := NewSqlReadRepository(db, factory)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if repo == nil {
+		t.Fatal("there should be a repository")
+	}
+	return repo
+}
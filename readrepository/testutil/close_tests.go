@@ -0,0 +1,67 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// ClosableReadRepository is a context aware read repository that can be
+// closed.
+type ClosableReadRepository interface {
+	eh.ContextReadRepository
+	eh.Lifecycle
+}
+
+// CloseCommonTests are test cases that are common to all read repositories
+// that can be closed. The repository should create mocks.Model as its model,
+// and is closed by the tests.
+func CloseCommonTests(t *testing.T, repo ClosableReadRepository) {
+	ctx := context.Background()
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+
+	if err := repo.SaveContext(ctx, model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("close repository")
+	if err := repo.Close(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("use closed repository")
+	if err := repo.SaveContext(ctx, model1.ID, model1); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if _, err := repo.FindContext(ctx, model1.ID); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if _, err := repo.FindAllContext(ctx); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+	if err := repo.RemoveContext(ctx, model1.ID); err != eh.ErrClosed {
+		t.Error("there should be a ErrClosed error:", err)
+	}
+
+	t.Log("close closed repository")
+	if err := repo.Close(ctx); err != nil {
+		t.Error("there should be no error:", err)
+	}
+}
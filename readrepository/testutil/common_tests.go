@@ -0,0 +1,134 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// QueryCommonTests are test cases for the Query method that are common to all
+// queryable read repositories. The repository should be empty.
+func QueryCommonTests(t *testing.T, repo eh.QueryableReadRepository) {
+	now := time.Now().Round(time.Millisecond)
+	for i, content := range []string{"c", "a", "e", "b", "d"} {
+		model := &mocks.Model{eh.NewUUID(), content, now.Add(time.Duration(i) * time.Hour)}
+		if err := repo.Save(model.ID, model); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+
+	t.Log("query all")
+	result, err := repo.Query(eh.NewQuery().SortBy("Content"))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if c := contents(result); c != "abcde" {
+		t.Error("the models should be correct:", c)
+	}
+	if result.Cursor != "" {
+		t.Error("there should be no cursor:", result.Cursor)
+	}
+
+	t.Log("query with equal filter")
+	result, err = repo.Query(eh.NewQuery().Equal("Content", "b"))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if c := contents(result); c != "b" {
+		t.Error("the models should be correct:", c)
+	}
+
+	t.Log("query with not equal filter")
+	result, err = repo.Query(eh.NewQuery().
+		Where("Content", eh.OperatorNotEqual, "b").SortBy("Content"))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if c := contents(result); c != "acde" {
+		t.Error("the models should be correct:", c)
+	}
+
+	t.Log("query with in filter")
+	result, err = repo.Query(eh.NewQuery().In("Content", "a", "e", "x").SortBy("Content"))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if c := contents(result); c != "ae" {
+		t.Error("the models should be correct:", c)
+	}
+
+	t.Log("query with range filter")
+	result, err = repo.Query(eh.NewQuery().
+		Range("CreatedAt", now.Add(time.Hour), now.Add(3*time.Hour)).SortBy("CreatedAt"))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if c := contents(result); c != "ae" {
+		t.Error("the models should be correct:", c)
+	}
+
+	t.Log("query with descending sort")
+	result, err = repo.Query(eh.NewQuery().SortByDesc("Content"))
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if c := contents(result); c != "edcba" {
+		t.Error("the models should be correct:", c)
+	}
+
+	t.Log("query pages")
+	query := eh.NewQuery().Where("Content", eh.OperatorGreaterThan, "a").
+		SortBy("Content").WithLimit(2)
+	pages := []string{}
+	for i := 0; i < 5; i++ {
+		result, err = repo.Query(query)
+		if err != nil {
+			t.Error("there should be no error:", err)
+			break
+		}
+		pages = append(pages, contents(result))
+		if result.Cursor == "" {
+			break
+		}
+		query.WithCursor(result.Cursor)
+	}
+	if len(pages) != 2 || pages[0] != "bc" || pages[1] != "de" {
+		t.Error("the pages should be correct:", pages)
+	}
+
+	t.Log("query with invalid cursor")
+	if _, err := repo.Query(eh.NewQuery().WithCursor("invalid")); err != eh.ErrInvalidCursor {
+		t.Error("there should be a ErrInvalidCursor error:", err)
+	}
+
+	t.Log("query with unknown field")
+	if _, err := repo.Query(eh.NewQuery().Equal("Unknown", "a")); err == nil {
+		t.Error("there should be an error")
+	}
+}
+
+func contents(result *eh.QueryResult) string {
+	s := ""
+	for _, m := range result.Models {
+		if model, ok := m.(*mocks.Model); ok {
+			s += model.Content
+		}
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// ContextCommonTests are test cases that are common to all context aware read
+// repositories. The repository should create mocks.Model as its model.
+func ContextCommonTests(t *testing.T, repo eh.ContextReadRepository) {
+	model1 := &mocks.Model{eh.NewUUID(), "model1", time.Now().Round(time.Millisecond)}
+
+	t.Log("use repository with canceled context")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := repo.SaveContext(ctx, model1.ID, model1); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if _, err := repo.FindContext(ctx, model1.ID); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if _, err := repo.FindAllContext(ctx); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+	if err := repo.RemoveContext(ctx, model1.ID); err != context.Canceled {
+		t.Error("there should be a context.Canceled error:", err)
+	}
+
+	t.Log("use repository with deadline")
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := repo.SaveContext(ctx, model1.ID, model1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	model, err := repo.FindContext(ctx, model1.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(model, model1) {
+		t.Error("the item should be correct:", model)
+	}
+	if err := repo.RemoveContext(ctx, model1.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if _, err := repo.FindContext(ctx, model1.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// TenantCommonTests are test cases that are common to all multi tenant read
+// repositories. The repository should be empty and create mocks.Model as its
+// model. The repositories of the tenants "a" and "b" are returned to be
+// cleared by the caller.
+func TenantCommonTests(t *testing.T, repo eh.MultiTenantReadRepository) (eh.ReadRepository, eh.ReadRepository) {
+	t.Log("repository for invalid tenant")
+	if _, err := repo.ForTenant(""); err != eh.ErrInvalidTenant {
+		t.Error("there should be a ErrInvalidTenant error:", err)
+	}
+
+	repoA, err := repo.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	repoB, err := repo.ForTenant("b")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("save model for tenant")
+	model := &mocks.Model{eh.NewUUID(), "model", time.Now().Round(time.Millisecond)}
+	if err := repoA.Save(model.ID, model); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	m, err := repoA.Find(model.ID)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if !reflect.DeepEqual(m, model) {
+		t.Error("the model should be correct:", m)
+	}
+
+	t.Log("find model of other tenant")
+	if _, err := repoB.Find(model.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+	if _, err := repo.Find(model.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+	models, err := repoB.FindAll()
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(models) != 0 {
+		t.Error("there should be no models:", len(models))
+	}
+
+	t.Log("remove model of other tenant")
+	if err := repoB.Remove(model.ID); err != eh.ErrModelNotFound {
+		t.Error("there should be a ErrModelNotFound error:", err)
+	}
+	if _, err := repoA.Find(model.ID); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	return repoA, repoB
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+// VersionedCommonTests are test cases that are common to all versioned read
+// repositories. The repository should be empty and create mocks.VersionedModel
+// as its model.
+func VersionedCommonTests(t *testing.T, repo eh.VersionedReadRepository) {
+	id := eh.NewUUID()
+
+	t.Log("compare and save new model")
+	model := &mocks.VersionedModel{ID: id, Content: "a"}
+	if err := repo.CompareAndSave(id, model); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if model.Version != 1 {
+		t.Error("the version should be incremented:", model.Version)
+	}
+
+	t.Log("compare and save new model with existing id")
+	other := &mocks.VersionedModel{ID: id, Content: "b"}
+	if err := repo.CompareAndSave(id, other); err != eh.ErrIncorrectModelVersion {
+		t.Error("there should be a ErrIncorrectModelVersion error:", err)
+	}
+	if other.Version != 0 {
+		t.Error("the version should not be incremented:", other.Version)
+	}
+
+	t.Log("compare and save loaded model")
+	m, err := repo.Find(id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	loaded, ok := m.(*mocks.VersionedModel)
+	if !ok || loaded.Version != 1 || loaded.Content != "a" {
+		t.Fatal("the loaded model should be correct:", m)
+	}
+	loaded.Content = "c"
+	if err := repo.CompareAndSave(id, loaded); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("compare and save stale model")
+	model.Content = "d"
+	if err := repo.CompareAndSave(id, model); err != eh.ErrIncorrectModelVersion {
+		t.Error("there should be a ErrIncorrectModelVersion error:", err)
+	}
+	m, _ = repo.Find(id)
+	if m, ok := m.(*mocks.VersionedModel); !ok || m.Version != 2 || m.Content != "c" {
+		t.Error("the saved model should be correct:", m)
+	}
+
+	t.Log("update non-existing model")
+	id2 := eh.NewUUID()
+	err = repo.Update(id2, func(m interface{}) error {
+		model := m.(*mocks.VersionedModel)
+		model.ID = id2
+		model.Content = "new"
+		return nil
+	})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	m, _ = repo.Find(id2)
+	if m, ok := m.(*mocks.VersionedModel); !ok || m.Version != 1 || m.Content != "new" {
+		t.Error("the saved model should be correct:", m)
+	}
+
+	t.Log("update with error")
+	updateErr := errors.New("update error")
+	err = repo.Update(id2, func(m interface{}) error {
+		m.(*mocks.VersionedModel).Content = "failed"
+		return updateErr
+	})
+	if err != updateErr {
+		t.Error("there should be an update error:", err)
+	}
+	m, _ = repo.Find(id2)
+	if m, ok := m.(*mocks.VersionedModel); !ok || m.Version != 1 || m.Content != "new" {
+		t.Error("the saved model should not be changed:", m)
+	}
+
+	t.Log("update concurrently")
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.Update(id2, func(m interface{}) error {
+				m.(*mocks.VersionedModel).Content += "x"
+				return nil
+			})
+			if err != nil {
+				t.Error("there should be no error:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	m, _ = repo.Find(id2)
+	if m, ok := m.(*mocks.VersionedModel); !ok || m.Version != 6 || m.Content != "newxxxxx" {
+		t.Error("all updates should be saved:", m)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ErrNilFactoryResult is when a factory creates a nil event, command or
+// aggregate when registering it.
+var ErrNilFactoryResult = errors.New("created value is nil")
+
+// ErrEmptyType is when an event, command or aggregate with an empty type is
+// registered.
+var ErrEmptyType = errors.New("empty type")
+
+// ErrDuplicateType is when an event, command or aggregate type is registered
+// twice in the same registry.
+var ErrDuplicateType = errors.New("type is already registered")
+
+// ErrIncorrectType is when a created event or command is not of the requested
+// Go type.
+var ErrIncorrectType = errors.New("created value is of incorrect type")
+
+// RegistryError is returned when a type could not be registered.
+type RegistryError struct {
+	Err  error
+	Type string
+}
+
+// Error implements the Error method of the error interface.
+func (e RegistryError) Error() string {
+	return fmt.Sprintf("%s: %q", e.Err, e.Type)
+}
+
+// Registry holds the factories used to create concrete events, commands and
+// aggregates by type, for example when loading events from a store or
+// receiving them on a bus. Stores, buses and repositories use the default
+// registry, which is the one used by RegisterEvent, RegisterCommand and
+// RegisterAggregate, unless another one is set with SetRegistry. Separate
+// registries makes it possible to have bounded contexts with the same types in
+// one binary.
+type Registry struct {
+	events     map[EventType]func() Event
+	commands   map[CommandType]func() Command
+	aggregates map[AggregateType]func(UUID) Aggregate
+	mu         sync.RWMutex
+}
+
+// NewRegistry creates a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		events:     make(map[EventType]func() Event),
+		commands:   make(map[CommandType]func() Command),
+		aggregates: make(map[AggregateType]func(UUID) Aggregate),
+	}
+}
+
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry returns the registry used by the package level register and
+// create functions, and by the stores, buses and repositories that have no
+// registry set.
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// RegisterEvent registers an event factory for the type of the events it
+// creates. Returns a RegistryError if the type is empty or already registered.
+func (r *Registry) RegisterEvent(factory func() Event) error {
+	event := factory()
+	if event == nil {
+		return RegistryError{Err: ErrNilFactoryResult}
+	}
+	eventType := event.EventType()
+	if eventType == EventType("") {
+		return RegistryError{Err: ErrEmptyType}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.events[eventType]; ok {
+		return RegistryError{Err: ErrDuplicateType, Type: string(eventType)}
+	}
+	r.events[eventType] = factory
+	return nil
+}
+
+// CreateEvent creates an event of a type using the registered factory.
+func (r *Registry) CreateEvent(eventType EventType) (Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if factory, ok := r.events[eventType]; ok {
+		return factory(), nil
+	}
+	return nil, ErrEventNotRegistered
+}
+
+// EventTypes returns all registered event types, sorted.
+func (r *Registry) EventTypes() []EventType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]EventType, 0, len(r.events))
+	for t := range r.events {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// RegisterCommand registers a command factory for the type of the commands it
+// creates. Returns a RegistryError if the type is empty or already registered.
+func (r *Registry) RegisterCommand(factory func() Command) error {
+	command := factory()
+	if command == nil {
+		return RegistryError{Err: ErrNilFactoryResult}
+	}
+	commandType := command.CommandType()
+	if commandType == CommandType("") {
+		return RegistryError{Err: ErrEmptyType}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.commands[commandType]; ok {
+		return RegistryError{Err: ErrDuplicateType, Type: string(commandType)}
+	}
+	r.commands[commandType] = factory
+	return nil
+}
+
+// CreateCommand creates a command of a type using the registered factory.
+func (r *Registry) CreateCommand(commandType CommandType) (Command, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if factory, ok := r.commands[commandType]; ok {
+		return factory(), nil
+	}
+	return nil, ErrCommandNotRegistered
+}
+
+// CommandTypes returns all registered command types, sorted.
+func (r *Registry) CommandTypes() []CommandType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]CommandType, 0, len(r.commands))
+	for t := range r.commands {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// RegisterAggregate registers an aggregate factory for the type of the
+// aggregates it creates. Returns a RegistryError if the type is empty or
+// already registered.
+func (r *Registry) RegisterAggregate(factory func(UUID) Aggregate) error {
+	aggregate := factory(NewUUID())
+	if aggregate == nil {
+		return RegistryError{Err: ErrNilFactoryResult}
+	}
+	aggregateType := aggregate.AggregateType()
+	if aggregateType == AggregateType("") {
+		return RegistryError{Err: ErrEmptyType}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.aggregates[aggregateType]; ok {
+		return RegistryError{Err: ErrDuplicateType, Type: string(aggregateType)}
+	}
+	r.aggregates[aggregateType] = factory
+	return nil
+}
+
+// CreateAggregate creates an aggregate of a type with an ID using the
+// registered factory.
+func (r *Registry) CreateAggregate(aggregateType AggregateType, id UUID) (Aggregate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if factory, ok := r.aggregates[aggregateType]; ok {
+		return factory(id), nil
+	}
+	return nil, ErrAggregateNotRegistered
+}
+
+// AggregateTypes returns all registered aggregate types, sorted.
+func (r *Registry) AggregateTypes() []AggregateType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]AggregateType, 0, len(r.aggregates))
+	for t := range r.aggregates {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// RegisterEventType registers an event type in a registry without a factory
+// func. The events are created as new values of type *T, which must implement
+// Event.
+//
+// An example would be:
+//
+//	RegisterEventType[MyEvent](registry)
+func RegisterEventType[T any, PT interface {
+	*T
+	Event
+}](r *Registry) error {
+	return r.RegisterEvent(func() Event { return PT(new(T)) })
+}
+
+// RegisterCommandType registers a command type in a registry without a
+// factory func. The commands are created as new values of type *T, which must
+// implement Command.
+//
+// An example would be:
+//
+//	RegisterCommandType[MyCommand](registry)
+func RegisterCommandType[T any, PT interface {
+	*T
+	Command
+}](r *Registry) error {
+	return r.RegisterCommand(func() Command { return PT(new(T)) })
+}
+
+// CreateEventAs creates an event of a type with the registered factory, as
+// the concrete type T. Returns ErrEventNotRegistered if the type is not
+// registered, and ErrIncorrectType if the created event is not a T.
+func CreateEventAs[T Event](r *Registry, eventType EventType) (T, error) {
+	var zero T
+	event, err := r.CreateEvent(eventType)
+	if err != nil {
+		return zero, err
+	}
+	e, ok := event.(T)
+	if !ok {
+		return zero, ErrIncorrectType
+	}
+	return e, nil
+}
+
+// CreateCommandAs creates a command of a type with the registered factory, as
+// the concrete type T. Returns ErrCommandNotRegistered if the type is not
+// registered, and ErrIncorrectType if the created command is not a T.
+func CreateCommandAs[T Command](r *Registry, commandType CommandType) (T, error) {
+	var zero T
+	command, err := r.CreateCommand(commandType)
+	if err != nil {
+		return zero, err
+	}
+	c, ok := command.(T)
+	if !ok {
+		return zero, ErrIncorrectType
+	}
+	return c, nil
+}
+
+// mustRegister panics with the messages used before registries were added,
+// for the package level register functions.
+func mustRegister(err error, kind string) {
+	if err == nil {
+		return
+	}
+	e := err.(RegistryError)
+	switch e.Err {
+	case ErrNilFactoryResult:
+		panic(fmt.Sprintf("eventhorizon: created %s is nil", kind))
+	case ErrEmptyType:
+		panic(fmt.Sprintf("eventhorizon: attempt to register empty %s type", kind))
+	default:
+		panic(fmt.Sprintf("eventhorizon: registering duplicate types for %q", e.Type))
+	}
+}
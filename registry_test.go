@@ -0,0 +1,101 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+
+	if _, err := r.CreateEvent(TestEventType); err != ErrEventNotRegistered {
+		t.Error("there should be a ErrEventNotRegistered error:", err)
+	}
+
+	if err := RegisterEventType[TestEvent](r); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := RegisterEventType[TestEvent2](r); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := RegisterCommandType[TestCommand](r); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := r.RegisterAggregate(func(id UUID) Aggregate {
+		return &TestAggregate{AggregateBase: NewAggregateBase(id)}
+	}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	event, err := CreateEventAs[*TestEvent](r, TestEventType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if event == nil {
+		t.Error("there should be an event")
+	}
+	if _, err := CreateEventAs[*TestEvent2](r, TestEventType); err != ErrIncorrectType {
+		t.Error("there should be a ErrIncorrectType error:", err)
+	}
+	command, err := CreateCommandAs[*TestCommand](r, TestCommandType)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if command == nil {
+		t.Error("there should be a command")
+	}
+	id := NewUUID()
+	aggregate, err := r.CreateAggregate(TestAggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if aggregate.AggregateID() != id {
+		t.Error("the aggregate ID should be correct:", aggregate.AggregateID())
+	}
+
+	if types := r.EventTypes(); !reflect.DeepEqual(types, []EventType{TestEventType, TestEvent2Type}) {
+		t.Error("the event types should be correct:", types)
+	}
+	if types := r.CommandTypes(); !reflect.DeepEqual(types, []CommandType{TestCommandType}) {
+		t.Error("the command types should be correct:", types)
+	}
+	if types := r.AggregateTypes(); !reflect.DeepEqual(types, []AggregateType{TestAggregateType}) {
+		t.Error("the aggregate types should be correct:", types)
+	}
+
+	// Registries are separate from each other and from the default registry,
+	// which already has the test event registered.
+	if err := RegisterEventType[TestEvent](NewRegistry()); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	err = RegisterEventType[TestEvent](r)
+	if err != (RegistryError{Err: ErrDuplicateType, Type: string(TestEventType)}) {
+		t.Error("there should be a duplicate type error:", err)
+	}
+	if err := r.RegisterEvent(func() Event { return nil }); err != (RegistryError{Err: ErrNilFactoryResult}) {
+		t.Error("there should be a nil factory result error:", err)
+	}
+}
+
+func TestEventSourcingRepositoryRegistry(t *testing.T) {
+	repo, _, _ := createRepoAndStore(t)
+	repo.SetRegistry(NewRegistry())
+
+	if _, err := repo.Load(TestAggregateType, NewUUID()); err != ErrAggregateNotRegistered {
+		t.Error("there should be a ErrAggregateNotRegistered error:", err)
+	}
+}
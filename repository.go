@@ -15,7 +15,9 @@
 package eventhorizon
 
 import (
+	"context"
 	"errors"
+	"time"
 )
 
 // ErrInvalidEventStore is when a dispatcher is created with a nil event store.
@@ -27,6 +29,15 @@ var ErrInvalidEventBus = errors.New("invalid event bus")
 // ErrMismatchedEventType occurs when loaded events from ID does not match aggregate type.
 var ErrMismatchedEventType = errors.New("mismatched event type and aggregate type")
 
+// ErrSnapshotsNotSupported is when saving a snapshot of an aggregate that is
+// not a SnapshotAggregate, or to an event store that is not a
+// SnapshotEventStore.
+var ErrSnapshotsNotSupported = errors.New("snapshots not supported")
+
+// ErrUncommittedEvents is when saving a snapshot of an aggregate with
+// uncommitted events.
+var ErrUncommittedEvents = errors.New("aggregate has uncommitted events")
+
 // Repository is a repository responsible for loading and saving aggregates.
 type Repository interface {
 	// Load loads the most recent version of an aggregate with a type and id.
@@ -36,11 +47,27 @@ type Repository interface {
 	Save(Aggregate) error
 }
 
+// CatchUpRepository is a Repository that can bring an already loaded aggregate
+// up to date, by applying only the events after its version. It is used by
+// caches of aggregates.
+type CatchUpRepository interface {
+	Repository
+
+	// CatchUp applies the events after the version of the aggregate.
+	CatchUp(context.Context, Aggregate) error
+}
+
 // EventSourcingRepository is an aggregate repository using event sourcing. It
 // uses an event store for loading and saving events used to build the aggregate.
 type EventSourcingRepository struct {
 	eventStore EventStore
 	eventBus   EventBus
+
+	// tenantID is the tenant of the repository, if any.
+	tenantID TenantID
+
+	// registry is used to create the aggregates.
+	registry *Registry
 }
 
 // NewEventSourcingRepository creates a repository that will use an event store
@@ -57,58 +84,238 @@ func NewEventSourcingRepository(eventStore EventStore, eventBus EventBus) (*Even
 	d := &EventSourcingRepository{
 		eventStore: eventStore,
 		eventBus:   eventBus,
+		registry:   DefaultRegistry(),
+	}
+	return d, nil
+}
+
+// SetRegistry sets the registry used to create aggregates, the default is
+// DefaultRegistry.
+func (r *EventSourcingRepository) SetRegistry(registry *Registry) {
+	r.registry = registry
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantRepository interface. The repository uses the event
+// store and bus of the tenant, if they are multi tenant, and rejects loading
+// and saving events of other tenants with ErrCrossTenantAccess.
+func (r *EventSourcingRepository) ForTenant(tenantID TenantID) (Repository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	eventStore := r.eventStore
+	if s, ok := eventStore.(MultiTenantEventStore); ok {
+		var err error
+		if eventStore, err = s.ForTenant(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	eventBus := r.eventBus
+	if b, ok := eventBus.(MultiTenantEventBus); ok {
+		var err error
+		if eventBus, err = b.ForTenant(tenantID); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &EventSourcingRepository{
+		eventStore: eventStore,
+		eventBus:   eventBus,
+		tenantID:   tenantID,
+		registry:   r.registry,
 	}
 	return d, nil
 }
 
 // Load loads an aggregate from the event store. It does so by creating a new
 // aggregate of the type with the ID and then applies all events to it, thus
-// making it the most current version of the aggregate.
+// making it the most current version of the aggregate. Aggregates implementing
+// SnapshotAggregate are restored from their latest snapshot if the event store
+// is a SnapshotEventStore, and only the events after it are applied.
 func (r *EventSourcingRepository) Load(aggregateType AggregateType, id UUID) (Aggregate, error) {
+	return r.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextRepository interface.
+func (r *EventSourcingRepository) LoadContext(ctx context.Context, aggregateType AggregateType, id UUID) (Aggregate, error) {
 	// Create the aggregate.
-	aggregate, err := CreateAggregate(aggregateType, id)
+	aggregate, err := r.registry.CreateAggregate(aggregateType, id)
 	if err != nil {
 		return nil, err
 	}
 
+	// Restore the aggregate from its snapshot, if any, and apply the events
+	// after the snapshot.
+	if a, ok := aggregate.(SnapshotAggregate); ok {
+		if s, ok := r.eventStore.(SnapshotEventStore); ok {
+			snapshot, err := s.LoadSnapshot(ctx, aggregateType, id)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot != nil {
+				if err := a.ApplySnapshotState(snapshot.State); err != nil {
+					return nil, err
+				}
+				for i := 0; i < snapshot.Version; i++ {
+					aggregate.IncrementVersion()
+				}
+				if err := r.CatchUp(ctx, aggregate); err != nil {
+					return nil, err
+				}
+				return aggregate, nil
+			}
+		}
+	}
+
 	// Load aggregate eventRecords.
-	eventRecords, err := r.eventStore.Load(aggregate.AggregateType(), aggregate.AggregateID())
+	eventRecords, err := AdaptEventStore(r.eventStore).LoadContext(ctx, aggregate.AggregateType(), aggregate.AggregateID())
 	if err != nil {
 		return nil, err
 	}
 
-	// Apply the events.
+	if err := r.applyEvents(aggregate, eventRecords); err != nil {
+		return nil, err
+	}
+
+	return aggregate, nil
+}
+
+// CatchUp implements the CatchUp method of the eventhorizon.CatchUpRepository
+// interface. Only the newest events are loaded if the event store is an
+// IncrementalEventStore, otherwise all events are loaded and the ones up to
+// the version of the aggregate are skipped.
+func (r *EventSourcingRepository) CatchUp(ctx context.Context, aggregate Aggregate) error {
+	var eventRecords []EventRecord
+	var err error
+	if s, ok := r.eventStore.(IncrementalEventStore); ok {
+		eventRecords, err = s.LoadAfter(ctx, aggregate.AggregateType(), aggregate.AggregateID(), aggregate.Version())
+	} else {
+		eventRecords, err = AdaptEventStore(r.eventStore).LoadContext(ctx, aggregate.AggregateType(), aggregate.AggregateID())
+	}
+	if err != nil {
+		return err
+	}
+
+	var newEventRecords []EventRecord
+	for _, eventRecord := range eventRecords {
+		if eventRecord.Version() > aggregate.Version() {
+			newEventRecords = append(newEventRecords, eventRecord)
+		}
+	}
+
+	return r.applyEvents(aggregate, newEventRecords)
+}
+
+// SaveSnapshot saves a snapshot of the state of a saved aggregate, after which
+// the events before the snapshot can be truncated from the event store.
+// Returns ErrSnapshotsNotSupported if the aggregate or event store does not
+// support snapshots.
+func (r *EventSourcingRepository) SaveSnapshot(ctx context.Context, aggregate Aggregate) error {
+	a, ok := aggregate.(SnapshotAggregate)
+	if !ok {
+		return ErrSnapshotsNotSupported
+	}
+	s, ok := r.eventStore.(SnapshotEventStore)
+	if !ok {
+		return ErrSnapshotsNotSupported
+	}
+
+	if len(aggregate.GetUncommittedEvents()) > 0 {
+		return ErrUncommittedEvents
+	}
+
+	state, err := a.SnapshotState()
+	if err != nil {
+		return err
+	}
+
+	return s.SaveSnapshot(ctx, aggregate.AggregateType(), aggregate.AggregateID(), Snapshot{
+		Version:   aggregate.Version(),
+		Timestamp: time.Now(),
+		State:     state,
+	})
+}
+
+// applyEvents applies loaded events to an aggregate.
+func (r *EventSourcingRepository) applyEvents(aggregate Aggregate, eventRecords []EventRecord) error {
 	for _, eventRecord := range eventRecords {
-		if eventRecord.Event().AggregateType() != aggregateType {
-			return nil, ErrMismatchedEventType
+		if eventRecord.Event().AggregateType() != aggregate.AggregateType() {
+			return ErrMismatchedEventType
+		}
+
+		// Guard against loading events of other tenants, in case the event
+		// store is not partitioned by tenant.
+		if e, ok := eventRecord.Event().(TenantEvent); ok && e.TenantID() != r.tenantID {
+			return ErrCrossTenantAccess
 		}
 
 		aggregate.ApplyEvent(eventRecord.Event())
 		aggregate.IncrementVersion()
 	}
 
-	return aggregate, nil
+	return nil
 }
 
-// Save saves all uncommitted events from an aggregate to the event store.
+// Save saves all uncommitted events from an aggregate to the event store. The
+// aggregate is up to date after saving: the events are applied and the version
+// incremented, unless they were already applied when stored by an
+// ApplyOnStoreAggregate.
 func (r *EventSourcingRepository) Save(aggregate Aggregate) error {
+	return r.SaveContext(context.Background(), aggregate)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextRepository interface. Stored events are published even
+// if the context is canceled after they were stored, with the context values.
+func (r *EventSourcingRepository) SaveContext(ctx context.Context, aggregate Aggregate) error {
 	uncommittedEvents := aggregate.GetUncommittedEvents()
 	if len(uncommittedEvents) < 1 {
 		return nil
 	}
 
+	// The version of aggregates that apply events when stored already
+	// includes the uncommitted events.
+	originalVersion := aggregate.Version()
+	a, ok := aggregate.(ApplyOnStoreAggregate)
+	appliedOnStore := ok && a.AppliesOnStore()
+	if appliedOnStore {
+		originalVersion -= len(uncommittedEvents)
+	}
+
+	// Set the tenants of the events that support it.
+	for _, event := range uncommittedEvents {
+		if e, ok := event.(TenantEvent); ok {
+			if e.TenantID() == "" {
+				e.SetTenantID(r.tenantID)
+			} else if e.TenantID() != r.tenantID {
+				return ErrCrossTenantAccess
+			}
+		}
+	}
+
 	// Store events, check for error after publishing on the bus.
-	if err := r.eventStore.Save(uncommittedEvents, aggregate.Version()); err != nil {
+	if err := AdaptEventStore(r.eventStore).SaveContext(ctx, uncommittedEvents, originalVersion); err != nil {
 		return err
 	}
 
-	// TODO: Possibly apply the events and increment the aggregate version here
-	// to have a up to date aggregate. Currently it is discarded by the
-	// command handler after saving.
+	// Apply the events to have an up to date aggregate after saving.
+	if !appliedOnStore {
+		for _, event := range uncommittedEvents {
+			aggregate.ApplyEvent(event)
+			aggregate.IncrementVersion()
+		}
+	}
 
-	// Publish all events on the bus.
-	for _, event := range uncommittedEvents {
-		r.eventBus.PublishEvent(event)
+	// Publish all events on the bus, with the version of the aggregate after
+	// each event in the context.
+	// The context can not be done as it is not canceled with ctx.
+	eventBus := AdaptEventBus(r.eventBus)
+	publishCtx := context.WithoutCancel(ctx)
+	for i, event := range uncommittedEvents {
+		eventBus.PublishEventContext(WithEventVersion(publishCtx, originalVersion+i+1), event)
 	}
 
 	aggregate.ClearUncommittedEvents()
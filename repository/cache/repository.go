@@ -0,0 +1,245 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// ErrNoRepositoryDefined is if no repository has been defined.
+var ErrNoRepositoryDefined = errors.New("no repository defined")
+
+// ErrInvalidSize is when the size of the cache is not positive.
+var ErrInvalidSize = errors.New("invalid cache size")
+
+// ErrInvalidRepository is when the repository of a tenant can not catch up
+// aggregates.
+var ErrInvalidRepository = errors.New("invalid repository")
+
+// Stats are the statistics of a cache.
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+
+	// Size is the current number of aggregates in the cache.
+	Size int
+}
+
+// Repository wraps a repository with an in process LRU cache of aggregates,
+// typically wrapping an eventhorizon.EventSourcingRepository. Cached
+// aggregates are brought up to date with the events saved after they were
+// cached, which only loads the newest events if the event store is an
+// eventhorizon.IncrementalEventStore.
+//
+// An aggregate is removed from the cache while it is loaded, and is cached
+// again when it is saved, so concurrent commands never share an aggregate and
+// aggregates of failed commands are not cached. Aggregates are invalidated if
+// saving fails, for example because of a concurrency error in the store.
+type Repository struct {
+	repo eh.CatchUpRepository
+	size int
+
+	items map[key]*list.Element
+	lru   *list.List
+	stats Stats
+	mu    sync.Mutex
+
+	tenants   map[eh.TenantID]*Repository
+	tenantsMu sync.Mutex
+}
+
+type key struct {
+	aggregateType eh.AggregateType
+	id            eh.UUID
+}
+
+type entry struct {
+	key       key
+	aggregate eh.Aggregate
+}
+
+// NewRepository creates a new Repository, which caches up to size aggregates.
+func NewRepository(repo eh.CatchUpRepository, size int) (*Repository, error) {
+	if repo == nil {
+		return nil, ErrNoRepositoryDefined
+	}
+
+	if size <= 0 {
+		return nil, ErrInvalidSize
+	}
+
+	r := &Repository{
+		repo:    repo,
+		size:    size,
+		items:   make(map[key]*list.Element),
+		lru:     list.New(),
+		tenants: make(map[eh.TenantID]*Repository),
+	}
+	return r, nil
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantRepository interface. Each tenant has its own cache
+// with the same size, of the repository of the tenant of the wrapped
+// repository. Returns eventhorizon.ErrTenantsNotSupported if the wrapped
+// repository is not multi tenant.
+func (r *Repository) ForTenant(tenantID eh.TenantID) (eh.Repository, error) {
+	if err := tenantID.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.tenantsMu.Lock()
+	defer r.tenantsMu.Unlock()
+
+	if tenant, ok := r.tenants[tenantID]; ok {
+		return tenant, nil
+	}
+
+	m, ok := r.repo.(eh.MultiTenantRepository)
+	if !ok {
+		return nil, eh.ErrTenantsNotSupported
+	}
+	repo, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+	catchUpRepo, ok := repo.(eh.CatchUpRepository)
+	if !ok {
+		return nil, ErrInvalidRepository
+	}
+
+	tenant, err := NewRepository(catchUpRepo, r.size)
+	if err != nil {
+		return nil, err
+	}
+	r.tenants[tenantID] = tenant
+
+	return tenant, nil
+}
+
+// Load implements the Load method of the eventhorizon.Repository interface.
+// Cached aggregates are brought up to date, other aggregates are loaded by the
+// wrapped repository.
+func (r *Repository) Load(aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	return r.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextRepository interface.
+func (r *Repository) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	r.mu.Lock()
+	var aggregate eh.Aggregate
+	if e, ok := r.items[key{aggregateType, id}]; ok {
+		aggregate = e.Value.(*entry).aggregate
+		r.remove(e)
+		r.stats.Hits++
+	} else {
+		r.stats.Misses++
+	}
+	r.mu.Unlock()
+
+	if aggregate == nil {
+		return eh.AdaptRepository(r.repo).LoadContext(ctx, aggregateType, id)
+	}
+
+	if err := r.repo.CatchUp(ctx, aggregate); err != nil {
+		return nil, err
+	}
+
+	return aggregate, nil
+}
+
+// Save implements the Save method of the eventhorizon.Repository interface.
+// The aggregate is cached if it was saved, and invalidated otherwise.
+func (r *Repository) Save(aggregate eh.Aggregate) error {
+	return r.SaveContext(context.Background(), aggregate)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextRepository interface.
+func (r *Repository) SaveContext(ctx context.Context, aggregate eh.Aggregate) error {
+	k := key{aggregate.AggregateType(), aggregate.AggregateID()}
+	if err := eh.AdaptRepository(r.repo).SaveContext(ctx, aggregate); err != nil {
+		r.Invalidate(k.aggregateType, k.id)
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.add(k, aggregate)
+
+	return nil
+}
+
+// Invalidate removes an aggregate from the cache.
+func (r *Repository) Invalidate(aggregateType eh.AggregateType, id eh.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e, ok := r.items[key{aggregateType, id}]; ok {
+		r.remove(e)
+	}
+}
+
+// Purge removes all aggregates from the cache.
+func (r *Repository) Purge() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.items = make(map[key]*list.Element)
+	r.lru.Init()
+}
+
+// Stats returns the statistics of the cache.
+func (r *Repository) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.Size = r.lru.Len()
+	return stats
+}
+
+// add adds an aggregate to the cache and evicts the least recently used
+// aggregate if the cache is full, the lock must be held. An aggregate that is
+// already cached is only replaced by a newer version.
+func (r *Repository) add(k key, aggregate eh.Aggregate) {
+	if e, ok := r.items[k]; ok {
+		if e.Value.(*entry).aggregate.Version() > aggregate.Version() {
+			return
+		}
+		r.remove(e)
+	}
+
+	r.items[k] = r.lru.PushFront(&entry{key: k, aggregate: aggregate})
+
+	if r.lru.Len() > r.size {
+		r.remove(r.lru.Back())
+		r.stats.Evictions++
+	}
+}
+
+// remove removes an element from the cache, the lock must be held.
+func (r *Repository) remove(e *list.Element) {
+	r.lru.Remove(e)
+	delete(r.items, e.Value.(*entry).key)
+}
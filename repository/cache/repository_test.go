@@ -0,0 +1,177 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"testing"
+
+	eh "github.com/looplab/eventhorizon"
+	localbus "github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+	"github.com/looplab/eventhorizon/mocks"
+)
+
+func TestRepository(t *testing.T) {
+	store := memory.NewEventStore()
+	baseRepo, err := eh.NewEventSourcingRepository(store, localbus.NewEventBus())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := NewRepository(nil, 10); err != ErrNoRepositoryDefined {
+		t.Error("there should be a ErrNoRepositoryDefined error:", err)
+	}
+	if _, err := NewRepository(baseRepo, 0); err != ErrInvalidSize {
+		t.Error("there should be a ErrInvalidSize error:", err)
+	}
+
+	repo, err := NewRepository(baseRepo, 2)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("load and save new aggregate")
+	id := eh.NewUUID()
+	agg, err := repo.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	agg.(*mocks.Aggregate).StoreEvent(&mocks.Event{id, "event1"})
+	if err := repo.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := repo.Stats(); stats != (Stats{Misses: 1, Size: 1}) {
+		t.Error("the stats should be correct:", stats)
+	}
+
+	t.Log("load cached aggregate with newer events in the store")
+	event2 := &mocks.Event{id, "event2"}
+	if err := store.Save([]eh.Event{event2}, 1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	cached, err := repo.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if cached != agg {
+		t.Error("the cached aggregate should be loaded")
+	}
+	if cached.Version() != 2 {
+		t.Error("the version should be 2:", cached.Version())
+	}
+	if events := cached.(*mocks.Aggregate).Events; len(events) != 2 || events[1] != event2 {
+		t.Error("the newer event should be applied:", events)
+	}
+	if stats := repo.Stats(); stats != (Stats{Hits: 1, Misses: 1, Size: 0}) {
+		t.Error("the loaded aggregate should not be cached:", stats)
+	}
+
+	t.Log("invalidate aggregate on concurrency error")
+	if err := repo.Save(cached); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := store.Save([]eh.Event{&mocks.Event{id, "event3"}}, 2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	cached.(*mocks.Aggregate).StoreEvent(&mocks.Event{id, "event4"})
+	if err := repo.Save(cached); err != eh.ErrIncorrectAggregateVersion {
+		t.Error("there should be a ErrIncorrectAggregateVersion error:", err)
+	}
+	if stats := repo.Stats(); stats.Size != 0 {
+		t.Error("the aggregate should be invalidated:", stats)
+	}
+	loaded, err := repo.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if loaded == cached {
+		t.Error("the aggregate should be loaded from the store")
+	}
+	if loaded.Version() != 3 {
+		t.Error("the version should be 3:", loaded.Version())
+	}
+
+	t.Log("evict least recently used aggregate")
+	var ids []eh.UUID
+	for i := 0; i < 3; i++ {
+		id := eh.NewUUID()
+		ids = append(ids, id)
+		agg, err := repo.Load(mocks.AggregateType, id)
+		if err != nil {
+			t.Fatal("there should be no error:", err)
+		}
+		if err := repo.Save(agg); err != nil {
+			t.Error("there should be no error:", err)
+		}
+	}
+	if stats := repo.Stats(); stats.Size != 2 || stats.Evictions != 1 {
+		t.Error("the aggregate should be evicted:", stats)
+	}
+	hits := repo.Stats().Hits
+	if _, err := repo.Load(mocks.AggregateType, ids[0]); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if repo.Stats().Hits != hits {
+		t.Error("the evicted aggregate should not be cached")
+	}
+
+	t.Log("invalidate and purge")
+	repo.Invalidate(mocks.AggregateType, ids[1])
+	if stats := repo.Stats(); stats.Size != 1 {
+		t.Error("the aggregate should be invalidated:", stats)
+	}
+	repo.Purge()
+	if stats := repo.Stats(); stats.Size != 0 {
+		t.Error("the cache should be empty:", stats)
+	}
+}
+
+func TestRepositoryTenants(t *testing.T) {
+	store := memory.NewEventStore()
+	baseRepo, err := eh.NewEventSourcingRepository(store, localbus.NewEventBus())
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	repo, err := NewRepository(baseRepo, 2)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	if _, err := repo.ForTenant(""); err != eh.ErrInvalidTenant {
+		t.Error("there should be a ErrInvalidTenant error:", err)
+	}
+	repoA, err := repo.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if r, err := repo.ForTenant("a"); err != nil || r != repoA {
+		t.Error("the cache of the tenant should be reused:", r, err)
+	}
+
+	id := eh.NewUUID()
+	agg, err := repoA.Load(mocks.AggregateType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := repoA.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if stats := repoA.(*Repository).Stats(); stats.Size != 1 {
+		t.Error("the aggregate should be cached for the tenant:", stats)
+	}
+	if stats := repo.Stats(); stats.Size != 0 {
+		t.Error("the aggregate should not be cached without tenant:", stats)
+	}
+}
@@ -15,8 +15,10 @@
 package eventhorizon
 
 import (
+	"context"
 	"errors"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -143,8 +145,11 @@ func TestEventSourcingRepositorySaveEvents(t *testing.T) {
 	if len(agg.GetUncommittedEvents()) != 0 {
 		t.Error("there should be no uncommitted events:", agg.GetUncommittedEvents())
 	}
-	if agg.Version() != 0 {
-		t.Error("the version should be 0:", agg.Version())
+	if agg.Version() != 1 {
+		t.Error("the version should be 1:", agg.Version())
+	}
+	if agg.appliedEvent != event1 {
+		t.Error("the event should be applied:", agg.appliedEvent)
 	}
 
 	if !reflect.DeepEqual(bus.Events, []Event{event1}) {
@@ -158,6 +163,162 @@ func TestEventSourcingRepositorySaveEvents(t *testing.T) {
 	}
 }
 
+func TestEventSourcingRepositorySaveEventsAppliedOnStore(t *testing.T) {
+	repo, store, _ := createRepoAndStore(t)
+
+	id := NewUUID()
+	agg := &TestAggregate{
+		AggregateBase: NewAggregateBase(id),
+	}
+	agg.EnableApplyOnStore(agg)
+
+	event1 := &TestEvent{id, "event1"}
+	event2 := &TestEvent{id, "event2"}
+	agg.StoreEvent(event1)
+	agg.StoreEvent(event2)
+	if agg.Version() != 2 {
+		t.Error("the version should be 2:", agg.Version())
+	}
+	if agg.appliedEvent != event2 {
+		t.Error("the event should be applied:", agg.appliedEvent)
+	}
+	if err := repo.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if agg.Version() != 2 {
+		t.Error("the version should be 2:", agg.Version())
+	}
+
+	events, err := store.Load(TestAggregateType, id)
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(events) != 2 {
+		t.Fatal("there should be two events stored:", len(events))
+	}
+	if events[0].Version() != 1 || events[1].Version() != 2 {
+		t.Error("the stored versions should be correct:", events[0].Version(), events[1].Version())
+	}
+}
+
+func TestEventSourcingRepositoryCatchUp(t *testing.T) {
+	repo, store, _ := createRepoAndStore(t)
+
+	id := NewUUID()
+	agg := &TestAggregate{
+		AggregateBase: NewAggregateBase(id),
+	}
+	event1 := &TestEvent{id, "event1"}
+	agg.StoreEvent(event1)
+	if err := repo.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	event2 := &TestEvent{id, "event2"}
+	if err := store.Save([]Event{event2}, 1); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := repo.CatchUp(context.Background(), agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if agg.Version() != 2 {
+		t.Error("the version should be 2:", agg.Version())
+	}
+	if agg.appliedEvent != event2 {
+		t.Error("the newer event should be applied:", agg.appliedEvent)
+	}
+
+	store.err = errors.New("error")
+	if err := repo.CatchUp(context.Background(), agg); err == nil || err.Error() != "error" {
+		t.Error("there should be an error named 'error':", err)
+	}
+}
+
+type TestSnapshotAggregate struct {
+	*AggregateBase
+
+	contents []string
+}
+
+func (a *TestSnapshotAggregate) AggregateType() AggregateType        { return TestAggregateType }
+func (a *TestSnapshotAggregate) HandleCommand(command Command) error { return nil }
+func (a *TestSnapshotAggregate) ApplyEvent(event Event) {
+	a.contents = append(a.contents, event.(*TestEvent).Content)
+}
+func (a *TestSnapshotAggregate) SnapshotState() ([]byte, error) {
+	return []byte(strings.Join(a.contents, ",")), nil
+}
+func (a *TestSnapshotAggregate) ApplySnapshotState(state []byte) error {
+	a.contents = strings.Split(string(state), ",")
+	return nil
+}
+
+type MockSnapshotEventStore struct {
+	*MockEventStore
+	snapshot *Snapshot
+}
+
+func (m *MockSnapshotEventStore) SaveSnapshot(ctx context.Context, aggregateType AggregateType, id UUID, snapshot Snapshot) error {
+	m.snapshot = &snapshot
+	return nil
+}
+
+func (m *MockSnapshotEventStore) LoadSnapshot(ctx context.Context, aggregateType AggregateType, id UUID) (*Snapshot, error) {
+	return m.snapshot, nil
+}
+
+func TestEventSourcingRepositorySnapshot(t *testing.T) {
+	store := &MockSnapshotEventStore{MockEventStore: &MockEventStore{}}
+	repo, err := NewEventSourcingRepository(store, &MockEventBus{})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	registry := NewRegistry()
+	if err := registry.RegisterAggregate(func(id UUID) Aggregate {
+		return &TestSnapshotAggregate{AggregateBase: NewAggregateBase(id)}
+	}); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	repo.SetRegistry(registry)
+
+	id := NewUUID()
+	agg := &TestSnapshotAggregate{AggregateBase: NewAggregateBase(id)}
+	agg.StoreEvent(&TestEvent{id, "event1"})
+	if err := repo.SaveSnapshot(context.Background(), agg); err != ErrUncommittedEvents {
+		t.Error("there should be a ErrUncommittedEvents error:", err)
+	}
+	agg.StoreEvent(&TestEvent{id, "event2"})
+	if err := repo.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if err := repo.SaveSnapshot(context.Background(), agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if store.snapshot == nil || store.snapshot.Version != 2 || string(store.snapshot.State) != "event1,event2" {
+		t.Error("the snapshot should be saved:", store.snapshot)
+	}
+
+	// Replace the events before the snapshot, which should not be applied.
+	store.Events[0] = MockEventRecord{event: &TestEvent{id, "truncated"}, version: 1}
+	if err := store.Save([]Event{&TestEvent{id, "event3"}}, 2); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	loaded, err := repo.Load(TestAggregateType, id)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if loaded.Version() != 3 {
+		t.Error("the version should be 3:", loaded.Version())
+	}
+	if contents := loaded.(*TestSnapshotAggregate).contents; !reflect.DeepEqual(contents, []string{"event1", "event2", "event3"}) {
+		t.Error("the aggregate should be restored from the snapshot:", contents)
+	}
+
+	if err := repo.SaveSnapshot(context.Background(), &TestAggregate{AggregateBase: NewAggregateBase(id)}); err != ErrSnapshotsNotSupported {
+		t.Error("there should be a ErrSnapshotsNotSupported error:", err)
+	}
+}
+
 func TestEventSourcingRepositoryAggregateNotRegistered(t *testing.T) {
 	repo, _, _ := createRepoAndStore(t)
 
@@ -15,7 +15,7 @@
 package eventhorizon
 
 import (
-	"log"
+	"context"
 )
 
 // Saga is an interface for a CQRS saga that listens to events and generate
@@ -45,6 +45,7 @@ type SagaType string
 type SagaBase struct {
 	saga       Saga
 	commandBus CommandBus
+	logger     Logger
 }
 
 // NewSagaBase creates a new SagaBase.
@@ -52,19 +53,38 @@ func NewSagaBase(commandBus CommandBus, saga Saga) *SagaBase {
 	return &SagaBase{
 		saga:       saga,
 		commandBus: commandBus,
+		logger:     DefaultLogger(),
 	}
 }
 
+// SetLogger sets the logger used for commands that fail.
+func (s *SagaBase) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
 // HandleEvent implements the HandleEvent method of the EventHandler interface.
 func (s *SagaBase) HandleEvent(event Event) {
+	s.HandleEventContext(context.Background(), event)
+}
+
+// HandleEventContext implements the HandleEventContext method of the
+// ContextEventHandler interface. The commands are handled with the context of
+// the event, which keeps request scoped values such as the trace and tenant.
+func (s *SagaBase) HandleEventContext(ctx context.Context, event Event) {
 	// Run the saga and collect commands.
 	commands := s.saga.RunSaga(event)
 
 	// Dispatch commands back on the command bus.
+	commandBus := AdaptCommandHandler(s.commandBus)
 	for _, command := range commands {
-		if err := s.commandBus.HandleCommand(command); err != nil {
+		if err := commandBus.HandleCommandContext(ctx, command); err != nil {
 			// TODO: Better error handling.
-			log.Println("could not handle command in saga:", err)
+			s.logger.Error("could not handle command in saga",
+				F("saga_type", s.saga.SagaType()),
+				F("command_type", command.CommandType()),
+				F("aggregate_id", command.AggregateID()),
+				F("error", err),
+			)
 		}
 	}
 }
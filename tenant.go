@@ -0,0 +1,106 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"regexp"
+)
+
+// ErrInvalidTenant is when a tenant ID is empty or contains other characters
+// than letters, digits, '-' and '_'.
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// ErrCrossTenantAccess is when an event of one tenant is loaded or saved for
+// another tenant.
+var ErrCrossTenantAccess = errors.New("cross tenant access")
+
+// ErrTenantsNotSupported is when a command for a tenant is handled with a
+// repository that does not support tenants.
+var ErrTenantsNotSupported = errors.New("tenants not supported")
+
+// TenantID is the ID of a tenant, used to partition the data of many tenants
+// in the same stores, repositories and buses. It is used in database, table,
+// index and key names and can therefore only contain letters, digits, '-' and
+// '_'.
+type TenantID string
+
+var validTenant = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Validate returns ErrInvalidTenant if the tenant ID is not valid.
+func (t TenantID) Validate() error {
+	if !validTenant.MatchString(string(t)) {
+		return ErrInvalidTenant
+	}
+	return nil
+}
+
+// TenantCommand is a command for an aggregate of a tenant. It is handled with
+// the repository of the tenant by the AggregateCommandHandler.
+type TenantCommand interface {
+	Command
+
+	// TenantID returns the ID of the tenant of the aggregate.
+	TenantID() TenantID
+}
+
+// TenantEvent is an event that knows the tenant of its aggregate. The tenant
+// is set by the EventSourcingRepository when saving events, and checked when
+// loading them to reject loading the events of other tenants.
+type TenantEvent interface {
+	Event
+
+	// TenantID returns the ID of the tenant of the aggregate.
+	TenantID() TenantID
+
+	// SetTenantID sets the ID of the tenant of the aggregate.
+	SetTenantID(TenantID)
+}
+
+// MultiTenantRepository is a repository that can be partitioned by tenant.
+type MultiTenantRepository interface {
+	Repository
+
+	// ForTenant returns the repository of a tenant.
+	ForTenant(TenantID) (Repository, error)
+}
+
+// MultiTenantEventStore is an event store that can be partitioned by tenant.
+type MultiTenantEventStore interface {
+	EventStore
+
+	// ForTenant returns the event store of a tenant, which only loads and
+	// saves the events of the tenant.
+	ForTenant(TenantID) (EventStore, error)
+}
+
+// MultiTenantEventBus is an event bus that can be partitioned by tenant.
+type MultiTenantEventBus interface {
+	EventBus
+
+	// ForTenant returns the event bus of a tenant. Events published on it are
+	// only received by the handlers and observers added to it.
+	ForTenant(TenantID) (EventBus, error)
+}
+
+// MultiTenantReadRepository is a read repository that can be partitioned by
+// tenant.
+type MultiTenantReadRepository interface {
+	ReadRepository
+
+	// ForTenant returns the read repository of a tenant, which only finds and
+	// saves the models of the tenant.
+	ForTenant(TenantID) (ReadRepository, error)
+}
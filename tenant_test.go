@@ -0,0 +1,181 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"testing"
+)
+
+const TestTenantCommandType CommandType = "TestTenantCommand"
+
+const TestTenantEventType EventType = "TestTenantEvent"
+
+type TestTenantCommand struct {
+	TestID  UUID
+	Tenant  TenantID
+	Content string
+}
+
+func (t TestTenantCommand) AggregateID() UUID            { return t.TestID }
+func (t TestTenantCommand) AggregateType() AggregateType { return TestAggregateType }
+func (t TestTenantCommand) CommandType() CommandType     { return TestTenantCommandType }
+func (t TestTenantCommand) TenantID() TenantID           { return t.Tenant }
+
+type TestTenantEvent struct {
+	TestID  UUID
+	Tenant  TenantID
+	Content string
+}
+
+func (t TestTenantEvent) AggregateID() UUID              { return t.TestID }
+func (t TestTenantEvent) AggregateType() AggregateType   { return TestAggregateType }
+func (t TestTenantEvent) EventType() EventType           { return TestTenantEventType }
+func (t TestTenantEvent) TenantID() TenantID             { return t.Tenant }
+func (t *TestTenantEvent) SetTenantID(tenantID TenantID) { t.Tenant = tenantID }
+
+type MockMultiTenantEventStore struct {
+	MockEventStore
+	Tenants map[TenantID]*MockEventStore
+}
+
+func (m *MockMultiTenantEventStore) ForTenant(tenantID TenantID) (EventStore, error) {
+	if _, ok := m.Tenants[tenantID]; !ok {
+		m.Tenants[tenantID] = &MockEventStore{Events: make([]EventRecord, 0)}
+	}
+	return m.Tenants[tenantID], nil
+}
+
+type MockMultiTenantEventBus struct {
+	MockEventBus
+	Tenants map[TenantID]*MockEventBus
+}
+
+func (m *MockMultiTenantEventBus) ForTenant(tenantID TenantID) (EventBus, error) {
+	if _, ok := m.Tenants[tenantID]; !ok {
+		m.Tenants[tenantID] = &MockEventBus{Events: make([]Event, 0)}
+	}
+	return m.Tenants[tenantID], nil
+}
+
+func TestTenantIDValidate(t *testing.T) {
+	for _, tenantID := range []TenantID{"a", "tenant-1", "Tenant_2"} {
+		if err := tenantID.Validate(); err != nil {
+			t.Error("there should be no error:", tenantID, err)
+		}
+	}
+	for _, tenantID := range []TenantID{"", "a b", "a/b", "a.b", "a:b"} {
+		if err := tenantID.Validate(); err != ErrInvalidTenant {
+			t.Error("there should be a ErrInvalidTenant error:", tenantID, err)
+		}
+	}
+}
+
+func TestEventSourcingRepositoryForTenant(t *testing.T) {
+	repo, store, _ := createRepoAndStore(t)
+
+	if _, err := repo.ForTenant(""); err != ErrInvalidTenant {
+		t.Error("there should be a ErrInvalidTenant error:", err)
+	}
+	repoA, err := repo.ForTenant("a")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	repoB, err := repo.ForTenant("b")
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("save event for tenant")
+	id := NewUUID()
+	agg := &TestAggregate{AggregateBase: NewAggregateBase(id)}
+	event := &TestTenantEvent{TestID: id, Content: "event"}
+	agg.StoreEvent(event)
+	if err := repoA.Save(agg); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if event.Tenant != "a" {
+		t.Error("the tenant should be set:", event.Tenant)
+	}
+	if len(store.Events) != 1 {
+		t.Error("there should be one event stored:", len(store.Events))
+	}
+
+	t.Log("load event for tenant")
+	if _, err := repoA.Load(TestAggregateType, id); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	t.Log("load event of other tenant")
+	if _, err := repoB.Load(TestAggregateType, id); err != ErrCrossTenantAccess {
+		t.Error("there should be a ErrCrossTenantAccess error:", err)
+	}
+	if _, err := repo.Load(TestAggregateType, id); err != ErrCrossTenantAccess {
+		t.Error("there should be a ErrCrossTenantAccess error:", err)
+	}
+
+	t.Log("save event of other tenant")
+	agg.StoreEvent(&TestTenantEvent{TestID: id, Tenant: "a", Content: "event"})
+	if err := repoB.Save(agg); err != ErrCrossTenantAccess {
+		t.Error("there should be a ErrCrossTenantAccess error:", err)
+	}
+}
+
+func TestEventSourcingRepositoryForTenantMultiTenant(t *testing.T) {
+	store := &MockMultiTenantEventStore{Tenants: make(map[TenantID]*MockEventStore)}
+	bus := &MockMultiTenantEventBus{Tenants: make(map[TenantID]*MockEventBus)}
+	repo, err := NewEventSourcingRepository(store, bus)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	h, err := NewAggregateCommandHandler(repo)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.SetAggregate(TestAggregateType, TestTenantCommandType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	t.Log("handle command for tenant")
+	id := NewUUID()
+	err = h.HandleCommand(&TestTenantCommand{TestID: id, Tenant: "a", Content: "command"})
+	if err != nil {
+		t.Error("there should be no error:", err)
+	}
+	if len(store.Events) != 0 || len(store.Tenants["a"].Events) != 1 {
+		t.Error("the event should be stored for the tenant:", store.Tenants["a"].Events)
+	}
+	if len(bus.Events) != 0 || len(bus.Tenants["a"].Events) != 1 {
+		t.Error("the event should be published for the tenant:", bus.Tenants["a"].Events)
+	}
+	if e := store.Tenants["a"].Events[0].Event().(TenantEvent); e.TenantID() != "a" {
+		t.Error("the tenant should be set:", e.TenantID())
+	}
+}
+
+func TestCommandHandlerTenantsNotSupported(t *testing.T) {
+	h, err := NewAggregateCommandHandler(&MockRepository{})
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	if err := h.SetAggregate(TestAggregateType, TestTenantCommandType); err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+
+	err = h.HandleCommand(&TestTenantCommand{TestID: NewUUID(), Tenant: "a", Content: "command"})
+	if err != ErrTenantsNotSupported {
+		t.Error("there should be a ErrTenantsNotSupported error:", err)
+	}
+}
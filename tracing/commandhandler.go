@@ -0,0 +1,55 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// CommandHandler is a command handler that traces the handling of commands,
+// typically wrapping an eventhorizon.AggregateCommandHandler.
+type CommandHandler struct {
+	handler eh.ContextCommandHandler
+}
+
+// NewCommandHandler creates a new CommandHandler.
+func NewCommandHandler(handler eh.CommandHandler) *CommandHandler {
+	return &CommandHandler{
+		handler: eh.AdaptCommandHandler(handler),
+	}
+}
+
+// HandleCommand implements the HandleCommand method of the
+// eventhorizon.CommandHandler interface.
+func (h *CommandHandler) HandleCommand(command eh.Command) error {
+	return h.HandleCommandContext(context.Background(), command)
+}
+
+// HandleCommandContext implements the HandleCommandContext method of the
+// eventhorizon.ContextCommandHandler interface.
+func (h *CommandHandler) HandleCommandContext(ctx context.Context, command eh.Command) error {
+	ctx, span := startSpan(ctx, "HandleCommand "+string(command.CommandType()), trace.SpanKindInternal,
+		CommandTypeKey.String(string(command.CommandType())),
+		AggregateTypeKey.String(string(command.AggregateType())),
+		AggregateIDKey.String(command.AggregateID().String()),
+	)
+	err := h.handler.HandleCommandContext(ctx, command)
+	endSpan(span, err)
+	return err
+}
@@ -0,0 +1,141 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// EventBus is an event bus that traces the publishing of events, and the
+// handling of them by the handlers and observers added to it. Remote observers
+// are traced as part of the trace of the publisher if the wrapped bus sends
+// the context values along with the events, like the Redis bus.
+type EventBus struct {
+	eventBus eh.ContextEventBus
+}
+
+// NewEventBus creates a new EventBus.
+func NewEventBus(eventBus eh.EventBus) *EventBus {
+	return &EventBus{
+		eventBus: eh.AdaptEventBus(eventBus),
+	}
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantEventBus interface. The bus is shared by all tenants
+// if the wrapped bus is not multi tenant.
+func (b *EventBus) ForTenant(tenantID eh.TenantID) (eh.EventBus, error) {
+	m, ok := b.eventBus.(eh.MultiTenantEventBus)
+	if !ok {
+		return b, nil
+	}
+
+	eventBus, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewEventBus(eventBus), nil
+}
+
+// PublishEvent implements the PublishEvent method of the
+// eventhorizon.EventBus interface.
+func (b *EventBus) PublishEvent(event eh.Event) {
+	b.PublishEventContext(context.Background(), event)
+}
+
+// PublishEventContext implements the PublishEventContext method of the
+// eventhorizon.ContextEventBus interface.
+func (b *EventBus) PublishEventContext(ctx context.Context, event eh.Event) error {
+	ctx, span := startSpan(ctx, "PublishEvent "+string(event.EventType()), trace.SpanKindProducer,
+		eventAttributes(event)...,
+	)
+	err := b.eventBus.PublishEventContext(ctx, event)
+	endSpan(span, err)
+	return err
+}
+
+// AddHandler implements the AddHandler method of the eventhorizon.EventBus
+// interface.
+func (b *EventBus) AddHandler(handler eh.EventHandler, eventType eh.EventType) {
+	b.eventBus.AddHandler(&eventHandler{eh.AdaptEventHandler(handler)}, eventType)
+}
+
+// AddObserver implements the AddObserver method of the eventhorizon.EventBus
+// interface.
+func (b *EventBus) AddObserver(observer eh.EventObserver) {
+	b.eventBus.AddObserver(&eventObserver{eh.AdaptEventObserver(observer)})
+}
+
+// SetHandlingStrategy implements the SetHandlingStrategy method of the
+// eventhorizon.EventBus interface.
+func (b *EventBus) SetHandlingStrategy(strategy eh.EventHandlingStrategy) {
+	b.eventBus.SetHandlingStrategy(strategy)
+}
+
+// eventHandler traces the handling of events.
+type eventHandler struct {
+	eh.ContextEventHandler
+}
+
+// HandleEvent implements the HandleEvent method of the
+// eventhorizon.EventHandler interface.
+func (h *eventHandler) HandleEvent(event eh.Event) {
+	h.HandleEventContext(context.Background(), event)
+}
+
+// HandleEventContext implements the HandleEventContext method of the
+// eventhorizon.ContextEventHandler interface.
+func (h *eventHandler) HandleEventContext(ctx context.Context, event eh.Event) {
+	ctx, span := startSpan(ctx, "HandleEvent "+string(event.EventType()), trace.SpanKindConsumer,
+		append(eventAttributes(event), HandlerTypeKey.String(string(h.HandlerType())))...,
+	)
+	h.ContextEventHandler.HandleEventContext(ctx, event)
+	span.End()
+}
+
+// eventObserver traces the notifications of events.
+type eventObserver struct {
+	eh.ContextEventObserver
+}
+
+// Notify implements the Notify method of the eventhorizon.EventObserver
+// interface.
+func (o *eventObserver) Notify(event eh.Event) {
+	o.NotifyContext(context.Background(), event)
+}
+
+// NotifyContext implements the NotifyContext method of the
+// eventhorizon.ContextEventObserver interface.
+func (o *eventObserver) NotifyContext(ctx context.Context, event eh.Event) {
+	ctx, span := startSpan(ctx, "NotifyEvent "+string(event.EventType()), trace.SpanKindConsumer,
+		eventAttributes(event)...,
+	)
+	o.ContextEventObserver.NotifyContext(ctx, event)
+	span.End()
+}
+
+func eventAttributes(event eh.Event) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		EventTypeKey.String(string(event.EventType())),
+		AggregateTypeKey.String(string(event.AggregateType())),
+		AggregateIDKey.String(event.AggregateID().String()),
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// Repository is a repository that traces the loading and saving of aggregates,
+// typically wrapping an eventhorizon.EventSourcingRepository.
+type Repository struct {
+	repository eh.ContextRepository
+}
+
+// NewRepository creates a new Repository.
+func NewRepository(repository eh.Repository) *Repository {
+	return &Repository{
+		repository: eh.AdaptRepository(repository),
+	}
+}
+
+// ForTenant implements the ForTenant method of the
+// eventhorizon.MultiTenantRepository interface. Returns
+// eventhorizon.ErrTenantsNotSupported if the wrapped repository is not multi
+// tenant.
+func (r *Repository) ForTenant(tenantID eh.TenantID) (eh.Repository, error) {
+	m, ok := r.repository.(eh.MultiTenantRepository)
+	if !ok {
+		return nil, eh.ErrTenantsNotSupported
+	}
+
+	repository, err := m.ForTenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRepository(repository), nil
+}
+
+// Load implements the Load method of the eventhorizon.Repository interface.
+func (r *Repository) Load(aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	return r.LoadContext(context.Background(), aggregateType, id)
+}
+
+// LoadContext implements the LoadContext method of the
+// eventhorizon.ContextRepository interface.
+func (r *Repository) LoadContext(ctx context.Context, aggregateType eh.AggregateType, id eh.UUID) (eh.Aggregate, error) {
+	ctx, span := startSpan(ctx, "LoadAggregate "+string(aggregateType), trace.SpanKindInternal,
+		AggregateTypeKey.String(string(aggregateType)),
+		AggregateIDKey.String(id.String()),
+	)
+	aggregate, err := r.repository.LoadContext(ctx, aggregateType, id)
+	if aggregate != nil {
+		span.SetAttributes(VersionKey.Int(aggregate.Version()))
+	}
+	endSpan(span, err)
+	return aggregate, err
+}
+
+// Save implements the Save method of the eventhorizon.Repository interface.
+func (r *Repository) Save(aggregate eh.Aggregate) error {
+	return r.SaveContext(context.Background(), aggregate)
+}
+
+// SaveContext implements the SaveContext method of the
+// eventhorizon.ContextRepository interface.
+func (r *Repository) SaveContext(ctx context.Context, aggregate eh.Aggregate) error {
+	ctx, span := startSpan(ctx, "SaveAggregate "+string(aggregate.AggregateType()), trace.SpanKindInternal,
+		AggregateTypeKey.String(string(aggregate.AggregateType())),
+		AggregateIDKey.String(aggregate.AggregateID().String()),
+		VersionKey.Int(aggregate.Version()),
+		EventCountKey.Int(len(aggregate.GetUncommittedEvents())),
+	)
+	err := r.repository.SaveContext(ctx, aggregate)
+	endSpan(span, err)
+	return err
+}
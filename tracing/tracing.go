@@ -0,0 +1,89 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tracing traces command handling, aggregates, event stores and event
+// buses with OpenTelemetry. The components are wrapped with the decorators of
+// this package, which start spans with the tracer provider registered with
+// otel.SetTracerProvider.
+//
+// Importing the package also registers a context marshaler that sends the
+// trace context along with events on the remote event buses, so that handlers
+// in other services, for example sagas and their follow-up commands, are
+// traced as part of the same trace.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	eh "github.com/looplab/eventhorizon"
+)
+
+// tracerName is the name of the tracer used for all spans.
+const tracerName = "github.com/looplab/eventhorizon"
+
+// Attribute keys used for the spans.
+const (
+	CommandTypeKey   = attribute.Key("eh.command_type")
+	AggregateTypeKey = attribute.Key("eh.aggregate_type")
+	AggregateIDKey   = attribute.Key("eh.aggregate_id")
+	EventTypeKey     = attribute.Key("eh.event_type")
+	EventCountKey    = attribute.Key("eh.event_count")
+	VersionKey       = attribute.Key("eh.version")
+	HandlerTypeKey   = attribute.Key("eh.handler_type")
+	TenantIDKey      = attribute.Key("eh.tenant_id")
+)
+
+// propagator propagates the trace context and baggage in the event headers.
+var propagator = propagation.NewCompositeTextMapPropagator(
+	propagation.TraceContext{},
+	propagation.Baggage{},
+)
+
+func init() {
+	eh.RegisterContextMarshaler(
+		func(ctx context.Context, values map[string]string) {
+			propagator.Inject(ctx, propagation.MapCarrier(values))
+		},
+		func(ctx context.Context, values map[string]string) context.Context {
+			return propagator.Extract(ctx, propagation.MapCarrier(values))
+		},
+	)
+}
+
+// startSpan starts a span with the tracer of the global tracer provider, with
+// the tenant of the context if any.
+func startSpan(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if tenantID, ok := eh.TenantIDFromContext(ctx); ok {
+		attrs = append(attrs, TenantIDKey.String(string(tenantID)))
+	}
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// endSpan records the error, if any, and ends the span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	eh "github.com/looplab/eventhorizon"
+	"github.com/looplab/eventhorizon/commandbus/local"
+	localbus "github.com/looplab/eventhorizon/eventbus/local"
+	"github.com/looplab/eventhorizon/eventstore/memory"
+)
+
+func init() {
+	eh.RegisterAggregate(func(id eh.UUID) eh.Aggregate {
+		return &TracedAggregate{AggregateBase: eh.NewAggregateBase(id)}
+	})
+	eh.RegisterEvent(func() eh.Event { return &StartedEvent{} })
+}
+
+const (
+	TracedAggregateType eh.AggregateType = "TracedAggregate"
+
+	StartCommandType    eh.CommandType = "Start"
+	FollowUpCommandType eh.CommandType = "FollowUp"
+
+	StartedEventType eh.EventType = "Started"
+)
+
+type StartCommand struct {
+	ID      eh.UUID
+	Content string
+}
+
+func (c StartCommand) AggregateID() eh.UUID            { return c.ID }
+func (c StartCommand) AggregateType() eh.AggregateType { return TracedAggregateType }
+func (c StartCommand) CommandType() eh.CommandType     { return StartCommandType }
+
+type FollowUpCommand struct {
+	ID eh.UUID
+}
+
+func (c FollowUpCommand) AggregateID() eh.UUID            { return c.ID }
+func (c FollowUpCommand) AggregateType() eh.AggregateType { return TracedAggregateType }
+func (c FollowUpCommand) CommandType() eh.CommandType     { return FollowUpCommandType }
+
+type StartedEvent struct {
+	ID eh.UUID
+}
+
+func (e StartedEvent) AggregateID() eh.UUID            { return e.ID }
+func (e StartedEvent) AggregateType() eh.AggregateType { return TracedAggregateType }
+func (e StartedEvent) EventType() eh.EventType         { return StartedEventType }
+
+type TracedAggregate struct {
+	*eh.AggregateBase
+}
+
+func (a *TracedAggregate) AggregateType() eh.AggregateType { return TracedAggregateType }
+func (a *TracedAggregate) ApplyEvent(event eh.Event)       {}
+
+func (a *TracedAggregate) HandleCommand(command eh.Command) error {
+	switch command := command.(type) {
+	case *StartCommand:
+		if command.Content == "error" {
+			return errors.New("command error")
+		}
+		a.StoreEvent(&StartedEvent{command.ID})
+	}
+	return nil
+}
+
+type FollowUpSaga struct {
+	*eh.SagaBase
+}
+
+func (s *FollowUpSaga) SagaType() eh.SagaType { return "FollowUpSaga" }
+
+func (s *FollowUpSaga) RunSaga(event eh.Event) []eh.Command {
+	return []eh.Command{&FollowUpCommand{event.AggregateID()}}
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	eventStore := NewEventStore(memory.NewEventStore())
+	eventBus := NewEventBus(localbus.NewEventBus())
+	repo, err := eh.NewEventSourcingRepository(eventStore, eventBus)
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	aggregateHandler, err := eh.NewAggregateCommandHandler(NewRepository(repo))
+	if err != nil {
+		t.Fatal("there should be no error:", err)
+	}
+	aggregateHandler.SetAggregate(TracedAggregateType, StartCommandType)
+	aggregateHandler.SetAggregate(TracedAggregateType, FollowUpCommandType)
+	handler := NewCommandHandler(aggregateHandler)
+
+	commandBus := local.NewCommandBus()
+	commandBus.SetHandler(handler, StartCommandType)
+	commandBus.SetHandler(handler, FollowUpCommandType)
+	saga := &FollowUpSaga{}
+	saga.SagaBase = eh.NewSagaBase(commandBus, saga)
+	eventBus.AddHandler(saga, StartedEventType)
+
+	t.Log("handle command")
+	if err := commandBus.HandleCommand(&StartCommand{eh.NewUUID(), "command"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+
+	spans := exporter.GetSpans()
+	parents := [][2]string{
+		{"LoadAggregate TracedAggregate", "HandleCommand Start"},
+		{"EventStore.Load", "LoadAggregate TracedAggregate"},
+		{"SaveAggregate TracedAggregate", "HandleCommand Start"},
+		{"EventStore.Save", "SaveAggregate TracedAggregate"},
+		{"PublishEvent Started", "SaveAggregate TracedAggregate"},
+		{"HandleEvent Started", "PublishEvent Started"},
+		{"HandleCommand FollowUp", "HandleEvent Started"},
+	}
+	for _, p := range parents {
+		if !hasParent(spans, p[0], p[1]) {
+			t.Error("the span should have the correct parent:", p[0], p[1])
+		}
+	}
+	root := spans[len(spans)-1]
+	if root.Name != "HandleCommand Start" || root.Parent.IsValid() {
+		t.Fatal("the root span should be correct:", root.Name)
+	}
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != root.SpanContext.TraceID() {
+			t.Error("the span should be in the same trace:", s.Name)
+		}
+	}
+
+	t.Log("handle command for tenant")
+	exporter.Reset()
+	ctx := eh.WithTenantID(context.Background(), "a")
+	if err := commandBus.HandleCommandContext(ctx, &StartCommand{eh.NewUUID(), "command"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	spans = exporter.GetSpans()
+	if s := spans[len(spans)-1]; !hasAttribute(s, TenantIDKey.String("a")) {
+		t.Error("the span should have the tenant:", s.Attributes)
+	}
+
+	t.Log("handle command with error")
+	exporter.Reset()
+	if err := handler.HandleCommand(&StartCommand{eh.NewUUID(), "error"}); err == nil {
+		t.Error("there should be an error")
+	}
+	spans = exporter.GetSpans()
+	if s := spans[len(spans)-1]; s.Status.Code != codes.Error {
+		t.Error("the span should have an error status:", s.Status)
+	}
+}
+
+// hasParent returns true if there is a span with the name and a parent span
+// with the parent name.
+func hasParent(spans tracetest.SpanStubs, name, parent string) bool {
+	for _, s := range spans {
+		if s.Name != name {
+			continue
+		}
+		for _, p := range spans {
+			if p.Name == parent && p.SpanContext.SpanID() == s.Parent.SpanID() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func hasAttribute(span tracetest.SpanStub, attr attribute.KeyValue) bool {
+	for _, a := range span.Attributes {
+		if a == attr {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPropagation(t *testing.T) {
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	values := eh.MarshalContext(ctx)
+	if values["traceparent"] == "" {
+		t.Fatal("the trace context should be marshaled:", values)
+	}
+
+	spanContext := trace.SpanContextFromContext(eh.UnmarshalContext(values))
+	if !spanContext.IsRemote() {
+		t.Error("the span context should be remote")
+	}
+	if spanContext.TraceID() != span.SpanContext().TraceID() ||
+		spanContext.SpanID() != span.SpanContext().SpanID() {
+		t.Error("the span context should be correct:", spanContext)
+	}
+}
@@ -0,0 +1,401 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Validator is an interface for commands, and structs in commands, that
+// validate themselves in addition to the rules in their struct tags. Validate
+// is only called when the fields have passed their rules. Errors of type
+// CommandFieldError and CommandFieldErrors returned by structs in commands get
+// the path of the struct as prefix of their field names.
+type Validator interface {
+	Validate() error
+}
+
+// CommandFieldErrors is a list of the invalid fields of a command, returned by
+// ValidateCommand.
+type CommandFieldErrors []CommandFieldError
+
+// Error implements the Error method of the error interface.
+func (e CommandFieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ValidateCommand validates the exported fields of a command with the rules in
+// their eh struct tags, and then with the Validate method of the command if it
+// implements Validator. All fields are required unless tagged as optional,
+// which means that strings can not be empty and that pointers, slices, maps
+// and interfaces can not be nil. Numbers and bools are never missing. Structs
+// are missing if all their fields are, and their fields are validated with
+// their own rules. The rules are separated by commas:
+//
+//	optional     the field can be missing, the other rules are used if not
+//	min=N        numbers must be at least N
+//	max=N        numbers must be at most N
+//	len=N        strings, slices, arrays and maps must have a length of N
+//	minlen=N     strings, slices, arrays and maps must have at least length N
+//	maxlen=N     strings, slices, arrays and maps must have at most length N
+//	enum=a|b|c   the value must be one of the listed values
+//	uuid         strings must be valid UUIDs
+//	regex=expr   strings must match the regular expression, which must be the
+//	             last rule as it can contain commas
+//
+// Options used by other packages must be set with IgnoreTagOption, all other
+// options are invalid rules.
+//
+// An example would be:
+//
+//	type CreateUser struct {
+//		ID    eh.UUID
+//		Name  string `eh:"maxlen=100"`
+//		Email string `eh:"optional,regex=^[^@]+@[^@]+$"`
+//		Age   int    `eh:"min=18,max=150"`
+//		Role  string `eh:"enum=admin|user"`
+//	}
+//
+// Invalid fields are returned as CommandFieldErrors, with one CommandFieldError
+// per field. Errors from Validate are returned as is.
+func ValidateCommand(command Command) error {
+	if errs := validateStruct(reflect.Indirect(reflect.ValueOf(command)), ""); len(errs) > 0 {
+		return errs
+	}
+
+	if v, ok := command.(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// validateStruct validates the exported fields of a struct, with the prefix
+// added to the field names.
+func validateStruct(rv reflect.Value, prefix string) CommandFieldErrors {
+	var errs CommandFieldErrors
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.PkgPath != "" {
+			continue // Skip private field.
+		}
+
+		name := prefix + field.Name
+		rules, err := parseFieldRules(field.Tag.Get("eh"))
+		if err != nil {
+			errs = append(errs, CommandFieldError{Field: name, Reason: err.Error()})
+			continue
+		}
+		errs = append(errs, validateField(rv.Field(i), name, rules)...)
+	}
+	return errs
+}
+
+func validateField(v reflect.Value, name string, rules *fieldRules) CommandFieldErrors {
+	switch v.Kind() {
+	case reflect.Func, reflect.Chan, reflect.Uintptr, reflect.UnsafePointer:
+		if rules.optional && v.IsZero() {
+			return nil
+		}
+		return CommandFieldErrors{{Field: name, Reason: "unsupported type"}}
+	}
+
+	if isMissing(v) {
+		if rules.optional {
+			return nil
+		}
+		return CommandFieldErrors{{Field: name, Reason: "missing"}}
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	if reason := rules.check(v); reason != "" {
+		return CommandFieldErrors{{Field: name, Reason: reason}}
+	}
+
+	return validateNested(v, name)
+}
+
+// validateNested validates structs and the structs in slices and arrays.
+func validateNested(v reflect.Value, name string) CommandFieldErrors {
+	switch v.Kind() {
+	case reflect.Struct:
+		if _, ok := v.Interface().(time.Time); ok {
+			return nil
+		}
+		if errs := validateStruct(v, name+"."); len(errs) > 0 {
+			return errs
+		}
+		return validateWithValidator(v, name)
+	case reflect.Slice, reflect.Array:
+		var errs CommandFieldErrors
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			for elem.Kind() == reflect.Ptr && !elem.IsNil() {
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Struct {
+				errs = append(errs, validateNested(elem, fmt.Sprintf("%s[%d]", name, i))...)
+			}
+		}
+		return errs
+	}
+	return nil
+}
+
+func validateWithValidator(v reflect.Value, name string) CommandFieldErrors {
+	var validator Validator
+	if val, ok := v.Interface().(Validator); ok {
+		validator = val
+	} else if v.CanAddr() {
+		if val, ok := v.Addr().Interface().(Validator); ok {
+			validator = val
+		}
+	}
+	if validator == nil {
+		return nil
+	}
+
+	switch err := validator.Validate().(type) {
+	case nil:
+		return nil
+	case CommandFieldErrors:
+		errs := make(CommandFieldErrors, len(err))
+		for i, e := range err {
+			errs[i] = CommandFieldError{Field: name + "." + e.Field, Reason: e.Reason}
+		}
+		return errs
+	case CommandFieldError:
+		return CommandFieldErrors{{Field: name + "." + err.Field, Reason: err.Reason}}
+	default:
+		return CommandFieldErrors{{Field: name, Reason: err.Error()}}
+	}
+}
+
+// isMissing returns true if a field has no value.
+func isMissing(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	case reflect.String:
+		return v.Len() == 0
+	case reflect.Array:
+		return v.IsZero()
+	case reflect.Struct:
+		// Special case to get zero values by method.
+		if t, ok := v.Interface().(time.Time); ok {
+			return t.IsZero()
+		}
+
+		// Check public fields for missing values.
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue // Skip private fields.
+			}
+			if !isMissing(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		// Don't check for zero for value types:
+		// Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32,
+		// Uint64, Float32, Float64, Complex64, Complex128
+		return false
+	}
+}
+
+// fieldRules are the parsed rules of a field.
+type fieldRules struct {
+	optional bool
+	min, max *float64
+	length   *int
+	minLen   *int
+	maxLen   *int
+	enum     []string
+	uuid     bool
+	regex    *regexp.Regexp
+}
+
+// fieldRulesCache caches parsed rules by tag, to only compile regexps once.
+var fieldRulesCache sync.Map
+
+// ignoredTagOptions are the options in eh struct tags that are used by other
+// packages, set with IgnoreTagOption.
+var ignoredTagOptions sync.Map
+
+// IgnoreTagOption makes ValidateCommand ignore an option in eh struct tags
+// that is used by another package, such as the pii option of the encryption
+// event store. Other unknown options are invalid rules.
+func IgnoreTagOption(option string) {
+	ignoredTagOptions.Store(option, true)
+}
+
+func parseFieldRules(tag string) (*fieldRules, error) {
+	if r, ok := fieldRulesCache.Load(tag); ok {
+		return r.(*fieldRules), nil
+	}
+
+	rules := &fieldRules{}
+	options := strings.Split(tag, ",")
+	for i, option := range options {
+		key, value, _ := strings.Cut(option, "=")
+		var err error
+		switch key {
+		case "":
+		case "optional":
+			rules.optional = true
+		case "uuid":
+			rules.uuid = true
+		case "min":
+			rules.min, err = parseFloatRule(key, value)
+		case "max":
+			rules.max, err = parseFloatRule(key, value)
+		case "len":
+			rules.length, err = parseIntRule(key, value)
+		case "minlen":
+			rules.minLen, err = parseIntRule(key, value)
+		case "maxlen":
+			rules.maxLen, err = parseIntRule(key, value)
+		case "enum":
+			rules.enum = strings.Split(value, "|")
+		case "regex":
+			// The regex is the rest of the tag, as it can contain commas.
+			expr := strings.TrimPrefix(strings.Join(options[i:], ","), "regex=")
+			if rules.regex, err = regexp.Compile(expr); err != nil {
+				return nil, fmt.Errorf("invalid rule regex: %s", err)
+			}
+		default:
+			if _, ok := ignoredTagOptions.Load(key); !ok {
+				return nil, fmt.Errorf("unknown rule %q", key)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key == "regex" {
+			break
+		}
+	}
+
+	fieldRulesCache.Store(tag, rules)
+	return rules, nil
+}
+
+func parseFloatRule(key, value string) (*float64, error) {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %s: %q", key, value)
+	}
+	return &f, nil
+}
+
+func parseIntRule(key, value string) (*int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule %s: %q", key, value)
+	}
+	return &n, nil
+}
+
+// check returns the reason why a value is invalid, or an empty string.
+func (r *fieldRules) check(v reflect.Value) string {
+	if r.min != nil || r.max != nil {
+		var f float64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			f = float64(v.Uint())
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		default:
+			return "min and max are only supported for numbers"
+		}
+		if r.min != nil && f < *r.min {
+			return "must be at least " + strconv.FormatFloat(*r.min, 'g', -1, 64)
+		}
+		if r.max != nil && f > *r.max {
+			return "must be at most " + strconv.FormatFloat(*r.max, 'g', -1, 64)
+		}
+	}
+
+	if r.length != nil || r.minLen != nil || r.maxLen != nil {
+		var n int
+		switch v.Kind() {
+		case reflect.String:
+			n = utf8.RuneCountInString(v.String())
+		case reflect.Slice, reflect.Array, reflect.Map:
+			n = v.Len()
+		default:
+			return "len, minlen and maxlen are only supported for strings, slices, arrays and maps"
+		}
+		if r.length != nil && n != *r.length {
+			return fmt.Sprintf("length must be %d", *r.length)
+		}
+		if r.minLen != nil && n < *r.minLen {
+			return fmt.Sprintf("length must be at least %d", *r.minLen)
+		}
+		if r.maxLen != nil && n > *r.maxLen {
+			return fmt.Sprintf("length must be at most %d", *r.maxLen)
+		}
+	}
+
+	if r.enum != nil {
+		s := fmt.Sprint(v.Interface())
+		found := false
+		for _, e := range r.enum {
+			if s == e {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "must be one of " + strings.Join(r.enum, ", ")
+		}
+	}
+
+	if r.uuid || r.regex != nil {
+		if v.Kind() != reflect.String {
+			return "uuid and regex are only supported for strings"
+		}
+		if r.uuid {
+			if _, err := ParseUUID(v.String()); err != nil {
+				return "must be a UUID"
+			}
+		}
+		if r.regex != nil && !r.regex.MatchString(v.String()) {
+			return "must match " + r.regex.String()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2016 - Max Ekman <max@looplab.se>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventhorizon
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateCommand(t *testing.T) {
+	id := NewUUID()
+	testCases := map[string]struct {
+		command Command
+		errs    CommandFieldErrors
+	}{
+		"valid": {
+			&TestCommandRules{
+				ID:      id,
+				Name:    "name",
+				Age:     20,
+				Role:    "admin",
+				Ref:     string(NewUUID()),
+				Code:    "AB-12",
+				Tags:    []string{"a"},
+				Address: &TestAddress{Street: "street"},
+				Items:   []TestItem{{Name: "item"}},
+			},
+			nil,
+		},
+		"all invalid": {
+			&TestCommandRules{
+				ID:      id,
+				Name:    "a too long name",
+				Age:     10,
+				Role:    "guest",
+				Ref:     "ref",
+				Code:    "AB,12",
+				Tags:    []string{"a", "b", "c"},
+				Address: &TestAddress{Street: "street", Zip: "1234567"},
+				Items:   []TestItem{{Name: "item"}, {}},
+				Fn:      func() {},
+			},
+			CommandFieldErrors{
+				{Field: "Name", Reason: "length must be at most 10"},
+				{Field: "Age", Reason: "must be at least 18"},
+				{Field: "Role", Reason: "must be one of admin, user"},
+				{Field: "Ref", Reason: "must be a UUID"},
+				{Field: "Code", Reason: "must match ^[A-Z]{1,2}-[0-9]{2,}$"},
+				{Field: "Tags", Reason: "length must be at most 2"},
+				{Field: "Address.Zip", Reason: "length must be 5"},
+				{Field: "Items[1].Name", Reason: "missing"},
+				{Field: "Fn", Reason: "unsupported type"},
+			},
+		},
+		"missing": {
+			&TestCommandRules{ID: id, Age: 18, Role: "user", Ref: string(id), Code: "A-10"},
+			CommandFieldErrors{
+				{Field: "Name", Reason: "missing"},
+				{Field: "Address", Reason: "missing"},
+			},
+		},
+		"nested validator": {
+			&TestCommandRules{
+				ID:      id,
+				Name:    "name",
+				Age:     18,
+				Role:    "user",
+				Ref:     string(id),
+				Code:    "A-10",
+				Address: &TestAddress{Street: "invalid"},
+			},
+			CommandFieldErrors{
+				{Field: "Address.Street", Reason: "is invalid"},
+			},
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := ValidateCommand(tc.command)
+			if tc.errs == nil {
+				if err != nil {
+					t.Error("there should be no error:", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(err, tc.errs) {
+				t.Errorf("the errors should be correct:\n%#v\n%#v", err, tc.errs)
+			}
+		})
+	}
+}
+
+func TestValidateCommandValidator(t *testing.T) {
+	err := ValidateCommand(&TestCommandValidator{Content: "invalid"})
+	if err != errInvalidContent {
+		t.Error("there should be a validation error:", err)
+	}
+
+	// Validate is not called when the fields are invalid.
+	err = ValidateCommand(&TestCommandValidator{})
+	if err == nil || err.Error() != "missing field: Content" {
+		t.Error("there should be a missing field error:", err)
+	}
+}
+
+func TestValidateCommandInvalidRule(t *testing.T) {
+	err := ValidateCommand(&TestCommandInvalidRule{Content: "content"})
+	expected := CommandFieldErrors{{Field: "Content", Reason: `unknown rule "maximum"`}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Error("there should be a rule error:", err)
+	}
+	if err.Error() != `invalid field: Content: unknown rule "maximum"` {
+		t.Error("the error message should be correct:", err)
+	}
+}
+
+func TestValidateCommandIgnoredOption(t *testing.T) {
+	err := ValidateCommand(&TestCommandIgnoredOption{Content: "content"})
+	expected := CommandFieldErrors{{Field: "Content", Reason: `unknown rule "ignored"`}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Error("there should be a rule error:", err)
+	}
+
+	IgnoreTagOption("ignored")
+	if err := ValidateCommand(&TestCommandIgnoredOption{Content: "content"}); err != nil {
+		t.Error("there should be no error:", err)
+	}
+	err = ValidateCommand(&TestCommandIgnoredOption{Content: "long content"})
+	expected = CommandFieldErrors{{Field: "Content", Reason: "length must be at most 10"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Error("the other rules should be used:", err)
+	}
+}
+
+type TestCommandRules struct {
+	ID      UUID
+	Name    string   `eh:"maxlen=10"`
+	Age     int      `eh:"min=18,max=150"`
+	Role    string   `eh:"enum=admin|user"`
+	Ref     string   `eh:"uuid"`
+	Code    string   `eh:"regex=^[A-Z]{1,2}-[0-9]{2,}$"`
+	Tags    []string `eh:"optional,maxlen=2"`
+	Address *TestAddress
+	Items   []TestItem `eh:"optional"`
+	Fn      func()     `eh:"optional"`
+}
+
+func (t TestCommandRules) AggregateID() UUID            { return t.ID }
+func (t TestCommandRules) AggregateType() AggregateType { return AggregateType("Test") }
+func (t TestCommandRules) CommandType() CommandType     { return CommandType("TestCommandRules") }
+
+type TestAddress struct {
+	Street string
+	Zip    string `eh:"optional,len=5"`
+}
+
+func (a *TestAddress) Validate() error {
+	if a.Street == "invalid" {
+		return CommandFieldError{Field: "Street", Reason: "is invalid"}
+	}
+	return nil
+}
+
+type TestItem struct {
+	Name string
+}
+
+var errInvalidContent = errors.New("invalid content")
+
+type TestCommandValidator struct {
+	Content string
+}
+
+func (t TestCommandValidator) AggregateID() UUID            { return UUID("") }
+func (t TestCommandValidator) AggregateType() AggregateType { return AggregateType("Test") }
+func (t TestCommandValidator) CommandType() CommandType     { return CommandType("TestCommandValidator") }
+
+func (t TestCommandValidator) Validate() error {
+	if t.Content == "invalid" {
+		return errInvalidContent
+	}
+	return nil
+}
+
+type TestCommandInvalidRule struct {
+	Content string `eh:"maximum=10"`
+}
+
+func (t TestCommandInvalidRule) AggregateID() UUID            { return UUID("") }
+func (t TestCommandInvalidRule) AggregateType() AggregateType { return AggregateType("Test") }
+func (t TestCommandInvalidRule) CommandType() CommandType {
+	return CommandType("TestCommandInvalidRule")
+}
+
+type TestCommandIgnoredOption struct {
+	Content string `eh:"maxlen=10,ignored"`
+}
+
+func (t TestCommandIgnoredOption) AggregateID() UUID            { return UUID("") }
+func (t TestCommandIgnoredOption) AggregateType() AggregateType { return AggregateType("Test") }
+func (t TestCommandIgnoredOption) CommandType() CommandType {
+	return CommandType("TestCommandIgnoredOption")
+}